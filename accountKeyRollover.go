@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// accountKeyRolloverItem holds the new account key in the account secret while it's being rolled over, so it isn't lost if storing it fails after the CA has accepted it
+const accountKeyRolloverItem = "account.key.next"
+
+var accountKeyRolloverHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// acmeKeyChangeDirectory holds the urls of the ACME directory needed for a key rollover; lego doesn't implement the keyChange request
+type acmeKeyChangeDirectory struct {
+	NewNonce  string `json:"newNonce"`
+	KeyChange string `json:"keyChange"`
+}
+
+// acmeNonceSource gets a fresh nonce from the newNonce url of the ACME directory for every signed request
+type acmeNonceSource struct {
+	url string
+}
+
+func (s acmeNonceSource) Nonce() (string, error) {
+	response, err := accountKeyRolloverHTTPClient.Head(s.url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	nonce := response.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("ACME directory responded without a nonce")
+	}
+
+	return nonce, nil
+}
+
+// rolloverAccountKey replaces the key of the account in the account secret with a new key at the CA and then stores the new key in the secret; a new key left by an interrupted rollover is picked up again, so rerunning the command completes it
+func rolloverAccountKey(ctx context.Context) error {
+	kubeClientConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	namespace := *rolloverAccountKeyNamespace
+	if namespace == "" {
+		namespace = getCurrentNamespace()
+	}
+
+	secret, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, *rolloverAccountKeySecret, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if isEncryptedPrivateKey(secret.Data["account.key"]) {
+		return fmt.Errorf("Account key in secret %v.%v is encrypted, which rolling over doesn't support", secret.Name, secret.Namespace)
+	}
+	user, err := getLetsEncryptUserFromSecret(secret)
+	if err != nil {
+		return err
+	}
+	if user.Registration == nil || user.Registration.URI == "" {
+		return fmt.Errorf("Account in secret %v.%v has no account url", secret.Name, secret.Namespace)
+	}
+	if accountPath, ok := findAccountPath(strings.Split(*accountPaths, ","), user.Registration.URI); ok {
+		return fmt.Errorf("Account %v is also loaded from %v with --account-paths, which would keep the old key after the rollover; move it to an account secret with --account-secret or --account-secrets only", user.Registration.URI, accountPath)
+	}
+
+	directoryURL := getACMEDirectoryURL(environmentProduction)
+	if *rolloverAccountKeyStaging {
+		directoryURL = getACMEDirectoryURL(environmentStaging)
+	}
+
+	newKeyBytes, interrupted := secret.Data[accountKeyRolloverItem]
+	var newKey crypto.PrivateKey
+	if interrupted {
+		newKey, err = parsePrivateKey(newKeyBytes, nil)
+		if err != nil {
+			return err
+		}
+		if isAccountKey(directoryURL, user, newKey) {
+			log.Info().Msgf("CA already accepted the new key of account %v from an interrupted rollover, storing it...", user.Registration.URI)
+			return storeRolledOverAccountKey(ctx, kubeClientset, secret, newKeyBytes)
+		}
+		log.Info().Msgf("Retrying rollover of account %v to the new key from an interrupted rollover...", user.Registration.URI)
+	} else {
+		newKey, err = certcrypto.GeneratePrivateKey(accountKeyTypes[*rolloverAccountKeyType])
+		if err != nil {
+			return err
+		}
+		newKeyBytes = certcrypto.PEMEncode(newKey)
+
+		// keep the new key next to the current one before the CA switches to it
+		secret.Data[accountKeyRolloverItem] = newKeyBytes
+		setLastChangedBy(secret, "account-key-rollover")
+		secret, err = kubeClientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msgf("Rolling over account %v to a new %v key...", user.Registration.URI, *rolloverAccountKeyType)
+	err = changeAccountKey(directoryURL, user.Registration.URI, user.key, newKey)
+	if err != nil {
+		return fmt.Errorf("Rolling over the account key failed, the new key is kept in %v of secret %v.%v for the next attempt: %w", accountKeyRolloverItem, secret.Name, secret.Namespace, err)
+	}
+
+	return storeRolledOverAccountKey(ctx, kubeClientset, secret, newKeyBytes)
+}
+
+// findAccountPath returns the account path holding the account with the account url; paths that can't be read are skipped, since the rollover usually runs without the controller's account volumes
+func findAccountPath(accountPaths []string, accountURL string) (string, bool) {
+	for _, accountPath := range accountPaths {
+		if strings.TrimSpace(accountPath) == "" {
+			continue
+		}
+		user, err := loadLetsEncryptUser(strings.TrimSpace(accountPath))
+		if err == nil && user.Registration != nil && user.Registration.URI == accountURL {
+			return accountPath, true
+		}
+	}
+
+	return "", false
+}
+
+// storeRolledOverAccountKey replaces the account key in the secret with the new key in a single update, which fails on a conflicting change so the new key is never lost
+func storeRolledOverAccountKey(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, newKeyBytes []byte) error {
+	secret.Data["account.key"] = newKeyBytes
+	delete(secret.Data, accountKeyRolloverItem)
+	setLastChangedBy(secret, "account-key-rollover")
+
+	_, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("Storing the new account key failed, it's kept in %v of secret %v.%v and stored by running the rollover again: %w", accountKeyRolloverItem, secret.Name, secret.Namespace, err)
+	}
+
+	log.Info().Msgf("Stored new account key in secret %v.%v", secret.Name, secret.Namespace)
+
+	return nil
+}
+
+// isEncryptedPrivateKey checks whether the pem encoded private key is encrypted
+func isEncryptedPrivateKey(keyBytes []byte) bool {
+	keyBlock, _ := pem.Decode(keyBytes)
+
+	return keyBlock != nil && (keyBlock.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(keyBlock))
+}
+
+// isAccountKey checks whether the CA knows the key as the key of the account
+func isAccountKey(directoryURL string, user LetsEncryptUser, key crypto.PrivateKey) bool {
+	config := lego.NewConfig(&LetsEncryptUser{Email: user.Email, key: key})
+	config.CADirURL = directoryURL
+
+	legoClient, err := lego.NewClient(config)
+	if err != nil {
+		return false
+	}
+	resource, err := legoClient.Registration.ResolveAccountByKey()
+
+	return err == nil && resource.URI == user.Registration.URI
+}
+
+// changeAccountKey sends the keyChange request of RFC 8555 section 7.3.5: an inner jws signed by the new key, wrapped in a jws signed by the current key of the account
+func changeAccountKey(directoryURL, accountURL string, oldKey, newKey crypto.PrivateKey) error {
+	directory, err := getKeyChangeDirectory(directoryURL)
+	if err != nil {
+		return err
+	}
+
+	oldSigner, ok := oldKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("Account key of type %T is not supported", oldKey)
+	}
+	keyChange, err := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}{accountURL, jose.JSONWebKey{Key: oldSigner.Public()}})
+	if err != nil {
+		return err
+	}
+
+	innerJWS, err := signJWS(newKey, "", directory.KeyChange, nil, keyChange)
+	if err != nil {
+		return err
+	}
+	outerJWS, err := signJWS(oldKey, accountURL, directory.KeyChange, acmeNonceSource{url: directory.NewNonce}, []byte(innerJWS))
+	if err != nil {
+		return err
+	}
+
+	response, err := accountKeyRolloverHTTPClient.Post(directory.KeyChange, "application/jose+json", bytes.NewReader([]byte(outerJWS)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		problem := &acme.ProblemDetails{}
+		if json.NewDecoder(response.Body).Decode(problem) != nil || problem.Type == "" {
+			return fmt.Errorf("ACME keyChange endpoint responded with status code %v", response.StatusCode)
+		}
+		problem.HTTPStatus = response.StatusCode
+		return problem
+	}
+
+	return nil
+}
+
+// getKeyChangeDirectory reads the newNonce and keyChange urls from the ACME directory
+func getKeyChangeDirectory(directoryURL string) (directory acmeKeyChangeDirectory, err error) {
+	response, err := accountKeyRolloverHTTPClient.Get(directoryURL)
+	if err != nil {
+		return directory, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return directory, fmt.Errorf("ACME directory responded with status code %v", response.StatusCode)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&directory)
+	if err != nil {
+		return directory, err
+	}
+	if directory.NewNonce == "" || directory.KeyChange == "" {
+		return directory, fmt.Errorf("ACME directory %v doesn't offer key rollover", directoryURL)
+	}
+
+	return directory, nil
+}
+
+// signJWS signs the payload for the url with the key, identifying the account by its url (kid) if set and otherwise embedding the public key (jwk)
+func signJWS(key crypto.PrivateKey, kid, url string, nonces jose.NonceSource, payload []byte) (string, error) {
+	algorithm, err := getJWSAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+
+	options := &jose.SignerOptions{
+		NonceSource:  nonces,
+		EmbedJWK:     kid == "",
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": url},
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: jose.JSONWebKey{Key: key, KeyID: kid}}, options)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return signed.FullSerialize(), nil
+}
+
+// getJWSAlgorithm returns the signature algorithm for an rsa or ecdsa account key
+func getJWSAlgorithm(key crypto.PrivateKey) (jose.SignatureAlgorithm, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return jose.ES256, nil
+		case 384:
+			return jose.ES384, nil
+		}
+	}
+
+	return "", fmt.Errorf("Account key of type %T is not supported, use an rsa or ec256/ec384 key", key)
+}
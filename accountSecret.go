@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getAccountSecretNamespace returns the namespace of the account secret, the one the controller runs in
+func getAccountSecretNamespace() string {
+	return getCurrentNamespace()
+}
+
+// getLetsEncryptUserFromSecret returns the account from the account.json and account.key items of the secret; an encrypted account.key is decrypted with the passphrase from the account.passphrase item or the flag
+func getLetsEncryptUserFromSecret(secret *v1.Secret) (LetsEncryptUser, error) {
+	accountJSON, accountKey := secret.Data["account.json"], secret.Data["account.key"]
+	if len(accountJSON) == 0 || len(accountKey) == 0 {
+		return LetsEncryptUser{}, fmt.Errorf("Account secret %v.%v should have account.json and account.key items", secret.Name, secret.Namespace)
+	}
+
+	passphrase := []byte(*accountKeyPassphrase)
+	if value, ok := secret.Data["account.passphrase"]; ok {
+		passphrase = []byte(strings.TrimRight(string(value), "\r\n"))
+	}
+
+	return parseLetsEncryptUser(accountJSON, accountKey, passphrase)
+}
+
+// loadLetsEncryptUserFromSecret reads the account from the account secret; it's read for every lego client, so an updated account key is picked up without a restart
+func loadLetsEncryptUserFromSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace, name string) (LetsEncryptUser, error) {
+	secret, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return LetsEncryptUser{}, err
+	}
+
+	return getLetsEncryptUserFromSecret(secret)
+}
+
+// ensureAccountSecret registers an account with the email address and stores it in the account secret if that doesn't exist yet, so the account files don't have to be created before installing the controller
+func ensureAccountSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace, name, email string) error {
+	_, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		log.Info().Msgf("Using account from secret %v.%v", name, namespace)
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	if email == "" {
+		return fmt.Errorf("Account secret %v.%v doesn't exist and --account-email isn't set to register an account with", name, namespace)
+	}
+
+	key, err := certcrypto.GeneratePrivateKey(accountKeyTypes[*accountKeyType])
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Account secret %v.%v doesn't exist, registering %v account for %v...", name, namespace, *accountKeyType, email)
+	user, err := registerAccount(email, key, false)
+	if err != nil {
+		return err
+	}
+
+	files, err := getAccountFiles(user)
+	if err != nil {
+		return err
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: files,
+	}
+	setLastChangedBy(secret, "account-registration")
+	_, err = kubeClientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// another replica registered an account at the same time; use that one, the account registered here is left unused
+		log.Warn().Msgf("Account secret %v.%v has been created by another replica, discarding account %v", name, namespace, user.Registration.URI)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Stored account %v in secret %v.%v", user.Registration.URI, name, namespace)
+
+	return nil
+}
+
+// parseAccountSecrets parses the comma-separated name=secret pairs of the named accounts
+func parseAccountSecrets(value string) (map[string]string, error) {
+	accounts := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Account %v is not a name=secret pair", pair)
+		}
+		if _, ok := accounts[parts[0]]; ok {
+			return nil, fmt.Errorf("Account %v is configured more than once", parts[0])
+		}
+		accounts[parts[0]] = parts[1]
+	}
+
+	return accounts, nil
+}
+
+// getNamedAccountSecret returns the name of the secret holding the named account of --account-secrets
+func getNamedAccountSecret(account string) (string, error) {
+	accounts, err := parseAccountSecrets(*accountSecrets)
+	if err != nil {
+		return "", err
+	}
+
+	secretName, ok := accounts[account]
+	if !ok {
+		return "", fmt.Errorf("Account %v is not one of the accounts of --account-secrets", account)
+	}
+
+	return secretName, nil
+}
+
+// loadAccount returns the named account if requested, which has to match the email address if that's requested as well; otherwise the account matching the requested email address, or the default account: the account secret if set, otherwise the first of the account paths
+func loadAccount(ctx context.Context, kubeClientset *kubernetes.Clientset, account, email string) (LetsEncryptUser, error) {
+	if account != "" {
+		secretName, err := getNamedAccountSecret(account)
+		if err != nil {
+			return LetsEncryptUser{}, err
+		}
+		user, err := loadLetsEncryptUserFromSecret(ctx, kubeClientset, getAccountSecretNamespace(), secretName)
+		if err != nil {
+			return user, err
+		}
+		if email != "" && !strings.EqualFold(user.Email, email) {
+			return LetsEncryptUser{}, fmt.Errorf("Account %v has email address %v instead of %v", account, user.Email, email)
+		}
+		return user, nil
+	}
+
+	if *accountSecret != "" {
+		user, err := loadLetsEncryptUserFromSecret(ctx, kubeClientset, getAccountSecretNamespace(), *accountSecret)
+		if err != nil {
+			return user, err
+		}
+		if email == "" || strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+
+	return loadLetsEncryptUserByEmail(strings.Split(*accountPaths, ","), email)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetLetsEncryptUserFromSecret(t *testing.T) {
+
+	t.Run("ReturnsAccountFromSecretItems", func(t *testing.T) {
+
+		key, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+		assert.Nil(t, err)
+		user := LetsEncryptUser{Email: "name@server.com", Registration: &registration.Resource{URI: "https://acme-v02.api.letsencrypt.org/acme/acct/1"}, key: key}
+		files, err := getAccountFiles(user)
+		assert.Nil(t, err)
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "letsencrypt-account", Namespace: "estafette"}, Data: files}
+
+		// act
+		loadedUser, err := getLetsEncryptUserFromSecret(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, user, loadedUser)
+	})
+
+	t.Run("ReturnsErrorIfAccountKeyIsMissing", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "letsencrypt-account", Namespace: "estafette"}, Data: map[string][]byte{"account.json": []byte("{}")}}
+
+		// act
+		_, err := getLetsEncryptUserFromSecret(secret)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestParseAccountSecrets(t *testing.T) {
+
+	t.Run("ReturnsSecretPerAccount", func(t *testing.T) {
+
+		// act
+		accounts, err := parseAccountSecrets("entity-a=letsencrypt-account-a, entity-b=letsencrypt-account-b")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"entity-a": "letsencrypt-account-a", "entity-b": "letsencrypt-account-b"}, accounts)
+	})
+
+	t.Run("ReturnsErrorForPairWithoutSecret", func(t *testing.T) {
+
+		// act
+		_, err := parseAccountSecrets("entity-a=letsencrypt-account-a,entity-b")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForDuplicateAccount", func(t *testing.T) {
+
+		// act
+		_, err := parseAccountSecrets("entity-a=letsencrypt-account-a,entity-a=letsencrypt-account-b")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetNamedAccountSecret(t *testing.T) {
+
+	t.Run("ReturnsSecretOfAccount", func(t *testing.T) {
+
+		*accountSecrets = "entity-a=letsencrypt-account-a,entity-b=letsencrypt-account-b"
+		defer func() { *accountSecrets = "" }()
+
+		// act
+		secretName, err := getNamedAccountSecret("entity-b")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "letsencrypt-account-b", secretName)
+	})
+
+	t.Run("ReturnsErrorForUnknownAccount", func(t *testing.T) {
+
+		*accountSecrets = "entity-a=letsencrypt-account-a"
+		defer func() { *accountSecrets = "" }()
+
+		// act
+		_, err := getNamedAccountSecret("entity-c")
+
+		assert.NotNil(t, err)
+	})
+}
@@ -1,15 +1,31 @@
 package main
 
 import (
+	"bytes"
 	"crypto"
-	"crypto/x509"
-	"encoding/pem"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
 )
 
+// acmeUserActionRequiredError is the problem type returned by the CA when the account has to agree to updated terms of service
+const acmeUserActionRequiredError = "urn:ietf:params:acme:error:userActionRequired"
+
+// acmeDNSError is the problem type returned by the CA when the DNS lookups for a challenge fail
+const acmeDNSError = "urn:ietf:params:acme:error:dns"
+
 type LetsEncryptUser struct {
 	Email        string                 `json:"email"`
 	Registration *registration.Resource `json:"registration"`
@@ -26,20 +42,206 @@ func (u LetsEncryptUser) GetPrivateKey() crypto.PrivateKey {
 	return u.key
 }
 
-func loadPrivateKey(file string) (crypto.PrivateKey, error) {
-	keyBytes, err := ioutil.ReadFile(file)
+// loadAccountKeyPassphrase returns the content of the account.passphrase file in accountPath, or the passphrase from the flag if the file doesn't exist
+func loadAccountKeyPassphrase(accountPath string) ([]byte, error) {
+	passphrase, err := ioutil.ReadFile(filepath.Join(accountPath, "account.passphrase"))
+	if os.IsNotExist(err) {
+		return []byte(*accountKeyPassphrase), nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	keyBlock, _ := pem.Decode(keyBytes)
+	return bytes.TrimRight(passphrase, "\r\n"), nil
+}
+
+// loadLetsEncryptUser reads the account.json and account.key files from accountPath; an encrypted account.key is decrypted with the passphrase from account.passphrase or the flag
+func loadLetsEncryptUser(accountPath string) (letsEncryptUser LetsEncryptUser, err error) {
+	accountJSON, err := ioutil.ReadFile(filepath.Join(accountPath, "account.json"))
+	if err != nil {
+		return letsEncryptUser, err
+	}
+
+	accountKey, err := ioutil.ReadFile(filepath.Join(accountPath, "account.key"))
+	if err != nil {
+		return letsEncryptUser, err
+	}
+
+	passphrase, err := loadAccountKeyPassphrase(accountPath)
+	if err != nil {
+		return letsEncryptUser, err
+	}
+
+	return parseLetsEncryptUser(accountJSON, accountKey, passphrase)
+}
+
+// parseLetsEncryptUser returns the account from the content of the account.json and account.key files
+func parseLetsEncryptUser(accountJSON, accountKey, passphrase []byte) (letsEncryptUser LetsEncryptUser, err error) {
+	err = json.Unmarshal(accountJSON, &letsEncryptUser)
+	if err != nil {
+		return letsEncryptUser, err
+	}
+
+	letsEncryptUser.key, err = parsePrivateKey(accountKey, passphrase)
+	if err != nil {
+		return letsEncryptUser, err
+	}
+
+	return letsEncryptUser, nil
+}
+
+// loadLetsEncryptUserByEmail returns the configured account with a matching email address, or the first (default) account if email is empty
+func loadLetsEncryptUserByEmail(accountPaths []string, email string) (letsEncryptUser LetsEncryptUser, err error) {
+	if len(accountPaths) == 0 {
+		return letsEncryptUser, errors.New("No account paths are configured")
+	}
+
+	if email == "" {
+		return loadLetsEncryptUser(accountPaths[0])
+	}
+
+	for _, accountPath := range accountPaths {
+		letsEncryptUser, err = loadLetsEncryptUser(accountPath)
+		if err != nil {
+			return letsEncryptUser, err
+		}
+		if strings.EqualFold(letsEncryptUser.Email, email) {
+			return letsEncryptUser, nil
+		}
+	}
+
+	return LetsEncryptUser{}, fmt.Errorf("No configured account has email address %v", email)
+}
+
+// sequentialDNSProvider wraps a DNS-01 provider to have lego solve its challenges one at a time
+type sequentialDNSProvider struct {
+	challenge.Provider
+	interval time.Duration
+}
+
+// Sequential returns the interval to wait between solving challenges
+func (p *sequentialDNSProvider) Sequential() time.Duration {
+	return p.interval
+}
+
+// Timeout passes through the timeout and polling interval of the wrapped provider
+func (p *sequentialDNSProvider) Timeout() (timeout, interval time.Duration) {
+	if providerTimeout, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return providerTimeout.Timeout()
+	}
+
+	return 60 * time.Second, 2 * time.Second
+}
+
+// skipPreCheck replaces lego's DNS propagation check so challenges are validated right away
+func skipPreCheck(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+	return true, nil
+}
+
+// isTermsOfServiceUpdateError checks whether the CA rejected a request because the account hasn't agreed to its updated terms of service
+func isTermsOfServiceUpdateError(err error) bool {
+	var problem *acme.ProblemDetails
+	return errors.As(err, &problem) && problem.Type == acmeUserActionRequiredError
+}
+
+// getIssueFailure categorizes a failed order as RateLimited or DNSError, or returns an empty string for any other failure; the problems of failed authorizations are only available as text, since lego joins them into a single error per order
+func getIssueFailure(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var problem *acme.ProblemDetails
+	if errors.As(err, &problem) {
+		switch problem.Type {
+		case acmeRateLimitedError:
+			return "RateLimited"
+		case acmeDNSError:
+			return "DNSError"
+		}
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, acmeRateLimitedError):
+		return "RateLimited"
+	case strings.Contains(message, acmeDNSError), strings.Contains(message, "propagation"), strings.Contains(message, "time limit exceeded"):
+		return "DNSError"
+	}
+
+	return ""
+}
+
+// getACMEProxy returns the proxy to reach the CA through for the account email or environment, from comma-separated key=url pairs in which the account email takes precedence over the environment; it returns nil if neither has a proxy configured
+func getACMEProxy(proxies, accountEmail, environment string) (proxyURL *url.URL, err error) {
+	var environmentProxyURL *url.URL
+	for _, pair := range strings.Split(proxies, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ACME proxy %v is not a key=url pair", pair)
+		}
+		pairURL, err := url.Parse(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if accountEmail != "" && strings.EqualFold(parts[0], accountEmail) {
+			proxyURL = pairURL
+		}
+		if parts[0] == environment {
+			environmentProxyURL = pairURL
+		}
+	}
+
+	if proxyURL != nil {
+		return proxyURL, nil
+	}
+
+	return environmentProxyURL, nil
+}
+
+// getACMEDirectoryURL returns the url of the ACME directory for the production or staging environment
+func getACMEDirectoryURL(environment string) string {
+	if environment == environmentStaging {
+		return *acmeStagingDirectoryURL
+	}
+
+	return *acmeDirectoryURL
+}
+
+// getStateACMEDirectoryURL returns the ACME directory set for the secret, or the one of its environment
+func getStateACMEDirectoryURL(state LetsEncryptCertificateState) string {
+	if state.ACMEDirectoryURL != "" {
+		return state.ACMEDirectoryURL
+	}
+
+	return getACMEDirectoryURL(state.Environment)
+}
+
+// isRegisteredWithDirectory checks whether the account registration has been made at the CA of the ACME directory, going by the host of their urls
+func isRegisteredWithDirectory(registration *registration.Resource, directoryURL string) bool {
+	if registration == nil {
+		return false
+	}
+
+	registrationURL, err := url.Parse(registration.URI)
+	if err != nil {
+		return false
+	}
+	parsedDirectoryURL, err := url.Parse(directoryURL)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(registrationURL.Host, parsedDirectoryURL.Host)
+}
 
-	switch keyBlock.Type {
-	case "RSA PRIVATE KEY":
-		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
-	case "EC PRIVATE KEY":
-		return x509.ParseECPrivateKey(keyBlock.Bytes)
+// registerWithDirectory registers the account of the lego client, with external account binding if the credentials have a key id
+func registerWithDirectory(legoClient *lego.Client, eabCredentials acmeEABCredentials) (*registration.Resource, error) {
+	if eabCredentials.KeyID == "" {
+		return legoClient.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
 	}
 
-	return nil, errors.New("Unknown private key type.")
+	return legoClient.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{TermsOfServiceAgreed: true, Kid: eabCredentials.KeyID, HmacEncoded: eabCredentials.HMACKey})
 }
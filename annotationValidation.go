@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// annotationError describes why the value of an annotation is invalid
+type annotationError struct {
+	Annotation string `json:"annotation"`
+	Value      string `json:"value"`
+	Message    string `json:"message"`
+}
+
+// annotationValidationResult is the response of the annotation validation endpoint
+type annotationValidationResult struct {
+	Valid  bool              `json:"valid"`
+	Errors []annotationError `json:"errors"`
+}
+
+// boolAnnotations are the annotations that only accept boolean values
+var boolAnnotations = []string{
+	annotationLetsEncryptCertificate,
+	annotationLetsEncryptCertificateCopyToAllNamespaces,
+	annotationLetsEncryptCertificateCopyPrivateKey,
+	annotationLetsEncryptCertificateUploadToCloudflare,
+	annotationLetsEncryptCertificateSeparateKeySecret,
+	annotationLetsEncryptCertificateStaging,
+	annotationLetsEncryptCertificatePromote,
+	annotationLetsEncryptCertificateAutoAddWWW,
+	annotationLetsEncryptCertificateDNSDisablePropagationCheck,
+	annotationLetsEncryptCertificateDNSSequential,
+	annotationLetsEncryptCertificateBundle,
+	annotationLetsEncryptCertificateWriteJSON,
+	annotationLetsEncryptCertificateDualKeyTypes,
+}
+
+// enumAnnotations are the annotations that only accept one of a fixed set of values
+var enumAnnotations = map[string][]string{
+	annotationLetsEncryptCertificateOutputFamilies: {"tls", "ssl", "both"},
+	annotationLetsEncryptCertificateExpiredAction:  {"keep", "clear", "revoke"},
+	annotationLetsEncryptCertificateDNSProvider:    dnsProviders,
+	annotationLetsEncryptCertificateKeyType:        keyTypeNames,
+	annotationLetsEncryptCertificateChallengeType:  {"dns-01", "http-01"},
+}
+
+// knownAnnotations are all annotations with the estafette.io/letsencrypt-certificate prefix, including the ones set by the controller itself
+var knownAnnotations = map[string]bool{
+	annotationLetsEncryptCertificateHostnames:                true,
+	annotationLetsEncryptCertificateCopyToNamespacesMatching: true,
+	annotationLetsEncryptCertificateCopyNameTemplate:         true,
+	annotationLetsEncryptCertificateCopyNameMapping:          true,
+	annotationLetsEncryptCertificateLinkedSecret:             true,
+	annotationLetsEncryptCertificateCloudflareZone:           true,
+	annotationLetsEncryptCertificateTargetSecret:             true,
+	annotationLetsEncryptCertificateAccountEmail:             true,
+	annotationLetsEncryptCertificateAccount:                  true,
+	annotationLetsEncryptCertificateExpiresAfter:             true,
+	annotationLetsEncryptCertificateStorage:                  true,
+	annotationLetsEncryptCertificateRenewDaysBeforeExpiry:    true,
+	annotationLetsEncryptCertificateRetryAfterMinutes:        true,
+	annotationLetsEncryptCertificateACMEDirectoryURL:         true,
+	annotationLetsEncryptCertificateCredentialsSecret:        true,
+	annotationLetsEncryptCertificateState:                    true,
+	annotationLetsEncryptCertificateCopySkips:                true,
+	annotationLetsEncryptCertificateNextRenewal:              true,
+	annotationLetsEncryptCertificateDiagnostics:              true,
+	annotationLetsEncryptCertificateLastChangedBy:            true,
+	annotationLetsEncryptCertificateSNICertificates:          true,
+	annotationLetsEncryptCertificateSNIState:                 true,
+	annotationLetsEncryptCertificateKeepPrevious:             true,
+	annotationLetsEncryptCertificateRollback:                 true,
+	annotationLetsEncryptCertificateRenewalsPausedUntil:      true,
+}
+
+func init() {
+	for _, annotation := range boolAnnotations {
+		knownAnnotations[annotation] = true
+	}
+	for annotation := range enumAnnotations {
+		knownAnnotations[annotation] = true
+	}
+}
+
+// validateAnnotations checks the estafette.io/letsencrypt-certificate annotations of a secret and returns an error for each invalid one, ordered by annotation
+func validateAnnotations(annotations map[string]string) (annotationErrors []annotationError) {
+
+	annotationErrors = []annotationError{}
+	fail := func(annotation, message string, args ...interface{}) {
+		annotationErrors = append(annotationErrors, annotationError{Annotation: annotation, Value: annotations[annotation], Message: fmt.Sprintf(message, args...)})
+	}
+
+	keys := []string{}
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key != annotationLetsEncryptCertificate && strings.HasPrefix(key, annotationLetsEncryptCertificate+"-") && !knownAnnotations[key] {
+			fail(key, "Annotation is unknown")
+		}
+	}
+
+	for _, annotation := range boolAnnotations {
+		if value, ok := annotations[annotation]; ok {
+			if _, err := strconv.ParseBool(value); err != nil {
+				fail(annotation, "Value is not a boolean")
+			}
+		}
+	}
+
+	for annotation, allowedValues := range enumAnnotations {
+		if value, ok := annotations[annotation]; ok && value != "" && !containsString(allowedValues, value) {
+			fail(annotation, "Value is not one of %v", strings.Join(allowedValues, ", "))
+		}
+	}
+
+	if enabled, _ := strconv.ParseBool(annotations[annotationLetsEncryptCertificate]); enabled {
+		hostnames := normalizeHostnames(annotations[annotationLetsEncryptCertificateHostnames])
+		if hostnames == "" {
+			fail(annotationLetsEncryptCertificateHostnames, "Hostnames are required when %v is true", annotationLetsEncryptCertificate)
+		}
+		for _, hostname := range strings.Split(hostnames, ",") {
+			if hostnames != "" && !validateHostname(hostname) {
+				fail(annotationLetsEncryptCertificateHostnames, "Hostname %v is invalid", hostname)
+			}
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateCopyToNamespacesMatching]; ok {
+		if _, err := regexp.Compile(value); err != nil {
+			fail(annotationLetsEncryptCertificateCopyToNamespacesMatching, "Value is not a valid regular expression: %v", err)
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateCopyNameTemplate]; ok {
+		if _, err := template.New("copyName").Parse(value); err != nil {
+			fail(annotationLetsEncryptCertificateCopyNameTemplate, "Value is not a valid template: %v", err)
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateCopyNameMapping]; ok {
+		for _, mapping := range strings.Split(value, ",") {
+			if parts := strings.SplitN(strings.TrimSpace(mapping), "=", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				fail(annotationLetsEncryptCertificateCopyNameMapping, "Mapping %v is not a namespace=name pair", mapping)
+			}
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateExpiresAfter]; ok {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				fail(annotationLetsEncryptCertificateExpiresAfter, "Value is not an RFC3339 timestamp or YYYY-MM-DD date")
+			}
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateRenewDaysBeforeExpiry]; ok {
+		if days, err := strconv.Atoi(value); err != nil || days <= 0 {
+			fail(annotationLetsEncryptCertificateRenewDaysBeforeExpiry, "Value is not a positive number of days")
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateACMEDirectoryURL]; ok {
+		if directoryURL, err := url.Parse(value); err != nil || directoryURL.Scheme != "https" || directoryURL.Host == "" {
+			fail(annotationLetsEncryptCertificateACMEDirectoryURL, "Value is not an https url")
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateRetryAfterMinutes]; ok {
+		if minutes, err := strconv.Atoi(value); err != nil || minutes <= 0 {
+			fail(annotationLetsEncryptCertificateRetryAfterMinutes, "Value is not a positive number of minutes")
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateKeepPrevious]; ok {
+		if keep, err := strconv.Atoi(value); err != nil || keep < 0 || keep > maxPreviousCertificates {
+			fail(annotationLetsEncryptCertificateKeepPrevious, "Value is not a number of certificates between 0 and %v", maxPreviousCertificates)
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateRollback]; ok {
+		if _, err := getRollbackPause(value); err != nil {
+			fail(annotationLetsEncryptCertificateRollback, "Value is not true or a positive duration like 48h")
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateAccount]; ok {
+		if _, err := getNamedAccountSecret(value); err != nil {
+			fail(annotationLetsEncryptCertificateAccount, "%v", err)
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateRenewalsPausedUntil]; ok {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			fail(annotationLetsEncryptCertificateRenewalsPausedUntil, "Value is not an RFC3339 timestamp")
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateStorage]; ok {
+		for _, storage := range strings.Split(value, ",") {
+			if !containsString([]string{"", "secret", "configmap", "file"}, strings.TrimSpace(storage)) {
+				fail(annotationLetsEncryptCertificateStorage, "Storage %v is not one of secret, configmap, file", storage)
+			}
+		}
+	}
+
+	if value, ok := annotations[annotationLetsEncryptCertificateSNICertificates]; ok {
+		certificates, err := parseSNICertificates(value)
+		if err != nil {
+			fail(annotationLetsEncryptCertificateSNICertificates, "%v", err)
+		}
+		names := []string{}
+		for name := range certificates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, hostname := range strings.Split(certificates[name], ",") {
+				if !validateHostname(hostname) {
+					fail(annotationLetsEncryptCertificateSNICertificates, "Hostname %v of sni certificate %v is invalid", hostname, name)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(annotationErrors, func(i, j int) bool {
+		return annotationErrors[i].Annotation < annotationErrors[j].Annotation
+	})
+
+	return annotationErrors
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateAnnotationsHandler validates the annotations posted as json object, for use by ci pipelines
+func validateAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	annotations := map[string]string{}
+	err := json.NewDecoder(r.Body).Decode(&annotations)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Body is not a json object with string values: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	annotationErrors := validateAnnotations(annotations)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(annotationValidationResult{
+		Valid:  len(annotationErrors) == 0,
+		Errors: annotationErrors,
+	})
+}
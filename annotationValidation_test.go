@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAnnotations(t *testing.T) {
+	t.Run("ReturnsNoErrorsForValidAnnotations", func(t *testing.T) {
+
+		annotations := map[string]string{
+			"estafette.io/letsencrypt-certificate":                          "true",
+			"estafette.io/letsencrypt-certificate-hostnames":                "estafette.io,www.estafette.io",
+			"estafette.io/letsencrypt-certificate-copy-to-all-namespaces":   "true",
+			"estafette.io/letsencrypt-certificate-output-families":          "tls",
+			"estafette.io/letsencrypt-certificate-expires-after":            "2026-12-31",
+			"estafette.io/letsencrypt-certificate-renew-days-before-expiry": "30",
+			"estafette.io/letsencrypt-certificate-retry-after-minutes":      "5",
+			"estafette.io/letsencrypt-certificate-key-type":                 "ec256",
+			"estafette.io/letsencrypt-certificate-storage":                  "secret,configmap",
+			"kubectl.kubernetes.io/last-applied-configuration":              "{}",
+		}
+
+		// act
+		annotationErrors := validateAnnotations(annotations)
+
+		assert.Equal(t, 0, len(annotationErrors))
+	})
+
+	t.Run("ReturnsErrorForEachInvalidAnnotation", func(t *testing.T) {
+
+		annotations := map[string]string{
+			"estafette.io/letsencrypt-certificate":                             "yes",
+			"estafette.io/letsencrypt-certificate-output-familes":              "tls",
+			"estafette.io/letsencrypt-certificate-expired-action":              "delete",
+			"estafette.io/letsencrypt-certificate-copy-to-namespaces-matching": "preview-(",
+		}
+
+		// act
+		annotationErrors := validateAnnotations(annotations)
+
+		assert.Equal(t, 4, len(annotationErrors))
+		assert.Equal(t, "estafette.io/letsencrypt-certificate", annotationErrors[0].Annotation)
+		assert.Equal(t, "yes", annotationErrors[0].Value)
+		assert.Equal(t, "estafette.io/letsencrypt-certificate-copy-to-namespaces-matching", annotationErrors[1].Annotation)
+		assert.Equal(t, "estafette.io/letsencrypt-certificate-expired-action", annotationErrors[2].Annotation)
+		assert.Equal(t, "estafette.io/letsencrypt-certificate-output-familes", annotationErrors[3].Annotation)
+		assert.Equal(t, "Annotation is unknown", annotationErrors[3].Message)
+	})
+
+	t.Run("ReturnsErrorIfEnabledWithoutHostnames", func(t *testing.T) {
+
+		annotations := map[string]string{
+			"estafette.io/letsencrypt-certificate": "true",
+		}
+
+		// act
+		annotationErrors := validateAnnotations(annotations)
+
+		assert.Equal(t, 1, len(annotationErrors))
+		assert.Equal(t, "estafette.io/letsencrypt-certificate-hostnames", annotationErrors[0].Annotation)
+	})
+
+	t.Run("ReturnsErrorForInvalidHostname", func(t *testing.T) {
+
+		annotations := map[string]string{
+			"estafette.io/letsencrypt-certificate":           "true",
+			"estafette.io/letsencrypt-certificate-hostnames": "estafette.io,localhost",
+		}
+
+		// act
+		annotationErrors := validateAnnotations(annotations)
+
+		assert.Equal(t, 1, len(annotationErrors))
+		assert.Equal(t, "Hostname localhost is invalid", annotationErrors[0].Message)
+	})
+
+	t.Run("ReturnsErrorForUnknownAccount", func(t *testing.T) {
+
+		*accountSecrets = "entity-a=letsencrypt-account-a"
+		defer func() { *accountSecrets = "" }()
+		annotations := map[string]string{
+			"estafette.io/letsencrypt-certificate-account": "entity-b",
+		}
+
+		// act
+		annotationErrors := validateAnnotations(annotations)
+
+		assert.Equal(t, 1, len(annotationErrors))
+		assert.Equal(t, "estafette.io/letsencrypt-certificate-account", annotationErrors[0].Annotation)
+	})
+}
+
+func TestValidateAnnotationsHandler(t *testing.T) {
+	t.Run("RespondsWithValidationResult", func(t *testing.T) {
+
+		request := httptest.NewRequest("POST", "/validate-annotations", strings.NewReader(`{"estafette.io/letsencrypt-certificate":"maybe"}`))
+		recorder := httptest.NewRecorder()
+
+		// act
+		validateAnnotationsHandler(recorder, request)
+
+		assert.Equal(t, 200, recorder.Code)
+		assert.Equal(t, `{"valid":false,"errors":[{"annotation":"estafette.io/letsencrypt-certificate","value":"maybe","message":"Value is not a boolean"}]}`+"\n", recorder.Body.String())
+	})
+
+	t.Run("RespondsWithBadRequestIfBodyIsNotAJsonObject", func(t *testing.T) {
+
+		request := httptest.NewRequest("POST", "/validate-annotations", strings.NewReader(`["estafette.io"]`))
+		recorder := httptest.NewRecorder()
+
+		// act
+		validateAnnotationsHandler(recorder, request)
+
+		assert.Equal(t, 400, recorder.Code)
+	})
+}
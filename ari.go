@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// renewalInfoGetter fetches the renewal information of a certificate from the ACME Renewal Information (ARI) endpoint of a CA, like the certifier of a lego client
+type renewalInfoGetter interface {
+	GetRenewalInfo(request certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error)
+}
+
+type renewalInfoEntry struct {
+	info      acme.RenewalInfoResponse
+	ok        bool
+	refreshAt time.Time
+}
+
+// renewalInfoCache keeps the renewal information per certificate, so the CA is only asked again once it's due for a refresh
+type renewalInfoCache struct {
+	mutex   sync.Mutex
+	entries map[string]renewalInfoEntry
+
+	// newGetter creates the client to ask the CA of a secret with; it's set at startup, without it renewal information isn't used
+	newGetter func(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalInfoGetter, error)
+}
+
+func newRenewalInfoCache() *renewalInfoCache {
+	return &renewalInfoCache{
+		entries: map[string]renewalInfoEntry{},
+	}
+}
+
+var renewalInfos = newRenewalInfoCache()
+
+const (
+	// lego doesn't pass on the Retry-After header of the ARI endpoint, so the renewal information is refreshed at the interval the ARI draft suggests by default
+	renewalInfoRefreshInterval = 6 * time.Hour
+	renewalInfoMinRetryAfter   = time.Hour
+	renewalInfoMaxRetryAfter   = 24 * time.Hour
+)
+
+// newLegoRenewalInfoGetter returns a factory for the certifier of the lego client certificates of a secret are obtained with, so renewal information is fetched with the same ACME directory and proxy
+func newLegoRenewalInfoGetter(ctx context.Context, kubeClientset *kubernetes.Clientset) func(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalInfoGetter, error) {
+	return func(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalInfoGetter, error) {
+		legoClient, _, err := newLegoClient(ctx, kubeClientset, secret, "renewal-info", desiredState)
+		if err != nil {
+			return nil, err
+		}
+
+		return legoClient.Certificate, nil
+	}
+}
+
+// Get returns the renewal information of the certificate from the ARI endpoint of the secret's CA; ok is false if the CA doesn't offer ARI or the lookup failed
+func (c *renewalInfoCache) Get(secret *v1.Secret, desiredState LetsEncryptCertificateState, leaf *x509.Certificate, now time.Time) (info acme.RenewalInfoResponse, ok bool) {
+	if c.newGetter == nil {
+		return info, false
+	}
+	certificateID, err := certificate.MakeARICertID(leaf)
+	if err != nil || len(leaf.AuthorityKeyId) == 0 {
+		return info, false
+	}
+
+	directoryURL := getStateACMEDirectoryURL(desiredState)
+	key := directoryURL + "|" + certificateID
+	c.mutex.Lock()
+	entry, found := c.entries[key]
+	c.mutex.Unlock()
+	if found && now.Before(entry.refreshAt) {
+		return entry.info, entry.ok
+	}
+
+	// the CA is asked without holding the lock, so a slow or unreachable endpoint doesn't hold up the renewal checks of other certificates; concurrent checks of the same certificate might both ask, which is harmless
+	entry = renewalInfoEntry{refreshAt: now.Add(renewalInfoMinRetryAfter)}
+	entry.info, err = c.fetch(secret, desiredState, leaf)
+	switch {
+	case errors.Is(err, api.ErrNoARI):
+		entry.refreshAt = now.Add(renewalInfoMaxRetryAfter)
+	case err != nil:
+		log.Warn().Err(err).Msgf("Fetching renewal information for certificate %v from %v failed", certificateID, directoryURL)
+	default:
+		entry.ok = true
+		entry.refreshAt = now.Add(renewalInfoRefreshInterval)
+	}
+	c.mutex.Lock()
+	c.entries[key] = entry
+	c.mutex.Unlock()
+
+	return entry.info, entry.ok
+}
+
+func (c *renewalInfoCache) fetch(secret *v1.Secret, desiredState LetsEncryptCertificateState, leaf *x509.Certificate) (info acme.RenewalInfoResponse, err error) {
+	getter, err := c.newGetter(secret, desiredState)
+	if err != nil {
+		return info, err
+	}
+
+	response, err := getter.GetRenewalInfo(certificate.RenewalInfoRequest{Cert: leaf})
+	if err != nil {
+		return info, err
+	}
+	if !response.SuggestedWindow.End.After(response.SuggestedWindow.Start) {
+		return info, fmt.Errorf("ARI endpoint returned an invalid window from %v to %v", response.SuggestedWindow.Start, response.SuggestedWindow.End)
+	}
+
+	return response.RenewalInfoResponse, nil
+}
+
+// getRenewalInfoTime returns a fixed time within the suggested window for the certificate, picked by its serial so renewals of many certificates are spread over the window
+func getRenewalInfoTime(info acme.RenewalInfoResponse, leaf *x509.Certificate) time.Time {
+	window := info.SuggestedWindow.End.Sub(info.SuggestedWindow.Start)
+	offset := time.Duration(hashKey(leaf.SerialNumber.String()) % uint64(window))
+
+	return info.SuggestedWindow.Start.Add(offset)
+}
+
+// getSecretRenewalInfoTime returns when to renew the certificate in the secret according to the renewal information of its CA; ok is false if ARI is disabled or unavailable for the certificate
+func getSecretRenewalInfoTime(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalTime time.Time, ok bool) {
+	if !*renewalInfoEnabled {
+		return renewalTime, false
+	}
+
+	certificateBytes, _ := getSecretCertificate(secret)
+	leaf, err := parseCertificate(certificateBytes)
+	if err != nil {
+		return renewalTime, false
+	}
+
+	info, ok := renewalInfos.Get(secret, desiredState, leaf, time.Now())
+	if !ok {
+		return renewalTime, false
+	}
+
+	return getRenewalInfoTime(info, leaf), true
+}
+
+// getSecretRenewalTime returns when the certificate in the secret is due for renewal: within the window suggested by the CA's renewal information if available, otherwise going by its validity period
+func getSecretRenewalTime(secret *v1.Secret, lastRenewed time.Time, desiredState LetsEncryptCertificateState) time.Time {
+	if renewalTime, ok := getSecretRenewalInfoTime(secret, desiredState); ok {
+		return renewalTime
+	}
+
+	return getRenewalTime(secret, lastRenewed, desiredState.RenewDaysBefore)
+}
+
+// isSecretDueForRenewal checks whether the certificate in the secret has reached its renewal time within the window suggested by the CA's renewal information, or otherwise going by its validity period
+func isSecretDueForRenewal(secret *v1.Secret, lastRenewed time.Time, desiredState LetsEncryptCertificateState) bool {
+	if renewalTime, ok := getSecretRenewalInfoTime(secret, desiredState); ok {
+		return time.Now().After(renewalTime)
+	}
+
+	return isDueForRenewal(secret, lastRenewed, desiredState.RenewDaysBefore)
+}
+
+// getReplacedCertificateID returns the ARI identifier of the certificate a new order for the secret replaces, so the CA knows it has been renewed and can exempt the order from its rate limits; it's empty if ARI is disabled, there's no unexpired certificate or it has been issued by another CA than the one the order goes to, like when promoting from staging
+func getReplacedCertificateID(secret *v1.Secret, desiredState LetsEncryptCertificateState, certificateBytes []byte, now time.Time) string {
+	if !*renewalInfoEnabled || len(certificateBytes) == 0 {
+		return ""
+	}
+	if getStateACMEDirectoryURL(getCurrentSecretState(secret)) != getStateACMEDirectoryURL(desiredState) {
+		return ""
+	}
+
+	leaf, err := parseCertificate(certificateBytes)
+	if err != nil || !now.Before(leaf.NotAfter) || len(leaf.AuthorityKeyId) == 0 {
+		return ""
+	}
+	certificateID, err := certificate.MakeARICertID(leaf)
+	if err != nil {
+		return ""
+	}
+
+	return certificateID
+}
+
+// isReplacementRejectedError checks whether the CA rejected an order because of the certificate it replaces, for example because another order has replaced it already
+func isReplacementRejectedError(err error) bool {
+	var problem *acme.ProblemDetails
+	return errors.As(err, &problem) && (problem.HTTPStatus == http.StatusConflict || strings.Contains(strings.ToLower(problem.Detail), "replace"))
+}
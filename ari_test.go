@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func generateTestRenewalInfoCertificate(t *testing.T, serial int64) *x509.Certificate {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(serial),
+		Subject:        pkix.Name{CommonName: "estafette.io"},
+		NotBefore:      time.Now().Add(-24 * time.Hour),
+		NotAfter:       time.Now().Add(89 * 24 * time.Hour),
+		AuthorityKeyId: []byte{0x69, 0x88, 0x5b, 0x6b, 0x87, 0x46, 0x40, 0x41, 0xe1, 0xb3, 0x7b, 0x84, 0x7b, 0xa0, 0xae, 0x2c, 0xde, 0x01, 0xc8, 0xd4},
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func TestGetRenewalInfoTime(t *testing.T) {
+	t.Run("ReturnsTimeWithinSuggestedWindow", func(t *testing.T) {
+
+		certificate := generateTestRenewalInfoCertificate(t, 12345)
+		start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		info := acme.RenewalInfoResponse{SuggestedWindow: acme.Window{Start: start, End: start.Add(48 * time.Hour)}}
+
+		// act
+		renewalTime := getRenewalInfoTime(info, certificate)
+
+		assert.False(t, renewalTime.Before(start))
+		assert.True(t, renewalTime.Before(start.Add(48*time.Hour)))
+	})
+}
+
+// renewalInfoGetterFunc fakes the certifier of a lego client
+type renewalInfoGetterFunc func(request certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error)
+
+func (f renewalInfoGetterFunc) GetRenewalInfo(request certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error) {
+	return f(request)
+}
+
+func TestRenewalInfoCacheGet(t *testing.T) {
+
+	productionState := LetsEncryptCertificateState{ACMEDirectoryURL: "https://acme-v02.api.letsencrypt.org/directory"}
+
+	t.Run("ReturnsSuggestedWindowFromClientForCAOfSecret", func(t *testing.T) {
+
+		requests := 0
+		cache := newRenewalInfoCache()
+		cache.newGetter = func(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalInfoGetter, error) {
+			assert.Equal(t, productionState, desiredState)
+			return renewalInfoGetterFunc(func(request certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error) {
+				requests++
+				return &certificate.RenewalInfoResponse{RenewalInfoResponse: acme.RenewalInfoResponse{SuggestedWindow: acme.Window{Start: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)}}}, nil
+			}), nil
+		}
+		leaf := generateTestRenewalInfoCertificate(t, 12345)
+		now := time.Now()
+
+		// act
+		info, ok := cache.Get(&v1.Secret{}, productionState, leaf, now)
+
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), info.SuggestedWindow.Start)
+		_, ok = cache.Get(&v1.Secret{}, productionState, leaf, now.Add(time.Hour))
+		assert.True(t, ok)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("ReturnsNotOkForADayIfCADoesNotOfferRenewalInfo", func(t *testing.T) {
+
+		requests := 0
+		cache := newRenewalInfoCache()
+		cache.newGetter = func(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalInfoGetter, error) {
+			return renewalInfoGetterFunc(func(request certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error) {
+				requests++
+				return nil, api.ErrNoARI
+			}), nil
+		}
+		leaf := generateTestRenewalInfoCertificate(t, 12345)
+		now := time.Now()
+
+		// act
+		_, ok := cache.Get(&v1.Secret{}, productionState, leaf, now)
+
+		assert.False(t, ok)
+		_, ok = cache.Get(&v1.Secret{}, productionState, leaf, now.Add(12*time.Hour))
+		assert.False(t, ok)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("ReturnsNotOkWithoutClient", func(t *testing.T) {
+
+		cache := newRenewalInfoCache()
+
+		// act
+		_, ok := cache.Get(&v1.Secret{}, productionState, generateTestRenewalInfoCertificate(t, 12345), time.Now())
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsCachedEntryWhileAnotherCertificateIsBeingFetched", func(t *testing.T) {
+
+		requested := make(chan struct{})
+		release := make(chan struct{})
+		cache := newRenewalInfoCache()
+		cache.newGetter = func(secret *v1.Secret, desiredState LetsEncryptCertificateState) (renewalInfoGetter, error) {
+			return renewalInfoGetterFunc(func(request certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error) {
+				close(requested)
+				<-release
+				return nil, api.ErrNoARI
+			}), nil
+		}
+		cachedCertificate := generateTestRenewalInfoCertificate(t, 12345)
+		cachedCertificateID, err := certificate.MakeARICertID(cachedCertificate)
+		assert.Nil(t, err)
+		now := time.Now()
+		cache.entries[getStateACMEDirectoryURL(productionState)+"|"+cachedCertificateID] = renewalInfoEntry{ok: true, refreshAt: now.Add(time.Hour)}
+		fetchedCertificate := generateTestRenewalInfoCertificate(t, 67890)
+		fetched := make(chan struct{})
+		go func() {
+			cache.Get(&v1.Secret{}, productionState, fetchedCertificate, now)
+			close(fetched)
+		}()
+		<-requested
+
+		// act
+		_, ok := cache.Get(&v1.Secret{}, productionState, cachedCertificate, now)
+
+		assert.True(t, ok)
+		close(release)
+		<-fetched
+	})
+}
+
+func TestGetReplacedCertificateID(t *testing.T) {
+
+	*renewalInfoEnabled = true
+	defer func() { *renewalInfoEnabled = false }()
+
+	certificate := generateTestRenewalInfoCertificate(t, 0x87654321)
+	certificateBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	productionSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		annotationLetsEncryptCertificateState: `{"acmeDirectoryUrl":"https://acme-v02.api.letsencrypt.org/directory"}`,
+	}}}
+	productionState := LetsEncryptCertificateState{ACMEDirectoryURL: "https://acme-v02.api.letsencrypt.org/directory"}
+
+	t.Run("ReturnsIDOfValidCertificateOfTheSameCA", func(t *testing.T) {
+
+		// act
+		certificateID := getReplacedCertificateID(productionSecret, productionState, certificateBytes, time.Now())
+
+		assert.Equal(t, "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE", certificateID)
+	})
+
+	t.Run("ReturnsEmptyStringIfCertificateIsFromAnotherCA", func(t *testing.T) {
+
+		stagingSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationLetsEncryptCertificateState: `{"acmeDirectoryUrl":"https://acme-staging-v02.api.letsencrypt.org/directory"}`,
+		}}}
+
+		// act
+		certificateID := getReplacedCertificateID(stagingSecret, productionState, certificateBytes, time.Now())
+
+		assert.Equal(t, "", certificateID)
+	})
+
+	t.Run("ReturnsEmptyStringIfCertificateHasExpired", func(t *testing.T) {
+
+		// act
+		certificateID := getReplacedCertificateID(productionSecret, productionState, certificateBytes, certificate.NotAfter.Add(time.Minute))
+
+		assert.Equal(t, "", certificateID)
+	})
+
+	t.Run("ReturnsEmptyStringWithoutCertificate", func(t *testing.T) {
+
+		// act
+		certificateID := getReplacedCertificateID(productionSecret, productionState, nil, time.Now())
+
+		assert.Equal(t, "", certificateID)
+	})
+
+	t.Run("ReturnsEmptyStringIfRenewalInfoIsDisabled", func(t *testing.T) {
+
+		*renewalInfoEnabled = false
+		defer func() { *renewalInfoEnabled = true }()
+
+		// act
+		certificateID := getReplacedCertificateID(productionSecret, productionState, certificateBytes, time.Now())
+
+		assert.Equal(t, "", certificateID)
+	})
+}
+
+func TestIsReplacementRejectedError(t *testing.T) {
+	t.Run("ReturnsTrueForConflict", func(t *testing.T) {
+
+		err := fmt.Errorf("acme: error: 409: %w", &acme.ProblemDetails{HTTPStatus: 409, Type: "urn:ietf:params:acme:error:conflict", Detail: "cannot indicate an order replaces a certificate which already has a replacement order"})
+
+		// act
+		rejected := isReplacementRejectedError(err)
+
+		assert.True(t, rejected)
+	})
+
+	t.Run("ReturnsFalseForOtherErrors", func(t *testing.T) {
+
+		// act
+		rejected := isReplacementRejectedError(errors.New("timeout"))
+
+		assert.False(t, rejected)
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// lastChangedBy records which initiator of which controller pod made the last change to a managed or copied secret
+type lastChangedBy struct {
+	Initiator string `json:"initiator"`
+	Pod       string `json:"pod"`
+	Version   string `json:"version"`
+	Time      string `json:"time"`
+}
+
+// setLastChangedBy stamps the secret with the initiator, pod and version of this controller before it's written; failing to do so doesn't fail the write
+func setLastChangedBy(secret *v1.Secret, initiator string) {
+	value, err := json.Marshal(lastChangedBy{
+		Initiator: initiator,
+		Pod:       os.Getenv("HOSTNAME"),
+		Version:   version,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Recording last change failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[annotationLetsEncryptCertificateLastChangedBy] = string(value)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetLastChangedBy(t *testing.T) {
+	t.Run("RecordsInitiatorAndPod", func(t *testing.T) {
+
+		os.Setenv("HOSTNAME", "estafette-letsencrypt-certificate-0")
+		defer os.Unsetenv("HOSTNAME")
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+
+		// act
+		setLastChangedBy(secret, "watcher:MODIFIED")
+
+		var changedBy lastChangedBy
+		err := json.Unmarshal([]byte(secret.Annotations[annotationLetsEncryptCertificateLastChangedBy]), &changedBy)
+		assert.Nil(t, err)
+		assert.Equal(t, "watcher:MODIFIED", changedBy.Initiator)
+		assert.Equal(t, "estafette-letsencrypt-certificate-0", changedBy.Pod)
+		assert.NotEmpty(t, changedBy.Time)
+	})
+
+	t.Run("OverwritesEarlierChange", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace", Annotations: map[string]string{annotationLetsEncryptCertificateLastChangedBy: `{"initiator":"poller"}`}}}
+
+		// act
+		setLastChangedBy(secret, "api")
+
+		var changedBy lastChangedBy
+		err := json.Unmarshal([]byte(secret.Annotations[annotationLetsEncryptCertificateLastChangedBy]), &changedBy)
+		assert.Nil(t, err)
+		assert.Equal(t, "api", changedBy.Initiator)
+	})
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2/google"
+	v1 "k8s.io/api/core/v1"
+)
+
+var errBackupNotFound = errors.New("Backup not found")
+
+// minBackupEncryptionKeyBytes is the minimum length of the decoded --backup-encryption-key, so backups are never encrypted with a guessable key
+const minBackupEncryptionKeyBytes = 32
+
+// certificateBackup is the content stored (encrypted) in the backup backend for each secret
+type certificateBackup struct {
+	Hostnames         string `json:"hostnames"`
+	LastRenewed       string `json:"lastRenewed"`
+	Domain            string `json:"domain"`
+	Certificate       []byte `json:"certificate"`
+	PrivateKey        []byte `json:"privateKey"`
+	IssuerCertificate []byte `json:"issuerCertificate,omitempty"`
+}
+
+// backupStore is the interface for the backends that can hold certificate backups
+type backupStore interface {
+	Store(key string, data []byte) error
+	Load(key string) ([]byte, error)
+}
+
+// fileBackupStore stores backups as files in a directory, for example a mounted persistent volume
+type fileBackupStore struct {
+	directory string
+}
+
+func (s *fileBackupStore) Store(key string, data []byte) error {
+	err := os.MkdirAll(s.directory, 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(s.directory, key), data, 0600)
+}
+
+func (s *fileBackupStore) Load(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.directory, key))
+	if os.IsNotExist(err) {
+		return nil, errBackupNotFound
+	}
+
+	return data, err
+}
+
+// vaultBackupStore stores backups in a Vault KV version 2 secrets engine
+type vaultBackupStore struct {
+	address string
+	token   string
+	path    string
+}
+
+func (s *vaultBackupStore) Store(key string, data []byte) error {
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{
+			"backup": base64.StdEncoding.EncodeToString(data),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.do("POST", key, bytes.NewReader(requestBody))
+	return err
+}
+
+func (s *vaultBackupStore) Load(key string) ([]byte, error) {
+	responseBody, err := s.do("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data struct {
+			Data struct {
+				Backup string `json:"backup"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(response.Data.Data.Backup)
+}
+
+func (s *vaultBackupStore) do(verb, key string, body io.Reader) ([]byte, error) {
+	request, err := http.NewRequest(verb, fmt.Sprintf("%v/v1/%v/%v", s.address, s.path, key), body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("X-Vault-Token", s.token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return readBackupResponse(response, "Vault", verb, key)
+}
+
+// s3BackupStore stores backups as objects in an AWS S3 bucket, or in a bucket of an S3 compatible store if endpoint is set
+type s3BackupStore struct {
+	bucket   string
+	prefix   string
+	endpoint string
+}
+
+func (s *s3BackupStore) Store(key string, data []byte) error {
+	_, err := s.do("PUT", key, data)
+	return err
+}
+
+func (s *s3BackupStore) Load(key string) ([]byte, error) {
+	return s.do("GET", key, nil)
+}
+
+func (s *s3BackupStore) do(verb, key string, body []byte) ([]byte, error) {
+	ctx := context.Background()
+
+	// use the same credential chain as the route53 dns provider: environment variables, shared config files or instance roles
+	config, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config.Region == "" {
+		return nil, fmt.Errorf("AWS_REGION should be set for the s3 backup backend")
+	}
+	credentials, err := config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, verb, s.getObjectURL(config.Region, key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := sha256.Sum256(body)
+	hexPayloadHash := hex.EncodeToString(payloadHash[:])
+	request.Header.Set("X-Amz-Content-Sha256", hexPayloadHash)
+
+	// s3 expects the object path to be escaped once only
+	signer := v4.NewSigner(func(options *v4.SignerOptions) { options.DisableURIPathEscaping = true })
+	err = signer.SignHTTP(ctx, credentials, request, hexPayloadHash, "s3", config.Region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return readBackupResponse(response, "S3", verb, key)
+}
+
+func (s *s3BackupStore) getObjectURL(region, key string) string {
+	objectName := path.Join(s.prefix, key)
+	if s.endpoint != "" {
+		// S3 compatible stores don't all support virtual-hosted-style urls, but do support path-style urls
+		return fmt.Sprintf("%v/%v/%v", strings.TrimSuffix(s.endpoint, "/"), s.bucket, objectName)
+	}
+
+	return fmt.Sprintf("https://%v.s3.%v.amazonaws.com/%v", s.bucket, region, objectName)
+}
+
+const gcsReadWriteScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsBackupStore stores backups as objects in a Google Cloud Storage bucket, authenticated with the application default credentials
+type gcsBackupStore struct {
+	bucket  string
+	prefix  string
+	baseURL string
+	client  *http.Client
+}
+
+func (s *gcsBackupStore) Store(key string, data []byte) error {
+	_, err := s.do("POST", key, fmt.Sprintf("%v/upload/storage/v1/b/%v/o?uploadType=media&name=%v", s.baseURL, url.PathEscape(s.bucket), url.QueryEscape(path.Join(s.prefix, key))), data)
+	return err
+}
+
+func (s *gcsBackupStore) Load(key string) ([]byte, error) {
+	return s.do("GET", key, fmt.Sprintf("%v/storage/v1/b/%v/o/%v?alt=media", s.baseURL, url.PathEscape(s.bucket), url.PathEscape(path.Join(s.prefix, key))), nil)
+}
+
+func (s *gcsBackupStore) do(verb, key, requestURL string, body []byte) ([]byte, error) {
+	client := s.client
+	if client == nil {
+		var err error
+		client, err = google.DefaultClient(context.Background(), gcsReadWriteScope)
+		if err != nil {
+			return nil, err
+		}
+		client.Timeout = 30 * time.Second
+	}
+
+	request, err := http.NewRequest(verb, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return readBackupResponse(response, "Google Cloud Storage", verb, key)
+}
+
+// readBackupResponse returns the body of a successful response of a backup backend, or errBackupNotFound if the backup doesn't exist
+func readBackupResponse(response *http.Response, backend, verb, key string) ([]byte, error) {
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errBackupNotFound
+	}
+	if response.StatusCode >= 300 {
+		return nil, fmt.Errorf("%v responded with status code %v for %v %v", backend, response.StatusCode, verb, key)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// getBackupStore returns the backend configured with the --backup-* flags, or nil if backups are disabled
+func getBackupStore() backupStore {
+	switch *backupBackend {
+	case "file":
+		return &fileBackupStore{directory: *backupPath}
+	case "vault":
+		return &vaultBackupStore{address: *backupVaultAddress, token: *backupVaultToken, path: *backupPath}
+	case "s3":
+		return &s3BackupStore{bucket: *backupBucket, prefix: *backupPath, endpoint: *backupS3Endpoint}
+	case "gcs":
+		return &gcsBackupStore{bucket: *backupBucket, prefix: *backupPath, baseURL: "https://storage.googleapis.com"}
+	}
+
+	return nil
+}
+
+// getBackupKey returns a stable, path-safe key for a secret
+func getBackupKey(secret *v1.Secret) string {
+	return fmt.Sprintf("%v.%v", secret.Namespace, secret.Name)
+}
+
+// validateBackupFlags checks the --backup-* flags whenever a backup backend is configured
+func validateBackupFlags() error {
+	if *backupBackend == "" {
+		return nil
+	}
+	if _, err := getBackupEncryptionKey(); err != nil {
+		return fmt.Errorf("Flag --backup-encryption-key is invalid: %w", err)
+	}
+	if (*backupBackend == "s3" || *backupBackend == "gcs") && *backupBucket == "" {
+		return fmt.Errorf("Flag --backup-bucket should be set when --backup-backend is %v", *backupBackend)
+	}
+
+	return nil
+}
+
+// getBackupEncryptionKey decodes --backup-encryption-key and refuses keys that are too short to encrypt backups with
+func getBackupEncryptionKey() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(*backupEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < minBackupEncryptionKeyBytes {
+		return nil, fmt.Errorf("Key should be at least %v bytes before base64 encoding, but is %v bytes", minBackupEncryptionKeyBytes, len(key))
+	}
+
+	return key, nil
+}
+
+func getBackupCipher() (cipher.AEAD, error) {
+	key, err := getBackupEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// derive a 256 bits key so keys longer than 32 bytes can be used as well
+	derivedKey := sha256.Sum256(key)
+	block, err := aes.NewCipher(derivedKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encryptBackup(plaintext []byte) ([]byte, error) {
+	gcm, err := getBackupCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return []byte(hex.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil))), nil
+}
+
+func decryptBackup(ciphertext []byte) ([]byte, error) {
+	gcm, err := getBackupCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(string(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("Backup is too short to be decrypted")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// backupCertificates stores an encrypted copy of the certificates in the backup backend; failures are logged but don't fail the renewal
+func backupCertificates(secret *v1.Secret, state LetsEncryptCertificateState, certificates *certificate.Resource, initiator string) {
+	store := getBackupStore()
+	if store == nil {
+		return
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Backing up certificates to %v backend...", initiator, secret.Name, secret.Namespace, *backupBackend)
+
+	plaintext, err := json.Marshal(certificateBackup{
+		Hostnames:         state.Hostnames,
+		LastRenewed:       state.LastRenewed,
+		Domain:            certificates.Domain,
+		Certificate:       certificates.Certificate,
+		PrivateKey:        certificates.PrivateKey,
+		IssuerCertificate: certificates.IssuerCertificate,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Backing up certificates failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	ciphertext, err := encryptBackup(plaintext)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Backing up certificates failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	err = store.Store(getBackupKey(secret), ciphertext)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Backing up certificates failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+}
+
+// restoreCertificatesFromBackup returns the backed up certificates for a secret without certificate data, as long as they're for the same hostnames and not due for renewal
+func restoreCertificatesFromBackup(secret, dataSecret *v1.Secret, desiredState LetsEncryptCertificateState, initiator string) (certificates *certificate.Resource, lastRenewed time.Time, ok bool) {
+	store := getBackupStore()
+	if store == nil {
+		return nil, lastRenewed, false
+	}
+
+	if certificate, _ := getSecretCertificate(dataSecret); certificate != nil {
+		return nil, lastRenewed, false
+	}
+
+	ciphertext, err := store.Load(getBackupKey(secret))
+	if err != nil {
+		if err != errBackupNotFound {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Loading certificates backup failed", initiator, secret.Name, secret.Namespace)
+		}
+		return nil, lastRenewed, false
+	}
+
+	plaintext, err := decryptBackup(ciphertext)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Decrypting certificates backup failed", initiator, secret.Name, secret.Namespace)
+		return nil, lastRenewed, false
+	}
+
+	var backup certificateBackup
+	err = json.Unmarshal(plaintext, &backup)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Unmarshalling certificates backup failed", initiator, secret.Name, secret.Namespace)
+		return nil, lastRenewed, false
+	}
+
+	lastRenewed, err = time.Parse(time.RFC3339, backup.LastRenewed)
+	if err != nil || backup.Hostnames != desiredState.Hostnames || !time.Now().Before(getRenewalTimeForCertificate(backup.Certificate, lastRenewed, desiredState.RenewDaysBefore)) {
+		return nil, lastRenewed, false
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Restoring certificates from %v backend...", initiator, secret.Name, secret.Namespace, *backupBackend)
+
+	return &certificate.Resource{
+		Domain:            backup.Domain,
+		Certificate:       backup.Certificate,
+		PrivateKey:        backup.PrivateKey,
+		IssuerCertificate: backup.IssuerCertificate,
+	}, lastRenewed, true
+}
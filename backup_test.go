@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEncryptBackup(t *testing.T) {
+	t.Run("ReturnsCiphertextThatDecryptsToPlaintext", func(t *testing.T) {
+
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		plaintext := []byte(`{"hostnames":"estafette.io"}`)
+
+		// act
+		ciphertext, err := encryptBackup(plaintext)
+
+		assert.Nil(t, err)
+		assert.NotEqual(t, plaintext, ciphertext)
+		decrypted, err := decryptBackup(ciphertext)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, decrypted)
+	})
+
+	t.Run("ReturnsErrorWhenDecryptingWithAnotherKey", func(t *testing.T) {
+
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		ciphertext, _ := encryptBackup([]byte(`{"hostnames":"estafette.io"}`))
+		*backupEncryptionKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+
+		// act
+		_, err := decryptBackup(ciphertext)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestValidateBackupFlags(t *testing.T) {
+	t.Run("ReturnsNilIfNoBackupBackendIsSet", func(t *testing.T) {
+
+		*backupBackend = ""
+		*backupEncryptionKey = ""
+
+		// act
+		err := validateBackupFlags()
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfBackupBackendIsSetWithoutEncryptionKey", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupEncryptionKey = ""
+		defer func() { *backupBackend = "" }()
+
+		// act
+		err := validateBackupFlags()
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfEncryptionKeyIsShorterThan32Bytes", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupEncryptionKey = "c2VjcmV0IGtleQ=="
+		defer func() { *backupBackend = "" }()
+
+		// act
+		err := validateBackupFlags()
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfEncryptionKeyIsNotBase64", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupEncryptionKey = "not base64 at all, but long enough to be a key"
+		defer func() { *backupBackend = "" }()
+
+		// act
+		err := validateBackupFlags()
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfBucketBackendIsSetWithoutBucket", func(t *testing.T) {
+
+		*backupBackend = "s3"
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		*backupBucket = ""
+		defer func() { *backupBackend = "" }()
+
+		// act
+		err := validateBackupFlags()
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsNilIfEncryptionKeyIsAtLeast32Bytes", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		defer func() { *backupBackend = "" }()
+
+		// act
+		err := validateBackupFlags()
+
+		assert.Nil(t, err)
+	})
+}
+
+func TestRestoreCertificatesFromBackup(t *testing.T) {
+
+	storeTestBackup := func(t *testing.T, secret *v1.Secret, backup certificateBackup) {
+		plaintext, err := json.Marshal(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := encryptBackup(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = getBackupStore().Store(getBackupKey(secret), ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("RestoresCertificateThatIsNotDueForRenewalByItsValidity", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupPath = t.TempDir()
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		*daysBeforeRenewal = 60
+		defer func() { *backupBackend = "" }()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "estafette-tls", Namespace: "estafette"}}
+		certificateBytes := generateTestCertificate(t, time.Now().Add(-10*24*time.Hour), time.Now().Add(80*24*time.Hour))
+		// the state's last renewal is older than the certificate itself, for example after a manual reissue
+		storeTestBackup(t, secret, certificateBackup{Hostnames: "estafette.io", LastRenewed: time.Now().Add(-70 * 24 * time.Hour).Format(time.RFC3339), Certificate: certificateBytes})
+
+		// act
+		certificates, _, ok := restoreCertificatesFromBackup(secret, &v1.Secret{}, LetsEncryptCertificateState{Hostnames: "estafette.io"}, "test")
+
+		assert.True(t, ok)
+		assert.Equal(t, certificateBytes, certificates.Certificate)
+	})
+
+	t.Run("DoesNotRestoreCertificateThatIsDueForRenewalByItsValidity", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupPath = t.TempDir()
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		*daysBeforeRenewal = 60
+		defer func() { *backupBackend = "" }()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "estafette-tls", Namespace: "estafette"}}
+		certificateBytes := generateTestCertificate(t, time.Now().Add(-80*24*time.Hour), time.Now().Add(10*24*time.Hour))
+		storeTestBackup(t, secret, certificateBackup{Hostnames: "estafette.io", LastRenewed: time.Now().Add(-24 * time.Hour).Format(time.RFC3339), Certificate: certificateBytes})
+
+		// act
+		_, _, ok := restoreCertificatesFromBackup(secret, &v1.Secret{}, LetsEncryptCertificateState{Hostnames: "estafette.io"}, "test")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("DoesNotRestoreCertificateForOtherHostnames", func(t *testing.T) {
+
+		*backupBackend = "file"
+		*backupPath = t.TempDir()
+		*backupEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+		*daysBeforeRenewal = 60
+		defer func() { *backupBackend = "" }()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "estafette-tls", Namespace: "estafette"}}
+		certificateBytes := generateTestCertificate(t, time.Now().Add(-10*24*time.Hour), time.Now().Add(80*24*time.Hour))
+		storeTestBackup(t, secret, certificateBackup{Hostnames: "estafette.io", LastRenewed: time.Now().Add(-10 * 24 * time.Hour).Format(time.RFC3339), Certificate: certificateBytes})
+
+		// act
+		_, _, ok := restoreCertificatesFromBackup(secret, &v1.Secret{}, LetsEncryptCertificateState{Hostnames: "estafette.io,www.estafette.io"}, "test")
+
+		assert.False(t, ok)
+	})
+}
+
+// newObjectStoreServer fakes the object api of a bucket, keeping objects in memory by the path they're requested at
+func newObjectStoreServer(t *testing.T, handle func(r *http.Request) (objectPath string, ok bool)) (*httptest.Server, map[string][]byte) {
+	mutex := sync.Mutex{}
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		objectPath, ok := handle(r)
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == "GET" {
+			data, exists := objects[objectPath]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		objects[objectPath] = data
+	}))
+	t.Cleanup(server.Close)
+
+	return server, objects
+}
+
+func TestS3BackupStore(t *testing.T) {
+
+	setTestAWSEnvironment := func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("AWS_REGION", "eu-west-1")
+		t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	}
+
+	t.Run("StoresAndLoadsSignedRequestsAtPathStyleUrlOfEndpoint", func(t *testing.T) {
+
+		setTestAWSEnvironment(t)
+		server, objects := newObjectStoreServer(t, func(r *http.Request) (string, bool) {
+			return r.URL.Path, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") && strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request")
+		})
+		store := &s3BackupStore{bucket: "backups", prefix: "letsencrypt", endpoint: server.URL}
+
+		// act
+		err := store.Store("estafette.estafette-tls", []byte("ciphertext"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("ciphertext"), objects["/backups/letsencrypt/estafette.estafette-tls"])
+		data, err := store.Load("estafette.estafette-tls")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("ciphertext"), data)
+	})
+
+	t.Run("ReturnsErrBackupNotFoundForMissingObject", func(t *testing.T) {
+
+		setTestAWSEnvironment(t)
+		server, _ := newObjectStoreServer(t, func(r *http.Request) (string, bool) { return r.URL.Path, true })
+		store := &s3BackupStore{bucket: "backups", endpoint: server.URL}
+
+		// act
+		_, err := store.Load("estafette.estafette-tls")
+
+		assert.Equal(t, errBackupNotFound, err)
+	})
+
+	t.Run("ReturnsVirtualHostedStyleUrlWithoutEndpoint", func(t *testing.T) {
+
+		store := &s3BackupStore{bucket: "backups", prefix: "letsencrypt"}
+
+		// act
+		objectURL := store.getObjectURL("eu-west-1", "estafette.estafette-tls")
+
+		assert.Equal(t, "https://backups.s3.eu-west-1.amazonaws.com/letsencrypt/estafette.estafette-tls", objectURL)
+	})
+}
+
+func TestGCSBackupStore(t *testing.T) {
+	t.Run("StoresAndLoadsObjectsWithPrefixedName", func(t *testing.T) {
+
+		server, objects := newObjectStoreServer(t, func(r *http.Request) (string, bool) {
+			if r.Method == "POST" {
+				return r.URL.Query().Get("name"), r.URL.Path == "/upload/storage/v1/b/backups/o" && r.URL.Query().Get("uploadType") == "media"
+			}
+			return strings.TrimPrefix(r.URL.Path, "/storage/v1/b/backups/o/"), r.URL.Query().Get("alt") == "media"
+		})
+		store := &gcsBackupStore{bucket: "backups", prefix: "letsencrypt", baseURL: server.URL, client: server.Client()}
+
+		// act
+		err := store.Store("estafette.estafette-tls", []byte("ciphertext"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("ciphertext"), objects["letsencrypt/estafette.estafette-tls"])
+		data, err := store.Load("estafette.estafette-tls")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("ciphertext"), data)
+	})
+
+	t.Run("ReturnsErrBackupNotFoundForMissingObject", func(t *testing.T) {
+
+		server, _ := newObjectStoreServer(t, func(r *http.Request) (string, bool) { return r.URL.Path, true })
+		store := &gcsBackupStore{bucket: "backups", baseURL: server.URL, client: server.Client()}
+
+		// act
+		_, err := store.Load("estafette.estafette-tls")
+
+		assert.Equal(t, errBackupNotFound, err)
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getSecretIssuerCertificates returns the issuer chain of the certificate in the secret, from the .issuer.crt item or else the certificates following the leaf in the bundle
+func getSecretIssuerCertificates(secret *v1.Secret) []*x509.Certificate {
+	for _, family := range []string{"tls", "ssl"} {
+		if issuerBytes, ok := secret.Data[family+".issuer.crt"]; ok && len(issuerBytes) > 0 {
+			return parseCertificates(issuerBytes)
+		}
+	}
+
+	certificateBytes, _ := getSecretCertificate(secret)
+	certificates := parseCertificates(certificateBytes)
+	if len(certificates) < 2 {
+		return []*x509.Certificate{}
+	}
+
+	return certificates[1:]
+}
+
+// parseCertificates returns all certificates in the pem encoded data, skipping blocks that can't be parsed
+func parseCertificates(data []byte) []*x509.Certificate {
+	certificates := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certificates
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certificates = append(certificates, certificate)
+	}
+}
+
+// getCABundle returns the deduplicated issuer chains of the certificates managed in the secrets as a pem bundle, leaving out expired issuers; the order is stable so the bundle only changes when the issuers do
+func getCABundle(secrets []v1.Secret, now time.Time) []byte {
+	issuers := map[[sha256.Size]byte]*x509.Certificate{}
+	for _, secret := range secrets {
+		if _, ok := secret.Annotations[annotationLetsEncryptCertificateState]; !ok {
+			continue
+		}
+		for _, issuer := range getSecretIssuerCertificates(&secret) {
+			if now.After(issuer.NotAfter) {
+				continue
+			}
+			issuers[sha256.Sum256(issuer.Raw)] = issuer
+		}
+	}
+
+	fingerprints := make([][sha256.Size]byte, 0, len(issuers))
+	for fingerprint := range issuers {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Slice(fingerprints, func(i, j int) bool {
+		subjectI, subjectJ := issuers[fingerprints[i]].Subject.String(), issuers[fingerprints[j]].Subject.String()
+		if subjectI != subjectJ {
+			return subjectI < subjectJ
+		}
+		return bytes.Compare(fingerprints[i][:], fingerprints[j][:]) < 0
+	})
+
+	bundle := []byte{}
+	for _, fingerprint := range fingerprints {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuers[fingerprint].Raw})...)
+	}
+
+	return bundle
+}
+
+// writeCABundleConfigMaps stores the issuer chains of all managed certificates as ca.crt in the config map with the given name in the controller's namespace and in the namespaces matching namespacesRegex, since workloads can only mount config maps from their own namespace; it continues with the other namespaces when writing to one fails
+func writeCABundleConfigMaps(ctx context.Context, kubeClientset *kubernetes.Clientset, name, namespacesRegex string, secrets []v1.Secret) error {
+	namespaces, err := getCABundleNamespaces(ctx, kubeClientset, namespacesRegex)
+	if err != nil {
+		return err
+	}
+
+	bundle := string(getCABundle(secrets, time.Now()))
+	bundleErrors := []error{}
+	for _, namespace := range namespaces {
+		err = writeCABundleConfigMap(ctx, kubeClientset, name, namespace, bundle)
+		if err != nil {
+			bundleErrors = append(bundleErrors, fmt.Errorf("namespace %v: %w", namespace, err))
+		}
+	}
+
+	if len(bundleErrors) > 0 {
+		return fmt.Errorf("Writing ca bundle config map %v failed for %v out of %v namespaces: %w", name, len(bundleErrors), len(namespaces), utilerrors.NewAggregate(bundleErrors))
+	}
+
+	return nil
+}
+
+// getCABundleNamespaces returns the controller's namespace followed by the other namespaces matching namespacesRegex, leaving out the namespaces excluded from copies
+func getCABundleNamespaces(ctx context.Context, kubeClientset *kubernetes.Clientset, namespacesRegex string) ([]string, error) {
+	namespaces := []string{getCurrentNamespace()}
+	if namespacesRegex == "" {
+		return namespaces, nil
+	}
+
+	namespaceList, err := kubeClientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		names = append(names, namespace.Name)
+	}
+
+	return append(namespaces, getMatchingCABundleNamespaces(names, namespaces[0], namespacesRegex)...), nil
+}
+
+// getMatchingCABundleNamespaces returns the namespaces other than the controller's namespace that match namespacesRegex and aren't excluded from copies
+func getMatchingCABundleNamespaces(namespaces []string, currentNamespace, namespacesRegex string) []string {
+	matchingNamespaces := []string{}
+	for _, namespace := range namespaces {
+		if namespace == currentNamespace || isExcludedFromCopy(namespace) {
+			continue
+		}
+		matches, err := regexp.MatchString(namespacesRegex, namespace)
+		if err != nil || !matches {
+			continue
+		}
+		matchingNamespaces = append(matchingNamespaces, namespace)
+	}
+
+	return matchingNamespaces
+}
+
+// writeCABundleConfigMap stores the bundle as ca.crt in the config map with the given name in the namespace; a config map with that name that hasn't been created for the ca bundle is left alone
+func writeCABundleConfigMap(ctx context.Context, kubeClientset *kubernetes.Clientset, name, namespace, bundle string) error {
+	data := map[string]string{"ca.crt": bundle}
+
+	configMap, err := kubeClientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Info().Msgf("Creating ca bundle config map %v.%v...", name, namespace)
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateManagedConfigMap: "ca-bundle",
+				},
+			},
+			Data: data,
+		}
+		_, err = kubeClientset.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !isManagedConfigMap(configMap, "ca-bundle") {
+		return fmt.Errorf("Config map %v.%v already exists and hasn't been created for the ca bundle, not overwriting it", name, namespace)
+	}
+
+	if configMap.Data["ca.crt"] == data["ca.crt"] {
+		return nil
+	}
+
+	log.Info().Msgf("Updating ca bundle config map %v.%v with changed issuers...", name, namespace)
+	configMap.Data = data
+	_, err = kubeClientset.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
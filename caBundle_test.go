@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSecretIssuerCertificates(t *testing.T) {
+	t.Run("ReturnsCertificatesOfIssuerItem", func(t *testing.T) {
+
+		issuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		secret := &v1.Secret{Data: map[string][]byte{"tls.issuer.crt": issuer}}
+
+		// act
+		issuers := getSecretIssuerCertificates(secret)
+
+		assert.Equal(t, 1, len(issuers))
+	})
+
+	t.Run("ReturnsCertificatesFollowingLeafIfSecretHasNoIssuerItem", func(t *testing.T) {
+
+		leaf := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		issuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": bytes.Join([][]byte{leaf, issuer}, []byte{})}}
+
+		// act
+		issuers := getSecretIssuerCertificates(secret)
+
+		assert.Equal(t, 1, len(issuers))
+		assert.Equal(t, parseCertificates(issuer)[0].Raw, issuers[0].Raw)
+	})
+
+	t.Run("ReturnsNoCertificatesForLeafOnly", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))}}
+
+		// act
+		issuers := getSecretIssuerCertificates(secret)
+
+		assert.Equal(t, 0, len(issuers))
+	})
+}
+
+func TestGetCABundle(t *testing.T) {
+
+	newManagedSecretWithIssuer := func(issuer []byte) v1.Secret {
+		return v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annotationLetsEncryptCertificateState: "{}"}},
+			Data:       map[string][]byte{"tls.issuer.crt": issuer},
+		}
+	}
+
+	t.Run("DeduplicatesIssuersOfSecrets", func(t *testing.T) {
+
+		issuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		otherIssuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		secrets := []v1.Secret{newManagedSecretWithIssuer(issuer), newManagedSecretWithIssuer(otherIssuer), newManagedSecretWithIssuer(issuer)}
+
+		// act
+		bundle := getCABundle(secrets, time.Now())
+
+		assert.Equal(t, 2, len(parseCertificates(bundle)))
+	})
+
+	t.Run("ReturnsSameBundleRegardlessOfSecretOrder", func(t *testing.T) {
+
+		issuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		otherIssuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+
+		// act
+		bundle := getCABundle([]v1.Secret{newManagedSecretWithIssuer(issuer), newManagedSecretWithIssuer(otherIssuer)}, time.Now())
+		reversedBundle := getCABundle([]v1.Secret{newManagedSecretWithIssuer(otherIssuer), newManagedSecretWithIssuer(issuer)}, time.Now())
+
+		assert.Equal(t, bundle, reversedBundle)
+	})
+
+	t.Run("LeavesOutExpiredIssuers", func(t *testing.T) {
+
+		issuer := generateTestCertificate(t, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour))
+
+		// act
+		bundle := getCABundle([]v1.Secret{newManagedSecretWithIssuer(issuer)}, time.Now())
+
+		assert.Equal(t, 0, len(bundle))
+	})
+
+	t.Run("LeavesOutSecretsThatAreNotManaged", func(t *testing.T) {
+
+		issuer := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		secret := v1.Secret{Data: map[string][]byte{"tls.issuer.crt": issuer}}
+
+		// act
+		bundle := getCABundle([]v1.Secret{secret}, time.Now())
+
+		assert.Equal(t, 0, len(bundle))
+	})
+}
+
+func TestGetMatchingCABundleNamespaces(t *testing.T) {
+	t.Run("ReturnsNamespacesMatchingRegex", func(t *testing.T) {
+
+		// act
+		namespaces := getMatchingCABundleNamespaces([]string{"default", "team-a", "team-b", "kube-system"}, "estafette", "^team-")
+
+		assert.Equal(t, []string{"team-a", "team-b"}, namespaces)
+	})
+
+	t.Run("LeavesOutControllerNamespace", func(t *testing.T) {
+
+		// act
+		namespaces := getMatchingCABundleNamespaces([]string{"estafette", "team-a"}, "estafette", ".*")
+
+		assert.Equal(t, []string{"team-a"}, namespaces)
+	})
+
+	t.Run("ReturnsNoNamespacesForInvalidRegex", func(t *testing.T) {
+
+		// act
+		namespaces := getMatchingCABundleNamespaces([]string{"team-a"}, "estafette", "team-(")
+
+		assert.Equal(t, []string{}, namespaces)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getCanaryState returns the state to obtain the canary certificate with
+func getCanaryState(hostname string, staging bool) LetsEncryptCertificateState {
+	state := LetsEncryptCertificateState{
+		Enabled:     "true",
+		Hostnames:   hostname,
+		Environment: environmentProduction,
+	}
+	if staging {
+		state.Environment = environmentStaging
+	}
+
+	return state
+}
+
+// getCurrentNamespace returns the namespace the controller runs in, from the mounted service account
+func getCurrentNamespace() string {
+	namespace, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(namespace))
+}
+
+// runCanary periodically obtains a certificate for the canary hostname, to prove the whole acme and dns pipeline still works when no real renewals are due
+func runCanary(ctx context.Context, waitGroup *sync.WaitGroup, kubeClientset *kubernetes.Clientset) {
+
+	initiator := "canary"
+	desiredState := getCanaryState(*canaryHostname, *canaryStaging)
+
+	// a secret isn't stored for the canary, but obtaining certificates needs one to log and report events about
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v-canary", app),
+			Namespace: getCurrentNamespace(),
+		},
+	}
+
+	for {
+		if controllerPause.IsPaused() {
+			log.Info().Msgf("[%v] Skipping canary certificate for %v, issuance is paused", initiator, *canaryHostname)
+		} else if !validateHostname(*canaryHostname) {
+			log.Error().Msgf("[%v] Canary hostname %v is invalid", initiator, *canaryHostname)
+			canaryTotals.With(prometheus.Labels{"status": "failed"}).Inc()
+		} else {
+			waitGroup.Add(1)
+			_, err := obtainCertificates(ctx, kubeClientset, secret, initiator, desiredState, []string{*canaryHostname}, nil)
+			waitGroup.Done()
+
+			if err != nil {
+				log.Error().Err(err).Msgf("[%v] Obtaining canary certificate for %v failed", initiator, *canaryHostname)
+				canaryTotals.With(prometheus.Labels{"status": "failed"}).Inc()
+			} else {
+				log.Info().Msgf("[%v] Obtained canary certificate for %v", initiator, *canaryHostname)
+				canaryTotals.With(prometheus.Labels{"status": "succeeded"}).Inc()
+				canaryLastSuccess.SetToCurrentTime()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*canaryInterval):
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCanaryState(t *testing.T) {
+	t.Run("ReturnsStagingEnvironmentIfStagingIsTrue", func(t *testing.T) {
+
+		// act
+		state := getCanaryState("canary.estafette.io", true)
+
+		assert.Equal(t, "true", state.Enabled)
+		assert.Equal(t, "canary.estafette.io", state.Hostnames)
+		assert.Equal(t, environmentStaging, state.Environment)
+	})
+
+	t.Run("ReturnsProductionEnvironmentIfStagingIsFalse", func(t *testing.T) {
+
+		// act
+		state := getCanaryState("canary.estafette.io", false)
+
+		assert.Equal(t, environmentProduction, state.Environment)
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// maxOwnedChallengeValues caps the challenge record values kept in the state, dropping the oldest first, so values of records that are never cleaned up don't grow the annotation
+const maxOwnedChallengeValues = 25
+
+// addChallengeValue appends the value to the owned challenge record values, moving it to the end if it's already known and dropping the oldest ones beyond the cap
+func addChallengeValue(values []string, value string) []string {
+	owned := removeChallengeValue(values, value)
+	owned = append(owned, value)
+	if len(owned) > maxOwnedChallengeValues {
+		owned = owned[len(owned)-maxOwnedChallengeValues:]
+	}
+
+	return owned
+}
+
+// removeChallengeValue returns the owned challenge record values without the value
+func removeChallengeValue(values []string, value string) []string {
+	owned := []string{}
+	for _, ownedValue := range values {
+		if ownedValue != value {
+			owned = append(owned, ownedValue)
+		}
+	}
+
+	return owned
+}
+
+// updateChallengeValues stores the challenge record values this controller owns in the state of the latest version of the secret, so they're still recognized after a restart
+func updateChallengeValues(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, values []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latestSecret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		state := getCurrentSecretState(latestSecret)
+		state.ChallengeValues = values
+		letsEncryptCertificateStateByteArray, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		latestSecret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+
+		_, err = kubeClientset.CoreV1().Secrets(latestSecret.Namespace).Update(ctx, latestSecret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// getDiagnosticsChallengeValues returns the challenge record values of the last failed order stored in the diagnostics annotation of the secret, which this controller has presented before a restart
+func getDiagnosticsChallengeValues(secret *v1.Secret) []string {
+	var diagnostics acmeDiagnostics
+	if err := json.Unmarshal([]byte(secret.Annotations[annotationLetsEncryptCertificateDiagnostics]), &diagnostics); err != nil {
+		return []string{}
+	}
+
+	values := []string{}
+	for _, record := range diagnostics.Records {
+		values = append(values, record.Value)
+	}
+
+	return values
+}
+
+// ownedOnlyDNSProvider wraps a DNS-01 provider to only clean up a challenge record when all TXT values at its name have been created by this controller; some providers remove the complete record set at the name, which would take the records of other tools like cert-manager along
+type ownedOnlyDNSProvider struct {
+	challenge.Provider
+	mutex       sync.Mutex
+	owned       []string
+	knownValues []string
+	lookupTXT   func(name string) ([]string, error)
+	persist     func(owned []string) error
+}
+
+// Present records the challenge record value as owned in the state of the secret before presenting it with the wrapped provider
+func (p *ownedOnlyDNSProvider) Present(domain, token, keyAuth string) error {
+	_, value := dns01.GetRecord(domain, keyAuth)
+	p.setOwned(addChallengeValue(p.getOwned(), value))
+
+	return p.Provider.Present(domain, token, keyAuth)
+}
+
+// CleanUp removes the challenge record with the wrapped provider, unless the name holds values of other tools or can't be looked up; leaving a stale challenge record behind is harmless, removing another tool's is not
+func (p *ownedOnlyDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+
+	values, err := p.lookupTXT(strings.TrimSuffix(fqdn, "."))
+	if err != nil {
+		log.Warn().Err(err).Msgf("Looking up TXT record %v failed, leaving it in place since it can't be verified to only hold values of this controller", fqdn)
+		return nil
+	}
+
+	owned := p.getOwned()
+	for _, existingValue := range values {
+		if existingValue != value && !containsString(p.knownValues, existingValue) && !containsString(owned, existingValue) {
+			log.Warn().Msgf("TXT record %v holds values that haven't been created by this controller, leaving it in place", fqdn)
+			return nil
+		}
+	}
+
+	err = p.Provider.CleanUp(domain, token, keyAuth)
+	if err != nil {
+		return err
+	}
+
+	p.setOwned(removeChallengeValue(p.getOwned(), value))
+
+	return nil
+}
+
+func (p *ownedOnlyDNSProvider) getOwned() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.owned
+}
+
+// setOwned keeps the owned challenge record values and persists them; a failure to persist only means a record left in place can't be recognized as owned after a restart, so it's logged instead of failing the order
+func (p *ownedOnlyDNSProvider) setOwned(owned []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.owned = owned
+	if p.persist == nil {
+		return
+	}
+	if err := p.persist(owned); err != nil {
+		log.Warn().Err(err).Msg("Storing the owned challenge record values in the state failed")
+	}
+}
+
+// Timeout passes through the timeout and polling interval of the wrapped provider
+func (p *ownedOnlyDNSProvider) Timeout() (timeout, interval time.Duration) {
+	if providerTimeout, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return providerTimeout.Timeout()
+	}
+
+	return 60 * time.Second, 2 * time.Second
+}
+
+// lookupChallengeTXT returns the values of the TXT record with the name
+var lookupChallengeTXT = lookupAuthoritativeTXT
+
+// lookupAuthoritativeTXT returns the values of the TXT record with the name at the authoritative nameservers of its zone, since recursive resolvers might still serve values from their cache that have been removed or miss values that have just been added
+func lookupAuthoritativeTXT(name string) ([]string, error) {
+	fqdn := dns.Fqdn(name)
+
+	zone, err := dns01.FindZoneByFqdn(fqdn)
+	if err != nil {
+		return nil, err
+	}
+	nameservers, err := net.LookupNS(zone)
+	if err != nil {
+		return nil, err
+	}
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("Zone %v has no nameservers", zone)
+	}
+
+	client := &dns.Client{Timeout: 10 * time.Second}
+	lookupErrors := []error{}
+	for _, nameserver := range nameservers {
+		message := new(dns.Msg)
+		message.SetQuestion(fqdn, dns.TypeTXT)
+		message.RecursionDesired = false
+
+		response, _, err := client.Exchange(message, net.JoinHostPort(strings.TrimSuffix(nameserver.Host, "."), "53"))
+		if err != nil {
+			lookupErrors = append(lookupErrors, err)
+			continue
+		}
+
+		switch response.Rcode {
+		case dns.RcodeNameError:
+			return []string{}, nil
+		case dns.RcodeSuccess:
+			return getTXTValues(response, fqdn), nil
+		default:
+			lookupErrors = append(lookupErrors, fmt.Errorf("Nameserver %v answered %v", nameserver.Host, dns.RcodeToString[response.Rcode]))
+		}
+	}
+
+	return nil, fmt.Errorf("Looking up TXT record %v at the nameservers of zone %v failed: %w", fqdn, zone, utilerrors.NewAggregate(lookupErrors))
+}
+
+// getTXTValues returns the values of the TXT records with the fqdn in the answer of a nameserver
+func getTXTValues(response *dns.Msg, fqdn string) []string {
+	values := []string{}
+	for _, answer := range response.Answer {
+		if txt, ok := answer.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, fqdn) {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAddChallengeValue(t *testing.T) {
+	t.Run("AppendsValue", func(t *testing.T) {
+
+		// act
+		values := addChallengeValue([]string{"a"}, "b")
+
+		assert.Equal(t, []string{"a", "b"}, values)
+	})
+
+	t.Run("MovesKnownValueToTheEnd", func(t *testing.T) {
+
+		// act
+		values := addChallengeValue([]string{"a", "b"}, "a")
+
+		assert.Equal(t, []string{"b", "a"}, values)
+	})
+
+	t.Run("DropsOldestValuesBeyondTheCap", func(t *testing.T) {
+
+		values := []string{}
+		for i := 0; i < maxOwnedChallengeValues; i++ {
+			values = append(values, fmt.Sprintf("value-%v", i))
+		}
+
+		// act
+		values = addChallengeValue(values, "newest")
+
+		assert.Equal(t, maxOwnedChallengeValues, len(values))
+		assert.Equal(t, "value-1", values[0])
+		assert.Equal(t, "newest", values[len(values)-1])
+	})
+}
+
+func TestRemoveChallengeValue(t *testing.T) {
+	t.Run("RemovesValue", func(t *testing.T) {
+
+		// act
+		values := removeChallengeValue([]string{"a", "b"}, "a")
+
+		assert.Equal(t, []string{"b"}, values)
+	})
+}
+
+func TestGetTXTValues(t *testing.T) {
+	t.Run("ReturnsJoinedValuesOfTXTRecordsWithTheName", func(t *testing.T) {
+
+		response := new(dns.Msg)
+		response.Answer = []dns.RR{
+			&dns.TXT{Hdr: dns.RR_Header{Name: "_acme-challenge.Estafette.io.", Rrtype: dns.TypeTXT}, Txt: []string{"ab", "c"}},
+			&dns.TXT{Hdr: dns.RR_Header{Name: "_acme-challenge.estafette.io.", Rrtype: dns.TypeTXT}, Txt: []string{"def"}},
+			&dns.TXT{Hdr: dns.RR_Header{Name: "other.estafette.io.", Rrtype: dns.TypeTXT}, Txt: []string{"ghi"}},
+			&dns.CNAME{Hdr: dns.RR_Header{Name: "_acme-challenge.estafette.io.", Rrtype: dns.TypeCNAME}, Target: "challenges.estafette.io."},
+		}
+
+		// act
+		values := getTXTValues(response, "_acme-challenge.estafette.io.")
+
+		assert.Equal(t, []string{"abc", "def"}, values)
+	})
+}
+
+func TestGetDiagnosticsChallengeValues(t *testing.T) {
+	t.Run("ReturnsValuesOfRecordsInDiagnostics", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateDiagnostics: `{"records":[{"domain":"estafette.io","fqdn":"_acme-challenge.estafette.io.","value":"abc"}]}`,
+				},
+			},
+		}
+
+		// act
+		values := getDiagnosticsChallengeValues(secret)
+
+		assert.Equal(t, []string{"abc"}, values)
+	})
+
+	t.Run("ReturnsEmptyListIfSecretHasNoDiagnostics", func(t *testing.T) {
+
+		// act
+		values := getDiagnosticsChallengeValues(&v1.Secret{})
+
+		assert.Equal(t, 0, len(values))
+	})
+}
+
+func TestOwnedOnlyDNSProvider(t *testing.T) {
+
+	_, value := dns01.GetRecord("estafette.io", "keyauth")
+
+	t.Run("PersistsPresentedValueAsOwned", func(t *testing.T) {
+
+		persisted := []string{}
+		ownedOnlyProvider := &ownedOnlyDNSProvider{Provider: &fakeDNSProvider{}, owned: []string{"earlier"}, persist: func(owned []string) error {
+			persisted = owned
+			return nil
+		}}
+
+		// act
+		err := ownedOnlyProvider.Present("estafette.io", "token", "keyauth")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"earlier", value}, persisted)
+	})
+
+	t.Run("PresentsRecordIfPersistingFails", func(t *testing.T) {
+
+		provider := &fakeDNSProvider{}
+		ownedOnlyProvider := &ownedOnlyDNSProvider{Provider: provider, persist: func(owned []string) error {
+			return errors.New("conflict")
+		}}
+
+		// act
+		err := ownedOnlyProvider.Present("estafette.io", "token", "keyauth")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, provider.presented)
+	})
+
+	t.Run("CleansUpRecordIfItOnlyHoldsOwnValues", func(t *testing.T) {
+
+		provider := &fakeDNSProvider{}
+		persisted := []string{}
+		ownedOnlyProvider := &ownedOnlyDNSProvider{Provider: provider, owned: []string{"stored"}, knownValues: []string{"earlier"}, lookupTXT: func(name string) ([]string, error) {
+			return []string{value, "earlier", "stored"}, nil
+		}, persist: func(owned []string) error {
+			persisted = owned
+			return nil
+		}}
+		err := ownedOnlyProvider.Present("estafette.io", "token", "keyauth")
+		assert.Nil(t, err)
+
+		// act
+		err = ownedOnlyProvider.CleanUp("estafette.io", "token", "keyauth")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, provider.cleanedUp)
+		assert.Equal(t, []string{"stored"}, persisted)
+	})
+
+	t.Run("LeavesRecordInPlaceIfItHoldsValuesOfOtherTools", func(t *testing.T) {
+
+		provider := &fakeDNSProvider{}
+		ownedOnlyProvider := &ownedOnlyDNSProvider{Provider: provider, lookupTXT: func(name string) ([]string, error) {
+			return []string{value, "cert-manager"}, nil
+		}}
+
+		// act
+		err := ownedOnlyProvider.CleanUp("estafette.io", "token", "keyauth")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, provider.cleanedUp)
+	})
+
+	t.Run("LeavesRecordInPlaceIfLookupFails", func(t *testing.T) {
+
+		provider := &fakeDNSProvider{}
+		ownedOnlyProvider := &ownedOnlyDNSProvider{Provider: provider, lookupTXT: func(name string) ([]string, error) {
+			return nil, errors.New("timeout")
+		}}
+
+		// act
+		err := ownedOnlyProvider.CleanUp("estafette.io", "token", "keyauth")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, provider.cleanedUp)
+	})
+
+	t.Run("LooksUpNameWithoutTrailingDot", func(t *testing.T) {
+
+		lookedUpName := ""
+		ownedOnlyProvider := &ownedOnlyDNSProvider{Provider: &fakeDNSProvider{}, lookupTXT: func(name string) ([]string, error) {
+			lookedUpName = name
+			return []string{}, nil
+		}}
+
+		// act
+		_ = ownedOnlyProvider.CleanUp("estafette.io", "token", "keyauth")
+
+		assert.Equal(t, "_acme-challenge.estafette.io", lookedUpName)
+	})
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 )
 
+var zoneIDRegex = regexp.MustCompile("^[0-9a-f]{32}$")
+
 // Cloudflare is the object to perform Cloudflare api calls with
 type Cloudflare struct {
 	restClient     restClient
@@ -39,7 +45,7 @@ func (cf *Cloudflare) getZonesByName(zoneName string) (r zonesResult, err error)
 	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
 
 	if !r.Success {
-		err = fmt.Errorf("Listing cloudflare zones failed | %v | %v", r.Errors, r.Messages)
+		err = newAPIError("Listing cloudflare zones", r.Errors, r.Messages)
 		return
 	}
 
@@ -115,7 +121,7 @@ func (cf *Cloudflare) getSSLConfigurationByZone(zone Zone) (r listResult, err er
 	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
 
 	if !r.Success {
-		err = fmt.Errorf("Listing cloudflare zones failed | %v | %v", r.Errors, r.Messages)
+		err = newAPIError("Listing cloudflare zones", r.Errors, r.Messages)
 		return
 	}
 
@@ -136,7 +142,7 @@ func (cf *Cloudflare) updateSSLConfigurationByZoneAndID(zone Zone, sslConfigID s
 	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
 
 	if !r.Success {
-		err = fmt.Errorf("Updating cloudflare ssl config failed for zone '%v' | %v | %v", zone.ID, r.Errors, r.Messages)
+		err = newAPIError(fmt.Sprintf("Updating cloudflare ssl config for zone '%v'", zone.ID), r.Errors, r.Messages)
 		return
 	}
 
@@ -157,7 +163,7 @@ func (cf *Cloudflare) createSSLConfigurationByZone(zone Zone, sslConfig SSLConfi
 	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
 
 	if !r.Success {
-		err = fmt.Errorf("Creating cloudflare ssl config failed | zone %v:%v | %v | %v", zone.Name, zone.ID, r.Errors, r.Messages)
+		err = newAPIError(fmt.Sprintf("Creating cloudflare ssl config for zone %v:%v", zone.Name, zone.ID), r.Errors, r.Messages)
 		return
 	}
 
@@ -165,8 +171,6 @@ func (cf *Cloudflare) createSSLConfigurationByZone(zone Zone, sslConfig SSLConfi
 }
 
 func (cf *Cloudflare) UpsertSSLConfigurationByDNSName(dnsName string, certificate, privateKey []byte) (r SSLConfiguration, err error) {
-	// new SSL configuration to be updated or inserted
-	newSSLConfig := SSLConfiguration{Certificate: string(certificate), PrivateKey: string(privateKey)}
 
 	// get zone
 	zone, err := cf.GetZoneByDNSName(dnsName)
@@ -174,6 +178,14 @@ func (cf *Cloudflare) UpsertSSLConfigurationByDNSName(dnsName string, certificat
 		return r, err
 	}
 
+	return cf.UpsertSSLConfigurationByZone(zone, certificate, privateKey)
+}
+
+// UpsertSSLConfigurationByZone updates the first custom certificate of the zone, or creates one if the zone has none yet.
+func (cf *Cloudflare) UpsertSSLConfigurationByZone(zone Zone, certificate, privateKey []byte) (r SSLConfiguration, err error) {
+	// new SSL configuration to be updated or inserted
+	newSSLConfig := SSLConfiguration{Certificate: string(certificate), PrivateKey: string(privateKey)}
+
 	// get ssl config at cloudflare api
 	var cloudflareSSLConfigListResult listResult
 	cloudflareSSLConfigListResult, err = cf.getSSLConfigurationByZone(zone)
@@ -218,6 +230,243 @@ func (cf *Cloudflare) UpsertSSLConfigurationByDNSName(dnsName string, certificat
 	return
 }
 
+func (cf *Cloudflare) getCertificatePacksByZone(zone Zone) (r certificatePacksResult, err error) {
+
+	// create api url
+	listCertificatePacksURI := fmt.Sprintf("%v/zones/%v/ssl/certificate_packs?status=all", cf.baseURL, zone.ID)
+
+	// fetch result from cloudflare api
+	body, err := cf.restClient.Get(listCertificatePacksURI, cf.authentication)
+	if err != nil {
+		return r, err
+	}
+
+	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
+
+	if !r.Success {
+		err = newAPIError(fmt.Sprintf("Listing cloudflare certificate packs for zone '%v'", zone.ID), r.Errors, r.Messages)
+		return
+	}
+
+	return
+}
+
+func (cf *Cloudflare) orderCertificatePackByZone(zone Zone, certificatePack CertificatePack) (r certificatePackResult, err error) {
+
+	// create cloudflare api url
+	orderCertificatePackURI := fmt.Sprintf("%v/zones/%v/ssl/certificate_packs/order", cf.baseURL, zone.ID)
+
+	// order advanced certificate
+	body, err := cf.restClient.Post(orderCertificatePackURI, certificatePack, cf.authentication)
+	if err != nil {
+		return r, err
+	}
+
+	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
+
+	if !r.Success {
+		err = newAPIError(fmt.Sprintf("Ordering cloudflare certificate pack for zone %v:%v", zone.Name, zone.ID), r.Errors, r.Messages)
+		return
+	}
+
+	return
+}
+
+// UpsertCertificatePackByZone orders an advanced certificate pack issued by Let's Encrypt for the hostnames within the zone, unless one of the zone's certificate packs already covers them.
+func (cf *Cloudflare) UpsertCertificatePackByZone(zone Zone, hostnames []string) (r CertificatePack, err error) {
+
+	// certificate packs can only hold hostnames of their own zone
+	hosts := getHostnamesInZone(zone, hostnames)
+	if len(hosts) == 0 {
+		return r, fmt.Errorf("cloudflare: none of the hostnames %v belong to zone %v", hostnames, zone.Name)
+	}
+
+	// get certificate packs at cloudflare api
+	cloudflareCertificatePacksResult, err := cf.getCertificatePacksByZone(zone)
+	if err != nil {
+		return
+	}
+
+	// zones can have multiple certificate packs, any of them covering all hosts suffices
+	for _, certificatePack := range cloudflareCertificatePacksResult.CertificatePacks {
+		if certificatePack.CoversHosts(hosts) {
+			return certificatePack, nil
+		}
+	}
+
+	// order certificate pack at cloudflare api
+	cloudflareCertificatePackResult, err := cf.orderCertificatePackByZone(zone, CertificatePack{
+		Type:                 "advanced",
+		Hosts:                hosts,
+		ValidationMethod:     "txt",
+		ValidityDays:         90,
+		CertificateAuthority: "lets_encrypt",
+	})
+	if err != nil {
+		return
+	}
+
+	r = cloudflareCertificatePackResult.CertificatePack
+	return
+}
+
+// UpsertCertificatePackByDNSName orders an advanced certificate pack for the hostnames within the zone of the dns name, unless it already has one covering them.
+func (cf *Cloudflare) UpsertCertificatePackByDNSName(dnsName string, hostnames []string) (r CertificatePack, err error) {
+
+	// get zone
+	zone, err := cf.GetZoneByDNSName(dnsName)
+	if err != nil {
+		return r, err
+	}
+
+	return cf.UpsertCertificatePackByZone(zone, hostnames)
+}
+
+// VerifyAuthentication performs a cheap read-only call to check whether the configured credentials are accepted by the cloudflare api.
+func (cf *Cloudflare) VerifyAuthentication() (err error) {
+
+	// create api url; scoped api tokens can only verify themselves, not read the user
+	userURI := fmt.Sprintf("%v/user", cf.baseURL)
+	if cf.authentication.Token != "" {
+		userURI = fmt.Sprintf("%v/user/tokens/verify", cf.baseURL)
+	}
+
+	// fetch result from cloudflare api
+	body, err := cf.restClient.Get(userURI, cf.authentication)
+	if err != nil {
+		return err
+	}
+
+	var r userResult
+	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
+
+	if !r.Success {
+		return newAPIError("Verifying cloudflare credentials", r.Errors, r.Messages)
+	}
+
+	return nil
+}
+
+func (cf *Cloudflare) getZoneByID(zoneID string) (r zoneResult, err error) {
+
+	// create api url
+	getZoneURI := fmt.Sprintf("%v/zones/%v", cf.baseURL, zoneID)
+
+	// fetch result from cloudflare api
+	body, err := cf.restClient.Get(getZoneURI, cf.authentication)
+	if err != nil {
+		return r, err
+	}
+
+	json.NewDecoder(bytes.NewReader(body)).Decode(&r)
+
+	if !r.Success {
+		err = newAPIError(fmt.Sprintf("Getting cloudflare zone '%v'", zoneID), r.Errors, r.Messages)
+		return
+	}
+
+	return
+}
+
+// GetZoneByNameOrID returns the Cloudflare zone with exactly the given name or ID, without guessing the zone from a dns name.
+func (cf *Cloudflare) GetZoneByNameOrID(zoneNameOrID string) (r Zone, err error) {
+
+	// zone ids are 32 character hexadecimal strings
+	if zoneIDRegex.MatchString(zoneNameOrID) {
+		zoneResult, err := cf.getZoneByID(zoneNameOrID)
+		if err != nil {
+			return r, err
+		}
+		return zoneResult.Zone, verifyZoneIsActive(zoneResult.Zone)
+	}
+
+	zonesResult, err := cf.getZonesByName(zoneNameOrID)
+	if err != nil {
+		return r, err
+	}
+
+	return getMatchingZoneFromZones(zonesResult.Zones, zoneNameOrID)
+}
+
+// SSLConfigurationNeedsRefreshByZone returns true if the zone has no custom certificate, or its custom certificate expires within the window from now and isn't the certificate to upload, so the same certificate isn't uploaded again on every check.
+func (cf *Cloudflare) SSLConfigurationNeedsRefreshByZone(zone Zone, certificate []byte, window time.Duration) (refresh bool, err error) {
+
+	// get ssl config at cloudflare api
+	cloudflareSSLConfigListResult, err := cf.getSSLConfigurationByZone(zone)
+	if err != nil {
+		return false, err
+	}
+
+	// only the first custom certificate gets managed, see UpsertSSLConfigurationByZone
+	if len(cloudflareSSLConfigListResult.SSLConfigurations) == 0 {
+		return true, nil
+	}
+
+	sslConfig := cloudflareSSLConfigListResult.SSLConfigurations[0]
+	if !sslConfig.ExpiresWithin(window) {
+		return false, nil
+	}
+
+	equal, err := sslConfig.CertificateEqual(certificate)
+	if err != nil {
+		return false, err
+	}
+
+	return !equal, nil
+}
+
+// VerifyCertificateForUpload checks that the bundle's leaf certificate is currently valid, covers all hostnames and that each certificate in the bundle is signed by the next one, since cloudflare rejects bad bundles with opaque errors.
+func VerifyCertificateForUpload(certificateBundle []byte, hostnames []string) (err error) {
+
+	certificates := []*x509.Certificate{}
+	rest := certificateBundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("cloudflare: parsing certificate %v of the bundle failed: %w", len(certificates), err)
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	if len(certificates) == 0 {
+		return errors.New("cloudflare: certificate bundle has no certificates")
+	}
+
+	leaf := certificates[0]
+	if leaf.SerialNumber == nil || leaf.SerialNumber.Sign() <= 0 {
+		return errors.New("cloudflare: certificate has no valid serial number")
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("cloudflare: certificate with serial %x is only valid from %v until %v", leaf.SerialNumber, leaf.NotBefore, leaf.NotAfter)
+	}
+
+	for _, hostname := range hostnames {
+		err = leaf.VerifyHostname(hostname)
+		if err != nil {
+			return fmt.Errorf("cloudflare: certificate with serial %x doesn't cover hostname %v: %w", leaf.SerialNumber, hostname, err)
+		}
+	}
+
+	for i := 0; i < len(certificates)-1; i++ {
+		err = certificates[i].CheckSignatureFrom(certificates[i+1])
+		if err != nil {
+			return fmt.Errorf("cloudflare: certificate %v of the bundle (%v) isn't signed by the next certificate (%v): %w", i, certificates[i].Subject.CommonName, certificates[i+1].Subject.CommonName, err)
+		}
+	}
+
+	return nil
+}
+
 func getLastItemsFromSlice(source []string, numberOfItems int) (r []string, err error) {
 
 	if len(source) == 0 {
@@ -242,13 +491,44 @@ func getMatchingZoneFromZones(zones []Zone, zoneName string) (r Zone, err error)
 		return
 	}
 
+	// skip zones that aren't active, but remember them to explain why there's no match
+	var inactiveZoneErr error
 	for _, zone := range zones {
 		if zone.Name == zoneName {
+			inactiveZoneErr = verifyZoneIsActive(zone)
+			if inactiveZoneErr != nil {
+				continue
+			}
 			r = zone
 			return
 		}
 	}
 
+	if inactiveZoneErr != nil {
+		err = inactiveZoneErr
+		return
+	}
+
 	err = errors.New("cloudflare: no zone matches name")
 	return
 }
+
+// verifyZoneIsActive returns an error if the zone's status isn't active, because uploading certificates to pending or moved zones fails
+func verifyZoneIsActive(zone Zone) error {
+	if zone.Status != "active" {
+		return fmt.Errorf("cloudflare: zone %v exists but has status '%v' instead of 'active': %w", zone.Name, zone.Status, ErrCloudflareZoneNotActive)
+	}
+
+	return nil
+}
+
+// getHostnamesInZone returns the hostnames that are the zone apex or any name below it
+func getHostnamesInZone(zone Zone, hostnames []string) (r []string) {
+	for _, hostname := range hostnames {
+		if hostname == zone.Name || strings.HasSuffix(hostname, "."+zone.Name) {
+			r = append(r, hostname)
+		}
+	}
+
+	return r
+}
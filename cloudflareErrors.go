@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrCloudflareAuthentication is returned when the api key or email are not accepted
+	ErrCloudflareAuthentication = errors.New("cloudflare: authentication failed")
+	// ErrCloudflareQuotaExceeded is returned when the custom certificate quota or rate limit is reached
+	ErrCloudflareQuotaExceeded = errors.New("cloudflare: quota exceeded")
+	// ErrCloudflareInvalidCertificate is returned when cloudflare rejects the uploaded certificate or key
+	ErrCloudflareInvalidCertificate = errors.New("cloudflare: invalid certificate")
+	// ErrCloudflareZoneNotActive is returned when the zone exists but isn't active yet, or anymore
+	ErrCloudflareZoneNotActive = errors.New("cloudflare: zone not active")
+)
+
+// cloudflare api error codes indicating the credentials aren't accepted
+var authenticationErrorCodes = map[int]bool{
+	6003:  true,
+	6103:  true,
+	9103:  true,
+	9106:  true,
+	9107:  true,
+	9109:  true,
+	10000: true,
+	10001: true,
+}
+
+// APIError is returned when the cloudflare api responds without success; use errors.Is with the ErrCloudflare* errors to check what kind of failure it is.
+type APIError struct {
+	Operation string
+	Kind      error
+	Errors    []apiMessage
+	Messages  []apiMessage
+}
+
+func newAPIError(operation string, errs, messages []apiMessage) *APIError {
+	return &APIError{
+		Operation: operation,
+		Kind:      getAPIErrorKind(errs),
+		Errors:    errs,
+		Messages:  messages,
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%v failed | %v | %v", e.Operation, e.Errors, e.Messages)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// getAPIErrorKind maps the error array of a cloudflare api response to one of the ErrCloudflare* errors, or nil if none applies
+func getAPIErrorKind(errs []apiMessage) error {
+	for _, e := range errs {
+		message := strings.ToLower(e.Message)
+
+		switch {
+		case authenticationErrorCodes[e.Code] || strings.Contains(message, "authentication") || strings.Contains(message, "x-auth-key"):
+			return ErrCloudflareAuthentication
+		case e.Code == 971 || strings.Contains(message, "quota") || strings.Contains(message, "rate limit") || strings.Contains(message, "maximum number"):
+			return ErrCloudflareQuotaExceeded
+		case strings.Contains(message, "not active") || strings.Contains(message, "pending") || strings.Contains(message, "moved"):
+			return ErrCloudflareZoneNotActive
+		case strings.Contains(message, "certificate") || strings.Contains(message, "private key"):
+			return ErrCloudflareInvalidCertificate
+		}
+	}
+
+	return nil
+}
+
+// getCloudflareErrorReason returns a short reason for an error returned by the cloudflare client, to be used as metric label
+func getCloudflareErrorReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrCloudflareAuthentication):
+		return "authentication"
+	case errors.Is(err, ErrCloudflareQuotaExceeded):
+		return "quota-exceeded"
+	case errors.Is(err, ErrCloudflareInvalidCertificate):
+		return "invalid-certificate"
+	case errors.Is(err, ErrCloudflareZoneNotActive):
+		return "zone-not-active"
+	}
+
+	return "other"
+}
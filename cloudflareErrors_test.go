@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAPIErrorKind(t *testing.T) {
+
+	t.Run("ReturnsAuthenticationErrorForAuthenticationErrorCode", func(t *testing.T) {
+
+		// act
+		kind := getAPIErrorKind([]apiMessage{{Code: 10000, Message: "Authentication error"}})
+
+		assert.Equal(t, ErrCloudflareAuthentication, kind)
+	})
+
+	t.Run("ReturnsQuotaExceededErrorForQuotaMessage", func(t *testing.T) {
+
+		// act
+		kind := getAPIErrorKind([]apiMessage{{Code: 1228, Message: "You have reached the maximum number of custom certificates"}})
+
+		assert.Equal(t, ErrCloudflareQuotaExceeded, kind)
+	})
+
+	t.Run("ReturnsInvalidCertificateErrorForCertificateMessage", func(t *testing.T) {
+
+		// act
+		kind := getAPIErrorKind([]apiMessage{{Code: 1211, Message: "The certificate and private key do not match"}})
+
+		assert.Equal(t, ErrCloudflareInvalidCertificate, kind)
+	})
+
+	t.Run("ReturnsZoneNotActiveErrorForPendingZoneMessage", func(t *testing.T) {
+
+		// act
+		kind := getAPIErrorKind([]apiMessage{{Code: 1000, Message: "Zone is pending activation"}})
+
+		assert.Equal(t, ErrCloudflareZoneNotActive, kind)
+	})
+
+	t.Run("ReturnsNilForUnknownError", func(t *testing.T) {
+
+		// act
+		kind := getAPIErrorKind([]apiMessage{{Code: 7003, Message: "Could not route to /zones, perhaps your object identifier is invalid?"}})
+
+		assert.Nil(t, kind)
+	})
+}
+
+func TestGetCloudflareErrorReason(t *testing.T) {
+
+	t.Run("ReturnsReasonOfWrappedAPIError", func(t *testing.T) {
+
+		err := fmt.Errorf("Uploading failed: %w", newAPIError("Creating cloudflare ssl config", []apiMessage{{Code: 9103, Message: "Unknown X-Auth-Key or X-Auth-Email"}}, nil))
+
+		// act
+		reason := getCloudflareErrorReason(err)
+
+		assert.Equal(t, "authentication", reason)
+	})
+
+	t.Run("ReturnsOtherForUnclassifiedError", func(t *testing.T) {
+
+		// act
+		reason := getCloudflareErrorReason(errors.New("connection refused"))
+
+		assert.Equal(t, "other", reason)
+	})
+}
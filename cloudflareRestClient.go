@@ -2,12 +2,37 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"time"
 )
 
+// maxCloudflareResponseSize is the maximum size of a (decompressed) response body read from the cloudflare api; the largest responses, listing custom certificates with their chains, stay well below it
+const maxCloudflareResponseSize = 10 * 1024 * 1024
+
+// cloudflareHTTPClient is shared by all requests to the cloudflare api, so connections are reused and a misbehaving upstream can't keep requests hanging
+var cloudflareHTTPClient = &http.Client{
+	Timeout: 60 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	},
+}
+
 // restClient is the interface to be able to mock http calls to cloudflare api.
 type restClient interface {
 	Get(string, APIAuthentication) ([]byte, error)
@@ -46,8 +71,7 @@ func core(verb, cloudflareAPIURL string, params interface{}, authentication APIA
 		requestBody = bytes.NewReader(data)
 	}
 
-	// create client, in order to add headers
-	client := &http.Client{}
+	// create request, in order to add headers
 	request, err := http.NewRequest(verb, cloudflareAPIURL, requestBody)
 	if err != nil {
 		return
@@ -55,21 +79,44 @@ func core(verb, cloudflareAPIURL string, params interface{}, authentication APIA
 
 	// add headers
 	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("X-Auth-Key", authentication.Key)
-	request.Header.Add("X-Auth-Email", authentication.Email)
+	if authentication.Token != "" {
+		request.Header.Add("Authorization", fmt.Sprintf("Bearer %v", authentication.Token))
+	} else {
+		request.Header.Add("X-Auth-Key", authentication.Key)
+		request.Header.Add("X-Auth-Email", authentication.Email)
+	}
 
 	// perform actual request
-	response, err := client.Do(request)
+	response, err := cloudflareHTTPClient.Do(request)
 	if err != nil {
 		return
 	}
 
 	defer response.Body.Close()
 
-	body, err = ioutil.ReadAll(response.Body)
+	return readResponseBody(response, maxCloudflareResponseSize)
+}
+
+// readResponseBody reads the response body up to maxSize bytes, decompressing it if it's gzip encoded without the transport having requested it
+func readResponseBody(response *http.Response, maxSize int64) (body []byte, err error) {
+	reader := response.Body
+	if response.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return body, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	// read a byte more than allowed, to detect bodies exceeding the limit without reading them entirely
+	body, err = ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
 	if err != nil {
-		return
+		return body, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("Response from %v exceeds the maximum size of %v bytes", response.Request.URL.Host, maxSize)
 	}
 
-	return
+	return body, nil
 }
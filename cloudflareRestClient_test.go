@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCore(t *testing.T) {
+
+	t.Run("SendsBearerTokenWhenAuthenticatingWithApiToken", func(t *testing.T) {
+
+		var authorization, authKey string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			authKey = r.Header.Get("X-Auth-Key")
+			w.Write([]byte(`{"success": true}`))
+		}))
+		defer server.Close()
+
+		// act
+		_, err := core("GET", server.URL, nil, APIAuthentication{Token: "zv18u3e9ntphs79kctdxxj5wr2kjepva04hij"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "Bearer zv18u3e9ntphs79kctdxxj5wr2kjepva04hij", authorization)
+		assert.Equal(t, "", authKey)
+	})
+
+	t.Run("DecompressesGzipEncodedResponse", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var buffer bytes.Buffer
+			gzipWriter := gzip.NewWriter(&buffer)
+			gzipWriter.Write([]byte(`{"success": true}`))
+			gzipWriter.Close()
+
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(buffer.Bytes())
+		}))
+		defer server.Close()
+
+		// act
+		body, err := core("GET", server.URL, nil, APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, `{"success": true}`, string(body))
+	})
+
+	t.Run("ReturnsErrorWhenResponseExceedsMaximumSize", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(bytes.Repeat([]byte("a"), maxCloudflareResponseSize+1))
+		}))
+		defer server.Close()
+
+		// act
+		_, err := core("GET", server.URL, nil, APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"})
+
+		assert.NotNil(t, err)
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -31,17 +33,35 @@ type Zone struct {
 	DeactReason string   `json:"deactivation_reason"`
 }
 
-// APIAuthentication contains the email address and api key to authenticate a request to the cloudflare api.
+// APIAuthentication contains the email address and api key, or the scoped api token, to authenticate a request to the cloudflare api.
 type APIAuthentication struct {
 	Key, Email string
+	Token      string
 }
 
 type zonesResult struct {
-	Success    bool        `json:"success"`
-	Errors     interface{} `json:"errors"`
-	Messages   interface{} `json:"messages"`
-	Zones      []Zone      `json:"result"`
-	ResultInfo resultInfo  `json:"result_info"`
+	Success    bool         `json:"success"`
+	Errors     []apiMessage `json:"errors"`
+	Messages   []apiMessage `json:"messages"`
+	Zones      []Zone       `json:"result"`
+	ResultInfo resultInfo   `json:"result_info"`
+}
+
+type zoneResult struct {
+	Success  bool         `json:"success"`
+	Errors   []apiMessage `json:"errors"`
+	Messages []apiMessage `json:"messages"`
+	Zone     Zone         `json:"result"`
+}
+
+// apiMessage is an item of the errors or messages array in a cloudflare api response
+type apiMessage struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (m apiMessage) String() string {
+	return fmt.Sprintf("%v: %v", m.Code, m.Message)
 }
 
 type resultInfo struct {
@@ -53,18 +73,24 @@ type resultInfo struct {
 
 type listResult struct {
 	Success           bool               `json:"success"`
-	Errors            interface{}        `json:"errors"`
-	Messages          interface{}        `json:"messages"`
+	Errors            []apiMessage       `json:"errors"`
+	Messages          []apiMessage       `json:"messages"`
 	SSLConfigurations []SSLConfiguration `json:"result,omitempty"`
 }
 
 type sslConfigResult struct {
 	Success          bool             `json:"success"`
-	Errors           interface{}      `json:"errors"`
-	Messages         interface{}      `json:"messages"`
+	Errors           []apiMessage     `json:"errors"`
+	Messages         []apiMessage     `json:"messages"`
 	SSLConfiguration SSLConfiguration `json:"result,omitempty"`
 }
 
+type userResult struct {
+	Success  bool         `json:"success"`
+	Errors   []apiMessage `json:"errors"`
+	Messages []apiMessage `json:"messages"`
+}
+
 type SSLConfiguration struct {
 	ID          string    `json:"id,omitempty"`
 	Hosts       []string  `json:"hosts,omitempty"`
@@ -75,8 +101,8 @@ type SSLConfiguration struct {
 }
 
 // this function should return true if the certificate to be uploaded is the same as the one saved at CF
-// right now is only comparing the expiration date, since it's not possible to get the actual certificate data from CF
-// it would also be possible to compare the hostnames, it wasn't done yet in favor of simplicity and lack of necessity so far
+// since it's not possible to get the actual certificate data or a fingerprint from CF it compares the expiration date,
+// and the hostnames if both CF and the certificate have them
 func (sslConfig *SSLConfiguration) CertificateEqual(rawCertificate []byte) (bool, error) {
 	block, _ := pem.Decode(rawCertificate)
 	if block == nil {
@@ -87,5 +113,77 @@ func (sslConfig *SSLConfiguration) CertificateEqual(rawCertificate []byte) (bool
 		return false, err
 	}
 
-	return sslConfig.ExpiresOn.Equal(certificate.NotAfter), nil
+	if !sslConfig.ExpiresOn.Equal(certificate.NotAfter) {
+		return false, nil
+	}
+
+	if len(sslConfig.Hosts) > 0 && len(certificate.DNSNames) > 0 {
+		hosts := append([]string{}, sslConfig.Hosts...)
+		dnsNames := append([]string{}, certificate.DNSNames...)
+		sort.Strings(hosts)
+		sort.Strings(dnsNames)
+
+		return strings.Join(hosts, ",") == strings.Join(dnsNames, ","), nil
+	}
+
+	return true, nil
+}
+
+// ExpiresWithin returns true if the custom certificate at CF expires within the window from now
+func (sslConfig *SSLConfiguration) ExpiresWithin(window time.Duration) bool {
+	return sslConfig.ExpiresOn.Before(time.Now().Add(window))
+}
+
+// CertificatePack represents a certificate pack in Cloudflare (https://api.cloudflare.com/#certificate-packs-list-certificate-packs).
+type CertificatePack struct {
+	ID                   string   `json:"id,omitempty"`
+	Type                 string   `json:"type,omitempty"`
+	Hosts                []string `json:"hosts,omitempty"`
+	Status               string   `json:"status,omitempty"`
+	ValidationMethod     string   `json:"validation_method,omitempty"`
+	ValidityDays         int      `json:"validity_days,omitempty"`
+	CertificateAuthority string   `json:"certificate_authority,omitempty"`
+}
+
+type certificatePacksResult struct {
+	Success          bool              `json:"success"`
+	Errors           []apiMessage      `json:"errors"`
+	Messages         []apiMessage      `json:"messages"`
+	CertificatePacks []CertificatePack `json:"result,omitempty"`
+}
+
+type certificatePackResult struct {
+	Success         bool            `json:"success"`
+	Errors          []apiMessage    `json:"errors"`
+	Messages        []apiMessage    `json:"messages"`
+	CertificatePack CertificatePack `json:"result,omitempty"`
+}
+
+// certificate pack statuses that won't lead to a usable certificate anymore
+var inactiveCertificatePackStatuses = map[string]bool{
+	"deleted":              true,
+	"expired":              true,
+	"deactivating":         true,
+	"inactive":             true,
+	"validation_timed_out": true,
+	"issuance_timed_out":   true,
+}
+
+// CoversHosts returns true if the certificate pack is (going to be) usable and includes all hosts
+func (pack *CertificatePack) CoversHosts(hosts []string) bool {
+	if inactiveCertificatePackStatuses[pack.Status] {
+		return false
+	}
+
+	packHosts := map[string]bool{}
+	for _, host := range pack.Hosts {
+		packHosts[host] = true
+	}
+	for _, host := range hosts {
+		if !packHosts[host] {
+			return false
+		}
+	}
+
+	return true
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -141,6 +143,45 @@ func TestGetZoneByDNSName(t *testing.T) {
 		assert.Equal(t, "server.com", zone.Name)
 	})
 
+	t.Run("ReturnsZoneNotActiveErrorWhenMatchingZoneIsPending", func(t *testing.T) {
+
+		dnsName := "server.com"
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/?name=server.com", authentication).Return([]byte(`
+		{
+			"success": true,
+			"errors": [],
+			"messages": [],
+			"result": [
+				{
+					"id": "023e105f4ecef8ad9ca31a8372d0c353",
+					"name": "server.com",
+					"status": "pending",
+					"paused": false,
+					"type": "full"
+				}
+			],
+			"result_info": {
+				"page": 1,
+				"per_page": 20,
+				"count": 1,
+				"total_count": 1
+			}
+		}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		_, err := apiClient.GetZoneByDNSName(dnsName)
+
+		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, ErrCloudflareZoneNotActive))
+	})
+
 }
 
 func TestGetZonesByName(t *testing.T) {
@@ -806,3 +847,449 @@ func TestUpsertSSLConfiguration(t *testing.T) {
 	})
 
 }
+
+func TestVerifyAuthentication(t *testing.T) {
+
+	t.Run("ReturnsNilWhenCredentialsAreAccepted", func(t *testing.T) {
+
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/user", authentication).Return([]byte(`
+		{
+			"success": true,
+			"errors": [],
+			"messages": [],
+			"result": {
+				"id": "7c5dae5552338874e5053f2534d2767a",
+				"email": "name@server.com"
+			}
+		}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		err := apiClient.VerifyAuthentication()
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorWhenCredentialsAreRejected", func(t *testing.T) {
+
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/user", authentication).Return([]byte(`
+		{
+			"success": false,
+			"errors": [{"code": 9103, "message": "Unknown X-Auth-Key or X-Auth-Email"}],
+			"messages": [],
+			"result": null
+		}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		err := apiClient.VerifyAuthentication()
+
+		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, ErrCloudflareAuthentication))
+	})
+
+	t.Run("VerifiesTokenWhenAuthenticatingWithApiToken", func(t *testing.T) {
+
+		authentication := APIAuthentication{Token: "zv18u3e9ntphs79kctdxxj5wr2kjepva04hij"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/user/tokens/verify", authentication).Return([]byte(`
+		{
+			"success": true,
+			"errors": [],
+			"messages": [{"code": 10000, "message": "This API Token is valid and active"}],
+			"result": {
+				"id": "ed17574386854bf78a67040be0a770b0",
+				"status": "active"
+			}
+		}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		err := apiClient.VerifyAuthentication()
+
+		assert.Nil(t, err)
+		fakeRESTClient.AssertExpectations(t)
+	})
+}
+
+func TestGetZoneByNameOrID(t *testing.T) {
+
+	t.Run("ReturnsZoneByIDWhenArgumentIsAZoneID", func(t *testing.T) {
+
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/023e105f4ecef8ad9ca31a8372d0c353", authentication).Return([]byte(`
+		{
+			"success": true,
+			"errors": [],
+			"messages": [],
+			"result": {
+				"id": "023e105f4ecef8ad9ca31a8372d0c353",
+				"name": "server.com",
+				"status": "active"
+			}
+		}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		zone, err := apiClient.GetZoneByNameOrID("023e105f4ecef8ad9ca31a8372d0c353")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "server.com", zone.Name)
+	})
+
+	t.Run("ReturnsZoneByNameWhenArgumentIsAZoneName", func(t *testing.T) {
+
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/?name=sub.server.com", authentication).Return([]byte(`
+		{
+			"success": true,
+			"errors": [],
+			"messages": [],
+			"result": [
+				{
+					"id": "023e105f4ecef8ad9ca31a8372d0c353",
+					"name": "sub.server.com",
+					"status": "active"
+				}
+			],
+			"result_info": {
+				"page": 1,
+				"per_page": 20,
+				"count": 1,
+				"total_count": 1
+			}
+		}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		zone, err := apiClient.GetZoneByNameOrID("sub.server.com")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "023e105f4ecef8ad9ca31a8372d0c353", zone.ID)
+	})
+}
+
+func TestVerifyCertificateForUpload(t *testing.T) {
+
+	t.Run("ReturnsNilWhenCertificateIsValidAndCoversHostnames", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+
+		// act
+		err := VerifyCertificateForUpload(certificate, []string{"estafette.io"})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorWhenCertificateDoesNotCoverHostname", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+
+		// act
+		err := VerifyCertificateForUpload(certificate, []string{"www.estafette.io"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorWhenCertificateIsExpired", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour))
+
+		// act
+		err := VerifyCertificateForUpload(certificate, []string{"estafette.io"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorWhenBundleIsNotSignedByNextCertificate", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		otherCertificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+
+		// act
+		err := VerifyCertificateForUpload(append(certificate, otherCertificate...), []string{"estafette.io"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorWhenBundleHasNoCertificates", func(t *testing.T) {
+
+		// act
+		err := VerifyCertificateForUpload([]byte("not a certificate"), []string{"estafette.io"})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCertificateEqual(t *testing.T) {
+
+	t.Run("ReturnsTrueIfExpiresOnAndHostsMatch", func(t *testing.T) {
+
+		notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), notAfter)
+		sslConfig := SSLConfiguration{ExpiresOn: notAfter, Hosts: []string{"estafette.io"}}
+
+		// act
+		equal, err := sslConfig.CertificateEqual(certificate)
+
+		assert.Nil(t, err)
+		assert.True(t, equal)
+	})
+
+	t.Run("ReturnsFalseIfExpiresOnDiffers", func(t *testing.T) {
+
+		notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), notAfter)
+		sslConfig := SSLConfiguration{ExpiresOn: notAfter.Add(-time.Hour), Hosts: []string{"estafette.io"}}
+
+		// act
+		equal, err := sslConfig.CertificateEqual(certificate)
+
+		assert.Nil(t, err)
+		assert.False(t, equal)
+	})
+
+	t.Run("ReturnsFalseIfHostsDiffer", func(t *testing.T) {
+
+		notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), notAfter)
+		sslConfig := SSLConfiguration{ExpiresOn: notAfter, Hosts: []string{"estafette.io", "www.estafette.io"}}
+
+		// act
+		equal, err := sslConfig.CertificateEqual(certificate)
+
+		assert.Nil(t, err)
+		assert.False(t, equal)
+	})
+}
+
+func TestExpiresWithin(t *testing.T) {
+
+	t.Run("ReturnsTrueIfExpiresOnIsWithinWindow", func(t *testing.T) {
+
+		sslConfig := SSLConfiguration{ExpiresOn: time.Now().Add(24 * time.Hour)}
+
+		// act
+		expires := sslConfig.ExpiresWithin(72 * time.Hour)
+
+		assert.True(t, expires)
+	})
+
+	t.Run("ReturnsFalseIfExpiresOnIsAfterWindow", func(t *testing.T) {
+
+		sslConfig := SSLConfiguration{ExpiresOn: time.Now().Add(96 * time.Hour)}
+
+		// act
+		expires := sslConfig.ExpiresWithin(72 * time.Hour)
+
+		assert.False(t, expires)
+	})
+}
+
+func TestSSLConfigurationNeedsRefreshByZone(t *testing.T) {
+
+	t.Run("ReturnsTrueIfZoneHasNoCustomCertificate", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/zone-1/custom_certificates", authentication).Return([]byte(`{"success": true, "errors": [], "messages": [], "result": []}`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		refresh, err := apiClient.SSLConfigurationNeedsRefreshByZone(Zone{ID: "zone-1"}, certificate, 72*time.Hour)
+
+		assert.Nil(t, err)
+		assert.True(t, refresh)
+	})
+
+	t.Run("ReturnsTrueIfExpiringCustomCertificateDiffersFromCertificate", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(90*24*time.Hour))
+		expiresOn := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/zone-1/custom_certificates", authentication).Return([]byte(`{"success": true, "errors": [], "messages": [], "result": [{"id": "cert-1", "expires_on": "`+expiresOn+`"}]}`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		refresh, err := apiClient.SSLConfigurationNeedsRefreshByZone(Zone{ID: "zone-1"}, certificate, 72*time.Hour)
+
+		assert.Nil(t, err)
+		assert.True(t, refresh)
+	})
+
+	t.Run("ReturnsFalseIfExpiringCustomCertificateIsTheCertificate", func(t *testing.T) {
+
+		notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), notAfter)
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/zone-1/custom_certificates", authentication).Return([]byte(`{"success": true, "errors": [], "messages": [], "result": [{"id": "cert-1", "expires_on": "`+notAfter.Format(time.RFC3339)+`"}]}`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		refresh, err := apiClient.SSLConfigurationNeedsRefreshByZone(Zone{ID: "zone-1"}, certificate, 72*time.Hour)
+
+		assert.Nil(t, err)
+		assert.False(t, refresh)
+	})
+
+	t.Run("ReturnsFalseIfCustomCertificateDoesNotExpireWithinWindow", func(t *testing.T) {
+
+		certificate := generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(90*24*time.Hour))
+		expiresOn := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/zone-1/custom_certificates", authentication).Return([]byte(`{"success": true, "errors": [], "messages": [], "result": [{"id": "cert-1", "expires_on": "`+expiresOn+`"}]}`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		refresh, err := apiClient.SSLConfigurationNeedsRefreshByZone(Zone{ID: "zone-1"}, certificate, 72*time.Hour)
+
+		assert.Nil(t, err)
+		assert.False(t, refresh)
+	})
+}
+
+func TestUpsertCertificatePackByZone(t *testing.T) {
+
+	t.Run("ReturnsExistingCertificatePackIfItCoversHostnames", func(t *testing.T) {
+
+		zone := Zone{ID: "023e105f4ecef8ad9ca31a8372d0c353", Name: "example.com", Status: "active"}
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/023e105f4ecef8ad9ca31a8372d0c353/ssl/certificate_packs?status=all", authentication).Return([]byte(`
+			{
+				"success": true,
+				"errors": [],
+				"messages": [],
+				"result": [
+					{
+						"id": "3822ff90-ea29-44df-9e55-21300bb9419b",
+						"type": "universal",
+						"hosts": ["example.com", "*.example.com"],
+						"status": "active"
+					},
+					{
+						"id": "7e7b8deba8538af625850f7d0b9c7e0f",
+						"type": "advanced",
+						"hosts": ["example.com", "www.example.com"],
+						"status": "pending_validation"
+					}
+				]
+			}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		certificatePack, err := apiClient.UpsertCertificatePackByZone(zone, []string{"www.example.com", "example.com", "example.org"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "7e7b8deba8538af625850f7d0b9c7e0f", certificatePack.ID)
+		fakeRESTClient.AssertNotCalled(t, "Post", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("OrdersCertificatePackIfNoneCoversHostnames", func(t *testing.T) {
+
+		zone := Zone{ID: "023e105f4ecef8ad9ca31a8372d0c353", Name: "example.com", Status: "active"}
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		fakeRESTClient := new(fakeRESTClient)
+		fakeRESTClient.On("Get", "https://api.cloudflare.com/client/v4/zones/023e105f4ecef8ad9ca31a8372d0c353/ssl/certificate_packs?status=all", authentication).Return([]byte(`
+			{
+				"success": true,
+				"errors": [],
+				"messages": [],
+				"result": [
+					{
+						"id": "7e7b8deba8538af625850f7d0b9c7e0f",
+						"type": "advanced",
+						"hosts": ["example.com", "www.example.com"],
+						"status": "expired"
+					}
+				]
+			}
+		`), nil)
+
+		order := CertificatePack{Type: "advanced", Hosts: []string{"www.example.com", "example.com"}, ValidationMethod: "txt", ValidityDays: 90, CertificateAuthority: "lets_encrypt"}
+		fakeRESTClient.On("Post", "https://api.cloudflare.com/client/v4/zones/023e105f4ecef8ad9ca31a8372d0c353/ssl/certificate_packs/order", order, authentication).Return([]byte(`
+			{
+				"success": true,
+				"errors": [],
+				"messages": [],
+				"result": {
+					"id": "3822ff90-ea29-44df-9e55-21300bb9419b",
+					"type": "advanced",
+					"hosts": ["www.example.com", "example.com"],
+					"status": "initializing"
+				}
+			}
+		`), nil)
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = fakeRESTClient
+
+		// act
+		certificatePack, err := apiClient.UpsertCertificatePackByZone(zone, []string{"www.example.com", "example.com"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "3822ff90-ea29-44df-9e55-21300bb9419b", certificatePack.ID)
+		assert.Equal(t, "initializing", certificatePack.Status)
+	})
+
+	t.Run("ReturnsErrorIfNoHostnameBelongsToZone", func(t *testing.T) {
+
+		zone := Zone{ID: "023e105f4ecef8ad9ca31a8372d0c353", Name: "example.com", Status: "active"}
+		authentication := APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}
+
+		apiClient := NewCloudflare(authentication)
+		apiClient.restClient = new(fakeRESTClient)
+
+		// act
+		_, err := apiClient.UpsertCertificatePackByZone(zone, []string{"notexample.com"})
+
+		assert.NotNil(t, err)
+	})
+}
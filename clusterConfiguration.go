@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// letsEncryptClusterConfigurationResource identifies the cluster-scoped LetsEncryptClusterConfiguration custom resource, defined by the crd in the helm chart
+var letsEncryptClusterConfigurationResource = schema.GroupVersionResource{Group: "estafette.io", Version: "v1", Resource: "letsencryptclusterconfigurations"}
+
+// LetsEncryptClusterConfiguration is the custom resource for platform admins to set defaults for all secrets with, replacing the equivalent flags
+type LetsEncryptClusterConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LetsEncryptClusterConfigurationSpec `json:"spec"`
+}
+
+// LetsEncryptClusterConfigurationSpec holds the defaults; unset fields fall back to the flags, and the per secret annotations override them where they exist
+type LetsEncryptClusterConfigurationSpec struct {
+	KeyType                 string   `json:"keyType,omitempty"`
+	RenewBeforeDays         *int     `json:"renewBeforeDays,omitempty"`
+	AllowedDomains          []string `json:"allowedDomains,omitempty"`
+	CopyExcludeNamespaces   []string `json:"copyExcludeNamespaces,omitempty"`
+	NotificationWebhookURLs []string `json:"notificationWebhookURLs,omitempty"`
+}
+
+// clusterDefaults holds the spec of the last valid cluster configuration read
+type clusterDefaults struct {
+	mutex sync.RWMutex
+	spec  LetsEncryptClusterConfigurationSpec
+}
+
+// Set replaces the defaults
+func (d *clusterDefaults) Set(spec LetsEncryptClusterConfigurationSpec) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.spec = spec
+}
+
+// Get returns the current defaults
+func (d *clusterDefaults) Get() LetsEncryptClusterConfigurationSpec {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.spec
+}
+
+var clusterConfiguration = &clusterDefaults{}
+
+var notificationHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// validateClusterConfigurationSpec checks the values the api server can't validate through the crd schema
+func validateClusterConfigurationSpec(spec LetsEncryptClusterConfigurationSpec) error {
+	if spec.KeyType != "" && !containsString(keyTypeNames, spec.KeyType) {
+		return fmt.Errorf("Key type %v is not one of %v", spec.KeyType, strings.Join(keyTypeNames, ", "))
+	}
+	if spec.RenewBeforeDays != nil && *spec.RenewBeforeDays < 0 {
+		return fmt.Errorf("Renew before days %v is negative", *spec.RenewBeforeDays)
+	}
+
+	return nil
+}
+
+// getDefaultKeyType returns the key type of the cluster configuration, or --key-type if it doesn't set one
+func getDefaultKeyType() string {
+	if spec := clusterConfiguration.Get(); spec.KeyType != "" {
+		return spec.KeyType
+	}
+
+	return *keyType
+}
+
+// getDefaultRenewBeforeDays returns the renew before days of the cluster configuration, or --renew-before-days if it doesn't set them
+func getDefaultRenewBeforeDays() int {
+	if spec := clusterConfiguration.Get(); spec.RenewBeforeDays != nil {
+		return *spec.RenewBeforeDays
+	}
+
+	return *renewBeforeDays
+}
+
+// isAllowedHostname checks whether the hostname is (a subdomain of) one of the allowed domains of the cluster configuration; without allowed domains any hostname is allowed
+func isAllowedHostname(hostname string) bool {
+	allowedDomains := clusterConfiguration.Get().AllowedDomains
+	if len(allowedDomains) == 0 {
+		return true
+	}
+
+	hostname = strings.TrimPrefix(strings.ToLower(hostname), "*.")
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(domain)
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isExcludedFromCopy checks whether the cluster configuration excludes the namespace from receiving copies of certificates
+func isExcludedFromCopy(namespace string) bool {
+	return containsString(clusterConfiguration.Get().CopyExcludeNamespaces, namespace)
+}
+
+// renewalNotification is posted to the notification webhooks of the cluster configuration after each renewal attempt
+type renewalNotification struct {
+	Namespace string `json:"namespace"`
+	Secret    string `json:"secret"`
+	Hostnames string `json:"hostnames"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Time      string `json:"time"`
+}
+
+// notifyRenewal posts the outcome of a renewal attempt to the notification webhooks of the cluster configuration; failures are logged but don't fail the renewal
+func notifyRenewal(ctx context.Context, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, status string, renewErr error) {
+	urls := clusterConfiguration.Get().NotificationWebhookURLs
+	if len(urls) == 0 {
+		return
+	}
+
+	notification := renewalNotification{
+		Namespace: secret.Namespace,
+		Secret:    secret.Name,
+		Hostnames: desiredState.Hostnames,
+		Status:    status,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if renewErr != nil {
+		notification.Error = renewErr.Error()
+	}
+
+	notificationBytes, err := json.Marshal(notification)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Marshalling renewal notification failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	for _, url := range urls {
+		err := postNotification(ctx, url, notificationBytes)
+		if err != nil {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting renewal notification to webhook failed", initiator, secret.Name, secret.Namespace)
+		}
+	}
+}
+
+func postNotification(ctx context.Context, url string, notificationBytes []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(notificationBytes))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := notificationHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with status code %v", response.StatusCode)
+	}
+
+	return nil
+}
+
+// watchClusterConfiguration periodically reads the LetsEncryptClusterConfiguration with the given name and applies its defaults
+func watchClusterConfiguration(ctx context.Context, dynamicClient dynamic.Interface, name string) {
+	lastGeneration := int64(-1)
+
+	for {
+		object, err := dynamicClient.Resource(letsEncryptClusterConfigurationResource).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			if lastGeneration != 0 {
+				log.Info().Msgf("LetsEncryptClusterConfiguration %v doesn't exist, using the flags as defaults", name)
+			}
+			lastGeneration = 0
+			clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+		case err != nil:
+			// keep the last known defaults, to not flip settings because of an api hiccup
+			log.Warn().Err(err).Msgf("Reading LetsEncryptClusterConfiguration %v failed, is the crd installed?", name)
+		default:
+			configuration := &LetsEncryptClusterConfiguration{}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, configuration)
+			if err == nil {
+				err = validateClusterConfigurationSpec(configuration.Spec)
+			}
+			if err != nil {
+				log.Warn().Err(err).Msgf("LetsEncryptClusterConfiguration %v is invalid, keeping the last valid defaults", name)
+				break
+			}
+			if configuration.Generation != lastGeneration {
+				log.Info().Msgf("Applying defaults of LetsEncryptClusterConfiguration %v generation %v", name, configuration.Generation)
+			}
+			lastGeneration = configuration.Generation
+			clusterConfiguration.Set(configuration.Spec)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
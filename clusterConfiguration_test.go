@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAllowedHostname(t *testing.T) {
+	t.Run("ReturnsTrueWithoutAllowedDomains", func(t *testing.T) {
+
+		clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+
+		// act
+		allowed := isAllowedHostname("estafette.io")
+
+		assert.True(t, allowed)
+	})
+
+	t.Run("ReturnsTrueForSubdomainOfAllowedDomain", func(t *testing.T) {
+
+		clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{AllowedDomains: []string{"estafette.io"}})
+		defer clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+
+		// act
+		allowed := isAllowedHostname("*.www.Estafette.io")
+
+		assert.True(t, allowed)
+	})
+
+	t.Run("ReturnsFalseForDomainEndingInAllowedDomain", func(t *testing.T) {
+
+		clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{AllowedDomains: []string{"estafette.io"}})
+		defer clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+
+		// act
+		allowed := isAllowedHostname("notestafette.io")
+
+		assert.False(t, allowed)
+	})
+}
+
+func TestGetDefaultRenewBeforeDays(t *testing.T) {
+	t.Run("ReturnsFlagIfNotSetInClusterConfiguration", func(t *testing.T) {
+
+		*renewBeforeDays = 30
+		clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+
+		// act
+		days := getDefaultRenewBeforeDays()
+
+		assert.Equal(t, 30, days)
+	})
+
+	t.Run("ReturnsDaysOfClusterConfiguration", func(t *testing.T) {
+
+		*renewBeforeDays = 30
+		days := 0
+		clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{RenewBeforeDays: &days})
+		defer clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+
+		// act
+		defaultDays := getDefaultRenewBeforeDays()
+
+		assert.Equal(t, 0, defaultDays)
+	})
+}
+
+func TestShouldCopyToNamespaceWithClusterConfiguration(t *testing.T) {
+	t.Run("ReturnsFalseForExcludedNamespaceWhenCopyingToAllNamespaces", func(t *testing.T) {
+
+		clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{CopyExcludeNamespaces: []string{"kube-system"}})
+		defer clusterConfiguration.Set(LetsEncryptClusterConfigurationSpec{})
+
+		// act
+		shouldCopy := shouldCopyToNamespace(LetsEncryptCertificateState{CopyToAllNamespaces: true}, "kube-system")
+
+		assert.False(t, shouldCopy)
+	})
+}
+
+func TestValidateClusterConfigurationSpec(t *testing.T) {
+	t.Run("ReturnsErrorForUnknownKeyType", func(t *testing.T) {
+
+		// act
+		err := validateClusterConfigurationSpec(LetsEncryptClusterConfigurationSpec{KeyType: "rsa1024"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForNegativeRenewBeforeDays", func(t *testing.T) {
+
+		days := -1
+
+		// act
+		err := validateClusterConfigurationSpec(LetsEncryptClusterConfigurationSpec{RenewBeforeDays: &days})
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// letsEncryptCertificateResource identifies the LetsEncryptCertificate custom resource, defined by the crd in the helm chart
+var letsEncryptCertificateResource = schema.GroupVersionResource{Group: "estafette.io", Version: "v1", Resource: "letsencryptcertificates"}
+
+// LetsEncryptCertificate is the custom resource to request a certificate with, as an alternative to annotating a secret
+type LetsEncryptCertificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LetsEncryptCertificateSpec   `json:"spec"`
+	Status LetsEncryptCertificateStatus `json:"status,omitempty"`
+}
+
+// LetsEncryptCertificateSpec holds the settings that are otherwise set through the annotations of a secret
+type LetsEncryptCertificateSpec struct {
+	Hostnames           []string `json:"hostnames"`
+	TargetSecret        string   `json:"targetSecret,omitempty"`
+	CopyToAllNamespaces bool     `json:"copyToAllNamespaces,omitempty"`
+	CopyToNamespaces    []string `json:"copyToNamespaces,omitempty"`
+	UploadToCloudflare  bool     `json:"uploadToCloudflare,omitempty"`
+	CloudflareZone      string   `json:"cloudflareZone,omitempty"`
+}
+
+// LetsEncryptCertificateStatus reflects the state of the certificate in the secret managed for the custom resource
+type LetsEncryptCertificateStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Secret             string             `json:"secret,omitempty"`
+	NotAfter           string             `json:"notAfter,omitempty"`
+	LastRenewal        string             `json:"lastRenewal,omitempty"`
+	Wildcard           bool               `json:"wildcard,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// getCertificateResourceAnnotations translates the spec of a custom resource to the annotations of the secret it manages; empty values mean the annotation isn't set
+func getCertificateResourceAnnotations(spec LetsEncryptCertificateSpec) map[string]string {
+	annotations := map[string]string{
+		annotationLetsEncryptCertificate:                         "true",
+		annotationLetsEncryptCertificateHostnames:                strings.Join(spec.Hostnames, ","),
+		annotationLetsEncryptCertificateTargetSecret:             spec.TargetSecret,
+		annotationLetsEncryptCertificateCopyToAllNamespaces:      "",
+		annotationLetsEncryptCertificateCopyToNamespacesMatching: "",
+		annotationLetsEncryptCertificateUploadToCloudflare:       "",
+		annotationLetsEncryptCertificateCloudflareZone:           spec.CloudflareZone,
+	}
+	if spec.CopyToAllNamespaces {
+		annotations[annotationLetsEncryptCertificateCopyToAllNamespaces] = "true"
+	}
+	if len(spec.CopyToNamespaces) > 0 {
+		namespaces := []string{}
+		for _, namespace := range spec.CopyToNamespaces {
+			namespaces = append(namespaces, regexp.QuoteMeta(namespace))
+		}
+		annotations[annotationLetsEncryptCertificateCopyToNamespacesMatching] = fmt.Sprintf("^(%v)$", strings.Join(namespaces, "|"))
+	}
+	if spec.UploadToCloudflare {
+		annotations[annotationLetsEncryptCertificateUploadToCloudflare] = "true"
+	}
+
+	return annotations
+}
+
+// applyCertificateResourceAnnotations sets the annotations for the spec on the secret and returns whether any of them changed
+func applyCertificateResourceAnnotations(secret *v1.Secret, spec LetsEncryptCertificateSpec) (changed bool) {
+	return applyManagedAnnotations(secret, getCertificateResourceAnnotations(spec))
+}
+
+// applyManagedAnnotations sets the annotations on the secret, removing the ones with an empty value, and returns whether any of them changed
+func applyManagedAnnotations(secret *v1.Secret, annotations map[string]string) (changed bool) {
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+
+	for annotation, value := range annotations {
+		currentValue, ok := secret.Annotations[annotation]
+		switch {
+		case value == "" && ok:
+			delete(secret.Annotations, annotation)
+			changed = true
+		case value != "" && currentValue != value:
+			secret.Annotations[annotation] = value
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// isOwnedByCertificateResource checks whether the secret has been created for the custom resource, so secrets managed otherwise are never taken over
+func isOwnedByCertificateResource(secret *v1.Secret, resource *LetsEncryptCertificate) bool {
+	for _, ownerReference := range secret.OwnerReferences {
+		if ownerReference.UID == resource.UID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCertificateResourceStatus returns the status of the custom resource from the state of its secret and the certificate in the secret holding the certificate data, with the Ready, Issuing, RateLimited and DNSError conditions for tools to gate rollouts on
+func getCertificateResourceStatus(resource *LetsEncryptCertificate, secret, dataSecret *v1.Secret, now time.Time) LetsEncryptCertificateStatus {
+	status := LetsEncryptCertificateStatus{
+		ObservedGeneration: resource.Generation,
+		Secret:             secret.Name,
+		Wildcard:           hasWildcardHostname(strings.Join(resource.Spec.Hostnames, ",")),
+		Conditions:         append([]metav1.Condition{}, resource.Status.Conditions...),
+	}
+
+	currentState := getCurrentSecretState(secret)
+	status.LastRenewal = currentState.LastRenewed
+
+	condition := metav1.Condition{
+		Type:               "Ready",
+		Status:             metav1.ConditionFalse,
+		Reason:             "Pending",
+		Message:            "Certificate hasn't been obtained yet",
+		ObservedGeneration: resource.Generation,
+	}
+
+	certificateBytes, _ := getSecretCertificate(dataSecret)
+	certificate, certificateErr := parseCertificate(certificateBytes)
+	if certificateErr == nil {
+		status.NotAfter = certificate.NotAfter.UTC().Format(time.RFC3339)
+	}
+
+	// a failed renewal doesn't make the resource unready while the certificate in place is still valid for its hostnames, it's reported in the RateLimited and DNSError conditions instead
+	switch {
+	case certificateErr == nil && !certificate.NotAfter.Before(now) && certificateCoversHostnames(certificate, resource.Spec.Hostnames):
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Issued"
+		condition.Message = fmt.Sprintf("Certificate is valid until %v", status.NotAfter)
+		if currentState.IssueStatus == "failed" {
+			condition.Message += ", renewing it has failed, see the events of the secret"
+		}
+	case currentState.IssueStatus == "failed":
+		condition.Reason = "IssueFailed"
+		condition.Message = "Obtaining the certificate has failed, see the events of the secret"
+	case certificateErr == nil && certificate.NotAfter.Before(now):
+		condition.Reason = "Expired"
+		condition.Message = fmt.Sprintf("Certificate has expired at %v", status.NotAfter)
+	case certificateErr == nil:
+		condition.Reason = "Renewing"
+		condition.Message = "Hostnames have changed, the certificate is being renewed"
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	for _, condition := range getCertificateResourceIssueConditions(resource, currentState, now) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}
+
+	return status
+}
+
+// certificateCoversHostnames checks whether the certificate is valid for all hostnames, including wildcard hostnames matching its wildcard names
+func certificateCoversHostnames(certificate *x509.Certificate, hostnames []string) bool {
+	for _, hostname := range hostnames {
+		if certificate.VerifyHostname(hostname) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getCertificateResourceIssueConditions returns the Issuing condition, true while an order holds the lock of the secret, and the RateLimited and DNSError conditions, true if the last order failed for that reason
+func getCertificateResourceIssueConditions(resource *LetsEncryptCertificate, currentState LetsEncryptCertificateState, now time.Time) []metav1.Condition {
+	issuing := metav1.Condition{
+		Type:               "Issuing",
+		Status:             metav1.ConditionFalse,
+		Reason:             "Idle",
+		Message:            "No order is in progress",
+		ObservedGeneration: resource.Generation,
+	}
+	lastAttempt, err := time.Parse(time.RFC3339, currentState.LastAttempt)
+	if err == nil && currentState.IssueStatus == "pending" && now.Sub(lastAttempt) <= getRetryAfter(currentState) {
+		issuing.Status = metav1.ConditionTrue
+		issuing.Reason = "Ordering"
+		issuing.Message = fmt.Sprintf("Certificate is being ordered since %v", currentState.LastAttempt)
+	}
+
+	rateLimited := metav1.Condition{
+		Type:               "RateLimited",
+		Status:             metav1.ConditionFalse,
+		Reason:             "NotRateLimited",
+		Message:            "Last order hasn't hit a rate limit",
+		ObservedGeneration: resource.Generation,
+	}
+	dnsError := metav1.Condition{
+		Type:               "DNSError",
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoDNSError",
+		Message:            "Last order hasn't failed on DNS",
+		ObservedGeneration: resource.Generation,
+	}
+	if currentState.IssueStatus == "failed" {
+		switch currentState.IssueFailure {
+		case "RateLimited":
+			rateLimited.Status = metav1.ConditionTrue
+			rateLimited.Reason = "RateLimited"
+			rateLimited.Message = "Last order has hit a rate limit of the CA, it's retried after the retry delay"
+		case "DNSError":
+			dnsError.Status = metav1.ConditionTrue
+			dnsError.Reason = "DNSError"
+			dnsError.Message = "Last order has failed on the DNS-01 challenge records, see the events of the secret"
+		}
+	}
+
+	return []metav1.Condition{issuing, rateLimited, dnsError}
+}
+
+// reconcileCertificateResource creates or updates the secret for the custom resource, which the annotation-driven flow then obtains the certificate for, and updates the status of the resource
+func reconcileCertificateResource(ctx context.Context, kubeClientset *kubernetes.Clientset, dynamicClient dynamic.Interface, resource *LetsEncryptCertificate) error {
+
+	secret, err := kubeClientset.CoreV1().Secrets(resource.Namespace).Get(ctx, resource.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      resource.Name,
+				Namespace: resource.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(resource, letsEncryptCertificateResource.GroupVersion().WithKind("LetsEncryptCertificate")),
+				},
+			},
+			Type: v1.SecretTypeOpaque,
+		}
+		applyCertificateResourceAnnotations(secret, resource.Spec)
+		secret, err = kubeClientset.CoreV1().Secrets(resource.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !isOwnedByCertificateResource(secret, resource):
+		status := LetsEncryptCertificateStatus{ObservedGeneration: resource.Generation, Conditions: append([]metav1.Condition{}, resource.Status.Conditions...)}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               "Ready",
+			Status:             metav1.ConditionFalse,
+			Reason:             "SecretConflict",
+			Message:            fmt.Sprintf("Secret %v already exists and isn't managed by this resource", resource.Name),
+			ObservedGeneration: resource.Generation,
+		})
+		return updateCertificateResourceStatus(ctx, dynamicClient, resource, status)
+	default:
+		if applyCertificateResourceAnnotations(secret, resource.Spec) {
+			secret, err = kubeClientset.CoreV1().Secrets(resource.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	dataSecret := secret
+	if resource.Spec.TargetSecret != "" {
+		dataSecret, err = kubeClientset.CoreV1().Secrets(resource.Namespace).Get(ctx, resource.Spec.TargetSecret, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			dataSecret = &v1.Secret{}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return updateCertificateResourceStatus(ctx, dynamicClient, resource, getCertificateResourceStatus(resource, secret, dataSecret, time.Now()))
+}
+
+// updateCertificateResourceStatus writes the status of the custom resource through its status subresource, if it has changed
+func updateCertificateResourceStatus(ctx context.Context, dynamicClient dynamic.Interface, resource *LetsEncryptCertificate, status LetsEncryptCertificateStatus) error {
+	if equalCertificateResourceStatus(resource.Status, status) {
+		return nil
+	}
+
+	resource.Status = status
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	if err != nil {
+		return err
+	}
+
+	_, err = dynamicClient.Resource(letsEncryptCertificateResource).Namespace(resource.Namespace).UpdateStatus(ctx, &unstructured.Unstructured{Object: object}, metav1.UpdateOptions{})
+	return err
+}
+
+// equalCertificateResourceStatus compares statuses ignoring the transition times of the conditions, which only change along with their status
+func equalCertificateResourceStatus(a, b LetsEncryptCertificateStatus) bool {
+	if a.ObservedGeneration != b.ObservedGeneration || a.Secret != b.Secret || a.NotAfter != b.NotAfter || a.LastRenewal != b.LastRenewal || a.Wildcard != b.Wildcard || len(a.Conditions) != len(b.Conditions) {
+		return false
+	}
+	for i := range a.Conditions {
+		if a.Conditions[i].Type != b.Conditions[i].Type || a.Conditions[i].Status != b.Conditions[i].Status || a.Conditions[i].Reason != b.Conditions[i].Reason || a.Conditions[i].Message != b.Conditions[i].Message || a.Conditions[i].ObservedGeneration != b.Conditions[i].ObservedGeneration {
+			return false
+		}
+	}
+
+	return true
+}
+
+// watchCertificateResources periodically reconciles all LetsEncryptCertificate custom resources alongside the annotated secrets
+func watchCertificateResources(ctx context.Context, kubeClientset *kubernetes.Clientset, dynamicClient dynamic.Interface) {
+	for {
+		list, err := dynamicClient.Resource(letsEncryptCertificateResource).Namespace("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			log.Error().Err(err).Msg("Listing LetsEncryptCertificate resources failed, is the crd installed?")
+		} else {
+			for _, item := range list.Items {
+				// resources of other shards are reconciled by their own replica, which also handles the secret with the same name
+				if !replicaShard.Owns(fmt.Sprintf("%v/%v", item.GetNamespace(), item.GetName())) {
+					continue
+				}
+				resource := &LetsEncryptCertificate{}
+				err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, resource)
+				if err != nil {
+					log.Warn().Err(err).Msgf("LetsEncryptCertificate %v.%v is invalid", item.GetName(), item.GetNamespace())
+					continue
+				}
+				if len(resource.Spec.Hostnames) == 0 {
+					log.Warn().Msgf("LetsEncryptCertificate %v.%v has no hostnames", resource.Name, resource.Namespace)
+					continue
+				}
+				err = reconcileCertificateResource(ctx, kubeClientset, dynamicClient, resource)
+				if err != nil {
+					log.Warn().Err(err).Msgf("Reconciling LetsEncryptCertificate %v.%v failed", resource.Name, resource.Namespace)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*certificateResourceInterval):
+		}
+	}
+}
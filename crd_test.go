@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyCertificateResourceAnnotations(t *testing.T) {
+
+	t.Run("SetsAnnotationsFromSpec", func(t *testing.T) {
+
+		secret := &v1.Secret{}
+		spec := LetsEncryptCertificateSpec{
+			Hostnames:          []string{"estafette.io", "www.estafette.io"},
+			CopyToNamespaces:   []string{"team-a", "team.b"},
+			UploadToCloudflare: true,
+		}
+
+		// act
+		changed := applyCertificateResourceAnnotations(secret, spec)
+
+		assert.True(t, changed)
+		assert.Equal(t, map[string]string{
+			annotationLetsEncryptCertificate:                         "true",
+			annotationLetsEncryptCertificateHostnames:                "estafette.io,www.estafette.io",
+			annotationLetsEncryptCertificateCopyToNamespacesMatching: `^(team-a|team\.b)$`,
+			annotationLetsEncryptCertificateUploadToCloudflare:       "true",
+		}, secret.Annotations)
+	})
+
+	t.Run("RemovesAnnotationsNoLongerInSpec", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationLetsEncryptCertificate:                    "true",
+			annotationLetsEncryptCertificateHostnames:           "estafette.io",
+			annotationLetsEncryptCertificateCopyToAllNamespaces: "true",
+			annotationLetsEncryptCertificateState:               "{}",
+		}}}
+
+		// act
+		changed := applyCertificateResourceAnnotations(secret, LetsEncryptCertificateSpec{Hostnames: []string{"estafette.io"}})
+
+		assert.True(t, changed)
+		assert.Equal(t, map[string]string{
+			annotationLetsEncryptCertificate:          "true",
+			annotationLetsEncryptCertificateHostnames: "estafette.io",
+			annotationLetsEncryptCertificateState:     "{}",
+		}, secret.Annotations)
+	})
+
+	t.Run("ReturnsFalseIfAnnotationsMatchSpec", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationLetsEncryptCertificate:          "true",
+			annotationLetsEncryptCertificateHostnames: "estafette.io",
+		}}}
+
+		// act
+		changed := applyCertificateResourceAnnotations(secret, LetsEncryptCertificateSpec{Hostnames: []string{"estafette.io"}})
+
+		assert.False(t, changed)
+	})
+}
+
+func TestGetCertificateResourceStatus(t *testing.T) {
+
+	resource := &LetsEncryptCertificate{
+		ObjectMeta: metav1.ObjectMeta{Name: "mycertificate", Namespace: "mynamespace", Generation: 2},
+		Spec:       LetsEncryptCertificateSpec{Hostnames: []string{"estafette.io"}},
+	}
+
+	t.Run("ReturnsPendingIfSecretHasNoCertificate", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mycertificate"}}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		condition := meta.FindStatusCondition(status.Conditions, "Ready")
+		assert.Equal(t, "mycertificate", status.Secret)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Pending", condition.Reason)
+		assert.Equal(t, int64(2), condition.ObservedGeneration)
+	})
+
+	t.Run("ReturnsReadyIfSecretHasValidCertificate", func(t *testing.T) {
+
+		notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: `{"hostnames":"estafette.io","lastRenewed":"2022-12-01T00:00:00Z"}`,
+				},
+			},
+			Data: map[string][]byte{"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), notAfter)},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		condition := meta.FindStatusCondition(status.Conditions, "Ready")
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Issued", condition.Reason)
+		assert.Equal(t, notAfter.Format(time.RFC3339), status.NotAfter)
+		assert.Equal(t, "2022-12-01T00:00:00Z", status.LastRenewal)
+	})
+
+	t.Run("ReturnsIssueFailedIfLastIssueFailed", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: `{"hostnames":"estafette.io","issueStatus":"failed"}`,
+				},
+			},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		condition := meta.FindStatusCondition(status.Conditions, "Ready")
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "IssueFailed", condition.Reason)
+	})
+
+	t.Run("ReturnsReadyIfLastIssueFailedWhileCertificateIsValid", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: `{"hostnames":"estafette.io","issueStatus":"failed","issueFailure":"RateLimited"}`,
+				},
+			},
+			Data: map[string][]byte{"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		condition := meta.FindStatusCondition(status.Conditions, "Ready")
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Issued", condition.Reason)
+		assert.Equal(t, metav1.ConditionTrue, meta.FindStatusCondition(status.Conditions, "RateLimited").Status)
+	})
+
+	t.Run("ReturnsRenewingIfCertificateDoesNotCoverHostnames", func(t *testing.T) {
+
+		resource := &LetsEncryptCertificate{
+			ObjectMeta: metav1.ObjectMeta{Name: "mycertificate", Namespace: "mynamespace", Generation: 3},
+			Spec:       LetsEncryptCertificateSpec{Hostnames: []string{"estafette.io", "www.estafette.io"}},
+		}
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: `{"hostnames":"estafette.io"}`,
+				},
+			},
+			Data: map[string][]byte{"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour))},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		condition := meta.FindStatusCondition(status.Conditions, "Ready")
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Renewing", condition.Reason)
+	})
+
+	t.Run("SetsObservedGeneration", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mycertificate"}}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		assert.Equal(t, int64(2), status.ObservedGeneration)
+		for _, conditionType := range []string{"Ready", "Issuing", "RateLimited", "DNSError"} {
+			assert.Equal(t, int64(2), meta.FindStatusCondition(status.Conditions, conditionType).ObservedGeneration, conditionType)
+		}
+	})
+
+	t.Run("ReturnsIssuingWhileOrderHoldsLock", func(t *testing.T) {
+
+		now := time.Now().UTC().Truncate(time.Second)
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: fmt.Sprintf(`{"hostnames":"estafette.io","lastAttempt":"%v","issueStatus":"pending","retryAfterMinutes":15}`, now.Add(-time.Minute).Format(time.RFC3339)),
+				},
+			},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, now)
+
+		condition := meta.FindStatusCondition(status.Conditions, "Issuing")
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Ordering", condition.Reason)
+	})
+
+	t.Run("ReturnsNotIssuingOnceLockHasExpired", func(t *testing.T) {
+
+		now := time.Now().UTC().Truncate(time.Second)
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: fmt.Sprintf(`{"hostnames":"estafette.io","lastAttempt":"%v","issueStatus":"pending","retryAfterMinutes":15}`, now.Add(-time.Hour).Format(time.RFC3339)),
+				},
+			},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, now)
+
+		condition := meta.FindStatusCondition(status.Conditions, "Issuing")
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Idle", condition.Reason)
+	})
+
+	t.Run("ReturnsRateLimitedIfLastIssueHitRateLimit", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: `{"hostnames":"estafette.io","issueStatus":"failed","issueFailure":"RateLimited"}`,
+				},
+			},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		assert.Equal(t, metav1.ConditionTrue, meta.FindStatusCondition(status.Conditions, "RateLimited").Status)
+		assert.Equal(t, metav1.ConditionFalse, meta.FindStatusCondition(status.Conditions, "DNSError").Status)
+	})
+
+	t.Run("ReturnsDNSErrorIfLastIssueFailedOnDNS", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycertificate",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateState: `{"hostnames":"estafette.io","issueStatus":"failed","issueFailure":"DNSError"}`,
+				},
+			},
+		}
+
+		// act
+		status := getCertificateResourceStatus(resource, secret, secret, time.Now())
+
+		assert.Equal(t, metav1.ConditionTrue, meta.FindStatusCondition(status.Conditions, "DNSError").Status)
+		assert.Equal(t, metav1.ConditionFalse, meta.FindStatusCondition(status.Conditions, "RateLimited").Status)
+	})
+}
+
+func TestIsOwnedByCertificateResource(t *testing.T) {
+
+	t.Run("ReturnsFalseIfSecretIsNotOwnedByResource", func(t *testing.T) {
+
+		resource := &LetsEncryptCertificate{ObjectMeta: metav1.ObjectMeta{UID: "a"}}
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{UID: "b"}}}}
+
+		// act
+		owned := isOwnedByCertificateResource(secret, resource)
+
+		assert.False(t, owned)
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getCloudflareAuthenticationForSecret returns the cloudflare credentials from the credentials secret in the namespace of a managed secret, or the global credentials if it has none configured
+func getCloudflareAuthenticationForSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace string, desiredState LetsEncryptCertificateState) (APIAuthentication, error) {
+	if desiredState.CredentialsSecret == "" {
+		return getCloudflareAuthentication(), nil
+	}
+
+	// only read secrets from the same namespace, so teams can't use each other's cloudflare accounts
+	credentialsSecret, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, desiredState.CredentialsSecret, metav1.GetOptions{})
+	if err != nil {
+		return APIAuthentication{}, fmt.Errorf("Getting credentials secret %v.%v failed: %w", desiredState.CredentialsSecret, namespace, err)
+	}
+
+	return getCloudflareAuthenticationFromSecret(credentialsSecret)
+}
+
+// getCloudflareAuthenticationFromSecret reads the cloudflareApiToken, or the cloudflareApiKey and cloudflareApiEmail data items, like in the secret of the helm chart
+func getCloudflareAuthenticationFromSecret(secret *v1.Secret) (APIAuthentication, error) {
+	if token := strings.TrimSpace(string(secret.Data["cloudflareApiToken"])); token != "" {
+		return APIAuthentication{Token: token}, nil
+	}
+
+	authentication := APIAuthentication{
+		Key:   strings.TrimSpace(string(secret.Data["cloudflareApiKey"])),
+		Email: strings.TrimSpace(string(secret.Data["cloudflareApiEmail"])),
+	}
+	if authentication.Key == "" || authentication.Email == "" {
+		return APIAuthentication{}, fmt.Errorf("Credentials secret %v.%v should have either a cloudflareApiToken or a cloudflareApiKey and cloudflareApiEmail", secret.Name, secret.Namespace)
+	}
+
+	return authentication, nil
+}
+
+// acmeEABCredentials are the key id and base64url encoded hmac key to bind the account to an external account at the CA with
+type acmeEABCredentials struct {
+	KeyID   string
+	HMACKey string
+}
+
+// getACMEEABCredentialsForSecret returns the external account binding credentials from the credentials secret in the namespace of a managed secret, or the ones of the controller if it has none configured or the secret doesn't hold any
+func getACMEEABCredentialsForSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace string, desiredState LetsEncryptCertificateState) (acmeEABCredentials, error) {
+	globalCredentials := acmeEABCredentials{KeyID: *acmeEABKeyID, HMACKey: *acmeEABHMACKey}
+	if desiredState.CredentialsSecret == "" {
+		return globalCredentials, nil
+	}
+
+	// only read secrets from the same namespace, so teams can't use each other's accounts
+	credentialsSecret, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, desiredState.CredentialsSecret, metav1.GetOptions{})
+	if err != nil {
+		return acmeEABCredentials{}, fmt.Errorf("Getting credentials secret %v.%v failed: %w", desiredState.CredentialsSecret, namespace, err)
+	}
+
+	credentials, err := getACMEEABCredentialsFromSecret(credentialsSecret)
+	if err != nil || credentials.KeyID == "" {
+		return globalCredentials, err
+	}
+
+	return credentials, nil
+}
+
+// getACMEEABCredentialsFromSecret reads the acmeEabKeyId and acmeEabHmacKey data items; both are empty if the secret has no external account binding
+func getACMEEABCredentialsFromSecret(secret *v1.Secret) (acmeEABCredentials, error) {
+	credentials := acmeEABCredentials{
+		KeyID:   strings.TrimSpace(string(secret.Data["acmeEabKeyId"])),
+		HMACKey: strings.TrimSpace(string(secret.Data["acmeEabHmacKey"])),
+	}
+	if (credentials.KeyID == "") != (credentials.HMACKey == "") {
+		return acmeEABCredentials{}, fmt.Errorf("Credentials secret %v.%v should have both acmeEabKeyId and acmeEabHmacKey or neither", secret.Name, secret.Namespace)
+	}
+
+	return credentials, nil
+}
+
+// azureCredentials are the settings to manage the records of azure dns zones with; without client id and secret the managed identity of the node is used
+type azureCredentials struct {
+	SubscriptionID string
+	ResourceGroup  string
+	TenantID       string
+	ClientID       string
+	ClientSecret   string
+}
+
+// getAzureCredentials returns the azure credentials set through the flags of the controller
+func getAzureCredentials() azureCredentials {
+	return azureCredentials{
+		SubscriptionID: *azureSubscriptionID,
+		ResourceGroup:  *azureResourceGroup,
+		TenantID:       *azureTenantID,
+		ClientID:       *azureClientID,
+		ClientSecret:   *azureClientSecret,
+	}
+}
+
+// getAzureCredentialsForSecret returns the azure credentials from the credentials secret in the namespace of a managed secret, or the ones of the controller if it has none configured
+func getAzureCredentialsForSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace string, desiredState LetsEncryptCertificateState) (azureCredentials, error) {
+	if desiredState.CredentialsSecret == "" {
+		return getAzureCredentials(), nil
+	}
+
+	// only read secrets from the same namespace, so teams can't use each other's azure subscriptions
+	credentialsSecret, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, desiredState.CredentialsSecret, metav1.GetOptions{})
+	if err != nil {
+		return azureCredentials{}, fmt.Errorf("Getting credentials secret %v.%v failed: %w", desiredState.CredentialsSecret, namespace, err)
+	}
+
+	return getAzureCredentialsFromSecret(credentialsSecret)
+}
+
+// getAzureCredentialsFromSecret reads the azureSubscriptionId, azureResourceGroup, azureTenantId, azureClientId and azureClientSecret data items of a service principal
+func getAzureCredentialsFromSecret(secret *v1.Secret) (azureCredentials, error) {
+	credentials := azureCredentials{
+		SubscriptionID: strings.TrimSpace(string(secret.Data["azureSubscriptionId"])),
+		ResourceGroup:  strings.TrimSpace(string(secret.Data["azureResourceGroup"])),
+		TenantID:       strings.TrimSpace(string(secret.Data["azureTenantId"])),
+		ClientID:       strings.TrimSpace(string(secret.Data["azureClientId"])),
+		ClientSecret:   strings.TrimSpace(string(secret.Data["azureClientSecret"])),
+	}
+	if credentials.SubscriptionID == "" || credentials.ResourceGroup == "" || credentials.TenantID == "" || credentials.ClientID == "" || credentials.ClientSecret == "" {
+		return azureCredentials{}, fmt.Errorf("Credentials secret %v.%v should have an azureSubscriptionId, azureResourceGroup, azureTenantId, azureClientId and azureClientSecret", secret.Name, secret.Namespace)
+	}
+
+	return credentials, nil
+}
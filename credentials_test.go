@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCloudflareAuthenticationFromSecret(t *testing.T) {
+
+	t.Run("ReturnsTokenIfSecretHasApiToken", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			Data: map[string][]byte{
+				"cloudflareApiToken": []byte("zv18u3e9ntphs79kctdxxj5wr2kjepva04hij\n"),
+				"cloudflareApiKey":   []byte("r2kjepva04hijzv18u3e9ntphs79kctdxxj5w"),
+				"cloudflareApiEmail": []byte("name@server.com"),
+			},
+		}
+
+		// act
+		authentication, err := getCloudflareAuthenticationFromSecret(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, APIAuthentication{Token: "zv18u3e9ntphs79kctdxxj5wr2kjepva04hij"}, authentication)
+	})
+
+	t.Run("ReturnsKeyAndEmailIfSecretHasNoApiToken", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			Data: map[string][]byte{
+				"cloudflareApiKey":   []byte("r2kjepva04hijzv18u3e9ntphs79kctdxxj5w"),
+				"cloudflareApiEmail": []byte("name@server.com"),
+			},
+		}
+
+		// act
+		authentication, err := getCloudflareAuthenticationFromSecret(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, APIAuthentication{Key: "r2kjepva04hijzv18u3e9ntphs79kctdxxj5w", Email: "name@server.com"}, authentication)
+	})
+
+	t.Run("ReturnsErrorIfSecretHasKeyWithoutEmail", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cloudflare", Namespace: "mynamespace"},
+			Data: map[string][]byte{
+				"cloudflareApiKey": []byte("r2kjepva04hijzv18u3e9ntphs79kctdxxj5w"),
+			},
+		}
+
+		// act
+		_, err := getCloudflareAuthenticationFromSecret(secret)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetAzureCredentialsFromSecret(t *testing.T) {
+
+	t.Run("ReturnsServicePrincipalFromSecret", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			Data: map[string][]byte{
+				"azureSubscriptionId": []byte("e4b9f8a0-0e1b-4a53-9b6e-6d3c1a2b7f10"),
+				"azureResourceGroup":  []byte("dns"),
+				"azureTenantId":       []byte("0d7b6c2e-5f4a-4e3b-8c1d-2a9f8e7d6c5b"),
+				"azureClientId":       []byte("7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d\n"),
+				"azureClientSecret":   []byte("kctdxxj5wr2kjepva04hij"),
+			},
+		}
+
+		// act
+		credentials, err := getAzureCredentialsFromSecret(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, azureCredentials{
+			SubscriptionID: "e4b9f8a0-0e1b-4a53-9b6e-6d3c1a2b7f10",
+			ResourceGroup:  "dns",
+			TenantID:       "0d7b6c2e-5f4a-4e3b-8c1d-2a9f8e7d6c5b",
+			ClientID:       "7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d",
+			ClientSecret:   "kctdxxj5wr2kjepva04hij",
+		}, credentials)
+	})
+
+	t.Run("ReturnsErrorIfSecretHasNoClientSecret", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "azure", Namespace: "mynamespace"},
+			Data: map[string][]byte{
+				"azureSubscriptionId": []byte("e4b9f8a0-0e1b-4a53-9b6e-6d3c1a2b7f10"),
+				"azureResourceGroup":  []byte("dns"),
+				"azureTenantId":       []byte("0d7b6c2e-5f4a-4e3b-8c1d-2a9f8e7d6c5b"),
+				"azureClientId":       []byte("7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d"),
+			},
+		}
+
+		// act
+		_, err := getAzureCredentialsFromSecret(secret)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetACMEEABCredentialsFromSecret(t *testing.T) {
+
+	t.Run("ReturnsKeyIdAndHmacKey", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			Data: map[string][]byte{
+				"acmeEabKeyId":   []byte("kid-1\n"),
+				"acmeEabHmacKey": []byte("c2VjcmV0LWhtYWMta2V5"),
+			},
+		}
+
+		// act
+		credentials, err := getACMEEABCredentialsFromSecret(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, acmeEABCredentials{KeyID: "kid-1", HMACKey: "c2VjcmV0LWhtYWMta2V5"}, credentials)
+	})
+
+	t.Run("ReturnsEmptyCredentialsIfSecretHasNoBinding", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			Data: map[string][]byte{
+				"cloudflareApiToken": []byte("zv18u3e9ntphs79kctdxxj5wr2kjepva04hij"),
+			},
+		}
+
+		// act
+		credentials, err := getACMEEABCredentialsFromSecret(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, acmeEABCredentials{}, credentials)
+	})
+
+	t.Run("ReturnsErrorIfHmacKeyIsMissing", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "team-a"},
+			Data: map[string][]byte{
+				"acmeEabKeyId": []byte("kid-1"),
+			},
+		}
+
+		// act
+		_, err := getACMEEABCredentialsFromSecret(secret)
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer postpones executing a function per key until no new calls for that key have come in for the configured delay, so a burst of calls results in a single execution of the last function passed in; with a maximum wait a steady stream of calls can't postpone it forever
+type debouncer struct {
+	delay      time.Duration
+	maxWait    time.Duration
+	mutex      sync.Mutex
+	calls      map[string]*debouncedCall
+	processing sync.Mutex
+}
+
+// debouncedCall is the function pending for a key and the timer that runs it
+type debouncedCall struct {
+	timer    *time.Timer
+	f        func()
+	deadline time.Time
+}
+
+// newDebouncer returns a debouncer that runs a function once no calls for its key came in for delay, or at the latest maxWait after the first of them; a maxWait of 0 waits as long as calls keep coming in
+func newDebouncer(delay, maxWait time.Duration) *debouncer {
+	return &debouncer{
+		delay:   delay,
+		maxWait: maxWait,
+		calls:   map[string]*debouncedCall{},
+	}
+}
+
+// Debounce schedules f to run after the delay, replacing any function still pending for the same key; functions for different keys never run simultaneously
+func (d *debouncer) Debounce(key string, f func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// a timer that has already fired can't be reset without running its function twice, so every call gets a timer of its own and a timer that fired for a replaced call does nothing
+	call := &debouncedCall{f: f}
+	if d.maxWait > 0 {
+		call.deadline = time.Now().Add(d.maxWait)
+	}
+	if pending, ok := d.calls[key]; ok {
+		pending.timer.Stop()
+		call.deadline = pending.deadline
+	}
+
+	delay := d.delay
+	if !call.deadline.IsZero() && time.Until(call.deadline) < delay {
+		delay = time.Until(call.deadline)
+	}
+
+	d.calls[key] = call
+	call.timer = time.AfterFunc(delay, func() {
+		d.mutex.Lock()
+		if d.calls[key] != call {
+			d.mutex.Unlock()
+			return
+		}
+		delete(d.calls, key)
+		d.mutex.Unlock()
+
+		d.processing.Lock()
+		defer d.processing.Unlock()
+		call.f()
+	})
+}
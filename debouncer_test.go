@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebounce(t *testing.T) {
+	t.Run("RunsOnlyTheLastFunctionForABurstOfCallsWithTheSameKey", func(t *testing.T) {
+
+		d := newDebouncer(50*time.Millisecond, 0)
+		var calls, lastValue int32
+
+		// act
+		for i := int32(1); i <= 5; i++ {
+			value := i
+			d.Debounce("mynamespace/mysecret", func() {
+				atomic.AddInt32(&calls, 1)
+				atomic.StoreInt32(&lastValue, value)
+			})
+		}
+		time.Sleep(200 * time.Millisecond)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		assert.Equal(t, int32(5), atomic.LoadInt32(&lastValue))
+	})
+
+	t.Run("RunsFunctionsForDifferentKeysIndependently", func(t *testing.T) {
+
+		d := newDebouncer(50*time.Millisecond, 0)
+		var calls int32
+
+		// act
+		d.Debounce("mynamespace/mysecret", func() { atomic.AddInt32(&calls, 1) })
+		d.Debounce("mynamespace/myothersecret", func() { atomic.AddInt32(&calls, 1) })
+		time.Sleep(200 * time.Millisecond)
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+	t.Run("RunsTheLastFunctionForCallsComingInWhileAnEarlierCallFires", func(t *testing.T) {
+
+		d := newDebouncer(time.Millisecond, 0)
+		var lastValue int32
+
+		// act
+		for i := int32(1); i <= 1000; i++ {
+			value := i
+			d.Debounce("mynamespace/mysecret", func() { atomic.StoreInt32(&lastValue, value) })
+			time.Sleep(time.Duration(i%3) * time.Millisecond)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		assert.Equal(t, int32(1000), atomic.LoadInt32(&lastValue))
+	})
+
+	t.Run("RunsAgainAfterAnEarlierCallHasRun", func(t *testing.T) {
+
+		d := newDebouncer(10*time.Millisecond, 0)
+		var calls int32
+
+		// act
+		d.Debounce("mynamespace/mysecret", func() { atomic.AddInt32(&calls, 1) })
+		time.Sleep(100 * time.Millisecond)
+		d.Debounce("mynamespace/mysecret", func() { atomic.AddInt32(&calls, 1) })
+		time.Sleep(100 * time.Millisecond)
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+	t.Run("RunsAfterTheMaximumWaitWhileCallsKeepComingIn", func(t *testing.T) {
+
+		d := newDebouncer(50*time.Millisecond, 100*time.Millisecond)
+		var calls int32
+		start := time.Now()
+
+		// act
+		for atomic.LoadInt32(&calls) == 0 && time.Since(start) < time.Second {
+			d.Debounce("namespaces", func() { atomic.AddInt32(&calls, 1) })
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// challengeRecord is a DNS-01 challenge record presented for a hostname during an order
+type challengeRecord struct {
+	Domain string `json:"domain"`
+	FQDN   string `json:"fqdn"`
+	Value  string `json:"value"`
+}
+
+// acmeDiagnostics describes a failed order, so it can be inspected at the CA instead of re-running it blind
+type acmeDiagnostics struct {
+	Time        string            `json:"time"`
+	Environment string            `json:"environment"`
+	Account     string            `json:"account"`
+	Hostnames   string            `json:"hostnames"`
+	URLs        []string          `json:"urls"`
+	Records     []challengeRecord `json:"records"`
+	Error       string            `json:"error"`
+}
+
+// acmeOrderError wraps the error of a failed order with its diagnostics
+type acmeOrderError struct {
+	err         error
+	diagnostics acmeDiagnostics
+}
+
+func (e *acmeOrderError) Error() string {
+	return e.err.Error()
+}
+
+func (e *acmeOrderError) Unwrap() error {
+	return e.err
+}
+
+// recordingDNSProvider wraps a DNS-01 provider to keep track of the challenge records it presents
+type recordingDNSProvider struct {
+	challenge.Provider
+	mutex   sync.Mutex
+	records []challengeRecord
+}
+
+// Present records the challenge record before presenting it with the wrapped provider
+func (p *recordingDNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
+
+	p.mutex.Lock()
+	p.records = append(p.records, challengeRecord{Domain: domain, FQDN: fqdn, Value: value})
+	p.mutex.Unlock()
+
+	return p.Provider.Present(domain, token, keyAuth)
+}
+
+// Timeout passes through the timeout and polling interval of the wrapped provider
+func (p *recordingDNSProvider) Timeout() (timeout, interval time.Duration) {
+	if providerTimeout, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return providerTimeout.Timeout()
+	}
+
+	return 60 * time.Second, 2 * time.Second
+}
+
+// Records returns the challenge records presented so far
+func (p *recordingDNSProvider) Records() []challengeRecord {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]challengeRecord{}, p.records...)
+}
+
+var acmeURLRegex = regexp.MustCompile(`https?://[^\s,"']+`)
+
+// getACMEErrorURLs returns the order, authorization and challenge urls the CA reported problems for; lego doesn't expose the urls of a failed order otherwise, and joins the problems per hostname into a single error message
+func getACMEErrorURLs(err error) []string {
+	urls := []string{}
+	add := func(url string) {
+		url = strings.TrimRight(url, ".:;)")
+		if url != "" && !containsString(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+
+	var problem *acme.ProblemDetails
+	if errors.As(err, &problem) {
+		add(problem.URL)
+		add(problem.Instance)
+	}
+	for _, url := range acmeURLRegex.FindAllString(err.Error(), -1) {
+		add(url)
+	}
+
+	return urls
+}
+
+// getACMEDiagnostics returns the diagnostics of a failed order
+func getACMEDiagnostics(err error, desiredState LetsEncryptCertificateState, account string, records []challengeRecord) acmeDiagnostics {
+	return acmeDiagnostics{
+		Time:        time.Now().UTC().Format(time.RFC3339),
+		Environment: desiredState.Environment,
+		Account:     account,
+		Hostnames:   desiredState.Hostnames,
+		URLs:        getACMEErrorURLs(err),
+		Records:     records,
+		Error:       err.Error(),
+	}
+}
+
+// recordACMEDiagnostics persists the diagnostics of a failed order in the diagnostics annotation of the secret or in an event, depending on --acme-diagnostics
+func recordACMEDiagnostics(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, orderErr error) {
+	var diagnosticsErr *acmeOrderError
+	if *acmeDiagnosticsTarget == "none" || !errors.As(orderErr, &diagnosticsErr) {
+		return
+	}
+
+	diagnosticsBytes, err := json.Marshal(diagnosticsErr.diagnostics)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Serializing order diagnostics failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	switch *acmeDiagnosticsTarget {
+	case "annotation":
+		// reload secret to avoid object has been modified error
+		secret, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+		if err == nil {
+			secret.Annotations[annotationLetsEncryptCertificateDiagnostics] = string(diagnosticsBytes)
+			_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		}
+	case "event":
+		err = postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "Obtain", "OrderDiagnostics", fmt.Sprintf("Order for secret %v failed: %v", secret.Name, string(diagnosticsBytes)), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Recording order diagnostics failed", initiator, secret.Name, secret.Namespace)
+	}
+}
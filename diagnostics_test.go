@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDNSProvider struct {
+	presented int
+	cleanedUp int
+}
+
+func (p *fakeDNSProvider) Present(domain, token, keyAuth string) error {
+	p.presented++
+	return nil
+}
+
+func (p *fakeDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	p.cleanedUp++
+	return nil
+}
+
+func (p *fakeDNSProvider) Timeout() (timeout, interval time.Duration) {
+	return 10 * time.Minute, 5 * time.Second
+}
+
+func TestRecordingDNSProvider(t *testing.T) {
+
+	t.Run("RecordsPresentedChallengeRecords", func(t *testing.T) {
+
+		provider := &fakeDNSProvider{}
+		recordingProvider := &recordingDNSProvider{Provider: provider}
+
+		// act
+		err := recordingProvider.Present("estafette.io", "token", "keyauth")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, provider.presented)
+		assert.Equal(t, 1, len(recordingProvider.Records()))
+		assert.Equal(t, "estafette.io", recordingProvider.Records()[0].Domain)
+		assert.Equal(t, "_acme-challenge.estafette.io.", recordingProvider.Records()[0].FQDN)
+		assert.NotEqual(t, "", recordingProvider.Records()[0].Value)
+	})
+
+	t.Run("PassesThroughTimeoutOfWrappedProvider", func(t *testing.T) {
+
+		recordingProvider := &recordingDNSProvider{Provider: &fakeDNSProvider{}}
+
+		// act
+		timeout, interval := recordingProvider.Timeout()
+
+		assert.Equal(t, 10*time.Minute, timeout)
+		assert.Equal(t, 5*time.Second, interval)
+	})
+}
+
+func TestGetACMEErrorURLs(t *testing.T) {
+
+	t.Run("ReturnsUrlsOfProblemDetails", func(t *testing.T) {
+
+		err := fmt.Errorf("obtaining failed: %w", &acme.ProblemDetails{
+			HTTPStatus: 403,
+			Type:       "urn:ietf:params:acme:error:unauthorized",
+			Method:     "POST",
+			URL:        "https://acme-v02.api.letsencrypt.org/acme/chall-v3/123/abc",
+			Instance:   "https://letsencrypt.org/docs/unauthorized",
+		})
+
+		// act
+		urls := getACMEErrorURLs(err)
+
+		assert.Equal(t, []string{"https://acme-v02.api.letsencrypt.org/acme/chall-v3/123/abc", "https://letsencrypt.org/docs/unauthorized"}, urls)
+	})
+
+	t.Run("ReturnsUrlsFromErrorMessageOnce", func(t *testing.T) {
+
+		err := errors.New("error: one or more domains had a problem:\n[estafette.io] acme: error: 400 :: POST :: https://acme-v02.api.letsencrypt.org/acme/authz-v3/1 :: dns\n[www.estafette.io] acme: error: 400 :: POST :: https://acme-v02.api.letsencrypt.org/acme/authz-v3/1 :: dns\n")
+
+		// act
+		urls := getACMEErrorURLs(err)
+
+		assert.Equal(t, []string{"https://acme-v02.api.letsencrypt.org/acme/authz-v3/1"}, urls)
+	})
+}
+
+func TestACMEOrderError(t *testing.T) {
+
+	t.Run("UnwrapsToOrderError", func(t *testing.T) {
+
+		problem := &acme.ProblemDetails{Type: acmeUserActionRequiredError}
+		err := &acmeOrderError{err: problem}
+
+		// act
+		result := isTermsOfServiceUpdateError(err)
+
+		assert.True(t, result)
+		assert.Equal(t, problem.Error(), err.Error())
+	})
+}
+
+func TestGetIssueFailure(t *testing.T) {
+
+	t.Run("ReturnsRateLimitedForRateLimitedProblem", func(t *testing.T) {
+
+		err := &acmeOrderError{err: fmt.Errorf("obtaining failed: %w", &acme.ProblemDetails{HTTPStatus: 429, Type: acmeRateLimitedError})}
+
+		// act
+		failure := getIssueFailure(err)
+
+		assert.Equal(t, "RateLimited", failure)
+	})
+
+	t.Run("ReturnsDNSErrorForFailedAuthorization", func(t *testing.T) {
+
+		err := errors.New("error: one or more domains had a problem:\n[estafette.io] acme: error: 400 :: urn:ietf:params:acme:error:dns :: DNS problem: NXDOMAIN looking up TXT for _acme-challenge.estafette.io")
+
+		// act
+		failure := getIssueFailure(err)
+
+		assert.Equal(t, "DNSError", failure)
+	})
+
+	t.Run("ReturnsDNSErrorIfRecordDidNotPropagate", func(t *testing.T) {
+
+		err := errors.New("[estafette.io] propagation: time limit exceeded: last error: NS ns1.estafette.io. did not return the expected TXT record")
+
+		// act
+		failure := getIssueFailure(err)
+
+		assert.Equal(t, "DNSError", failure)
+	})
+
+	t.Run("ReturnsEmptyStringForOtherFailures", func(t *testing.T) {
+
+		err := &acme.ProblemDetails{HTTPStatus: 403, Type: "urn:ietf:params:acme:error:unauthorized"}
+
+		// act
+		failure := getIssueFailure(err)
+
+		assert.Equal(t, "", failure)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// dnsBudget spaces out the api calls to each DNS provider, so bursts of challenges stay within a soft budget of calls per window instead of running into the quota of the provider
+type dnsBudget struct {
+	mutex  sync.Mutex
+	window time.Duration
+	limits map[string]int
+	calls  map[string][]time.Time
+}
+
+func newDNSBudget(limits map[string]int, window time.Duration) *dnsBudget {
+	return &dnsBudget{
+		window: window,
+		limits: limits,
+		calls:  map[string][]time.Time{},
+	}
+}
+
+// Reserve schedules an api call to the provider and returns how long to wait before making it; calls to providers without a budget never wait
+func (b *dnsBudget) Reserve(provider string, now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	calls := b.pruneCalls(provider, now)
+	at := now
+	if limit := b.limits[provider]; limit > 0 && len(calls) >= limit {
+		// the call has to wait until the limit-th most recent call has left the window
+		if slot := calls[len(calls)-limit].Add(b.window); slot.After(at) {
+			at = slot
+		}
+	}
+	b.calls[provider] = append(calls, at)
+
+	return at.Sub(now)
+}
+
+// Used returns the number of api calls to the provider made or scheduled within the window
+func (b *dnsBudget) Used(provider string, now time.Time) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.pruneCalls(provider, now))
+}
+
+// pruneCalls forgets the calls to the provider that have left the window; the caller has to hold the mutex
+func (b *dnsBudget) pruneCalls(provider string, now time.Time) []time.Time {
+	calls := b.calls[provider]
+	start := 0
+	for start < len(calls) && !calls[start].After(now.Add(-b.window)) {
+		start++
+	}
+	b.calls[provider] = calls[start:]
+
+	return b.calls[provider]
+}
+
+// parseDNSBudgetLimits parses comma-separated provider=calls pairs into the number of api calls per window allowed for each provider
+func parseDNSBudgetLimits(value string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || !containsString(dnsProviders, parts[0]) {
+			return nil, fmt.Errorf("DNS api budget %v is not a <provider>=<calls> pair for one of %v", pair, dnsProviders)
+		}
+		calls, err := strconv.Atoi(parts[1])
+		if err != nil || calls <= 0 {
+			return nil, fmt.Errorf("DNS api budget %v doesn't have a positive number of calls", pair)
+		}
+		limits[parts[0]] = calls
+	}
+
+	return limits, nil
+}
+
+// budgetedDNSProvider wraps a DNS-01 provider to count its api calls and space them out within the budget of the provider
+type budgetedDNSProvider struct {
+	challenge.Provider
+	name   string
+	budget *dnsBudget
+}
+
+// Present waits for room in the budget before presenting the challenge record with the wrapped provider
+func (p *budgetedDNSProvider) Present(domain, token, keyAuth string) error {
+	p.wait(domain, "present")
+
+	return p.Provider.Present(domain, token, keyAuth)
+}
+
+// CleanUp waits for room in the budget before removing the challenge record with the wrapped provider
+func (p *budgetedDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	p.wait(domain, "cleanup")
+
+	return p.Provider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout passes through the timeout and polling interval of the wrapped provider
+func (p *budgetedDNSProvider) Timeout() (timeout, interval time.Duration) {
+	if providerTimeout, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return providerTimeout.Timeout()
+	}
+
+	return 60 * time.Second, 2 * time.Second
+}
+
+func (p *budgetedDNSProvider) wait(domain, operation string) {
+	delay := p.budget.Reserve(p.name, time.Now())
+	if delay > 0 {
+		log.Info().Msgf("DNS api budget of %v is used up, waiting %v before the %v call for %v...", p.name, delay.Round(time.Second), operation, domain)
+		dnsAPIBudgetDelay.With(prometheus.Labels{"provider": p.name}).Add(delay.Seconds())
+		time.Sleep(delay)
+	}
+
+	dnsAPICallTotals.With(prometheus.Labels{"provider": p.name, "operation": operation}).Inc()
+	dnsAPIBudgetUsed.With(prometheus.Labels{"provider": p.name}).Set(float64(p.budget.Used(p.name, time.Now())))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSBudget(t *testing.T) {
+	t.Run("ReservesWithoutDelayIfProviderHasNoBudget", func(t *testing.T) {
+
+		budget := newDNSBudget(map[string]int{}, time.Minute)
+		now := time.Now()
+
+		// act
+		delays := []time.Duration{budget.Reserve("cloudflare", now), budget.Reserve("cloudflare", now), budget.Reserve("cloudflare", now)}
+
+		assert.Equal(t, []time.Duration{0, 0, 0}, delays)
+	})
+
+	t.Run("DelaysCallsBeyondBudgetUntilEarliestCallLeavesWindow", func(t *testing.T) {
+
+		budget := newDNSBudget(map[string]int{"cloudflare": 2}, time.Minute)
+		now := time.Now()
+
+		// act
+		delays := []time.Duration{budget.Reserve("cloudflare", now), budget.Reserve("cloudflare", now.Add(10*time.Second)), budget.Reserve("cloudflare", now.Add(20*time.Second)), budget.Reserve("cloudflare", now.Add(20*time.Second))}
+
+		assert.Equal(t, []time.Duration{0, 0, 40 * time.Second, 50 * time.Second}, delays)
+	})
+
+	t.Run("KeepsBudgetsOfProvidersApart", func(t *testing.T) {
+
+		budget := newDNSBudget(map[string]int{"cloudflare": 1, "route53": 1}, time.Minute)
+		now := time.Now()
+		budget.Reserve("cloudflare", now)
+
+		// act
+		delay := budget.Reserve("route53", now)
+
+		assert.Equal(t, time.Duration(0), delay)
+	})
+
+	t.Run("CountsCallsWithinWindowAsUsed", func(t *testing.T) {
+
+		budget := newDNSBudget(map[string]int{"cloudflare": 10}, time.Minute)
+		now := time.Now()
+		budget.Reserve("cloudflare", now.Add(-2*time.Minute))
+		budget.Reserve("cloudflare", now.Add(-30*time.Second))
+		budget.Reserve("cloudflare", now)
+
+		// act
+		used := budget.Used("cloudflare", now)
+
+		assert.Equal(t, 2, used)
+	})
+}
+
+func TestParseDNSBudgetLimits(t *testing.T) {
+	t.Run("ReturnsCallsPerProvider", func(t *testing.T) {
+
+		// act
+		limits, err := parseDNSBudgetLimits("cloudflare=1000, route53=300")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]int{"cloudflare": 1000, "route53": 300}, limits)
+	})
+
+	t.Run("ReturnsEmptyLimitsIfValueIsEmpty", func(t *testing.T) {
+
+		// act
+		limits, err := parseDNSBudgetLimits("")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(limits))
+	})
+
+	t.Run("ReturnsErrorForUnknownProvider", func(t *testing.T) {
+
+		// act
+		_, err := parseDNSBudgetLimits("godaddy=100")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfCallsIsNotPositive", func(t *testing.T) {
+
+		// act
+		_, err := parseDNSBudgetLimits("cloudflare=0")
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/providers/dns/azure"
+	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/gcloud"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+	"github.com/go-acme/lego/v4/providers/dns/route53"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// dnsProviders are the providers DNS-01 challenges can be solved with
+var dnsProviders = []string{"cloudflare", "route53", "clouddns", "azure", "rfc2136"}
+
+// getDNSProviderName returns the dns provider selected through the annotation of the secret, or the default one
+func getDNSProviderName(desiredState LetsEncryptCertificateState) string {
+	if desiredState.DNSProvider != "" {
+		return desiredState.DNSProvider
+	}
+
+	return *dnsProvider
+}
+
+// newDNSProvider creates the provider to solve the DNS-01 challenges for the hostnames of the secret with
+func newDNSProvider(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, desiredState LetsEncryptCertificateState) (challenge.Provider, error) {
+	switch providerName := getDNSProviderName(desiredState); providerName {
+	case "cloudflare":
+		return newCloudflareDNSProvider(ctx, kubeClientset, secret, desiredState)
+	case "route53":
+		return newRoute53DNSProvider()
+	case "clouddns":
+		return newCloudDNSProvider()
+	case "azure":
+		return newAzureDNSProvider(ctx, kubeClientset, secret, desiredState)
+	case "rfc2136":
+		return newRFC2136DNSProvider()
+	default:
+		return nil, fmt.Errorf("DNS provider %v is not supported, use one of %v", providerName, dnsProviders)
+	}
+}
+
+// newCloudflareDNSProvider creates a cloudflare provider, with the credentials of the team owning the secret if it has its own cloudflare account
+func newCloudflareDNSProvider(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, desiredState LetsEncryptCertificateState) (challenge.Provider, error) {
+	authentication, err := getCloudflareAuthenticationForSecret(ctx, kubeClientset, secret.Namespace, desiredState)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudflareConfig := cloudflare.NewDefaultConfig()
+	cloudflareConfig.AuthEmail = authentication.Email
+	cloudflareConfig.AuthKey = authentication.Key
+	cloudflareConfig.AuthToken = authentication.Token
+	cloudflareConfig.PropagationTimeout = 10 * time.Minute
+
+	return cloudflare.NewDNSProviderConfig(cloudflareConfig)
+}
+
+// newRoute53DNSProvider creates a route53 provider; it gets its credentials, region and optionally the hosted zone from the AWS_* environment variables, a service account with an IAM role or the instance profile
+func newRoute53DNSProvider() (challenge.Provider, error) {
+	route53Config := route53.NewDefaultConfig()
+	route53Config.PropagationTimeout = 10 * time.Minute
+
+	return route53.NewDNSProviderConfig(route53Config)
+}
+
+// newCloudDNSProvider creates a google cloud dns provider; it uses the service account key mounted at GCE_SERVICE_ACCOUNT_FILE if set, otherwise the application default credentials from workload identity or GOOGLE_APPLICATION_CREDENTIALS
+func newCloudDNSProvider() (challenge.Provider, error) {
+	return gcloud.NewDNSProvider()
+}
+
+// newAzureDNSProvider creates an azure dns provider, with the service principal of the team owning the secret if it has a credentials secret, otherwise with the one from the flags or the managed identity of the node
+func newAzureDNSProvider(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, desiredState LetsEncryptCertificateState) (challenge.Provider, error) {
+	credentials, err := getAzureCredentialsForSecret(ctx, kubeClientset, secret.Namespace, desiredState)
+	if err != nil {
+		return nil, err
+	}
+
+	azureConfig := azure.NewDefaultConfig()
+	azureConfig.SubscriptionID = credentials.SubscriptionID
+	azureConfig.ResourceGroup = credentials.ResourceGroup
+	azureConfig.TenantID = credentials.TenantID
+	azureConfig.ClientID = credentials.ClientID
+	azureConfig.ClientSecret = credentials.ClientSecret
+	azureConfig.PropagationTimeout = 10 * time.Minute
+
+	return azure.NewDNSProviderConfig(azureConfig)
+}
+
+// newRFC2136DNSProvider creates a provider that sends dynamic updates signed with the tsig key from the flags to the nameserver; lego follows a cname on _acme-challenge, so the records can be written to a dedicated challenge zone the production zones delegate to
+func newRFC2136DNSProvider() (challenge.Provider, error) {
+	rfc2136Config := rfc2136.NewDefaultConfig()
+	rfc2136Config.Nameserver = *rfc2136Nameserver
+	rfc2136Config.TSIGKey = *rfc2136TSIGKey
+	rfc2136Config.TSIGSecret = *rfc2136TSIGSecret
+	rfc2136Config.TSIGAlgorithm = strings.TrimSuffix(*rfc2136TSIGAlgorithm, ".") + "."
+	rfc2136Config.PropagationTimeout = 10 * time.Minute
+
+	return rfc2136.NewDNSProviderConfig(rfc2136Config)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGetDNSProviderName(t *testing.T) {
+
+	t.Run("ReturnsProviderFromAnnotation", func(t *testing.T) {
+
+		desiredState := LetsEncryptCertificateState{DNSProvider: "route53"}
+
+		// act
+		providerName := getDNSProviderName(desiredState)
+
+		assert.Equal(t, "route53", providerName)
+	})
+
+	t.Run("ReturnsDefaultProviderIfAnnotationIsNotSet", func(t *testing.T) {
+
+		desiredState := LetsEncryptCertificateState{}
+
+		// act
+		providerName := getDNSProviderName(desiredState)
+
+		assert.Equal(t, *dnsProvider, providerName)
+	})
+}
+
+func TestNewDNSProvider(t *testing.T) {
+
+	t.Run("ReturnsErrorForUnsupportedProvider", func(t *testing.T) {
+
+		desiredState := LetsEncryptCertificateState{DNSProvider: "bind"}
+
+		// act
+		_, err := newDNSProvider(context.Background(), nil, &v1.Secret{}, desiredState)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForCloudDNSWithInvalidServiceAccountKey", func(t *testing.T) {
+
+		t.Setenv("GCE_SERVICE_ACCOUNT", "{}")
+		desiredState := LetsEncryptCertificateState{DNSProvider: "clouddns"}
+
+		// act
+		_, err := newDNSProvider(context.Background(), nil, &v1.Secret{}, desiredState)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForRFC2136WithoutNameserver", func(t *testing.T) {
+
+		desiredState := LetsEncryptCertificateState{DNSProvider: "rfc2136"}
+
+		// act
+		_, err := newDNSProvider(context.Background(), nil, &v1.Secret{}, desiredState)
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getHostnameConflicts returns the hostnames requested by more than one managed secret, with the namespace/name of those secrets; this leads to duplicate certificates counting against the rate limits and makes it unclear which certificate is live
+func getHostnameConflicts(secrets []v1.Secret) map[string][]string {
+	secretsByHostname := map[string][]string{}
+	for i := range secrets {
+		desiredState := getDesiredSecretState(&secrets[i])
+		if desiredState.Enabled != "true" || desiredState.Hostnames == "" {
+			continue
+		}
+
+		secretKey := fmt.Sprintf("%v/%v", secrets[i].Namespace, secrets[i].Name)
+		for _, hostname := range strings.Split(strings.ToLower(desiredState.Hostnames), ",") {
+			secretsByHostname[hostname] = append(secretsByHostname[hostname], secretKey)
+		}
+	}
+
+	conflicts := map[string][]string{}
+	for hostname, secretKeys := range secretsByHostname {
+		if len(secretKeys) > 1 {
+			sort.Strings(secretKeys)
+			conflicts[hostname] = secretKeys
+		}
+	}
+
+	return conflicts
+}
+
+// reportHostnameConflicts updates the metric with the number of hostnames managed by more than one secret and emits a warning event on each of those secrets
+func reportHostnameConflicts(ctx context.Context, kubeClientset *kubernetes.Clientset, secrets []v1.Secret) {
+	conflicts := getHostnameConflicts(secrets)
+	hostnameConflicts.Set(float64(len(conflicts)))
+
+	if len(conflicts) == 0 {
+		return
+	}
+
+	// group the conflicting hostnames per secret, to emit a single event per secret
+	conflictsBySecret := map[string][]string{}
+	for hostname, secretKeys := range conflicts {
+		for _, secretKey := range secretKeys {
+			conflictsBySecret[secretKey] = append(conflictsBySecret[secretKey], fmt.Sprintf("%v (%v)", hostname, strings.Join(secretKeys, ", ")))
+		}
+	}
+
+	for i := range secrets {
+		secret := &secrets[i]
+		secretConflicts, ok := conflictsBySecret[fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)]
+		if !ok {
+			continue
+		}
+		sort.Strings(secretConflicts)
+
+		log.Warn().Msgf("Secret %v.%v - Hostnames are also managed by other secrets: %v", secret.Name, secret.Namespace, strings.Join(secretConflicts, "; "))
+
+		err := postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "DetectDuplicates", "DuplicateHostnames", fmt.Sprintf("Hostnames of secret %v are also managed by other secrets, leading to duplicate certificates: %v", secret.Name, strings.Join(secretConflicts, "; ")), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Secret %v.%v - Posting duplicate hostnames event failed", secret.Name, secret.Namespace)
+		}
+	}
+}
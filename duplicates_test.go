@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetHostnameConflicts(t *testing.T) {
+	t.Run("ReturnsNoConflictsIfHostnamesDoNotOverlap", func(t *testing.T) {
+
+		secrets := []v1.Secret{
+			newManagedSecret("ns1", "secret1", "estafette.io"),
+			newManagedSecret("ns2", "secret2", "www.estafette.io"),
+		}
+
+		// act
+		conflicts := getHostnameConflicts(secrets)
+
+		assert.Equal(t, 0, len(conflicts))
+	})
+
+	t.Run("ReturnsSecretsPerOverlappingHostname", func(t *testing.T) {
+
+		secrets := []v1.Secret{
+			newManagedSecret("ns2", "secret2", "estafette.io,www.estafette.io"),
+			newManagedSecret("ns1", "secret1", "Estafette.io"),
+		}
+
+		// act
+		conflicts := getHostnameConflicts(secrets)
+
+		assert.Equal(t, 1, len(conflicts))
+		assert.Equal(t, []string{"ns1/secret1", "ns2/secret2"}, conflicts["estafette.io"])
+	})
+
+	t.Run("IgnoresSecretsThatAreNotManaged", func(t *testing.T) {
+
+		copiedSecret := newManagedSecret("ns2", "secret1", "estafette.io")
+		copiedSecret.Annotations[annotationLetsEncryptCertificate] = "false"
+		secrets := []v1.Secret{
+			newManagedSecret("ns1", "secret1", "estafette.io"),
+			copiedSecret,
+		}
+
+		// act
+		conflicts := getHostnameConflicts(secrets)
+
+		assert.Equal(t, 0, len(conflicts))
+	})
+}
+
+func newManagedSecret(namespace, name, hostnames string) v1.Secret {
+	return v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				annotationLetsEncryptCertificate:          "true",
+				annotationLetsEncryptCertificateHostnames: hostnames,
+			},
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sampledError holds the last error logged for a key and how many identical errors have been suppressed since
+type sampledError struct {
+	message    string
+	lastLogged time.Time
+	suppressed int
+}
+
+// errorLogSampler keeps chronically failing secrets from logging the same error on every evaluation, by logging identical errors at most once per interval
+type errorLogSampler struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	errors   map[string]*sampledError
+}
+
+func newErrorLogSampler(interval time.Duration) *errorLogSampler {
+	return &errorLogSampler{
+		interval: interval,
+		errors:   map[string]*sampledError{},
+	}
+}
+
+// Sample returns true if err should be logged for key, either because it differs from the last error or the interval has passed, and the number of identical errors suppressed since it was last logged
+func (s *errorLogSampler) Sample(key string, err error) (ok bool, suppressed int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	last, found := s.errors[key]
+	if found && last.message == err.Error() && time.Since(last.lastLogged) < s.interval {
+		last.suppressed++
+		return false, 0
+	}
+
+	if found && last.message == err.Error() {
+		suppressed = last.suppressed
+	}
+	s.errors[key] = &sampledError{message: err.Error(), lastLogged: time.Now()}
+
+	return true, suppressed
+}
+
+// Clear forgets the last error for key, so the next error gets logged right away
+func (s *errorLogSampler) Clear(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.errors, key)
+}
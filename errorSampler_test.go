@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorLogSampler(t *testing.T) {
+
+	t.Run("ReturnsTrueForFirstError", func(t *testing.T) {
+
+		sampler := newErrorLogSampler(time.Hour)
+
+		// act
+		ok, suppressed := sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		assert.True(t, ok)
+		assert.Equal(t, 0, suppressed)
+	})
+
+	t.Run("ReturnsFalseForIdenticalErrorWithinInterval", func(t *testing.T) {
+
+		sampler := newErrorLogSampler(time.Hour)
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		// act
+		ok, _ := sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsTrueForDifferentError", func(t *testing.T) {
+
+		sampler := newErrorLogSampler(time.Hour)
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		// act
+		ok, _ := sampler.Sample("mynamespace/mysecret", errors.New("updating secret failed"))
+
+		assert.True(t, ok)
+	})
+
+	t.Run("ReturnsTrueForIdenticalErrorOfOtherKey", func(t *testing.T) {
+
+		sampler := newErrorLogSampler(time.Hour)
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		// act
+		ok, _ := sampler.Sample("mynamespace/othersecret", errors.New("obtaining certificate failed"))
+
+		assert.True(t, ok)
+	})
+
+	t.Run("ReturnsTrueWithSuppressedCountForIdenticalErrorAfterInterval", func(t *testing.T) {
+
+		sampler := newErrorLogSampler(time.Hour)
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+		sampler.errors["mynamespace/mysecret"].lastLogged = time.Now().Add(-2 * time.Hour)
+
+		// act
+		ok, suppressed := sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		assert.True(t, ok)
+		assert.Equal(t, 2, suppressed)
+	})
+
+	t.Run("ReturnsTrueForIdenticalErrorAfterClear", func(t *testing.T) {
+
+		sampler := newErrorLogSampler(time.Hour)
+		sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+		sampler.Clear("mynamespace/mysecret")
+
+		// act
+		ok, _ := sampler.Sample("mynamespace/mysecret", errors.New("obtaining certificate failed"))
+
+		assert.True(t, ok)
+	})
+}
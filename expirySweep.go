@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// expirySweepThresholds are the numbers of days before expiry at which the expiry sweep escalates, from the first warning to the last
+var expirySweepThresholds = []int{30, 14, 7, 1}
+
+// expiryAlert is raised by the expiry sweep for a certificate that crossed one of the thresholds without having been renewed
+type expiryAlert struct {
+	Secret    *v1.Secret
+	NotAfter  time.Time
+	Threshold int
+}
+
+// getExpiryThreshold returns the lowest threshold the certificate expiring at notAfter has crossed; ok is false if it isn't within any of them yet
+func getExpiryThreshold(notAfter, now time.Time) (threshold int, ok bool) {
+	remaining := notAfter.Sub(now)
+	for _, days := range expirySweepThresholds {
+		if remaining <= time.Duration(days)*24*time.Hour {
+			threshold, ok = days, true
+		}
+	}
+
+	return threshold, ok
+}
+
+// getExpiryAlerts parses the certificate of each managed secret and returns an alert for the ones that crossed a threshold; it deliberately only looks at the certificate data and not at the state annotations, so it keeps working when bad state wedges the renewals
+func getExpiryAlerts(secrets []v1.Secret, now time.Time) []expiryAlert {
+	secretsByKey := map[string]*v1.Secret{}
+	for i := range secrets {
+		secretsByKey[fmt.Sprintf("%v/%v", secrets[i].Namespace, secrets[i].Name)] = &secrets[i]
+	}
+
+	alerts := []expiryAlert{}
+	for i := range secrets {
+		secret := &secrets[i]
+		desiredState := getDesiredSecretState(secret)
+		if desiredState.Enabled != "true" || desiredState.Hostnames == "" {
+			continue
+		}
+
+		dataSecret := secret
+		if desiredState.TargetSecret != "" {
+			dataSecret = secretsByKey[fmt.Sprintf("%v/%v", secret.Namespace, desiredState.TargetSecret)]
+			if dataSecret == nil {
+				continue
+			}
+		}
+
+		certificateBytes, _ := getSecretCertificate(dataSecret)
+		certificate, err := parseCertificate(certificateBytes)
+		if err != nil {
+			continue
+		}
+		if threshold, ok := getExpiryThreshold(certificate.NotAfter, now); ok {
+			alerts = append(alerts, expiryAlert{Secret: secret, NotAfter: certificate.NotAfter, Threshold: threshold})
+		}
+	}
+
+	return alerts
+}
+
+// expirySweepAlerts keeps the last threshold alerted for each certificate, so every threshold is only alerted once per certificate and a renewed certificate starts over
+type expirySweepAlerts struct {
+	mutex      sync.Mutex
+	thresholds map[string]int
+}
+
+func newExpirySweepAlerts() *expirySweepAlerts {
+	return &expirySweepAlerts{
+		thresholds: map[string]int{},
+	}
+}
+
+// ShouldAlert checks whether the alert escalates beyond the last one for the same certificate and records it if so
+func (a *expirySweepAlerts) ShouldAlert(alert expiryAlert) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := fmt.Sprintf("%v/%v/%v", alert.Secret.Namespace, alert.Secret.Name, alert.NotAfter.Unix())
+	if lastThreshold, ok := a.thresholds[key]; ok && lastThreshold <= alert.Threshold {
+		return false
+	}
+	a.thresholds[key] = alert.Threshold
+
+	return true
+}
+
+// Prune forgets the certificates that no longer raise an alert, because they've been renewed or removed
+func (a *expirySweepAlerts) Prune(alerts []expiryAlert) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current := map[string]bool{}
+	for _, alert := range alerts {
+		current[fmt.Sprintf("%v/%v/%v", alert.Secret.Namespace, alert.Secret.Name, alert.NotAfter.Unix())] = true
+	}
+	for key := range a.thresholds {
+		if !current[key] {
+			delete(a.thresholds, key)
+		}
+	}
+}
+
+var expirySweepAlerted = newExpirySweepAlerts()
+
+// runExpirySweep periodically checks the expiry of all managed certificates and raises escalating warning events and notifications for the ones that haven't been renewed in time
+func runExpirySweep(ctx context.Context, kubeClientset *kubernetes.Clientset) {
+	for {
+		secretList, err := kubeClientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			log.Error().Err(err).Msg("Listing secrets for expiry sweep failed")
+		} else {
+			now := time.Now()
+			alerts := getExpiryAlerts(secretList.Items, now)
+			expirySweepAlerted.Prune(alerts)
+			for _, alert := range alerts {
+				if !expirySweepAlerted.ShouldAlert(alert) {
+					continue
+				}
+				raiseExpiryAlert(ctx, kubeClientset, alert, now)
+			}
+			log.Info().Msgf("Swept %v secrets for expiring certificates, %v haven't been renewed in time", len(secretList.Items), len(alerts))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*expirySweepInterval):
+		}
+	}
+}
+
+func raiseExpiryAlert(ctx context.Context, kubeClientset *kubernetes.Clientset, alert expiryAlert, now time.Time) {
+	secret := alert.Secret
+	reason := "CertificateExpiring"
+	message := fmt.Sprintf("Certificate for secret %v expires at %v, within %v days, and hasn't been renewed", secret.Name, alert.NotAfter.UTC().Format(time.RFC3339), alert.Threshold)
+	if !now.Before(alert.NotAfter) {
+		reason = "CertificateExpired"
+		message = fmt.Sprintf("Certificate for secret %v has expired at %v and hasn't been renewed", secret.Name, alert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	log.Warn().Msgf("[expiry-sweep] Secret %v.%v - %v", secret.Name, secret.Namespace, message)
+	expirySweepAlertTotals.With(prometheus.Labels{"namespace": secret.Namespace, "threshold": fmt.Sprint(alert.Threshold)}).Inc()
+
+	err := postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "ExpirySweep", reason, message, "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+	if err != nil {
+		log.Error().Err(err).Msgf("[expiry-sweep] Secret %v.%v - Posting event for expiring certificate failed", secret.Name, secret.Namespace)
+	}
+
+	notifyRenewal(ctx, secret, "expiry-sweep", getDesiredSecretState(secret), "expiring", fmt.Errorf("%v", message))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetExpiryThreshold(t *testing.T) {
+	t.Run("ReturnsFalseOutsideThresholds", func(t *testing.T) {
+
+		now := time.Now()
+
+		// act
+		_, ok := getExpiryThreshold(now.Add(45*24*time.Hour), now)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsLowestCrossedThreshold", func(t *testing.T) {
+
+		now := time.Now()
+
+		// act
+		threshold, ok := getExpiryThreshold(now.Add(10*24*time.Hour), now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 14, threshold)
+	})
+
+	t.Run("ReturnsLastThresholdForExpiredCertificate", func(t *testing.T) {
+
+		now := time.Now()
+
+		// act
+		threshold, ok := getExpiryThreshold(now.Add(-time.Hour), now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 1, threshold)
+	})
+}
+
+func TestGetExpiryAlerts(t *testing.T) {
+	t.Run("ReturnsAlertForManagedCertificateCloseToExpiry", func(t *testing.T) {
+
+		now := time.Now()
+		secrets := []v1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "expiring", Namespace: "my-namespace", Annotations: map[string]string{"estafette.io/letsencrypt-certificate": "true", "estafette.io/letsencrypt-certificate-hostnames": "estafette.io"}},
+				Data:       map[string][]byte{"tls.crt": generateTestCertificate(t, now.Add(-85*24*time.Hour), now.Add(5*24*time.Hour))},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "fresh", Namespace: "my-namespace", Annotations: map[string]string{"estafette.io/letsencrypt-certificate": "true", "estafette.io/letsencrypt-certificate-hostnames": "www.estafette.io"}},
+				Data:       map[string][]byte{"tls.crt": generateTestCertificate(t, now, now.Add(90*24*time.Hour))},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "my-namespace"},
+				Data:       map[string][]byte{"tls.crt": generateTestCertificate(t, now.Add(-85*24*time.Hour), now.Add(5*24*time.Hour))},
+			},
+		}
+
+		// act
+		alerts := getExpiryAlerts(secrets, now)
+
+		if assert.Equal(t, 1, len(alerts)) {
+			assert.Equal(t, "expiring", alerts[0].Secret.Name)
+			assert.Equal(t, 7, alerts[0].Threshold)
+		}
+	})
+
+	t.Run("IgnoresStateAnnotations", func(t *testing.T) {
+
+		now := time.Now()
+		secrets := []v1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "wedged", Namespace: "my-namespace", Annotations: map[string]string{"estafette.io/letsencrypt-certificate": "true", "estafette.io/letsencrypt-certificate-hostnames": "estafette.io", "estafette.io/letsencrypt-certificate-state": "{not json"}},
+				Data:       map[string][]byte{"tls.crt": generateTestCertificate(t, now.Add(-89*24*time.Hour), now.Add(12*time.Hour))},
+			},
+		}
+
+		// act
+		alerts := getExpiryAlerts(secrets, now)
+
+		assert.Equal(t, 1, len(alerts))
+	})
+}
+
+func TestExpirySweepAlerts(t *testing.T) {
+	t.Run("AlertsEachThresholdOnce", func(t *testing.T) {
+
+		alerted := newExpirySweepAlerts()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		notAfter := time.Now().Add(10 * 24 * time.Hour)
+
+		// act
+		first := alerted.ShouldAlert(expiryAlert{Secret: secret, NotAfter: notAfter, Threshold: 14})
+		repeated := alerted.ShouldAlert(expiryAlert{Secret: secret, NotAfter: notAfter, Threshold: 14})
+		escalated := alerted.ShouldAlert(expiryAlert{Secret: secret, NotAfter: notAfter, Threshold: 7})
+
+		assert.True(t, first)
+		assert.False(t, repeated)
+		assert.True(t, escalated)
+	})
+
+	t.Run("StartsOverForRenewedCertificate", func(t *testing.T) {
+
+		alerted := newExpirySweepAlerts()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		alert := expiryAlert{Secret: secret, NotAfter: time.Now().Add(10 * 24 * time.Hour), Threshold: 14}
+		alerted.ShouldAlert(alert)
+		alerted.Prune([]expiryAlert{})
+
+		// act
+		again := alerted.ShouldAlert(alert)
+
+		assert.True(t, again)
+	})
+}
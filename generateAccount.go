@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// accountKeyTypes maps the values of the --key-type flag of generate-account to lego's key types
+var accountKeyTypes = map[string]certcrypto.KeyType{
+	"ec256":   certcrypto.EC256,
+	"ec384":   certcrypto.EC384,
+	"rsa2048": certcrypto.RSA2048,
+	"rsa4096": certcrypto.RSA4096,
+}
+
+// generateAccount creates an account key, registers it with Let's Encrypt and writes the account to files or a secret, so no external scripts are needed before deploying the controller; the destination is checked before registering, so no account is registered that can't be written
+func generateAccount(ctx context.Context) error {
+	var kubeClientset *kubernetes.Clientset
+	namespace := *generateAccountNamespace
+	if *generateAccountSecret != "" {
+		if namespace == "" {
+			namespace = getCurrentNamespace()
+		}
+		kubeClientConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return err
+		}
+		kubeClientset, err = kubernetes.NewForConfig(kubeClientConfig)
+		if err != nil {
+			return err
+		}
+		err = checkAccountSecretAbsent(ctx, kubeClientset, namespace, *generateAccountSecret)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := checkAccountFilesAbsent(*generateAccountPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := certcrypto.GeneratePrivateKey(accountKeyTypes[*generateAccountKeyType])
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Registering %v account for %v...", *generateAccountKeyType, *generateAccountEmail)
+	user, err := registerAccount(*generateAccountEmail, key, *generateAccountStaging)
+	if err != nil {
+		return err
+	}
+
+	files, err := getAccountFiles(user)
+	if err != nil {
+		return err
+	}
+
+	if *generateAccountSecret != "" {
+		err = writeAccountSecret(ctx, kubeClientset, namespace, *generateAccountSecret, files)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Wrote account %v to secret %v.%v", user.Registration.URI, *generateAccountSecret, namespace)
+		return nil
+	}
+
+	err = writeAccountFiles(*generateAccountPath, files)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Wrote account %v to %v", user.Registration.URI, *generateAccountPath)
+
+	return nil
+}
+
+// registerAccount registers the key with the production or staging ACME directory, agreeing to the terms of service and binding it to the external account from the flags if set
+func registerAccount(email string, key crypto.PrivateKey, staging bool) (LetsEncryptUser, error) {
+	user := LetsEncryptUser{Email: email, key: key}
+
+	config := lego.NewConfig(&user)
+	config.CADirURL = getACMEDirectoryURL(environmentProduction)
+	if staging {
+		config.CADirURL = getACMEDirectoryURL(environmentStaging)
+	}
+
+	legoClient, err := lego.NewClient(config)
+	if err != nil {
+		return user, err
+	}
+
+	user.Registration, err = registerWithDirectory(legoClient, acmeEABCredentials{KeyID: *acmeEABKeyID, HMACKey: *acmeEABHMACKey})
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// getAccountFiles returns the content of the account.json and account.key files for a registered account, in the format loadLetsEncryptUser reads
+func getAccountFiles(user LetsEncryptUser) (map[string][]byte, error) {
+	accountJSON, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	accountKey := certcrypto.PEMEncode(user.key)
+	if accountKey == nil {
+		return nil, fmt.Errorf("Private key of type %T is not supported, use an rsa or ecdsa key", user.key)
+	}
+
+	return map[string][]byte{
+		"account.json": accountJSON,
+		"account.key":  accountKey,
+	}, nil
+}
+
+// checkAccountFilesAbsent returns an error if directory already holds an account key, since the account it belongs to would be lost when overwriting it
+func checkAccountFilesAbsent(directory string) error {
+	if _, err := os.Stat(filepath.Join(directory, "account.key")); err == nil {
+		return fmt.Errorf("File %v already exists", filepath.Join(directory, "account.key"))
+	}
+
+	return nil
+}
+
+// writeAccountFiles writes the account files to directory; it refuses to overwrite an existing account key, since the account it belongs to would be lost
+func writeAccountFiles(directory string, files map[string][]byte) error {
+	err := checkAccountFilesAbsent(directory)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(directory, 0700)
+	if err != nil {
+		return err
+	}
+
+	for name, data := range files {
+		err = os.WriteFile(filepath.Join(directory, name), data, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkAccountSecretAbsent returns an error if the secret to write the account to already exists, for the same reason as checkAccountFilesAbsent
+func checkAccountSecretAbsent(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace, name string) error {
+	_, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return fmt.Errorf("Secret %v.%v already exists", name, namespace)
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// writeAccountSecret creates a secret with the account files, to mount at one of the account paths; it fails if the secret already exists for the same reason as writeAccountFiles
+func writeAccountSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace, name string, files map[string][]byte) error {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: files,
+	}
+	_, err := kubeClientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAccountFiles(t *testing.T) {
+
+	t.Run("ReturnsFilesThatLoadAsSameAccount", func(t *testing.T) {
+
+		for keyType := range accountKeyTypes {
+			if keyType == "rsa4096" {
+				// generating it is slow and doesn't cover anything rsa2048 doesn't
+				continue
+			}
+			key, err := certcrypto.GeneratePrivateKey(accountKeyTypes[keyType])
+			assert.Nil(t, err)
+			user := LetsEncryptUser{Email: "name@server.com", Registration: &registration.Resource{URI: "https://acme-v02.api.letsencrypt.org/acme/acct/1"}, key: key}
+			accountPath := t.TempDir()
+
+			// act
+			files, err := getAccountFiles(user)
+
+			assert.Nil(t, err)
+			assert.Nil(t, writeAccountFiles(accountPath, files))
+			loadedUser, err := loadLetsEncryptUser(accountPath)
+			assert.Nil(t, err)
+			assert.Equal(t, user, loadedUser, keyType)
+		}
+	})
+}
+
+func TestWriteAccountFiles(t *testing.T) {
+
+	t.Run("ReturnsErrorIfAccountKeyAlreadyExists", func(t *testing.T) {
+
+		accountPath := t.TempDir()
+		files := map[string][]byte{"account.json": []byte("{}"), "account.key": []byte("key")}
+		assert.Nil(t, writeAccountFiles(accountPath, files))
+
+		// act
+		err := writeAccountFiles(accountPath, files)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCheckAccountFilesAbsent(t *testing.T) {
+
+	t.Run("ReturnsNilIfDirectoryDoesNotExist", func(t *testing.T) {
+
+		accountPath := filepath.Join(t.TempDir(), "account")
+
+		// act
+		err := checkAccountFilesAbsent(accountPath)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfAccountKeyAlreadyExists", func(t *testing.T) {
+
+		accountPath := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(accountPath, "account.key"), []byte("key"), 0600))
+
+		// act
+		err := checkAccountFilesAbsent(accountPath)
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// maxPreviousCertificates limits the number of previous certificates kept in a secret, to stay clear of the size limit of secrets
+const maxPreviousCertificates = 10
+
+// previousCertificateDataPrefix is the prefix of the data items holding previous certificates and keys
+const previousCertificateDataPrefix = "tls-previous"
+
+// getKeepPrevious returns the number of previous certificates to keep in the secret from the keep-previous annotation, or --keep-previous-certificates
+func getKeepPrevious(secret *v1.Secret) int {
+	keep := *keepPreviousCertificates
+	if value, err := strconv.Atoi(secret.Annotations[annotationLetsEncryptCertificateKeepPrevious]); err == nil && value >= 0 {
+		keep = value
+	}
+	if keep > maxPreviousCertificates {
+		keep = maxPreviousCertificates
+	}
+
+	return keep
+}
+
+// getPreviousCertificateDataPrefix returns the prefix of the items of the nth previous certificate: tls-previous for the last one and tls-previous-2 and up for older ones
+func getPreviousCertificateDataPrefix(n int) string {
+	if n <= 1 {
+		return previousCertificateDataPrefix
+	}
+
+	return fmt.Sprintf("%v-%v", previousCertificateDataPrefix, n)
+}
+
+// isPreviousCertificateDataKey checks whether the data item holds a previous certificate or key
+func isPreviousCertificateDataKey(key string) bool {
+	return key == previousCertificateDataPrefix+".crt" || key == previousCertificateDataPrefix+".key" || strings.HasPrefix(key, previousCertificateDataPrefix+"-")
+}
+
+// rotatePreviousCertificates shifts the current certificate and key of the secret into the tls-previous items before they're replaced by a renewed one, keeping the last keep certificates and removing older ones
+func rotatePreviousCertificates(secret *v1.Secret, keep int) {
+	if secret.Data == nil {
+		return
+	}
+
+	certificateBytes, privateKeyBytes := getSecretCertificate(secret)
+	if keep > 0 && len(certificateBytes) > 0 && len(privateKeyBytes) > 0 {
+		for n := keep; n > 1; n-- {
+			for _, suffix := range []string{".crt", ".key"} {
+				if value, ok := secret.Data[getPreviousCertificateDataPrefix(n-1)+suffix]; ok {
+					secret.Data[getPreviousCertificateDataPrefix(n)+suffix] = value
+				} else {
+					delete(secret.Data, getPreviousCertificateDataPrefix(n)+suffix)
+				}
+			}
+		}
+		secret.Data[getPreviousCertificateDataPrefix(1)+".crt"] = certificateBytes
+		secret.Data[getPreviousCertificateDataPrefix(1)+".key"] = privateKeyBytes
+	}
+
+	// remove the certificates beyond the retention, also after lowering it
+	kept := map[string]bool{}
+	for n := 1; n <= keep; n++ {
+		kept[getPreviousCertificateDataPrefix(n)+".crt"] = true
+		kept[getPreviousCertificateDataPrefix(n)+".key"] = true
+	}
+	for key := range secret.Data {
+		if isPreviousCertificateDataKey(key) && !kept[key] {
+			delete(secret.Data, key)
+		}
+	}
+}
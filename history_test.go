@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRotatePreviousCertificates(t *testing.T) {
+	t.Run("MovesCurrentCertificateToPreviousItems", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt-2"), "tls.key": []byte("key-2"), "tls-previous.crt": []byte("crt-1"), "tls-previous.key": []byte("key-1")}}
+
+		// act
+		rotatePreviousCertificates(secret, 2)
+
+		assert.Equal(t, []byte("crt-2"), secret.Data["tls-previous.crt"])
+		assert.Equal(t, []byte("key-2"), secret.Data["tls-previous.key"])
+		assert.Equal(t, []byte("crt-1"), secret.Data["tls-previous-2.crt"])
+		assert.Equal(t, []byte("key-1"), secret.Data["tls-previous-2.key"])
+	})
+
+	t.Run("RemovesCertificatesBeyondRetention", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt-3"), "tls.key": []byte("key-3"), "tls-previous.crt": []byte("crt-2"), "tls-previous.key": []byte("key-2"), "tls-previous-2.crt": []byte("crt-1"), "tls-previous-2.key": []byte("key-1")}}
+
+		// act
+		rotatePreviousCertificates(secret, 1)
+
+		assert.Equal(t, []byte("crt-3"), secret.Data["tls-previous.crt"])
+		_, ok := secret.Data["tls-previous-2.crt"]
+		assert.False(t, ok)
+		_, ok = secret.Data["tls-previous-2.key"]
+		assert.False(t, ok)
+	})
+
+	t.Run("RemovesAllPreviousCertificatesWithoutRetention", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt-2"), "tls.key": []byte("key-2"), "tls-previous.crt": []byte("crt-1"), "tls-previous.key": []byte("key-1")}}
+
+		// act
+		rotatePreviousCertificates(secret, 0)
+
+		assert.Equal(t, map[string][]byte{"tls.crt": []byte("crt-2"), "tls.key": []byte("key-2")}, secret.Data)
+	})
+
+	t.Run("KeepsPreviousCertificatesIfThereIsNoCurrentOne", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls-previous.crt": []byte("crt-1"), "tls-previous.key": []byte("key-1")}}
+
+		// act
+		rotatePreviousCertificates(secret, 1)
+
+		assert.Equal(t, []byte("crt-1"), secret.Data["tls-previous.crt"])
+	})
+
+	t.Run("LeavesSNICertificatesAlone", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key"), "tls-a.crt": []byte("a"), "tls-a.key": []byte("a-key")}}
+
+		// act
+		rotatePreviousCertificates(secret, 0)
+
+		assert.Equal(t, []byte("a"), secret.Data["tls-a.crt"])
+		assert.Equal(t, []byte("a-key"), secret.Data["tls-a.key"])
+	})
+}
+
+func TestGetKeepPrevious(t *testing.T) {
+	t.Run("ReturnsAnnotationValueOverFlag", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"estafette.io/letsencrypt-certificate-keep-previous": "3"}}}
+
+		// act
+		keep := getKeepPrevious(secret)
+
+		assert.Equal(t, 3, keep)
+	})
+
+	t.Run("LimitsToMaximum", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"estafette.io/letsencrypt-certificate-keep-previous": "50"}}}
+
+		// act
+		keep := getKeepPrevious(secret)
+
+		assert.Equal(t, maxPreviousCertificates, keep)
+	})
+}
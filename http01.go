@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// http01SharedKeyAuthorizations shares the key authorizations of pending HTTP-01 challenges between the replicas, since the service routes the CA's validation requests to any of them
+type http01SharedKeyAuthorizations interface {
+	Get(token string) (keyAuth string, ok bool, err error)
+	Set(token, keyAuth string) error
+	Delete(token string) error
+}
+
+// http01ChallengeStore keeps the key authorizations of the pending HTTP-01 challenges of all secrets, and serves them on the shared /.well-known/acme-challenge path routed to the controller, so no ingress has to be created per hostname; with shared set the key authorizations of the other replicas' orders are served as well
+type http01ChallengeStore struct {
+	mutex              sync.RWMutex
+	keyAuthorizations  map[string]string
+	presentedByDomains map[string]map[string]bool
+	shared             http01SharedKeyAuthorizations
+}
+
+func newHTTP01ChallengeStore() *http01ChallengeStore {
+	return &http01ChallengeStore{
+		keyAuthorizations:  map[string]string{},
+		presentedByDomains: map[string]map[string]bool{},
+	}
+}
+
+// Present makes the key authorization available for the token until it's cleaned up
+func (s *http01ChallengeStore) Present(domain, token, keyAuth string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keyAuthorizations[token] = keyAuth
+	if s.presentedByDomains[token] == nil {
+		s.presentedByDomains[token] = map[string]bool{}
+	}
+	s.presentedByDomains[token][strings.ToLower(domain)] = true
+
+	if s.shared != nil {
+		err := s.shared.Set(token, keyAuth)
+		if err != nil {
+			return fmt.Errorf("Sharing the HTTP-01 key authorization for %v with the other replicas failed: %w", domain, err)
+		}
+	}
+
+	return nil
+}
+
+// CleanUp removes the key authorization for the token once no domain is validated with it anymore
+func (s *http01ChallengeStore) CleanUp(domain, token, keyAuth string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.presentedByDomains[token], strings.ToLower(domain))
+	if len(s.presentedByDomains[token]) > 0 {
+		return nil
+	}
+	delete(s.presentedByDomains, token)
+	delete(s.keyAuthorizations, token)
+
+	if s.shared != nil {
+		return s.shared.Delete(token)
+	}
+
+	return nil
+}
+
+// ServeHTTP responds to the CA's validation requests with the key authorization of the token in the path
+func (s *http01ChallengeStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, http01.ChallengePath(""))
+	if token == r.URL.Path || token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mutex.RLock()
+	keyAuth, ok := s.keyAuthorizations[token]
+	s.mutex.RUnlock()
+	if !ok && s.shared != nil {
+		var err error
+		keyAuth, ok, err = s.shared.Get(token)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Reading the shared key authorization for HTTP-01 challenge token %v of host %v failed", token, r.Host)
+		}
+	}
+	if !ok {
+		log.Warn().Msgf("HTTP-01 challenge for token %v of host %v is unknown", token, r.Host)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(keyAuth))
+}
+
+var http01Challenges = newHTTP01ChallengeStore()
+
+// configMapKeyAuthorizations shares the key authorizations in a config map in the controller's namespace, keyed by token; the key authorizations are served publicly anyway, so they don't need a secret
+type configMapKeyAuthorizations struct {
+	kubeClientset *kubernetes.Clientset
+	name          string
+	namespace     string
+}
+
+// Get returns the key authorization for the token from the config map
+func (c *configMapKeyAuthorizations) Get(token string) (string, bool, error) {
+	configMap, err := c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Get(context.Background(), c.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	keyAuth, ok := configMap.Data[token]
+	return keyAuth, ok, nil
+}
+
+// Set adds the key authorization for the token to the config map, creating it if it doesn't exist yet
+func (c *configMapKeyAuthorizations) Set(token, keyAuth string) error {
+	return c.update(func(data map[string]string) {
+		data[token] = keyAuth
+	})
+}
+
+// Delete removes the key authorization for the token from the config map
+func (c *configMapKeyAuthorizations) Delete(token string) error {
+	return c.update(func(data map[string]string) {
+		delete(data, token)
+	})
+}
+
+// update applies the change to the data of the config map, retrying when another replica changed or created it in the meantime; a config map with that name that hasn't been created for the HTTP-01 challenges is left alone
+func (c *configMapKeyAuthorizations) update(change func(data map[string]string)) error {
+	ctx := context.Background()
+
+	return retry.OnError(retry.DefaultRetry, func(err error) bool { return errors.IsConflict(err) || errors.IsAlreadyExists(err) }, func() error {
+		configMap, err := c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      c.name,
+					Namespace: c.namespace,
+					Annotations: map[string]string{
+						annotationLetsEncryptCertificateManagedConfigMap: "http01",
+					},
+				},
+				Data: map[string]string{},
+			}
+			change(configMap.Data)
+			_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Create(ctx, configMap, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if !isManagedConfigMap(configMap, "http01") {
+			return fmt.Errorf("Config map %v.%v already exists and hasn't been created for the HTTP-01 challenges, not overwriting it", c.name, c.namespace)
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		change(configMap.Data)
+		_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// getChallengeType returns the challenge type of the challenge-type annotation, or the one of the challenge-type flag
+func getChallengeType(secret *v1.Secret) string {
+	if challengeType, ok := secret.Annotations[annotationLetsEncryptCertificateChallengeType]; ok && challengeType != "" {
+		return challengeType
+	}
+
+	return *challengeType
+}
+
+// validateHTTP01Hostnames returns an error for wildcard hostnames, which can only be validated with the DNS-01 challenge
+func validateHTTP01Hostnames(hostnames []string) error {
+	for _, hostname := range hostnames {
+		if strings.HasPrefix(hostname, "*.") {
+			return fmt.Errorf("Wildcard hostname %v can't be validated with the http-01 challenge type, use dns-01 instead", hostname)
+		}
+	}
+
+	return nil
+}
+
+// serveHTTP01Challenges serves the key authorizations of the pending HTTP-01 challenges on the address the controller's service routes the shared acme challenge ingress path to
+func serveHTTP01Challenges(address string) {
+	mux := http.NewServeMux()
+	mux.Handle(http01.ChallengePath(""), http01Challenges)
+
+	log.Info().Msgf("Serving HTTP-01 challenges on %v...", address)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Serving HTTP-01 challenges on %v failed", address)
+	}
+}
+
+// setHTTP01Provider sets the shared challenge store to solve the HTTP-01 challenges for the hostnames with
+func setHTTP01Provider(legoClient *lego.Client, secret *v1.Secret, initiator string, hostnames []string) error {
+	err := validateHTTP01Hostnames(hostnames)
+	if err != nil {
+		return err
+	}
+	if *http01Address == "" {
+		return fmt.Errorf("HTTP-01 challenges can't be served with an empty --http01-address")
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Solving HTTP-01 challenges through the shared acme challenge path...", initiator, secret.Name, secret.Namespace)
+
+	return legoClient.Challenge.SetHTTP01Provider(http01Challenges)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTP01ChallengeStore(t *testing.T) {
+	t.Run("ServesKeyAuthorizationOfPresentedToken", func(t *testing.T) {
+
+		store := newHTTP01ChallengeStore()
+		_ = store.Present("estafette.io", "token-1", "token-1.thumbprint")
+		recorder := httptest.NewRecorder()
+
+		// act
+		store.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://estafette.io/.well-known/acme-challenge/token-1", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "token-1.thumbprint", recorder.Body.String())
+	})
+
+	t.Run("ReturnsNotFoundForUnknownToken", func(t *testing.T) {
+
+		store := newHTTP01ChallengeStore()
+		recorder := httptest.NewRecorder()
+
+		// act
+		store.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://estafette.io/.well-known/acme-challenge/token-1", nil))
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("KeepsTokenUntilCleanedUpForAllDomains", func(t *testing.T) {
+
+		store := newHTTP01ChallengeStore()
+		_ = store.Present("estafette.io", "token-1", "token-1.thumbprint")
+		_ = store.Present("www.estafette.io", "token-1", "token-1.thumbprint")
+		_ = store.CleanUp("estafette.io", "token-1", "token-1.thumbprint")
+		recorder := httptest.NewRecorder()
+
+		// act
+		store.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://www.estafette.io/.well-known/acme-challenge/token-1", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		_ = store.CleanUp("www.estafette.io", "token-1", "token-1.thumbprint")
+		recorder = httptest.NewRecorder()
+		store.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://www.estafette.io/.well-known/acme-challenge/token-1", nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
+
+type fakeSharedKeyAuthorizations struct {
+	keyAuthorizations map[string]string
+}
+
+func (f *fakeSharedKeyAuthorizations) Get(token string) (string, bool, error) {
+	keyAuth, ok := f.keyAuthorizations[token]
+	return keyAuth, ok, nil
+}
+
+func (f *fakeSharedKeyAuthorizations) Set(token, keyAuth string) error {
+	f.keyAuthorizations[token] = keyAuth
+	return nil
+}
+
+func (f *fakeSharedKeyAuthorizations) Delete(token string) error {
+	delete(f.keyAuthorizations, token)
+	return nil
+}
+
+func TestHTTP01ChallengeStoreShared(t *testing.T) {
+	t.Run("ServesKeyAuthorizationPresentedByOtherReplica", func(t *testing.T) {
+
+		shared := &fakeSharedKeyAuthorizations{keyAuthorizations: map[string]string{}}
+		orderingReplica := newHTTP01ChallengeStore()
+		orderingReplica.shared = shared
+		servingReplica := newHTTP01ChallengeStore()
+		servingReplica.shared = shared
+		_ = orderingReplica.Present("estafette.io", "token-1", "token-1.thumbprint")
+		recorder := httptest.NewRecorder()
+
+		// act
+		servingReplica.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://estafette.io/.well-known/acme-challenge/token-1", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "token-1.thumbprint", recorder.Body.String())
+	})
+
+	t.Run("RemovesSharedKeyAuthorizationOnceCleanedUpForAllDomains", func(t *testing.T) {
+
+		shared := &fakeSharedKeyAuthorizations{keyAuthorizations: map[string]string{}}
+		store := newHTTP01ChallengeStore()
+		store.shared = shared
+		_ = store.Present("estafette.io", "token-1", "token-1.thumbprint")
+		_ = store.Present("www.estafette.io", "token-1", "token-1.thumbprint")
+
+		// act
+		_ = store.CleanUp("estafette.io", "token-1", "token-1.thumbprint")
+
+		assert.Equal(t, "token-1.thumbprint", shared.keyAuthorizations["token-1"])
+		_ = store.CleanUp("www.estafette.io", "token-1", "token-1.thumbprint")
+		_, ok := shared.keyAuthorizations["token-1"]
+		assert.False(t, ok)
+	})
+}
+
+func TestValidateHTTP01Hostnames(t *testing.T) {
+	t.Run("ReturnsErrorForWildcardHostname", func(t *testing.T) {
+
+		// act
+		err := validateHTTP01Hostnames([]string{"estafette.io", "*.estafette.io"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsNilForRegularHostnames", func(t *testing.T) {
+
+		// act
+		err := validateHTTP01Hostnames([]string{"estafette.io", "www.estafette.io"})
+
+		assert.Nil(t, err)
+	})
+}
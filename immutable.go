@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// isImmutableSecret returns true if the data of the secret can't be updated anymore
+func isImmutableSecret(secret *v1.Secret) bool {
+	return secret.Immutable != nil && *secret.Immutable
+}
+
+// getRecreatedSecret returns a copy of the secret without the fields set by the api server, so it can be created again after deleting it
+func getRecreatedSecret(secret *v1.Secret) *v1.Secret {
+	recreatedSecret := secret.DeepCopy()
+	recreatedSecret.ObjectMeta = metav1.ObjectMeta{
+		Name:            secret.Name,
+		Namespace:       secret.Namespace,
+		Labels:          secret.Labels,
+		Annotations:     secret.Annotations,
+		OwnerReferences: secret.OwnerReferences,
+		Finalizers:      secret.Finalizers,
+	}
+
+	return recreatedSecret
+}
+
+// updateSecretData updates a secret with changed data; since that fails for immutable secrets, those are deleted and created again with the recreate immutable secret policy, or otherwise left alone with a warning event
+func updateSecretData(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string) (*v1.Secret, error) {
+	setLastChangedBy(secret, initiator)
+
+	if !isImmutableSecret(secret) {
+		return kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+
+	if *immutableSecretPolicy != "recreate" {
+		postImmutableSecretEvent(ctx, kubeClientset, secret, initiator)
+		return nil, fmt.Errorf("Secret %v.%v is immutable", secret.Name, secret.Namespace)
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Recreating immutable secret to update its data...", initiator, secret.Name, secret.Namespace)
+
+	// only delete the secret that has been read, so a secret recreated in the meantime isn't lost
+	err := kubeClientset.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(secret.UID))})
+	if err != nil {
+		return nil, err
+	}
+
+	return kubeClientset.CoreV1().Secrets(secret.Namespace).Create(ctx, getRecreatedSecret(secret), metav1.CreateOptions{})
+}
+
+// postImmutableSecretEvent emits a warning event on an immutable secret that can't be updated with the new certificate
+func postImmutableSecretEvent(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string) {
+	err := postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "Update", "ImmutableSecret", fmt.Sprintf("Secret %v is immutable and can't be updated with the new certificate; remove it or set --immutable-secret-policy=recreate", secret.Name), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting event has failed", initiator, secret.Name, secret.Namespace)
+	}
+}
+
+// getImmutableStoreTarget returns the first secret the certificates are written to that is immutable, checking the annotated secret, its target secret and its separate key secret, or nil if none of them is
+func getImmutableStoreTarget(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, desiredState LetsEncryptCertificateState) *v1.Secret {
+	storeTargets := []*v1.Secret{secret, dataSecret}
+	if desiredState.SeparateKeySecret && dataSecret.Name != "" {
+		keySecret, err := kubeClientset.CoreV1().Secrets(dataSecret.Namespace).Get(ctx, fmt.Sprintf("%v-key", dataSecret.Name), metav1.GetOptions{})
+		if err == nil {
+			storeTargets = append(storeTargets, keySecret)
+		}
+	}
+
+	return getImmutableSecret(storeTargets)
+}
+
+// getImmutableSecret returns the first immutable secret, or nil if none of them is
+func getImmutableSecret(secrets []*v1.Secret) *v1.Secret {
+	for _, secret := range secrets {
+		if isImmutableSecret(secret) {
+			return secret
+		}
+	}
+
+	return nil
+}
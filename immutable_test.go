@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsImmutableSecret(t *testing.T) {
+
+	t.Run("ReturnsFalseIfImmutableIsNotSet", func(t *testing.T) {
+
+		secret := &v1.Secret{}
+
+		// act
+		immutable := isImmutableSecret(secret)
+
+		assert.False(t, immutable)
+	})
+
+	t.Run("ReturnsFalseIfImmutableIsFalse", func(t *testing.T) {
+
+		immutable := false
+		secret := &v1.Secret{Immutable: &immutable}
+
+		// act
+		result := isImmutableSecret(secret)
+
+		assert.False(t, result)
+	})
+
+	t.Run("ReturnsTrueIfImmutableIsTrue", func(t *testing.T) {
+
+		immutable := true
+		secret := &v1.Secret{Immutable: &immutable}
+
+		// act
+		result := isImmutableSecret(secret)
+
+		assert.True(t, result)
+	})
+}
+
+func TestGetRecreatedSecret(t *testing.T) {
+
+	t.Run("ClearsFieldsSetByApiServer", func(t *testing.T) {
+
+		immutable := true
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "mysecret",
+				Namespace:       "mynamespace",
+				UID:             "1234",
+				ResourceVersion: "5678",
+				Labels:          map[string]string{"app": "myapp"},
+				Annotations:     map[string]string{annotationLetsEncryptCertificate: "true"},
+			},
+			Immutable: &immutable,
+			Data:      map[string][]byte{"tls.crt": []byte("certificate")},
+		}
+
+		// act
+		recreatedSecret := getRecreatedSecret(secret)
+
+		assert.Equal(t, "mysecret", recreatedSecret.Name)
+		assert.Equal(t, "mynamespace", recreatedSecret.Namespace)
+		assert.Equal(t, "", string(recreatedSecret.UID))
+		assert.Equal(t, "", recreatedSecret.ResourceVersion)
+		assert.Equal(t, "myapp", recreatedSecret.Labels["app"])
+		assert.Equal(t, "true", recreatedSecret.Annotations[annotationLetsEncryptCertificate])
+		assert.True(t, isImmutableSecret(recreatedSecret))
+		assert.Equal(t, []byte("certificate"), recreatedSecret.Data["tls.crt"])
+	})
+}
+
+func TestGetImmutableSecret(t *testing.T) {
+
+	t.Run("ReturnsNilIfNoSecretIsImmutable", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret"}}
+		targetSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mytargetsecret"}}
+
+		// act
+		immutableSecret := getImmutableSecret([]*v1.Secret{secret, targetSecret})
+
+		assert.Nil(t, immutableSecret)
+	})
+
+	t.Run("ReturnsImmutableTargetSecret", func(t *testing.T) {
+
+		immutable := true
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret"}}
+		targetSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mytargetsecret"}, Immutable: &immutable}
+
+		// act
+		immutableSecret := getImmutableSecret([]*v1.Secret{secret, targetSecret})
+
+		assert.Equal(t, "mytargetsecret", immutableSecret.Name)
+	})
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// ingressAnnotations are the annotations that are passed on from an ingress to the secrets created for its tls section; the hostnames come from the tls section itself
+var ingressAnnotations = []string{
+	annotationLetsEncryptCertificateCopyToAllNamespaces,
+	annotationLetsEncryptCertificateCopyToNamespacesMatching,
+	annotationLetsEncryptCertificateCopyNameTemplate,
+	annotationLetsEncryptCertificateCopyNameMapping,
+	annotationLetsEncryptCertificateCopyPrivateKey,
+	annotationLetsEncryptCertificateUploadToCloudflare,
+	annotationLetsEncryptCertificateCloudflareZone,
+	annotationLetsEncryptCertificateSeparateKeySecret,
+	annotationLetsEncryptCertificateStaging,
+	annotationLetsEncryptCertificateOutputFamilies,
+	annotationLetsEncryptCertificateAutoAddWWW,
+	annotationLetsEncryptCertificateAccountEmail,
+	annotationLetsEncryptCertificateAccount,
+	annotationLetsEncryptCertificateDNSDisablePropagationCheck,
+	annotationLetsEncryptCertificateDNSSequential,
+	annotationLetsEncryptCertificateBundle,
+	annotationLetsEncryptCertificateExpiresAfter,
+	annotationLetsEncryptCertificateExpiredAction,
+	annotationLetsEncryptCertificateStorage,
+	annotationLetsEncryptCertificateRenewDaysBeforeExpiry,
+	annotationLetsEncryptCertificateRetryAfterMinutes,
+	annotationLetsEncryptCertificateACMEDirectoryURL,
+	annotationLetsEncryptCertificateKeyType,
+	annotationLetsEncryptCertificateCredentialsSecret,
+	annotationLetsEncryptCertificateDNSProvider,
+	annotationLetsEncryptCertificateWriteJSON,
+	annotationLetsEncryptCertificateDualKeyTypes,
+	annotationLetsEncryptCertificateChallengeType,
+	annotationLetsEncryptCertificateSNICertificates,
+	annotationLetsEncryptCertificateKeepPrevious,
+}
+
+// getIngressSecretHostnames returns the hostnames per secret named in the tls section of the ingress; tls entries without secret name or hosts are left out
+func getIngressSecretHostnames(ingress *networkingv1.Ingress) map[string][]string {
+	secretHostnames := map[string][]string{}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		for _, host := range tls.Hosts {
+			host = strings.TrimSpace(host)
+			if host == "" || containsString(secretHostnames[tls.SecretName], host) {
+				continue
+			}
+			secretHostnames[tls.SecretName] = append(secretHostnames[tls.SecretName], host)
+		}
+	}
+
+	return secretHostnames
+}
+
+// getIngressSecretAnnotations returns the annotations of a secret for the ingress with the hostnames; empty values mean the annotation isn't set
+func getIngressSecretAnnotations(ingress *networkingv1.Ingress, hostnames []string) map[string]string {
+	annotations := map[string]string{
+		annotationLetsEncryptCertificate:          "true",
+		annotationLetsEncryptCertificateHostnames: strings.Join(hostnames, ","),
+	}
+	for _, annotation := range ingressAnnotations {
+		annotations[annotation] = ingress.Annotations[annotation]
+	}
+
+	return annotations
+}
+
+// isOwnedByIngress checks whether the secret has been created for the ingress, so secrets managed otherwise are never taken over
+func isOwnedByIngress(secret *v1.Secret, ingress *networkingv1.Ingress) bool {
+	for _, ownerReference := range secret.OwnerReferences {
+		if ownerReference.UID == ingress.UID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reconcileIngress creates or updates the annotated secrets for the tls section of the ingress, which the annotation-driven flow then obtains the certificates for, and cleans up the secrets of removed tls entries according to the cleanup policy; an ingress that has lost the annotation gets all its secrets cleaned up
+func reconcileIngress(ctx context.Context, kubeClientset *kubernetes.Clientset, ingress *networkingv1.Ingress, cleanupPolicy string) error {
+	secretHostnames := map[string][]string{}
+	if getIngressBoolAnnotation(ingress, annotationLetsEncryptCertificate) {
+		secretHostnames = getIngressSecretHostnames(ingress)
+	}
+	secretNames := make([]string, 0, len(secretHostnames))
+	for secretName := range secretHostnames {
+		secretNames = append(secretNames, secretName)
+	}
+	sort.Strings(secretNames)
+
+	for _, secretName := range secretNames {
+		annotations := getIngressSecretAnnotations(ingress, secretHostnames[secretName])
+
+		secret, err := kubeClientset.CoreV1().Secrets(ingress.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			log.Info().Msgf("Ingress %v.%v - Creating secret %v for hostnames %v...", ingress.Name, ingress.Namespace, secretName, annotations[annotationLetsEncryptCertificateHostnames])
+			secret = &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: ingress.Namespace,
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress")),
+					},
+				},
+				Type: v1.SecretTypeOpaque,
+			}
+			applyManagedAnnotations(secret, annotations)
+			_, err = kubeClientset.CoreV1().Secrets(ingress.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !isOwnedByIngress(secret, ingress):
+			log.Warn().Msgf("Ingress %v.%v - Secret %v already exists and isn't managed for this ingress, leaving it as is", ingress.Name, ingress.Namespace, secretName)
+		default:
+			if applyManagedAnnotations(secret, annotations) {
+				log.Info().Msgf("Ingress %v.%v - Updating annotations of secret %v for hostnames %v...", ingress.Name, ingress.Namespace, secretName, annotations[annotationLetsEncryptCertificateHostnames])
+				_, err = kubeClientset.CoreV1().Secrets(ingress.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return cleanUpIngressSecrets(ctx, kubeClientset, ingress, secretHostnames, cleanupPolicy)
+}
+
+// cleanUpIngressSecrets orphans or deletes the secrets created for the ingress that are no longer named in its tls section, depending on the cleanup policy
+func cleanUpIngressSecrets(ctx context.Context, kubeClientset *kubernetes.Clientset, ingress *networkingv1.Ingress, secretHostnames map[string][]string, cleanupPolicy string) error {
+	secretList, err := kubeClientset.CoreV1().Secrets(ingress.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	removedSecrets := getRemovedIngressSecrets(secretList.Items, ingress, secretHostnames)
+	cleanupErrors := []error{}
+	for i := range removedSecrets {
+		secret := &removedSecrets[i]
+		if cleanupPolicy == "delete" {
+			log.Info().Msgf("Ingress %v.%v - Deleting secret %v, which is no longer in its tls section...", ingress.Name, ingress.Namespace, secret.Name)
+			err = kubeClientset.CoreV1().Secrets(ingress.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &secret.UID}})
+		} else {
+			log.Info().Msgf("Ingress %v.%v - Orphaning secret %v, which is no longer in its tls section...", ingress.Name, ingress.Namespace, secret.Name)
+			removeIngressOwnerReference(secret, ingress)
+			_, err = kubeClientset.CoreV1().Secrets(ingress.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			cleanupErrors = append(cleanupErrors, fmt.Errorf("secret %v: %w", secret.Name, err))
+		}
+	}
+
+	if len(cleanupErrors) > 0 {
+		return fmt.Errorf("Cleaning up %v out of %v removed secrets of ingress %v.%v failed: %w", len(cleanupErrors), len(removedSecrets), ingress.Name, ingress.Namespace, utilerrors.NewAggregate(cleanupErrors))
+	}
+
+	return nil
+}
+
+// getRemovedIngressSecrets returns the secrets owned by the ingress that aren't named in the secret hostnames of its tls section anymore
+func getRemovedIngressSecrets(secrets []v1.Secret, ingress *networkingv1.Ingress, secretHostnames map[string][]string) []v1.Secret {
+	removedSecrets := []v1.Secret{}
+	for _, secret := range secrets {
+		if _, ok := secretHostnames[secret.Name]; ok || !isOwnedByIngress(&secret, ingress) {
+			continue
+		}
+		removedSecrets = append(removedSecrets, secret)
+	}
+
+	return removedSecrets
+}
+
+// removeIngressOwnerReference removes the owner reference to the ingress, so the secret is kept as a standalone annotated secret when the ingress is deleted
+func removeIngressOwnerReference(secret *v1.Secret, ingress *networkingv1.Ingress) {
+	ownerReferences := []metav1.OwnerReference{}
+	for _, ownerReference := range secret.OwnerReferences {
+		if ownerReference.UID != ingress.UID {
+			ownerReferences = append(ownerReferences, ownerReference)
+		}
+	}
+	secret.OwnerReferences = ownerReferences
+}
+
+// getIngressBoolAnnotation returns the boolean value of the annotation of the ingress, or false if it's missing or can't be parsed
+func getIngressBoolAnnotation(ingress *networkingv1.Ingress, annotation string) bool {
+	return getBoolAnnotation(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: ingress.Annotations}}, annotation, false)
+}
+
+// ingressController feeds the events of an ingresses informer into a rate-limited workqueue, so reconciling the secrets of an ingress that failed is retried with backoff
+type ingressController struct {
+	kubeClientset *kubernetes.Clientset
+	informer      cache.SharedIndexInformer
+	lister        networkinglisters.IngressLister
+	queue         workqueue.RateLimitingInterface
+	debounce      time.Duration
+	maxRetries    int
+	cleanupPolicy string
+}
+
+func newIngressController(kubeClientset *kubernetes.Clientset, factory informers.SharedInformerFactory) *ingressController {
+	c := &ingressController{
+		kubeClientset: kubeClientset,
+		informer:      factory.Networking().V1().Ingresses().Informer(),
+		lister:        factory.Networking().V1().Ingresses().Lister(),
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(*secretRetryBaseDelay, *secretRetryMaxDelay), "ingresses"),
+		debounce:      *secretEventDebounce,
+		maxRetries:    *secretMaxRetries,
+		cleanupPolicy: *ingressCleanupPolicy,
+	}
+
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if ingress, ok := obj.(*networkingv1.Ingress); ok && getIngressBoolAnnotation(ingress, annotationLetsEncryptCertificate) {
+				c.enqueue(ingress)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldIngress, oldOk := oldObj.(*networkingv1.Ingress)
+			newIngress, newOk := newObj.(*networkingv1.Ingress)
+			if !oldOk || !newOk || oldIngress.ResourceVersion == newIngress.ResourceVersion {
+				return
+			}
+			// an ingress that has lost the annotation is queued as well, to clean up its secrets
+			if getIngressBoolAnnotation(newIngress, annotationLetsEncryptCertificate) || getIngressBoolAnnotation(oldIngress, annotationLetsEncryptCertificate) {
+				c.enqueue(newIngress)
+			}
+		},
+	})
+
+	return c
+}
+
+// enqueue adds the ingress to the queue after the debounce delay, so a burst of events for the same ingress results in a single reconciliation of its latest version
+func (c *ingressController) enqueue(ingress *networkingv1.Ingress) {
+	key := fmt.Sprintf("%v/%v", ingress.Namespace, ingress.Name)
+	if !replicaShard.Owns(key) {
+		return
+	}
+	if c.debounce <= 0 {
+		c.queue.Add(key)
+		return
+	}
+
+	c.queue.AddAfter(key, c.debounce)
+}
+
+// Run starts the informer and the worker, and returns once the informer has synced
+func (c *ingressController) Run(ctx context.Context, waitGroup *sync.WaitGroup, stopper chan struct{}) error {
+	log.Info().Msg("Watching ingresses for all namespaces...")
+
+	go c.informer.Run(stopper)
+	if !cache.WaitForCacheSync(stopper, c.informer.HasSynced) {
+		return fmt.Errorf("Syncing the ingresses informer failed")
+	}
+
+	go func() {
+		<-stopper
+		c.queue.ShutDown()
+	}()
+
+	go func() {
+		for c.processNextItem(ctx, waitGroup) {
+		}
+	}()
+
+	return nil
+}
+
+// processNextItem reconciles the next ingress in the queue and retries it with backoff if it failed; it returns false once the queue is shut down
+func (c *ingressController) processNextItem(ctx context.Context, waitGroup *sync.WaitGroup) bool {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.queue.Forget(obj)
+		return true
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.queue.Forget(obj)
+		return true
+	}
+
+	ingress, err := c.lister.Ingresses(namespace).Get(name)
+	if err != nil {
+		// deleted since it was queued, its secrets are removed along with it by their owner references
+		c.queue.Forget(obj)
+		return true
+	}
+
+	waitGroup.Add(1)
+	// the ingress is shared with the informer's cache, so work on a copy
+	err = reconcileIngress(ctx, c.kubeClientset, ingress.DeepCopy(), c.cleanupPolicy)
+	waitGroup.Done()
+
+	if err != nil {
+		log.Warn().Err(err).Msgf("Reconciling ingress %v.%v failed", name, namespace)
+		if c.queue.NumRequeues(obj) < c.maxRetries {
+			c.queue.AddRateLimited(obj)
+			return true
+		}
+	}
+
+	c.queue.Forget(obj)
+	return true
+}
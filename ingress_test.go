@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetIngressSecretHostnames(t *testing.T) {
+
+	t.Run("ReturnsHostsPerSecretName", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{
+			{Hosts: []string{"estafette.io", "www.estafette.io"}, SecretName: "estafette-tls"},
+			{Hosts: []string{"api.estafette.io", "estafette.io"}, SecretName: "estafette-tls"},
+			{Hosts: []string{"ci.estafette.io"}, SecretName: "ci-tls"},
+		}}}
+
+		// act
+		secretHostnames := getIngressSecretHostnames(ingress)
+
+		assert.Equal(t, map[string][]string{
+			"estafette-tls": {"estafette.io", "www.estafette.io", "api.estafette.io"},
+			"ci-tls":        {"ci.estafette.io"},
+		}, secretHostnames)
+	})
+
+	t.Run("SkipsEntriesWithoutSecretNameOrHosts", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{
+			{Hosts: []string{"estafette.io"}},
+			{SecretName: "estafette-tls"},
+		}}}
+
+		// act
+		secretHostnames := getIngressSecretHostnames(ingress)
+
+		assert.Equal(t, map[string][]string{}, secretHostnames)
+	})
+}
+
+func TestGetIngressSecretAnnotations(t *testing.T) {
+
+	t.Run("PassesOnAnnotationsOfTheIngress", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationLetsEncryptCertificate:                   "true",
+			annotationLetsEncryptCertificateHostnames:          "other.estafette.io",
+			annotationLetsEncryptCertificateUploadToCloudflare: "true",
+			"kubernetes.io/ingress.class":                      "nginx",
+		}}}
+		secret := &v1.Secret{}
+
+		// act
+		applyManagedAnnotations(secret, getIngressSecretAnnotations(ingress, []string{"estafette.io", "www.estafette.io"}))
+
+		assert.Equal(t, map[string]string{
+			annotationLetsEncryptCertificate:                   "true",
+			annotationLetsEncryptCertificateHostnames:          "estafette.io,www.estafette.io",
+			annotationLetsEncryptCertificateUploadToCloudflare: "true",
+		}, secret.Annotations)
+	})
+
+	t.Run("RemovesAnnotationsNoLongerOnTheIngress", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationLetsEncryptCertificate: "true",
+		}}}
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationLetsEncryptCertificate:          "true",
+			annotationLetsEncryptCertificateHostnames: "estafette.io",
+			annotationLetsEncryptCertificateStaging:   "true",
+			annotationLetsEncryptCertificateState:     "{}",
+		}}}
+
+		// act
+		changed := applyManagedAnnotations(secret, getIngressSecretAnnotations(ingress, []string{"estafette.io"}))
+
+		assert.True(t, changed)
+		assert.Equal(t, map[string]string{
+			annotationLetsEncryptCertificate:          "true",
+			annotationLetsEncryptCertificateHostnames: "estafette.io",
+			annotationLetsEncryptCertificateState:     "{}",
+		}, secret.Annotations)
+	})
+}
+
+func TestIsOwnedByIngress(t *testing.T) {
+
+	t.Run("ReturnsTrueIfSecretHasOwnerReferenceToIngress", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{UID: types.UID("ingress-uid")}}
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{UID: types.UID("ingress-uid")}}}}
+
+		// act
+		owned := isOwnedByIngress(secret, ingress)
+
+		assert.True(t, owned)
+	})
+
+	t.Run("ReturnsFalseForSecretManagedOtherwise", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{UID: types.UID("ingress-uid")}}
+		secret := &v1.Secret{}
+
+		// act
+		owned := isOwnedByIngress(secret, ingress)
+
+		assert.False(t, owned)
+	})
+}
+
+func TestGetRemovedIngressSecrets(t *testing.T) {
+
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{UID: types.UID("ingress-uid")}}
+	ownerReferences := []metav1.OwnerReference{{UID: types.UID("ingress-uid")}}
+
+	t.Run("ReturnsOwnedSecretsNoLongerInTLSSection", func(t *testing.T) {
+
+		secrets := []v1.Secret{
+			{ObjectMeta: metav1.ObjectMeta{Name: "kept-tls", OwnerReferences: ownerReferences}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "removed-tls", OwnerReferences: ownerReferences}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other-tls"}},
+		}
+
+		// act
+		removedSecrets := getRemovedIngressSecrets(secrets, ingress, map[string][]string{"kept-tls": {"estafette.io"}})
+
+		assert.Equal(t, 1, len(removedSecrets))
+		assert.Equal(t, "removed-tls", removedSecrets[0].Name)
+	})
+
+	t.Run("ReturnsAllOwnedSecretsIfIngressHasLostTheAnnotation", func(t *testing.T) {
+
+		secrets := []v1.Secret{
+			{ObjectMeta: metav1.ObjectMeta{Name: "first-tls", OwnerReferences: ownerReferences}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "second-tls", OwnerReferences: ownerReferences}},
+		}
+
+		// act
+		removedSecrets := getRemovedIngressSecrets(secrets, ingress, map[string][]string{})
+
+		assert.Equal(t, 2, len(removedSecrets))
+	})
+}
+
+func TestRemoveIngressOwnerReference(t *testing.T) {
+
+	t.Run("KeepsOtherOwnerReferences", func(t *testing.T) {
+
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{UID: types.UID("ingress-uid")}}
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{UID: types.UID("ingress-uid")}, {UID: types.UID("other-uid")}}}}
+
+		// act
+		removeIngressOwnerReference(secret, ingress)
+
+		assert.Equal(t, []metav1.OwnerReference{{UID: types.UID("other-uid")}}, secret.OwnerReferences)
+		assert.False(t, isOwnedByIngress(secret, ingress))
+	})
+}
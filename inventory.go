@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// inventoryItem describes a managed certificate for a single hostname, for import in asset management systems
+type inventoryItem struct {
+	Hostname    string `json:"hostname"`
+	Namespace   string `json:"namespace"`
+	Secret      string `json:"secret"`
+	Issuer      string `json:"issuer"`
+	Serial      string `json:"serial"`
+	NotAfter    string `json:"notAfter"`
+	NextRenewal string `json:"nextRenewal"`
+	Wildcard    bool   `json:"wildcard"`
+}
+
+// getInventoryItems returns an item per hostname of the certificate managed through secret; the certificate is read from the target secret if it's among secrets
+func getInventoryItems(secret *v1.Secret, secrets map[string]*v1.Secret) (items []inventoryItem) {
+	desiredState := getDesiredSecretState(secret)
+	if desiredState.Enabled != "true" || desiredState.Hostnames == "" {
+		return nil
+	}
+
+	dataSecret := secret
+	if desiredState.TargetSecret != "" {
+		dataSecret = secrets[fmt.Sprintf("%v/%v", secret.Namespace, desiredState.TargetSecret)]
+		if dataSecret == nil {
+			dataSecret = &v1.Secret{}
+		}
+	}
+
+	// list hostnames without a (readable) certificate as well, since those need attention from compliance teams most
+	var issuer, serial, notAfter, nextRenewal string
+	certificateBytes, _ := getSecretCertificate(dataSecret)
+	certificate, err := parseCertificate(certificateBytes)
+	if err == nil {
+		issuer = certificate.Issuer.CommonName
+		serial = fmt.Sprintf("%x", certificate.SerialNumber)
+		notAfter = certificate.NotAfter.UTC().Format(time.RFC3339)
+	}
+	if renewalTime, ok := getNextRenewal(secret, dataSecret); ok {
+		nextRenewal = renewalTime.UTC().Format(time.RFC3339)
+	}
+
+	for _, hostname := range strings.Split(desiredState.Hostnames, ",") {
+		items = append(items, inventoryItem{
+			Hostname:    hostname,
+			Namespace:   secret.Namespace,
+			Secret:      secret.Name,
+			Issuer:      issuer,
+			Serial:      serial,
+			NotAfter:    notAfter,
+			NextRenewal: nextRenewal,
+			Wildcard:    isWildcardHostname(hostname),
+		})
+	}
+
+	return items
+}
+
+// getInventory lists all secrets and returns the inventory items for the managed certificates
+func getInventory(ctx context.Context, kubeClientset *kubernetes.Clientset) ([]inventoryItem, error) {
+	secretList, err := kubeClientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]*v1.Secret{}
+	for i := range secretList.Items {
+		secrets[fmt.Sprintf("%v/%v", secretList.Items[i].Namespace, secretList.Items[i].Name)] = &secretList.Items[i]
+	}
+
+	items := []inventoryItem{}
+	for i := range secretList.Items {
+		items = append(items, getInventoryItems(&secretList.Items[i], secrets)...)
+	}
+
+	return items, nil
+}
+
+// writeInventoryCSV writes the inventory items as csv with a header row
+func writeInventoryCSV(w http.ResponseWriter, items []inventoryItem) error {
+	w.Header().Set("Content-Type", "text/csv")
+
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"hostname", "namespace", "secret", "issuer", "serial", "notAfter", "nextRenewal", "wildcard"})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		err = writer.Write([]string{item.Hostname, item.Namespace, item.Secret, item.Issuer, item.Serial, item.NotAfter, item.NextRenewal, strconv.FormatBool(item.Wildcard)})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// newInventoryHandler returns the handler for the /inventory endpoint, which responds with json or, with ?format=csv, with csv
+func newInventoryHandler(ctx context.Context, kubeClientset *kubernetes.Clientset) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := getInventory(ctx, kubeClientset)
+		if err != nil {
+			log.Error().Err(err).Msg("Getting inventory failed")
+			http.Error(w, "Getting inventory failed", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			err = writeInventoryCSV(w, items)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(items)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Writing inventory failed")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetInventoryItems(t *testing.T) {
+	t.Run("ReturnsNoItemsIfSecretIsNotManaged", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		items := getInventoryItems(secret, map[string]*v1.Secret{})
+
+		assert.Equal(t, 0, len(items))
+	})
+
+	t.Run("ReturnsItemPerHostnameWithCertificateDetails", func(t *testing.T) {
+
+		notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "mysecret",
+				Namespace: "mynamespace",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificate:          "true",
+					annotationLetsEncryptCertificateHostnames: "estafette.io,www.estafette.io",
+				},
+			},
+			Data: map[string][]byte{
+				"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), notAfter),
+			},
+		}
+
+		// act
+		items := getInventoryItems(secret, map[string]*v1.Secret{})
+
+		assert.Equal(t, 2, len(items))
+		assert.Equal(t, "www.estafette.io", items[1].Hostname)
+		assert.Equal(t, "mynamespace", items[1].Namespace)
+		assert.Equal(t, "mysecret", items[1].Secret)
+		assert.Equal(t, "estafette.io", items[1].Issuer)
+		assert.Equal(t, "1", items[1].Serial)
+		assert.Equal(t, notAfter.Format(time.RFC3339), items[1].NotAfter)
+		assert.Equal(t, "", items[1].NextRenewal)
+	})
+
+	t.Run("ReturnsNextRenewalIfCertificateHasBeenRenewed", func(t *testing.T) {
+
+		lastRenewed := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "mysecret",
+				Namespace: "mynamespace",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificate:          "true",
+					annotationLetsEncryptCertificateHostnames: "estafette.io",
+					annotationLetsEncryptCertificateState:     `{"enabled":"true","hostnames":"estafette.io","lastRenewed":"` + lastRenewed.Format(time.RFC3339) + `"}`,
+				},
+			},
+		}
+
+		// act
+		items := getInventoryItems(secret, map[string]*v1.Secret{})
+
+		assert.Equal(t, 1, len(items))
+		assert.Equal(t, lastRenewed.Add(time.Duration(*daysBeforeRenewal)*24*time.Hour).Format(time.RFC3339), items[0].NextRenewal)
+	})
+
+	t.Run("ReadsCertificateFromTargetSecret", func(t *testing.T) {
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "mysecret",
+				Namespace: "mynamespace",
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificate:             "true",
+					annotationLetsEncryptCertificateHostnames:    "estafette.io",
+					annotationLetsEncryptCertificateTargetSecret: "mysecret-tls",
+				},
+			},
+		}
+		targetSecret := &v1.Secret{
+			Data: map[string][]byte{
+				"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)),
+			},
+		}
+
+		// act
+		items := getInventoryItems(secret, map[string]*v1.Secret{"mynamespace/mysecret-tls": targetSecret})
+
+		assert.Equal(t, 1, len(items))
+		assert.Equal(t, "estafette.io", items[0].Issuer)
+	})
+}
+
+func TestWriteInventoryCSV(t *testing.T) {
+	t.Run("WritesHeaderAndRowPerItem", func(t *testing.T) {
+
+		recorder := httptest.NewRecorder()
+		items := []inventoryItem{{Hostname: "estafette.io", Namespace: "mynamespace", Secret: "mysecret", Issuer: "R10", Serial: "1", NotAfter: "2026-12-31T00:00:00Z", NextRenewal: "2026-11-30T00:00:00Z"}}
+
+		// act
+		err := writeInventoryCSV(recorder, items)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hostname,namespace,secret,issuer,serial,notAfter,nextRenewal,wildcard\nestafette.io,mynamespace,mysecret,R10,1,2026-12-31T00:00:00Z,2026-11-30T00:00:00Z,false\n", recorder.Body.String())
+	})
+}
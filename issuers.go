@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// issuerTracker remembers per environment when each issuing intermediate was last seen in a newly obtained certificate, so certificates issued by an intermediate the CA no longer uses can be detected
+type issuerTracker struct {
+	window   time.Duration
+	mutex    sync.Mutex
+	lastSeen map[string]map[string]time.Time
+}
+
+func newIssuerTracker(window time.Duration) *issuerTracker {
+	return &issuerTracker{
+		window:   window,
+		lastSeen: map[string]map[string]time.Time{},
+	}
+}
+
+// Observe records that the CA of the environment has just issued a certificate signed by issuer
+func (t *issuerTracker) Observe(environment, issuer string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.lastSeen[environment]; !ok {
+		t.lastSeen[environment] = map[string]time.Time{}
+	}
+	t.lastSeen[environment][issuer] = time.Now()
+}
+
+// IsRetired returns true if issuer hasn't been seen within the window while other issuers have, meaning the CA has moved on to another intermediate chain
+func (t *issuerTracker) IsRetired(environment, issuer string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	activeIssuers := 0
+	for observedIssuer, lastSeen := range t.lastSeen[environment] {
+		if time.Since(lastSeen) > t.window {
+			continue
+		}
+		if observedIssuer == issuer {
+			return false
+		}
+		activeIssuers++
+	}
+
+	return activeIssuers > 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuerTracker(t *testing.T) {
+
+	t.Run("ReturnsFalseIfNoIssuersHaveBeenObserved", func(t *testing.T) {
+
+		tracker := newIssuerTracker(time.Hour)
+
+		// act
+		retired := tracker.IsRetired("production", "R3")
+
+		assert.False(t, retired)
+	})
+
+	t.Run("ReturnsFalseIfIssuerHasBeenObservedWithinWindow", func(t *testing.T) {
+
+		tracker := newIssuerTracker(time.Hour)
+		tracker.Observe("production", "R10")
+		tracker.Observe("production", "R11")
+
+		// act
+		retired := tracker.IsRetired("production", "R10")
+
+		assert.False(t, retired)
+	})
+
+	t.Run("ReturnsTrueIfOnlyOtherIssuersHaveBeenObservedWithinWindow", func(t *testing.T) {
+
+		tracker := newIssuerTracker(time.Hour)
+		tracker.Observe("production", "R10")
+
+		// act
+		retired := tracker.IsRetired("production", "R3")
+
+		assert.True(t, retired)
+	})
+
+	t.Run("ReturnsFalseIfOtherIssuersHaveOnlyBeenObservedInOtherEnvironment", func(t *testing.T) {
+
+		tracker := newIssuerTracker(time.Hour)
+		tracker.Observe("staging", "(STAGING) Ersatz Edamame E1")
+
+		// act
+		retired := tracker.IsRetired("production", "R3")
+
+		assert.False(t, retired)
+	})
+
+	t.Run("ReturnsTrueIfIssuerHasOnlyBeenObservedBeforeWindow", func(t *testing.T) {
+
+		tracker := newIssuerTracker(time.Hour)
+		tracker.Observe("production", "R3")
+		tracker.lastSeen["production"]["R3"] = time.Now().Add(-2 * time.Hour)
+		tracker.Observe("production", "R10")
+
+		// act
+		retired := tracker.IsRetired("production", "R3")
+
+		assert.True(t, retired)
+	})
+}
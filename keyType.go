@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// keyTypeNames are the values of the key-type flag and annotation
+var keyTypeNames = []string{"rsa2048", "rsa4096", "rsa8192", "ec256", "ec384"}
+
+// keyTypes maps the values of the key-type flag and annotation to the key types lego generates certificate keys with
+var keyTypes = map[string]certcrypto.KeyType{
+	"rsa2048": certcrypto.RSA2048,
+	"rsa4096": certcrypto.RSA4096,
+	"rsa8192": certcrypto.RSA8192,
+	"ec256":   certcrypto.EC256,
+	"ec384":   certcrypto.EC384,
+}
+
+// getCertificateKeyType returns the key type of the certificate in the notation of the key-type flag, or an empty string for other keys
+func getCertificateKeyType(certificate *x509.Certificate) string {
+	switch publicKey := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa%v", publicKey.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ec%v", publicKey.Curve.Params().BitSize)
+	}
+
+	return ""
+}
+
+// getKeyTypeReissueTime returns when the certificate in the secret gets reissued because its key type differs from the configured one; each secret gets a fixed slot within the period after start, so not all certificates get reissued at once
+func getKeyTypeReissueTime(secret *v1.Secret, keyType string, start time.Time, period time.Duration) (reissueAt time.Time, ok bool) {
+	if period <= 0 {
+		return reissueAt, false
+	}
+
+	certificateBytes, _ := getSecretCertificate(secret)
+	certificate, err := parseCertificate(certificateBytes)
+	if err != nil || getCertificateKeyType(certificate) == keyType {
+		return reissueAt, false
+	}
+
+	offset := time.Duration(hashKey(fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)) % uint64(period))
+
+	return start.Add(offset), true
+}
+
+// getStateKeyType returns the key type set for the secret, or the default of the cluster configuration or key-type flag
+func getStateKeyType(state LetsEncryptCertificateState) string {
+	if _, ok := keyTypes[state.KeyType]; ok {
+		return state.KeyType
+	}
+
+	return getDefaultKeyType()
+}
+
+// hasOutdatedKeyType returns true once the slot for reissuing a certificate with another key type than the configured one has come
+func hasOutdatedKeyType(secret *v1.Secret, desiredState LetsEncryptCertificateState, initiator string) bool {
+	desiredKeyType := getStateKeyType(desiredState)
+	reissueAt, ok := getKeyTypeReissueTime(secret, desiredKeyType, controllerStartTime, *keyTypeReissuePeriod)
+	if !ok || time.Now().Before(reissueAt) {
+		return false
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Certificate has another key type than %v, reissuing it...", initiator, secret.Name, secret.Namespace, desiredKeyType)
+
+	return true
+}
+
+// dualCertificateDataPrefixes are the prefixes of the data items the certificates of both key types are stored under with the dual-key-types annotation
+var dualCertificateDataPrefixes = []string{"tls-ecdsa", "tls-rsa"}
+
+// getDualKeyType returns the key type of the second certificate obtained with the dual-key-types annotation: rsa for ecdsa certificates and the other way around
+func getDualKeyType(keyTypeName string) string {
+	if strings.HasPrefix(keyTypeName, "ec") {
+		return "rsa2048"
+	}
+
+	return "ec256"
+}
+
+// getKeyTypeDataPrefix returns the prefix of the data items the certificate with the key type is stored under with the dual-key-types annotation
+func getKeyTypeDataPrefix(keyTypeName string) string {
+	if strings.HasPrefix(keyTypeName, "ec") {
+		return "tls-ecdsa"
+	}
+
+	return "tls-rsa"
+}
+
+// getPrimaryKeyType returns the key type of the obtained certificate, falling back to the configured one if there's none or it can't be parsed
+func getPrimaryKeyType(certificates *certificate.Resource, desiredState LetsEncryptCertificateState) string {
+	if certificates == nil {
+		return getStateKeyType(desiredState)
+	}
+	if certificate, err := parseCertificate(certificates.Certificate); err == nil {
+		if keyType := getCertificateKeyType(certificate); keyType != "" {
+			return keyType
+		}
+	}
+
+	return getStateKeyType(desiredState)
+}
+
+// writeDualCertificatesToSecretData stores the certificates of both key types under their own tls-ecdsa.* and tls-rsa.* data items, or removes those items when the dual-key-types annotation isn't set; when obtaining the dual certificate failed only the items of the primary key type are replaced, and the other key type's items of the previous renewal are kept until it's obtained
+func writeDualCertificatesToSecretData(secret *v1.Secret, certificates, dualCertificates *certificate.Resource, desiredState LetsEncryptCertificateState) {
+	primaryKeyType := getPrimaryKeyType(certificates, desiredState)
+	resources := map[string]*certificate.Resource{
+		getKeyTypeDataPrefix(primaryKeyType):                 certificates,
+		getKeyTypeDataPrefix(getDualKeyType(primaryKeyType)): dualCertificates,
+	}
+
+	for _, prefix := range dualCertificateDataPrefixes {
+		if desiredState.DualKeyTypes && resources[prefix] == nil {
+			continue
+		}
+		for _, suffix := range []string{".crt", ".key", ".issuer.crt"} {
+			delete(secret.Data, prefix+suffix)
+		}
+	}
+
+	if !desiredState.DualKeyTypes && dualCertificates == nil {
+		return
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	for prefix, resource := range resources {
+		if resource == nil {
+			continue
+		}
+		secret.Data[prefix+".crt"] = resource.Certificate
+		secret.Data[prefix+".key"] = resource.PrivateKey
+		if resource.IssuerCertificate != nil {
+			secret.Data[prefix+".issuer.crt"] = resource.IssuerCertificate
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCertificateKeyType(t *testing.T) {
+	t.Run("ReturnsCurveSizeForEcdsaKey", func(t *testing.T) {
+
+		certificate, err := parseCertificate(generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)))
+		assert.Nil(t, err)
+
+		// act
+		keyType := getCertificateKeyType(certificate)
+
+		assert.Equal(t, "ec256", keyType)
+	})
+}
+
+func TestGetKeyTypeReissueTime(t *testing.T) {
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	newSecret := func(name string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "estafette"},
+			Data: map[string][]byte{
+				"tls.crt": generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)),
+			},
+		}
+	}
+
+	t.Run("ReturnsFalseIfPeriodIsZero", func(t *testing.T) {
+
+		// act
+		_, ok := getKeyTypeReissueTime(newSecret("wildcard-tls"), "rsa2048", start, 0)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsFalseIfKeyTypeMatches", func(t *testing.T) {
+
+		// act
+		_, ok := getKeyTypeReissueTime(newSecret("wildcard-tls"), "ec256", start, 7*24*time.Hour)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsFalseIfSecretHasNoCertificate", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "wildcard-tls", Namespace: "estafette"}}
+
+		// act
+		_, ok := getKeyTypeReissueTime(secret, "rsa2048", start, 7*24*time.Hour)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsStableTimeWithinPeriodIfKeyTypeDiffers", func(t *testing.T) {
+
+		period := 7 * 24 * time.Hour
+
+		// act
+		reissueAt, ok := getKeyTypeReissueTime(newSecret("wildcard-tls"), "rsa2048", start, period)
+		reissueAtAgain, _ := getKeyTypeReissueTime(newSecret("wildcard-tls"), "rsa2048", start, period)
+
+		assert.True(t, ok)
+		assert.False(t, reissueAt.Before(start))
+		assert.True(t, reissueAt.Before(start.Add(period)))
+		assert.Equal(t, reissueAt, reissueAtAgain)
+	})
+
+	t.Run("SpreadsSecretsOverPeriod", func(t *testing.T) {
+
+		period := 7 * 24 * time.Hour
+
+		// act
+		reissueAt1, _ := getKeyTypeReissueTime(newSecret("wildcard-tls"), "rsa2048", start, period)
+		reissueAt2, _ := getKeyTypeReissueTime(newSecret("other-tls"), "rsa2048", start, period)
+
+		assert.NotEqual(t, reissueAt1, reissueAt2)
+	})
+}
+
+func TestGetStateKeyType(t *testing.T) {
+
+	*keyType = "rsa2048"
+
+	t.Run("ReturnsKeyTypeOfStateIfSet", func(t *testing.T) {
+
+		// act
+		stateKeyType := getStateKeyType(LetsEncryptCertificateState{KeyType: "ec384"})
+
+		assert.Equal(t, "ec384", stateKeyType)
+	})
+
+	t.Run("ReturnsKeyTypeOfFlagIfStateHasNone", func(t *testing.T) {
+
+		// act
+		stateKeyType := getStateKeyType(LetsEncryptCertificateState{})
+
+		assert.Equal(t, "rsa2048", stateKeyType)
+	})
+
+	t.Run("ReturnsKeyTypeOfFlagIfStateHasUnknownKeyType", func(t *testing.T) {
+
+		// act
+		stateKeyType := getStateKeyType(LetsEncryptCertificateState{KeyType: "dsa1024"})
+
+		assert.Equal(t, "rsa2048", stateKeyType)
+	})
+}
+
+func TestGetDualKeyType(t *testing.T) {
+	t.Run("ReturnsRsaForEcdsaKeyType", func(t *testing.T) {
+
+		// act
+		dualKeyType := getDualKeyType("ec384")
+
+		assert.Equal(t, "rsa2048", dualKeyType)
+	})
+
+	t.Run("ReturnsEcdsaForRsaKeyType", func(t *testing.T) {
+
+		// act
+		dualKeyType := getDualKeyType("rsa4096")
+
+		assert.Equal(t, "ec256", dualKeyType)
+	})
+}
+
+func TestWriteDualCertificatesToSecretData(t *testing.T) {
+	t.Run("StoresCertificatesUnderPrefixOfTheirKeyType", func(t *testing.T) {
+
+		secret := &v1.Secret{}
+		certificates := &certificate.Resource{Certificate: generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)), PrivateKey: []byte("ecdsa-key")}
+		dualCertificates := &certificate.Resource{Certificate: []byte("rsa-crt"), PrivateKey: []byte("rsa-key"), IssuerCertificate: []byte("rsa-issuer")}
+
+		// act
+		writeDualCertificatesToSecretData(secret, certificates, dualCertificates, LetsEncryptCertificateState{KeyType: "rsa2048"})
+
+		assert.Equal(t, certificates.Certificate, secret.Data["tls-ecdsa.crt"])
+		assert.Equal(t, []byte("ecdsa-key"), secret.Data["tls-ecdsa.key"])
+		assert.Equal(t, []byte("rsa-crt"), secret.Data["tls-rsa.crt"])
+		assert.Equal(t, []byte("rsa-key"), secret.Data["tls-rsa.key"])
+		assert.Equal(t, []byte("rsa-issuer"), secret.Data["tls-rsa.issuer.crt"])
+	})
+
+	t.Run("RemovesDualItemsWithoutDualCertificate", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt"), "tls-rsa.crt": []byte("rsa-crt"), "tls-ecdsa.key": []byte("ecdsa-key")}}
+
+		// act
+		writeDualCertificatesToSecretData(secret, &certificate.Resource{}, nil, LetsEncryptCertificateState{})
+
+		assert.Equal(t, map[string][]byte{"tls.crt": []byte("crt")}, secret.Data)
+	})
+	t.Run("KeepsItemsOfOtherKeyTypeIfDualCertificateIsMissing", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls-ecdsa.crt": []byte("old-ecdsa-crt"), "tls-rsa.crt": []byte("old-rsa-crt"), "tls-rsa.key": []byte("old-rsa-key")}}
+		certificates := &certificate.Resource{Certificate: generateTestCertificate(t, time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)), PrivateKey: []byte("ecdsa-key")}
+
+		// act
+		writeDualCertificatesToSecretData(secret, certificates, nil, LetsEncryptCertificateState{KeyType: "ec256", DualKeyTypes: true})
+
+		assert.Equal(t, certificates.Certificate, secret.Data["tls-ecdsa.crt"])
+		assert.Equal(t, []byte("ecdsa-key"), secret.Data["tls-ecdsa.key"])
+		assert.Equal(t, []byte("old-rsa-crt"), secret.Data["tls-rsa.crt"])
+		assert.Equal(t, []byte("old-rsa-key"), secret.Data["tls-rsa.key"])
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// lockTracker remembers which renewal locks (the lastAttempt in the state) have been set by this controller process, to detect locks left behind by a process that stopped mid-order
+type lockTracker struct {
+	mutex    sync.Mutex
+	acquired map[string]string
+}
+
+func newLockTracker() *lockTracker {
+	return &lockTracker{
+		acquired: map[string]string{},
+	}
+}
+
+// Acquire records that this process has locked the secret with key at lastAttempt
+func (t *lockTracker) Acquire(key, lastAttempt string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.acquired[key] = lastAttempt
+}
+
+// IsStale returns true for a lock this process didn't set once it's older than timeout, since no order of this process can be behind it; the age is used rather than the number of evaluations, because a burst of events for the secret would otherwise release the lock of an order another process is still running
+func (t *lockTracker) IsStale(key, lastAttempt string, timeout time.Duration, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.acquired[key] == lastAttempt {
+		return false
+	}
+
+	lastAttemptTime, err := time.Parse(time.RFC3339, lastAttempt)
+	if err != nil {
+		return true
+	}
+
+	return now.Sub(lastAttemptTime) >= timeout
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockTracker(t *testing.T) {
+	t.Run("ReturnsFalseForLockAcquiredByThisProcess", func(t *testing.T) {
+
+		tracker := newLockTracker()
+		tracker.Acquire("mynamespace/mysecret", "2026-10-16T10:00:00Z")
+
+		// act
+		stale := tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC))
+
+		assert.False(t, stale)
+	})
+
+	t.Run("ReturnsFalseForForeignLockYoungerThanTimeout", func(t *testing.T) {
+
+		tracker := newLockTracker()
+
+		// act
+		stale := tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, time.Date(2026, 10, 16, 10, 2, 0, 0, time.UTC))
+
+		assert.False(t, stale)
+	})
+
+	t.Run("ReturnsFalseForForeignLockYoungerThanTimeoutRegardlessOfTheNumberOfEvaluations", func(t *testing.T) {
+
+		tracker := newLockTracker()
+		now := time.Date(2026, 10, 16, 10, 2, 0, 0, time.UTC)
+		for i := 0; i < 10; i++ {
+			tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, now)
+		}
+
+		// act
+		stale := tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, now)
+
+		assert.False(t, stale)
+	})
+
+	t.Run("ReturnsTrueForForeignLockOlderThanTimeout", func(t *testing.T) {
+
+		tracker := newLockTracker()
+
+		// act
+		stale := tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, time.Date(2026, 10, 16, 10, 6, 0, 0, time.UTC))
+
+		assert.True(t, stale)
+	})
+
+	t.Run("ReturnsFalseForOldLockAcquiredByThisProcess", func(t *testing.T) {
+
+		tracker := newLockTracker()
+		tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, time.Date(2026, 10, 16, 10, 2, 0, 0, time.UTC))
+		tracker.Acquire("mynamespace/mysecret", "2026-10-16T10:00:00Z")
+
+		// act
+		stale := tracker.IsStale("mynamespace/mysecret", "2026-10-16T10:00:00Z", 5*time.Minute, time.Date(2026, 10, 16, 10, 6, 0, 0, time.UTC))
+
+		assert.False(t, stale)
+	})
+}
@@ -3,17 +3,23 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/alecthomas/kingpin"
 	foundation "github.com/estafette/estafette-foundation"
@@ -22,14 +28,17 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
-	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/registration"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	k8sruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -39,11 +48,52 @@ import (
 const annotationLetsEncryptCertificate string = "estafette.io/letsencrypt-certificate"
 const annotationLetsEncryptCertificateHostnames string = "estafette.io/letsencrypt-certificate-hostnames"
 const annotationLetsEncryptCertificateCopyToAllNamespaces string = "estafette.io/letsencrypt-certificate-copy-to-all-namespaces"
+const annotationLetsEncryptCertificateCopyToNamespacesMatching string = "estafette.io/letsencrypt-certificate-copy-to-namespaces-matching"
+const annotationLetsEncryptCertificateCopyNameTemplate string = "estafette.io/letsencrypt-certificate-copy-name-template"
+const annotationLetsEncryptCertificateCopyNameMapping string = "estafette.io/letsencrypt-certificate-copy-name-mapping"
+const annotationLetsEncryptCertificateCopyPrivateKey string = "estafette.io/letsencrypt-certificate-copy-private-key"
 const annotationLetsEncryptCertificateLinkedSecret string = "estafette.io/letsencrypt-certificate-linked-secret"
 const annotationLetsEncryptCertificateUploadToCloudflare string = "estafette.io/letsencrypt-certificate-upload-to-cloudflare"
+const annotationLetsEncryptCertificateCloudflareZone string = "estafette.io/letsencrypt-certificate-cloudflare-zone"
+const annotationLetsEncryptCertificateSeparateKeySecret string = "estafette.io/letsencrypt-certificate-separate-key-secret"
+const annotationLetsEncryptCertificateTargetSecret string = "estafette.io/letsencrypt-certificate-target-secret"
+const annotationLetsEncryptCertificateStaging string = "estafette.io/letsencrypt-certificate-staging"
+const annotationLetsEncryptCertificatePromote string = "estafette.io/letsencrypt-certificate-promote"
+const annotationLetsEncryptCertificateOutputFamilies string = "estafette.io/letsencrypt-certificate-output-families"
+const annotationLetsEncryptCertificateAutoAddWWW string = "estafette.io/letsencrypt-certificate-auto-add-www"
+const annotationLetsEncryptCertificateAccountEmail string = "estafette.io/letsencrypt-certificate-account-email"
+const annotationLetsEncryptCertificateDNSDisablePropagationCheck string = "estafette.io/letsencrypt-certificate-dns-disable-propagation-check"
+const annotationLetsEncryptCertificateDNSSequential string = "estafette.io/letsencrypt-certificate-dns-sequential"
+const annotationLetsEncryptCertificateBundle string = "estafette.io/letsencrypt-certificate-bundle"
+const annotationLetsEncryptCertificateExpiresAfter string = "estafette.io/letsencrypt-certificate-expires-after"
+const annotationLetsEncryptCertificateExpiredAction string = "estafette.io/letsencrypt-certificate-expired-action"
+const annotationLetsEncryptCertificateStorage string = "estafette.io/letsencrypt-certificate-storage"
+const annotationLetsEncryptCertificateRenewDaysBeforeExpiry string = "estafette.io/letsencrypt-certificate-renew-days-before-expiry"
+const annotationLetsEncryptCertificateRetryAfterMinutes string = "estafette.io/letsencrypt-certificate-retry-after-minutes"
+const annotationLetsEncryptCertificateCredentialsSecret string = "estafette.io/letsencrypt-certificate-credentials-secret"
+const annotationLetsEncryptCertificateDNSProvider string = "estafette.io/letsencrypt-certificate-dns-provider"
+const annotationLetsEncryptCertificateKeyType string = "estafette.io/letsencrypt-certificate-key-type"
+const annotationLetsEncryptCertificateACMEDirectoryURL string = "estafette.io/letsencrypt-certificate-acme-directory-url"
+const annotationLetsEncryptCertificateWriteJSON string = "estafette.io/letsencrypt-certificate-write-json"
+const annotationLetsEncryptCertificateDualKeyTypes string = "estafette.io/letsencrypt-certificate-dual-key-types"
+const annotationLetsEncryptCertificateChallengeType string = "estafette.io/letsencrypt-certificate-challenge-type"
+const annotationLetsEncryptCertificateSNICertificates string = "estafette.io/letsencrypt-certificate-sni-certificates"
+const annotationLetsEncryptCertificateKeepPrevious string = "estafette.io/letsencrypt-certificate-keep-previous"
+const annotationLetsEncryptCertificateRollback string = "estafette.io/letsencrypt-certificate-rollback"
+const annotationLetsEncryptCertificateAccount string = "estafette.io/letsencrypt-certificate-account"
 
 const annotationLetsEncryptCertificateState string = "estafette.io/letsencrypt-certificate-state"
 
+const environmentProduction string = "production"
+const environmentStaging string = "staging"
+const annotationLetsEncryptCertificateCopySkips string = "estafette.io/letsencrypt-certificate-copy-skips"
+const annotationLetsEncryptCertificateNextRenewal string = "estafette.io/letsencrypt-certificate-next-renewal"
+const annotationLetsEncryptCertificateDiagnostics string = "estafette.io/letsencrypt-certificate-diagnostics"
+const annotationLetsEncryptCertificateLastChangedBy string = "estafette.io/letsencrypt-certificate-last-changed-by"
+const annotationLetsEncryptCertificateSNIState string = "estafette.io/letsencrypt-certificate-sni-state"
+const annotationLetsEncryptCertificateRenewalsPausedUntil string = "estafette.io/letsencrypt-certificate-renewals-paused-until"
+const annotationLetsEncryptCertificateManagedConfigMap string = "estafette.io/letsencrypt-certificate-managed-config-map"
+
 // LetsEncryptCertificateState represents the state of the secret with respect to Let's Encrypt certificates
 type LetsEncryptCertificateState struct {
 	Enabled             string `json:"enabled"`
@@ -52,6 +102,49 @@ type LetsEncryptCertificateState struct {
 	UploadToCloudflare  bool   `json:"uploadToCloudflare"`
 	LastRenewed         string `json:"lastRenewed"`
 	LastAttempt         string `json:"lastAttempt"`
+	RateLimitedUntil    string `json:"rateLimitedUntil,omitempty"`
+	AccountEmail        string `json:"accountEmail,omitempty"`
+	Account             string `json:"account,omitempty"`
+	DataHash            string `json:"dataHash,omitempty"`
+	CloudflareZone      string `json:"cloudflareZone,omitempty"`
+	SeparateKeySecret   bool   `json:"separateKeySecret,omitempty"`
+	TargetSecret        string `json:"targetSecret,omitempty"`
+	Environment         string `json:"environment,omitempty"`
+	OutputFamilies      string `json:"outputFamilies,omitempty"`
+	ExpiresAfter        string `json:"expiresAfter,omitempty"`
+	ExpiredAction       string `json:"expiredAction,omitempty"`
+	Expired             bool   `json:"expired,omitempty"`
+	CopyToNamespaces    string `json:"copyToNamespaces,omitempty"`
+	RenewedBy           string `json:"renewedBy,omitempty"`
+	IssueStatus         string `json:"issueStatus,omitempty"`
+	IssueFailure        string `json:"issueFailure,omitempty"`
+	StoreStatus         string `json:"storeStatus,omitempty"`
+	CopyStatus          string `json:"copyStatus,omitempty"`
+	UploadStatus        string `json:"uploadStatus,omitempty"`
+	RenewDaysBefore     int    `json:"renewDaysBeforeExpiry,omitempty"`
+	RetryAfterMinutes   int    `json:"retryAfterMinutes,omitempty"`
+	NextRenewal         string `json:"nextRenewal,omitempty"`
+	CredentialsSecret   string `json:"credentialsSecret,omitempty"`
+	DNSProvider         string `json:"dnsProvider,omitempty"`
+	ACMEDirectoryURL    string `json:"acmeDirectoryUrl,omitempty"`
+	KeyType             string `json:"keyType,omitempty"`
+	DualKeyTypes        bool   `json:"dualKeyTypes,omitempty"`
+	LeafOnly            bool   `json:"leafOnly,omitempty"`
+
+	// the challenge record values this controller has presented and not cleaned up yet, so an owned-only cleanup can tell them apart from the records of other tools after a restart
+	ChallengeValues []string `json:"challengeValues,omitempty"`
+
+	// the custom certificates and certificate packs at cloudflare this controller has uploaded to, so it's known which objects it owns
+	UploadTargets []cloudflareUploadTarget `json:"uploadTargets,omitempty"`
+}
+
+// cloudflareUploadTarget is a custom certificate or certificate pack in a cloudflare zone the certificate has been uploaded to
+type cloudflareUploadTarget struct {
+	ZoneID       string `json:"zoneId"`
+	Zone         string `json:"zone,omitempty"`
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	LastUploaded string `json:"lastUploaded"`
 }
 
 var (
@@ -65,9 +158,161 @@ var (
 )
 
 var (
-	cfAPIKey          = kingpin.Flag("cloudflare-api-key", "The API key to connect to cloudflare.").Envar("CF_API_KEY").Required().String()
-	cfAPIEmail        = kingpin.Flag("cloudflare-api-email", "The API email address to connect to cloudflare.").Envar("CF_API_EMAIL").Required().String()
-	daysBeforeRenewal = kingpin.Flag("days-before-renewal", "Number of days after which to renew the certificate.").Default("60").OverrideDefaultFromEnvar("DAYS_BEFORE_RENEWAL").Int()
+	runCommand            = kingpin.Command("run", "Run the controller.").Default()
+	validateConfigCommand = kingpin.Command("validate-config", "Validate flags, account material, DNS provider credentials and RBAC permissions and exit.")
+
+	generateAccountCommand   = kingpin.Command("generate-account", "Generate an account key, register it with Let's Encrypt, write the account.json and account.key files or the account secret and exit.")
+	generateAccountEmail     = generateAccountCommand.Flag("email", "Email address to register the account with.").Required().String()
+	generateAccountKeyType   = generateAccountCommand.Flag("key-type", "Type of the account key: ec256, ec384, rsa2048 or rsa4096.").Default("ec256").Enum("ec256", "ec384", "rsa2048", "rsa4096")
+	generateAccountStaging   = generateAccountCommand.Flag("staging", "Register the account with the Let's Encrypt staging environment instead of production.").Bool()
+	generateAccountPath      = generateAccountCommand.Flag("output-path", "Directory to write account.json and account.key to.").Default(".").String()
+	generateAccountSecret    = generateAccountCommand.Flag("secret-name", "Create a secret with this name holding account.json and account.key instead of writing files; requires running in the cluster.").String()
+	generateAccountNamespace = generateAccountCommand.Flag("secret-namespace", "Namespace to create the account secret in; defaults to the namespace the command runs in.").String()
+
+	rolloverAccountKeyCommand   = kingpin.Command("rollover-account-key", "Replace the key of the account in an account secret with a new key at Let's Encrypt, store the new key in the secret and exit.")
+	rolloverAccountKeySecret    = rolloverAccountKeyCommand.Flag("secret-name", "Name of the secret holding account.json and account.key.").Required().String()
+	rolloverAccountKeyNamespace = rolloverAccountKeyCommand.Flag("secret-namespace", "Namespace of the account secret; defaults to the namespace the command runs in.").String()
+	rolloverAccountKeyType      = rolloverAccountKeyCommand.Flag("key-type", "Type of the new account key: ec256, ec384, rsa2048 or rsa4096.").Default("ec256").Enum("ec256", "ec384", "rsa2048", "rsa4096")
+	rolloverAccountKeyStaging   = rolloverAccountKeyCommand.Flag("staging", "The account is registered with the Let's Encrypt staging environment instead of production.").Bool()
+
+	cfAPIKey           = kingpin.Flag("cloudflare-api-key", "The API key to connect to cloudflare.").Envar("CF_API_KEY").String()
+	cfAPIEmail         = kingpin.Flag("cloudflare-api-email", "The API email address to connect to cloudflare.").Envar("CF_API_EMAIL").String()
+	cfAPIToken         = kingpin.Flag("cloudflare-api-token", "A scoped API token with Zone:Read and DNS:Edit permissions to connect to cloudflare, instead of the API key and email address.").Envar("CF_API_TOKEN").String()
+	accountPaths       = kingpin.Flag("account-paths", "Comma-separated list of directories holding an account.json and account.key; the first one is the default account.").Default("/account").Envar("ACCOUNT_PATHS").String()
+	daysBeforeRenewal  = kingpin.Flag("days-before-renewal", "Number of days after which to renew the certificate.").Default("60").OverrideDefaultFromEnvar("DAYS_BEFORE_RENEWAL").Int()
+	renewBeforeDays    = kingpin.Flag("renew-before-days", "Renew the certificate once less than this number of days of its validity remains, when that is earlier than after --days-before-renewal days; 0 disables it. The renew-days-before-expiry annotation overrides it per secret.").Default("30").Envar("RENEW_BEFORE_DAYS").Int()
+	retryAfterMinutes  = kingpin.Flag("retry-after-minutes", "Number of minutes a secret stays locked after an attempt to obtain a certificate, before it's attempted again. The retry-after-minutes annotation overrides it per secret.").Default("15").Envar("RETRY_AFTER_MINUTES").Int()
+	renewalInfoEnabled = kingpin.Flag("renewal-info", "Renew certificates within the window suggested by the ACME Renewal Information (ARI) endpoint of the CA, if it offers one, instead of going by their validity period; lets the CA trigger early renewals after incidents.").Default("true").Envar("RENEWAL_INFO").Bool()
+	renewBeforePercent = kingpin.Flag("renew-before-percent", "Renew the certificate once less than this percentage of its validity remains, instead of after --days-before-renewal days; 0 disables it.").Default("0").Envar("RENEW_BEFORE_PERCENT").Int()
+
+	keyType              = kingpin.Flag("key-type", "Type of the private keys of newly obtained certificates: rsa2048, rsa4096, rsa8192, ec256 or ec384. The key-type annotation overrides it per secret.").Default("rsa2048").Envar("KEY_TYPE").Enum(keyTypeNames...)
+	keyTypeReissuePeriod = kingpin.Flag("key-type-reissue-period", "Reissue certificates with another key type than --key-type, spread over this period after the controller starts, instead of waiting for their renewal; 0 keeps them until their renewal.").Default("0").Envar("KEY_TYPE_REISSUE_PERIOD").Duration()
+
+	accountSecret  = kingpin.Flag("account-secret", "Name of a secret in the controller's namespace holding account.json and account.key to use as the default account instead of the first of the account paths; it's created by registering an account with --account-email if it doesn't exist.").Envar("ACCOUNT_SECRET").String()
+	accountEmail   = kingpin.Flag("account-email", "Email address to register an account with on first run when the account secret doesn't exist.").Envar("ACCOUNT_EMAIL").String()
+	accountKeyType = kingpin.Flag("account-key-type", "Type of the key of the account registered for the account secret: ec256, ec384, rsa2048 or rsa4096.").Default("ec256").Envar("ACCOUNT_KEY_TYPE").Enum("ec256", "ec384", "rsa2048", "rsa4096")
+
+	accountSecrets = kingpin.Flag("account-secrets", "Comma-separated name=secret pairs of accounts, each read from its own secret in the controller's namespace holding account.json and account.key, for secrets to select with the account annotation.").Envar("ACCOUNT_SECRETS").String()
+
+	accountKeyPassphrase = kingpin.Flag("account-key-passphrase", "Passphrase to decrypt encrypted account.key files with, for account paths without an account.passphrase file.").Envar("ACCOUNT_KEY_PASSPHRASE").String()
+
+	backupBackend       = kingpin.Flag("backup-backend", "Backend to store an encrypted backup of issued certificates in, to restore from after a cluster rebuild; file, vault, s3 or gcs.").Default("").Envar("BACKUP_BACKEND").Enum("", "file", "vault", "s3", "gcs")
+	backupPath          = kingpin.Flag("backup-path", "Directory for the file backup backend, KV v2 data path (for example secret/data/letsencrypt) for the vault backup backend or object name prefix for the s3 and gcs backup backends.").Envar("BACKUP_PATH").String()
+	backupEncryptionKey = kingpin.Flag("backup-encryption-key", "Base64 encoded key of at least 32 bytes to encrypt backups with; required when --backup-backend is set.").Envar("BACKUP_ENCRYPTION_KEY").String()
+	backupVaultAddress  = kingpin.Flag("backup-vault-address", "Address of the Vault server for the vault backup backend.").Envar("VAULT_ADDR").String()
+	backupVaultToken    = kingpin.Flag("backup-vault-token", "Token to authenticate with Vault for the vault backup backend.").Envar("VAULT_TOKEN").String()
+	backupBucket        = kingpin.Flag("backup-bucket", "Bucket for the s3 and gcs backup backends.").Envar("BACKUP_BUCKET").String()
+	backupS3Endpoint    = kingpin.Flag("backup-s3-endpoint", "Endpoint of an S3 compatible store (for example https://minio.example.com) for the s3 backup backend; defaults to AWS S3 in the region set with AWS_REGION.").Envar("BACKUP_S3_ENDPOINT").String()
+
+	pendingRenewalsPath = kingpin.Flag("pending-renewals-path", "Directory (for example an emptyDir or persistent volume) to keep obtained certificates in until they're stored in their secret, so they're stored once the api server is available again instead of being obtained again after a restart.").Envar("PENDING_RENEWALS_PATH").String()
+
+	issuerChangePolicy = kingpin.Flag("issuer-change-policy", "What to do when a certificate has been issued by an intermediate the CA no longer issues from: ignore, warn only logs it, renew reissues the certificate early.").Default("warn").Envar("ISSUER_CHANGE_POLICY").Enum("ignore", "warn", "renew")
+	issuerChangeWindow = kingpin.Flag("issuer-change-window", "Issuers seen in newly obtained certificates within this window are considered active.").Default("168h").Envar("ISSUER_CHANGE_WINDOW").Duration()
+
+	acmeDirectoryURL        = kingpin.Flag("acme-directory-url", "URL of the ACME directory to obtain production certificates from.").Default(lego.LEDirectoryProduction).Envar("ACME_DIRECTORY_URL").String()
+	acmeStagingDirectoryURL = kingpin.Flag("acme-staging-directory-url", "URL of the ACME directory to obtain certificates from for secrets with the staging annotation and the canary.").Default(lego.LEDirectoryStaging).Envar("ACME_STAGING_DIRECTORY_URL").String()
+
+	acmeEABKeyID   = kingpin.Flag("acme-eab-key-id", "Key id for the external account binding required by CAs like ZeroSSL or Google Trust Services when registering the account with them.").Envar("ACME_EAB_KEY_ID").String()
+	acmeEABHMACKey = kingpin.Flag("acme-eab-hmac-key", "Base64url encoded hmac key for the external account binding with --acme-eab-key-id.").Envar("ACME_EAB_HMAC_KEY").String()
+
+	acmeProxies = kingpin.Flag("acme-proxies", "Comma-separated <account email or environment>=<proxy url> pairs to reach the CA through a specific (authenticated) proxy per account or for the production or staging environment, instead of the proxy from the environment variables.").Envar("ACME_PROXIES").String()
+
+	acceptTermsOfServiceUpdates = kingpin.Flag("accept-terms-of-service-updates", "Automatically agree to updated terms of service of the CA when it requires so, instead of failing all renewals.").Envar("ACCEPT_TERMS_OF_SERVICE_UPDATES").Bool()
+
+	secretSizePolicy      = kingpin.Flag("secret-size-policy", "What to do when a secret gets close to the size limit of secrets: warn only logs it, fail aborts the update, drop-json removes the ssl.json and tls.json data items.").Default("warn").Envar("SECRET_SIZE_POLICY").Enum("warn", "fail", "drop-json")
+	secretSizeWarnPercent = kingpin.Flag("secret-size-warn-percent", "Percentage of the size limit of secrets from which the secret size policy is applied.").Default("90").Envar("SECRET_SIZE_WARN_PERCENT").Int()
+
+	rollbackPause            = kingpin.Flag("rollback-pause", "How long to pause the renewals of a secret after rolling it back to its previous certificate with the rollback annotation or admin endpoint, while the issue is investigated.").Default("168h").Envar("ROLLBACK_PAUSE").Duration()
+	keepPreviousCertificates = kingpin.Flag("keep-previous-certificates", "Number of previous certificates and keys to keep in the tls-previous data items of each secret on renewal, for a quick rollback; overridden by the keep-previous annotation, at most 10.").Default("0").Envar("KEEP_PREVIOUS_CERTIFICATES").Int()
+
+	jitterDeviation   = kingpin.Flag("jitter-deviation", "Maximum deviation as a fraction of the sleep time of the main loops, to spread their iterations; 0.25 sleeps the poller between 675 and 1125 seconds.").Default("0.25").Envar("JITTER_DEVIATION").Float64()
+	loopSleepStrategy = kingpin.Flag("loop-sleep-strategy", "How the main loops back off after failed iterations: fixed keeps the same sleep time, exponential doubles it per consecutive failure, decorrelated picks a random sleep time between the normal one and three times the previous one.").Default("fixed").Envar("LOOP_SLEEP_STRATEGY").Enum("fixed", "exponential", "decorrelated")
+	loopMaxSleep      = kingpin.Flag("loop-max-sleep", "Maximum sleep time of the main loops when backing off with the exponential or decorrelated loop sleep strategy.").Default("1h").Envar("LOOP_MAX_SLEEP").Duration()
+
+	errorLogInterval = kingpin.Flag("error-log-interval", "Log an error that's identical to the last error of the same secret at most once per this interval; set to 0 to log every error.").Default("1h").Envar("ERROR_LOG_INTERVAL").Duration()
+
+	staleLockTimeout = kingpin.Flag("stale-lock-timeout", "Age after which a renewal lock not set by this controller process is released; keep it above the time an order takes, so the lock of an order running in another process isn't released. Set to 0 to use the retry delay of the secret, so a lock is only released once it has expired.").Default("0").Envar("STALE_LOCK_TIMEOUT").Duration()
+
+	storageFilePath = kingpin.Flag("storage-file-path", "Directory to store certificates in for secrets with the file storage annotation, in a <namespace>/<name> subdirectory.").Envar("STORAGE_FILE_PATH").String()
+
+	pruneOutputFamilies = kingpin.Flag("prune-output-families", "Remove the data items of output families that are no longer written when renewing a certificate, instead of leaving the outdated certificates in the secret.").Envar("PRUNE_OUTPUT_FAMILIES").Bool()
+
+	immutableSecretPolicy = kingpin.Flag("immutable-secret-policy", "What to do when a secret to write certificates to is immutable: event fails the update with a warning event, recreate deletes the secret and creates it again with the new data.").Default("event").Envar("IMMUTABLE_SECRET_POLICY").Enum("event", "recreate")
+
+	tamperPolicy = kingpin.Flag("tamper-policy", "What to do when the certificate data of a managed secret has been replaced out-of-band: warn only emits an event, restore reissues the certificate, adopt accepts the new data as managed data.").Default("warn").Envar("TAMPER_POLICY").Enum("warn", "restore", "adopt")
+
+	stateCacheTTL       = kingpin.Flag("state-cache-ttl", "Maximum time the poller skips re-evaluating an unchanged secret that didn't need any action; set to 0 to evaluate every secret on each poll.").Default("1h").Envar("STATE_CACHE_TTL").Duration()
+	secretEventDebounce = kingpin.Flag("secret-event-debounce", "Time to wait for more events for the same secret before evaluating it, starting over with every event; set to 0 to evaluate every event immediately.").Default("5s").Envar("SECRET_EVENT_DEBOUNCE").Duration()
+	secretEventMaxWait  = kingpin.Flag("secret-event-max-wait", "Maximum time to wait for more events for the same secret, after which it's evaluated even if more keep coming in.").Default("1m").Envar("SECRET_EVENT_MAX_WAIT").Duration()
+
+	secretWorkers        = kingpin.Flag("secret-workers", "Number of secrets evaluated in parallel from the queue of watched and polled secrets.").Default("1").Envar("SECRET_WORKERS").Int()
+	secretMaxRetries     = kingpin.Flag("secret-max-retries", "Number of times the evaluation of a secret that failed is retried with backoff, before waiting for its next event or poll.").Default("5").Envar("SECRET_MAX_RETRIES").Int()
+	secretRetryBaseDelay = kingpin.Flag("secret-retry-base-delay", "Time to wait before the first retry of a failed secret evaluation; it doubles for each following retry.").Default("30s").Envar("SECRET_RETRY_BASE_DELAY").Duration()
+	secretRetryMaxDelay  = kingpin.Flag("secret-retry-max-delay", "Maximum time to wait before retrying a failed secret evaluation.").Default("15m").Envar("SECRET_RETRY_MAX_DELAY").Duration()
+
+	shardCount = kingpin.Flag("shard-count", "Number of replicas to shard the secrets across; each secret is handled by a single replica, picked by a consistent hash of its namespace and name.").Default("1").Envar("SHARD_COUNT").Int()
+	shardIndex = kingpin.Flag("shard-index", "Index of this replica among the shards, from 0 up to the shard count; -1 derives it from the ordinal at the end of the pod name of a stateful set.").Default("-1").Envar("SHARD_INDEX").Int()
+
+	namespaceEventDebounce = kingpin.Flag("namespace-event-debounce", "Time to wait for more created or deleted namespaces before handling them together with a single listing of all secrets.").Default("5s").Envar("NAMESPACE_EVENT_DEBOUNCE").Duration()
+	namespaceEventMaxWait  = kingpin.Flag("namespace-event-max-wait", "Maximum time to wait for more created or deleted namespaces, after which the namespaces seen so far are handled even if more keep coming in.").Default("1m").Envar("NAMESPACE_EVENT_MAX_WAIT").Duration()
+
+	successEventType = kingpin.Flag("success-event-type", "Type of the events emitted for successful and other routine actions; Normal, Warning or none to not emit them at all, for clusters where event rate limits would drop important events.").Default("Normal").Envar("SUCCESS_EVENT_TYPE").Enum("Normal", "Warning", "none")
+	failureEventType = kingpin.Flag("failure-event-type", "Type of the events emitted for failed actions and other problems; Warning, Normal or none to not emit them at all.").Default("Warning").Envar("FAILURE_EVENT_TYPE").Enum("Warning", "Normal", "none")
+	eventSeries      = kingpin.Flag("event-series", "Record repeated events as an event series with the last observed time, instead of only increasing their count.").Envar("EVENT_SERIES").Bool()
+
+	cloudflareRefreshWindow = kingpin.Flag("cloudflare-refresh-window", "Re-upload the stored certificate if the custom certificate at cloudflare expires within this window and differs from it.").Default("720h").Envar("CLOUDFLARE_REFRESH_WINDOW").Duration()
+
+	canaryHostname = kingpin.Flag("canary-hostname", "Hostname to periodically obtain a certificate for, to prove obtaining certificates still works when no renewals are due; empty disables the canary.").Envar("CANARY_HOSTNAME").String()
+	canaryInterval = kingpin.Flag("canary-interval", "Time between obtaining certificates for the canary hostname.").Default("24h").Envar("CANARY_INTERVAL").Duration()
+	canaryStaging  = kingpin.Flag("canary-staging", "Obtain the canary certificate from the Let's Encrypt staging environment, to stay clear of production rate limits.").Default("true").Envar("CANARY_STAGING").Bool()
+
+	cloudflareCertificateAPI = kingpin.Flag("cloudflare-certificate-api", "Cloudflare api used for upload-to-cloudflare: custom-certificates uploads the certificate, certificate-packs orders an advanced certificate pack issued by Let's Encrypt for zones on the newer certificate packs api.").Default("custom-certificates").Envar("CLOUDFLARE_CERTIFICATE_API").Enum("custom-certificates", "certificate-packs")
+
+	adminEndpoints = kingpin.Flag("admin-endpoints", "Serve the /pause and /resume admin endpoints on the metrics port; anyone able to reach the port can pause issuance.").Envar("ADMIN_ENDPOINTS").Bool()
+	pauseConfigMap = kingpin.Flag("pause-configmap", "Name of a config map in the controller's namespace whose paused key set to true pauses issuance; empty disables the config map switch.").Envar("PAUSE_CONFIGMAP").String()
+
+	dnsProvider = kingpin.Flag("dns-provider", "Provider to solve DNS-01 challenges with, unless a secret selects another one with its dns-provider annotation: cloudflare, route53 with the credentials from the AWS_* environment variables or an IAM role, clouddns with a mounted service account key or workload identity, azure with the azure-* flags or the managed identity, or rfc2136 to send dynamic updates to the nameserver of a (delegated) challenge zone set with the rfc2136-* flags.").Default("cloudflare").Envar("DNS_PROVIDER").Enum(dnsProviders...)
+
+	reportInterval     = kingpin.Flag("report-interval", "Time between generating the per-namespace tenant reports; also the period renewals are counted in.").Default("24h").Envar("REPORT_INTERVAL").Duration()
+	reportExpiryWindow = kingpin.Flag("report-expiry-window", "Certificates expiring within this window are listed as upcoming expirations in the tenant reports.").Default("720h").Envar("REPORT_EXPIRY_WINDOW").Duration()
+	caBundleConfigMap  = kingpin.Flag("ca-bundle-configmap", "Name of a config map in the controller's namespace to keep the deduplicated issuer chains of all managed certificates in as ca.crt; empty disables it.").Envar("CA_BUNDLE_CONFIGMAP").String()
+	caBundleNamespaces = kingpin.Flag("ca-bundle-namespaces-matching", "Regular expression for the namespaces to keep the ca bundle config map in as well, so workloads in those namespaces can mount it; empty keeps it in the controller's namespace only.").Envar("CA_BUNDLE_NAMESPACES_MATCHING").String()
+	reportConfigMap    = kingpin.Flag("report-configmap", "Name of the config map to write the tenant report to in each namespace with managed certificates; empty disables writing reports to config maps.").Envar("REPORT_CONFIGMAP").String()
+	reportWebhookURL   = kingpin.Flag("report-webhook-url", "Url to post the tenant report of each namespace to as json; empty disables pushing reports.").Envar("REPORT_WEBHOOK_URL").String()
+
+	expirySweepInterval = kingpin.Flag("expiry-sweep-interval", "Time between sweeps of all managed certificates for ones that haven't been renewed 30, 14, 7 or 1 days before they expire, raising escalating warning events and notifications; 0 disables the sweep.").Default("24h").Envar("EXPIRY_SWEEP_INTERVAL").Duration()
+
+	azureSubscriptionID = kingpin.Flag("azure-subscription-id", "Subscription of the azure dns zones; detected from the instance metadata if empty.").Envar("AZURE_SUBSCRIPTION_ID").String()
+	azureResourceGroup  = kingpin.Flag("azure-resource-group", "Resource group of the azure dns zones.").Envar("AZURE_RESOURCE_GROUP").String()
+	azureTenantID       = kingpin.Flag("azure-tenant-id", "Tenant of the service principal to manage azure dns zones with.").Envar("AZURE_TENANT_ID").String()
+	azureClientID       = kingpin.Flag("azure-client-id", "Client id of the service principal to manage azure dns zones with; the managed identity is used if empty.").Envar("AZURE_CLIENT_ID").String()
+	azureClientSecret   = kingpin.Flag("azure-client-secret", "Client secret of the service principal to manage azure dns zones with.").Envar("AZURE_CLIENT_SECRET").String()
+
+	rfc2136Nameserver    = kingpin.Flag("rfc2136-nameserver", "Host and optional port of the nameserver to send dynamic updates for the challenge records to, for the rfc2136 dns provider.").Envar("RFC2136_NAMESERVER").String()
+	rfc2136TSIGKey       = kingpin.Flag("rfc2136-tsig-key", "Name of the tsig key to sign dynamic updates with; updates are unsigned if empty.").Envar("RFC2136_TSIG_KEY").String()
+	rfc2136TSIGSecret    = kingpin.Flag("rfc2136-tsig-secret", "Base64 encoded secret of the tsig key.").Envar("RFC2136_TSIG_SECRET").String()
+	rfc2136TSIGAlgorithm = kingpin.Flag("rfc2136-tsig-algorithm", "Algorithm of the tsig key, for example hmac-sha256 or hmac-sha512.").Default("hmac-sha256").Envar("RFC2136_TSIG_ALGORITHM").String()
+
+	dnsFollowCNAME = kingpin.Flag("dns-follow-cname", "Resolve a CNAME on the _acme-challenge record name and write the challenge record to its target, for domains that delegate their challenges to another zone.").Envar("DNS_FOLLOW_CNAME").Bool()
+
+	acmeDiagnosticsTarget = kingpin.Flag("acme-diagnostics", "Where to record the urls and challenge records of a failed order, to inspect it at the CA: none, annotation to store them in the diagnostics annotation of the secret, or event.").Default("none").Envar("ACME_DIAGNOSTICS").Enum("none", "annotation", "event")
+
+	certificateResources        = kingpin.Flag("certificate-resources", "Reconcile LetsEncryptCertificate custom resources into annotated secrets and report their status; requires the crd from the helm chart.").Envar("CERTIFICATE_RESOURCES").Bool()
+	ingresses                   = kingpin.Flag("ingresses", "Create and maintain the secrets named in the tls section of ingresses annotated with estafette.io/letsencrypt-certificate: \"true\", with the hostnames from the tls section.").Envar("INGRESSES").Bool()
+	ingressCleanupPolicy        = kingpin.Flag("ingress-cleanup-policy", "What to do with the secrets created for an ingress once their tls entry is removed or the ingress loses the estafette.io/letsencrypt-certificate annotation: orphan removes the ingress as their owner, so they're kept and renewed as standalone annotated secrets, delete removes them.").Default("orphan").Envar("INGRESS_CLEANUP_POLICY").Enum("orphan", "delete")
+	certificateResourceInterval = kingpin.Flag("certificate-resource-interval", "Time between reconciling all LetsEncryptCertificate custom resources.").Default("30s").Envar("CERTIFICATE_RESOURCE_INTERVAL").Duration()
+	clusterConfigurationName    = kingpin.Flag("cluster-configuration", "Name of the LetsEncryptClusterConfiguration custom resource holding the defaults for all secrets, overriding the equivalent flags; requires the crd from the helm chart, empty disables it.").Envar("CLUSTER_CONFIGURATION").String()
+
+	challengeType   = kingpin.Flag("challenge-type", "Type of ACME challenge to validate the hostnames with: dns-01 through the dns provider, or http-01 through the shared /.well-known/acme-challenge ingress path routed to the controller. The challenge-type annotation overrides it per secret.").Default("dns-01").Envar("CHALLENGE_TYPE").Enum("dns-01", "http-01")
+	http01Address   = kingpin.Flag("http01-address", "Address to serve the responses to HTTP-01 challenges on, for the shared /.well-known/acme-challenge ingress path; empty disables it.").Default(":8089").Envar("HTTP01_ADDRESS").String()
+	http01ConfigMap = kingpin.Flag("http01-configmap", "Name of the config map in the controller's namespace to share the key authorizations of pending HTTP-01 challenges between the replicas through, since the service routes the validation requests to any of them; empty only serves the challenges of the replica's own orders.").Default("estafette-letsencrypt-certificate-http01").Envar("HTTP01_CONFIGMAP").String()
+
+	dnsDisablePropagationCheck = kingpin.Flag("dns-disable-propagation-check", "Skip checking whether the DNS-01 challenge record has propagated to the authoritative nameservers.").Envar("DNS_DISABLE_PROPAGATION_CHECK").Bool()
+	dnsSequential              = kingpin.Flag("dns-sequential", "Solve DNS-01 challenges one by one instead of in parallel, for DNS providers with strict API limits.").Envar("DNS_SEQUENTIAL").Bool()
+	dnsCleanupPolicy           = kingpin.Flag("dns-cleanup-policy", "Which challenge records to remove after an order: all removes them with the DNS provider as is, owned-only leaves a record in place when its name holds TXT values that haven't been created by this controller, for zones shared with other tools.").Default("all").Envar("DNS_CLEANUP_POLICY").Enum("all", "owned-only")
+	dnsAPIBudget               = kingpin.Flag("dns-api-budget", "Comma-separated <provider>=<calls> pairs with the number of api calls to a DNS provider allowed per --dns-api-budget-window, for example cloudflare=1000; challenges beyond the budget wait for room instead of running into the quota of the provider.").Envar("DNS_API_BUDGET").String()
+	dnsAPIBudgetWindow         = kingpin.Flag("dns-api-budget-window", "Window in which the api calls to a DNS provider are counted against --dns-api-budget.").Default("5m").Envar("DNS_API_BUDGET_WINDOW").Duration()
+	dnsSequentialInterval      = kingpin.Flag("dns-sequential-interval", "Time to wait between sequentially solved DNS-01 challenges.").Default("60s").Envar("DNS_SEQUENTIAL_INTERVAL").Duration()
 
 	// seed random number
 	r = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -78,9 +323,166 @@ var (
 			Name: "estafette_letsencrypt_certificate_totals",
 			Help: "Number of generated certificates with LetsEncrypt.",
 		},
-		[]string{"namespace", "status", "initiator", "type"},
+		[]string{"namespace", "status", "initiator", "type", "wildcard"},
+	)
+
+	// define prometheus counter for orders placed at the CA, since wildcard and regular certificates are budgeted differently against rate limits
+	certificateIssuedTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_issued_totals",
+			Help: "Number of certificates obtained from the CA, by environment and whether they contain wildcard hostnames.",
+		},
+		[]string{"environment", "wildcard"},
+	)
+
+	// define prometheus counter for the alerts of the expiry sweep, which should stay at zero while renewals work
+	expirySweepAlertTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_expiry_sweep_alert_totals",
+			Help: "Number of alerts raised by the expiry sweep for certificates that weren't renewed before crossing a threshold of days before expiry.",
+		},
+		[]string{"namespace", "threshold"},
+	)
+
+	// define prometheus counter for copies to other namespaces
+	certificateCopyTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_copy_totals",
+			Help: "Number of copies of certificate secrets to other namespaces, by what triggered the copy: renewal, drift-repair or ns-watcher.",
+		},
+		[]string{"namespace", "status", "initiator"},
+	)
+
+	// define prometheus gauge with the build information of the controller
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "estafette_letsencrypt_certificate_build_info",
+			Help: "Build information of the controller, always 1.",
+		},
+		[]string{"version", "revision", "branch", "goVersion"},
+	)
+
+	// define prometheus counter for secrets that didn't need any action
+	certificateSkippedTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_skipped_totals",
+			Help: "Number of evaluations of secrets that didn't need any action, by reason.",
+		},
+		[]string{"initiator", "reason"},
 	)
 
+	// define prometheus counter and gauge for the canary certificate
+	canaryTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_canary_totals",
+			Help: "Number of attempts to obtain the canary certificate.",
+		},
+		[]string{"status"},
+	)
+	canaryLastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "estafette_letsencrypt_certificate_canary_last_success_timestamp_seconds",
+			Help: "Unix time at which the canary certificate has last been obtained successfully.",
+		},
+	)
+
+	// define prometheus gauge for whether issuance is paused
+	controllerPaused = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "estafette_letsencrypt_certificate_paused",
+			Help: "Whether issuance is paused through the admin endpoints or the pause config map.",
+		},
+	)
+
+	// define prometheus gauge for hostnames managed by more than one secret
+	hostnameConflicts = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "estafette_letsencrypt_certificate_hostname_conflicts",
+			Help: "Number of hostnames requested by more than one managed secret.",
+		},
+	)
+
+	// define prometheus counter for events that couldn't be posted
+	eventFailureTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_event_failure_totals",
+			Help: "Number of events that failed to be created or updated, for example due to missing permissions in the namespace.",
+		},
+		[]string{"namespace", "reason"},
+	)
+
+	// define prometheus counter for uploads to cloudflare
+	cloudflareUploadTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_cloudflare_upload_totals",
+			Help: "Number of certificate uploads to Cloudflare, with the reason for failed uploads.",
+		},
+		[]string{"status", "reason"},
+	)
+
+	// define prometheus counters and gauge for the api usage of the DNS providers
+	dnsAPICallTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_dns_api_call_totals",
+			Help: "Number of challenge record calls made to the api of each DNS provider.",
+		},
+		[]string{"provider", "operation"},
+	)
+	dnsAPIBudgetDelay = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_letsencrypt_certificate_dns_api_budget_delay_seconds_total",
+			Help: "Time challenge record calls have waited for room in the api budget of each DNS provider.",
+		},
+		[]string{"provider"},
+	)
+	dnsAPIBudgetUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "estafette_letsencrypt_certificate_dns_api_budget_used",
+			Help: "Number of challenge record calls to each DNS provider within the current budget window.",
+		},
+		[]string{"provider"},
+	)
+
+	// define prometheus histogram of the time from receiving a secret to having processed it
+	secretProcessingLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "estafette_letsencrypt_certificate_processing_latency_seconds",
+			Help:    "Time from receiving the event of a secret (watcher) or starting the listing (poller) until the secret has been processed, including debouncing, queueing and retries.",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 900, 1800, 3600},
+		},
+		[]string{"initiator"},
+	)
+
+	// define prometheus histogram of the remaining validity of all managed certificates
+	certificateExpiry = newExpiryCollector()
+
+	// define prometheus gauge of the planned renewal time per managed certificate
+	certificateRenewals = newRenewalCollector()
+
+	// secrets evaluated by the poller that can't need action yet
+	secretStates = newSecretStateCache()
+
+	// renewal locks set by this process, to detect stale locks
+	secretLocks = newLockTracker()
+
+	// the secrets handled by this replica, all of them unless sharding is enabled
+	replicaShard = secretShard{index: 0, count: 1}
+
+	// in-progress acme orders per hostname set, so the same domains are never ordered simultaneously
+	certificateFlights = newCertificateSingleFlight()
+
+	// issuers seen in newly obtained certificates, to detect chain changes at the CA
+	issuers *issuerTracker
+
+	// api calls to the DNS providers, spaced out within their budgets
+	dnsBudgets = newDNSBudget(map[string]int{}, 5*time.Minute)
+
+	// switch to pause issuance for the whole controller
+	controllerPause = newPauseSwitch()
+
+	// last error logged per secret, to keep chronically failing secrets from flooding the logs
+	secretErrors *errorLogSampler
+
 	// set controller Start time to watch only for newly created resources
 	controllerStartTime time.Time = time.Now().Local()
 )
@@ -88,17 +490,85 @@ var (
 func init() {
 	// metrics have to be registered to be exposed
 	prometheus.MustRegister(certificateTotals)
+	prometheus.MustRegister(certificateIssuedTotals)
+	prometheus.MustRegister(expirySweepAlertTotals)
+	prometheus.MustRegister(secretProcessingLatency)
+	prometheus.MustRegister(certificateExpiry)
+	prometheus.MustRegister(certificateRenewals)
+	prometheus.MustRegister(certificateCopyTotals)
+	prometheus.MustRegister(cloudflareUploadTotals)
+	prometheus.MustRegister(certificateSkippedTotals)
+	prometheus.MustRegister(buildInfo)
+	prometheus.MustRegister(eventFailureTotals)
+	prometheus.MustRegister(canaryTotals)
+	prometheus.MustRegister(canaryLastSuccess)
+	prometheus.MustRegister(hostnameConflicts)
+	prometheus.MustRegister(controllerPaused)
+	prometheus.MustRegister(dnsAPICallTotals)
+	prometheus.MustRegister(dnsAPIBudgetDelay)
+	prometheus.MustRegister(dnsAPIBudgetUsed)
 }
 
 func main() {
 
 	// parse command line parameters
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	issuers = newIssuerTracker(*issuerChangeWindow)
+
+	// lego follows cnames for the challenge record name unless this environment variable is set
+	if !*dnsFollowCNAME {
+		os.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	}
+	secretErrors = newErrorLogSampler(*errorLogInterval)
 
 	ctx := context.Background()
 	// init log format from envvar ESTAFETTE_LOG_FORMAT
 	foundation.InitLoggingFromEnv(foundation.NewApplicationInfo(appgroup, app, version, branch, revision, buildDate))
 
+	if command == validateConfigCommand.FullCommand() {
+		if !validateConfig(ctx) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == generateAccountCommand.FullCommand() {
+		if err := generateAccount(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Generating account failed")
+		}
+		return
+	}
+
+	if command == rolloverAccountKeyCommand.FullCommand() {
+		if err := rolloverAccountKey(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Rolling over account key failed")
+		}
+		return
+	}
+
+	// refuse to start with flag values the controller can't work with, the same way validate-config reports them
+	if err := validateFlags(); err != nil {
+		log.Fatal().Err(err).Msg("Validating flags failed")
+	}
+
+	shard, err := newSecretShard(*shardIndex, *shardCount, os.Getenv("HOSTNAME"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Determining the shard of this replica failed")
+	}
+	replicaShard = shard
+
+	dnsBudgetLimits, err := parseDNSBudgetLimits(*dnsAPIBudget)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Parsing the DNS api budgets failed")
+	}
+	dnsBudgets = newDNSBudget(dnsBudgetLimits, *dnsAPIBudgetWindow)
+	if *shardCount > 1 {
+		log.Info().Msgf("Handling shard %v out of %v shards", replicaShard.index, replicaShard.count)
+	}
+
+	buildInfo.With(prometheus.Labels{"version": version, "revision": revision, "branch": branch, "goVersion": goVersion}).Set(1)
+
 	// init /liveness endpoint
 	foundation.InitLiveness()
 
@@ -113,6 +583,14 @@ func main() {
 		log.Fatal().Err(err)
 	}
 
+	// register an account on first run and keep it in the account secret, instead of requiring mounted account files
+	if *accountSecret != "" {
+		err = ensureAccountSecret(ctx, kubeClientset, getAccountSecretNamespace(), *accountSecret, *accountEmail)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Ensuring the account secret failed")
+		}
+	}
+
 	// create the shared informer factory and use the client to connect to Kubernetes API
 	factory := informers.NewSharedInformerFactory(kubeClientset, 0)
 
@@ -123,102 +601,99 @@ func main() {
 	// handle kubernetes API crashes
 	defer k8sruntime.HandleCrash()
 
-	foundation.InitMetrics()
-
-	gracefulShutdown, waitGroup := foundation.InitGracefulShutdownHandling()
+	// serve the inventory of managed certificates next to the metrics
+	http.HandleFunc("/inventory", newInventoryHandler(ctx, kubeClientset))
+	http.HandleFunc("/validate-annotations", validateAnnotationsHandler)
+	if *adminEndpoints {
+		http.Handle("/pause", controllerPause)
+		http.Handle("/resume", controllerPause)
+		http.HandleFunc("/rollback", newRollbackHandler(ctx, kubeClientset))
+	}
 
-	// watch secrets for all namespaces
-	go watchSecrets(ctx, waitGroup, kubeClientset)
+	foundation.InitMetrics()
 
-	go listSecrets(ctx, waitGroup, kubeClientset)
+	// serve the responses to HTTP-01 challenges for the shared acme challenge ingress path
+	if *http01ConfigMap != "" {
+		http01Challenges.shared = &configMapKeyAuthorizations{kubeClientset: kubeClientset, name: *http01ConfigMap, namespace: getCurrentNamespace()}
+	}
+	if *http01Address != "" {
+		go serveHTTP01Challenges(*http01Address)
+	}
 
-	// watch namespaces
-	watchNamespaces(ctx, waitGroup, kubeClientset, factory, stopper)
+	// fetch renewal information through the same lego client and proxy as the certificates are obtained with
+	renewalInfos.newGetter = newLegoRenewalInfoGetter(ctx, kubeClientset)
 
-	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup)
-}
+	gracefulShutdown, waitGroup := foundation.InitGracefulShutdownHandling()
 
-func watchSecrets(ctx context.Context, waitGroup *sync.WaitGroup, kubeClientset *kubernetes.Clientset) {
-	// loop indefinitely
-	for {
-		log.Info().Msg("Watching secrets for all namespaces...")
-		timeoutSeconds := int64(300)
+	// watch and periodically poll secrets for all namespaces, evaluating them from a rate-limited queue
+	err = newSecretController(kubeClientset, factory).Run(ctx, waitGroup, stopper, *secretWorkers)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Starting the secret controller failed")
+	}
 
-		watcher, err := kubeClientset.CoreV1().Secrets("").Watch(ctx, metav1.ListOptions{
-			TimeoutSeconds: &timeoutSeconds,
-		})
+	// pause issuance while the pause config map says so
+	if *pauseConfigMap != "" {
+		go watchPauseConfigMap(ctx, kubeClientset, *pauseConfigMap)
+	}
 
+	// apply the defaults of the LetsEncryptClusterConfiguration custom resource
+	if *clusterConfigurationName != "" {
+		dynamicClient, err := dynamic.NewForConfig(kubeClientConfig)
 		if err != nil {
-			log.Error().Err(err).Msg("WatchSecrets call failed")
-		} else {
-			// loop indefinitely, unless it errors
-			for {
-				event, ok := <-watcher.ResultChan()
-				if !ok {
-					log.Warn().Msg("Watcher for secrets is closed")
-					break
-				}
-
-				if event.Type == watch.Added || event.Type == watch.Modified {
-					secret, ok := event.Object.(*v1.Secret)
-					if !ok {
-						log.Warn().Msg("Watcher for secrets returns event object of incorrect type")
-						break
-					}
-					waitGroup.Add(1)
-					status, err := processSecret(ctx, kubeClientset, secret, fmt.Sprintf("watcher:%v", event.Type))
-					certificateTotals.With(prometheus.Labels{"namespace": secret.Namespace, "status": status, "initiator": "watcher", "type": "secret"}).Inc()
-					waitGroup.Done()
-
-					if err != nil {
-						log.Error().Err(err).Msgf("Processing secret %v.%v failed", secret.Name, secret.Namespace)
-						continue
-					}
-				}
-			}
+			log.Fatal().Err(err).Msg("Creating dynamic client failed")
 		}
+		go watchClusterConfiguration(ctx, dynamicClient, *clusterConfigurationName)
+	}
 
-		// sleep random time between 22 and 37 seconds
-		sleepTime := applyJitter(30)
-		log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-		time.Sleep(time.Duration(sleepTime) * time.Second)
+	// reconcile the LetsEncryptCertificate custom resources alongside the annotated secrets
+	if *certificateResources {
+		dynamicClient, err := dynamic.NewForConfig(kubeClientConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating dynamic client failed")
+		}
+		go watchCertificateResources(ctx, kubeClientset, dynamicClient)
 	}
-}
 
-func listSecrets(ctx context.Context, waitGroup *sync.WaitGroup, kubeClientset *kubernetes.Clientset) {
-	// loop indefinitely
-	for {
-		// get secrets for all namespaces
-		log.Info().Msg("Listing secrets for all namespaces...")
-		secrets, err := kubeClientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+	// create and maintain the secrets for the tls section of annotated ingresses
+	if *ingresses {
+		err = newIngressController(kubeClientset, factory).Run(ctx, waitGroup, stopper)
 		if err != nil {
-			log.Error().Err(err).Msg("ListSecrets call failed")
+			log.Fatal().Err(err).Msg("Starting the ingress controller failed")
 		}
-		log.Info().Msgf("Cluster has %v secrets", len(secrets.Items))
+	}
 
-		// loop all secrets
-		for _, secret := range secrets.Items {
-			waitGroup.Add(1)
-			status, err := processSecret(ctx, kubeClientset, &secret, "poller")
-			certificateTotals.With(prometheus.Labels{"namespace": secret.Namespace, "status": status, "initiator": "poller", "type": "secret"}).Inc()
-			waitGroup.Done()
+	// periodically share certificate health with the tenants of each namespace
+	if (*reportConfigMap != "" || *reportWebhookURL != "") && replicaShard.IsFirst() {
+		go runReports(ctx, kubeClientset)
+	}
 
-			if err != nil {
-				log.Error().Err(err).Msgf("Processing secret %v.%v failed", secret.Name, secret.Namespace)
-				continue
-			}
-		}
+	// periodically check for certificates that haven't been renewed in time, as a safety net for wedged renewals
+	if *expirySweepInterval > 0 && replicaShard.IsFirst() {
+		go runExpirySweep(ctx, kubeClientset)
+	}
 
-		// sleep random time around 900 seconds
-		sleepTime := applyJitter(900)
-		log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-		time.Sleep(time.Duration(sleepTime) * time.Second)
+	// periodically obtain a certificate for the canary hostname
+	if *canaryHostname != "" && replicaShard.IsFirst() {
+		go runCanary(ctx, waitGroup, kubeClientset)
 	}
+
+	// watch namespaces
+	watchNamespaces(ctx, waitGroup, kubeClientset, factory, stopper)
+
+	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup)
 }
 
 func watchNamespaces(ctx context.Context, waitGroup *sync.WaitGroup, kubeClientset *kubernetes.Clientset, factory informers.SharedInformerFactory, stopper chan struct{}) {
 	log.Info().Msg("Watching for new namespaces...")
 
+	// coalesce namespace events, so a burst of created or deleted namespaces is handled with a single listing of all secrets, without a steady stream of them delaying copies indefinitely
+	namespaceEventDebouncer := newDebouncer(*namespaceEventDebounce, *namespaceEventMaxWait)
+	namespaces := newNamespaceBatch()
+	processNamespaces := func() {
+		added, deleted := namespaces.Take()
+		processNamespaceBatch(ctx, waitGroup, kubeClientset, added, deleted)
+	}
+
 	namespacesInformer := factory.Core().V1().Namespaces().Informer()
 	namespacesInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -230,48 +705,91 @@ func watchNamespaces(ctx context.Context, waitGroup *sync.WaitGroup, kubeClients
 			// compare CreationTimestamp and controllerStartTime and act only on latest events
 			isNewNamespace := namespace.CreationTimestamp.Sub(controllerStartTime).Seconds() > 0
 			if isNewNamespace {
-
-				log.Info().Msg("Listing secrets with 'copyToAllNamespaces' for all namespaces...")
-
-				secrets, err := kubeClientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
-				if err != nil {
-					log.Error().Err(err).Msgf("[%v] ListSecrets call failed", "ns-watcher:ADDED")
-				} else {
-					// loop all secrets
-					for _, secret := range secrets.Items {
-						copyToAllNamespacesValue, ok := secret.Annotations[annotationLetsEncryptCertificateCopyToAllNamespaces]
-						if ok {
-							shouldCopyToAllNamespaces, err := strconv.ParseBool(copyToAllNamespacesValue)
-							if err != nil {
-								log.Error().Err(err)
-								continue
-							}
-							if shouldCopyToAllNamespaces {
-								waitGroup.Add(1)
-								err = copySecretToNamespace(ctx, kubeClientset, &secret, namespace, "ns-watcher:ADDED")
-								waitGroup.Done()
-
-								if err != nil {
-									log.Error().Err(err)
-									continue
-								}
-							}
-						}
-					}
+				namespaces.Add(namespace)
+				namespaceEventDebouncer.Debounce("namespaces", processNamespaces)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			namespace, ok := obj.(*v1.Namespace)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					log.Warn().Msg("Watcher for namespaces returns event object of incorrect type")
+					return
+				}
+				namespace, ok = tombstone.Obj.(*v1.Namespace)
+				if !ok {
+					log.Warn().Msg("Watcher for namespaces returns event object of incorrect type")
+					return
 				}
 			}
-
+			namespaces.Delete(namespace.Name)
+			namespaceEventDebouncer.Debounce("namespaces", processNamespaces)
 		},
 	})
 
 	go namespacesInformer.Run(stopper)
 }
 
-func applyJitter(input int) (output int) {
+// processNamespaceBatch copies secrets to the created namespaces and cleans up the copy skips recorded for the deleted namespaces
+func processNamespaceBatch(ctx context.Context, waitGroup *sync.WaitGroup, kubeClientset *kubernetes.Clientset, added []*v1.Namespace, deleted []string) {
+	if len(added) == 0 && len(deleted) == 0 {
+		return
+	}
+
+	log.Info().Msgf("Listing secrets for all namespaces to handle %v created and %v deleted namespaces...", len(added), len(deleted))
+
+	secrets, err := kubeClientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] ListSecrets call failed", "ns-watcher")
+		return
+	}
+
+	// loop all secrets
+	for _, secret := range secrets.Items {
+		// secrets of other shards are copied by their own replica
+		if !replicaShard.Owns(fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)) {
+			continue
+		}
+
+		desiredState := getDesiredSecretState(&secret)
+		for _, namespace := range added {
+			if !shouldCopyToNamespace(desiredState, namespace.Name) {
+				continue
+			}
+
+			waitGroup.Add(1)
+			dataSecret, err := getCertificateDataSecret(ctx, kubeClientset, &secret, desiredState)
+			if err == nil {
+				err = copySecretToNamespace(ctx, kubeClientset, &secret, dataSecret, namespace, "ns-watcher:ADDED")
+			}
+			waitGroup.Done()
+
+			if err != nil {
+				log.Error().Err(err)
+				certificateCopyTotals.With(prometheus.Labels{"namespace": namespace.Name, "status": "failed", "initiator": "ns-watcher"}).Inc()
+				continue
+			}
+			certificateCopyTotals.With(prometheus.Labels{"namespace": namespace.Name, "status": "succeeded", "initiator": "ns-watcher"}).Inc()
+		}
+
+		// the copies get deleted with the namespaces, but skips recorded for them have to be cleaned up
+		if len(deleted) > 0 {
+			err = removeCopySkips(ctx, kubeClientset, &secret, deleted)
+			if err != nil {
+				log.Error().Err(err).Msgf("[%v] Secret %v.%v - Removing copy skips for deleted namespaces failed", "ns-watcher:DELETED", secret.Name, secret.Namespace)
+			}
+		}
+	}
+}
 
-	deviation := int(0.25 * float64(input))
+// getCloudflareAuthentication returns the credentials for the cloudflare api, preferring a scoped api token over the global api key
+func getCloudflareAuthentication() APIAuthentication {
+	if *cfAPIToken != "" {
+		return APIAuthentication{Token: *cfAPIToken}
+	}
 
-	return input - deviation + r.Intn(2*deviation)
+	return APIAuthentication{Key: *cfAPIKey, Email: *cfAPIEmail}
 }
 
 func getDesiredSecretState(secret *v1.Secret) (state LetsEncryptCertificateState) {
@@ -283,9 +801,13 @@ func getDesiredSecretState(secret *v1.Secret) (state LetsEncryptCertificateState
 	if !ok {
 		state.Enabled = "false"
 	}
-	state.Hostnames, ok = secret.Annotations[annotationLetsEncryptCertificateHostnames]
-	if !ok {
-		state.Hostnames = ""
+	state.Hostnames = normalizeHostnames(secret.Annotations[annotationLetsEncryptCertificateHostnames])
+	autoAddWWWValue, ok := secret.Annotations[annotationLetsEncryptCertificateAutoAddWWW]
+	if ok && state.Hostnames != "" {
+		b, err := strconv.ParseBool(autoAddWWWValue)
+		if err == nil && b {
+			state.Hostnames = addCompanionHostnames(state.Hostnames)
+		}
 	}
 	copyToAllNamespacesValue, ok := secret.Annotations[annotationLetsEncryptCertificateCopyToAllNamespaces]
 	if ok {
@@ -301,10 +823,74 @@ func getDesiredSecretState(secret *v1.Secret) (state LetsEncryptCertificateState
 			state.UploadToCloudflare = b
 		}
 	}
+	state.CopyToNamespaces = secret.Annotations[annotationLetsEncryptCertificateCopyToNamespacesMatching]
+	state.AccountEmail = secret.Annotations[annotationLetsEncryptCertificateAccountEmail]
+	state.Account = secret.Annotations[annotationLetsEncryptCertificateAccount]
+	state.CloudflareZone = secret.Annotations[annotationLetsEncryptCertificateCloudflareZone]
+	state.SeparateKeySecret = getBoolAnnotation(secret, annotationLetsEncryptCertificateSeparateKeySecret, false)
+	state.TargetSecret = secret.Annotations[annotationLetsEncryptCertificateTargetSecret]
+	state.CredentialsSecret = secret.Annotations[annotationLetsEncryptCertificateCredentialsSecret]
+	state.DNSProvider = secret.Annotations[annotationLetsEncryptCertificateDNSProvider]
+	state.ACMEDirectoryURL = secret.Annotations[annotationLetsEncryptCertificateACMEDirectoryURL]
+	state.KeyType = secret.Annotations[annotationLetsEncryptCertificateKeyType]
+	state.DualKeyTypes = getBoolAnnotation(secret, annotationLetsEncryptCertificateDualKeyTypes, false)
+	state.LeafOnly = !getBoolAnnotation(secret, annotationLetsEncryptCertificateBundle, true)
+	state.OutputFamilies = secret.Annotations[annotationLetsEncryptCertificateOutputFamilies]
+	state.ExpiresAfter = secret.Annotations[annotationLetsEncryptCertificateExpiresAfter]
+	state.ExpiredAction = secret.Annotations[annotationLetsEncryptCertificateExpiredAction]
+	state.RenewDaysBefore = getPositiveIntAnnotation(secret, annotationLetsEncryptCertificateRenewDaysBeforeExpiry)
+	state.RetryAfterMinutes = getPositiveIntAnnotation(secret, annotationLetsEncryptCertificateRetryAfterMinutes)
+	state.Environment = environmentProduction
+	if getBoolAnnotation(secret, annotationLetsEncryptCertificateStaging, false) {
+		state.Environment = environmentStaging
+	}
 
 	return
 }
 
+// normalizeHostnames turns a comma, whitespace or newline separated list of hostnames, or a yaml style list pasted from a helm template, into a comma separated list without empty entries
+func normalizeHostnames(value string) string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '[' || r == ']' || r == '"' || r == '\'' || unicode.IsSpace(r)
+	})
+
+	hostnames := []string{}
+	for _, field := range fields {
+		// skip the dashes of yaml list items
+		if field == "-" {
+			continue
+		}
+		hostnames = append(hostnames, field)
+	}
+
+	return strings.Join(hostnames, ",")
+}
+
+// getBoolAnnotation returns the boolean value of the annotation, or defaultValue if the annotation is missing or can't be parsed
+func getBoolAnnotation(secret *v1.Secret, annotation string, defaultValue bool) bool {
+	value, ok := secret.Annotations[annotation]
+	if !ok {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return b
+}
+
+// getPositiveIntAnnotation returns the number in the annotation, or 0 if it's missing, can't be parsed or isn't positive, so the flag it overrides applies instead
+func getPositiveIntAnnotation(secret *v1.Secret, annotation string) int {
+	value, err := strconv.Atoi(strings.TrimSpace(secret.Annotations[annotation]))
+	if err != nil || value <= 0 {
+		return 0
+	}
+
+	return value
+}
+
 func getCurrentSecretState(secret *v1.Secret) (state LetsEncryptCertificateState) {
 
 	// get state stored in annotations if present or set to empty struct
@@ -321,6 +907,11 @@ func getCurrentSecretState(secret *v1.Secret) (state LetsEncryptCertificateState
 		return
 	}
 
+	// certificates from before the environment was tracked have been issued by production
+	if state.Environment == "" {
+		state.Environment = environmentProduction
+	}
+
 	// return deserialized state
 	return
 }
@@ -348,27 +939,126 @@ func makeSecretChanges(ctx context.Context, kubeClientset *kubernetes.Clientset,
 		}
 	}
 
-	// check if letsencrypt is enabled for this secret, hostnames are set and either the hostnames have changed or the certificate is older than 60 days and the last attempt was more than 15 minutes ago
-	if desiredState.Enabled == "true" && len(desiredState.Hostnames) > 0 && time.Since(lastAttempt).Minutes() > 15 && (desiredState.Hostnames != currentState.Hostnames || time.Since(lastRenewed).Hours() > float64(*daysBeforeRenewal*24)) {
+	// leave all secrets alone while issuance is paused, for example during CA incidents or cloudflare maintenance
+	if controllerPause.IsPaused() {
+		log.Debug().Msgf("[%v] Secret %v.%v - Skipping, issuance is paused", initiator, secret.Name, secret.Namespace)
 
-		log.Info().Msgf("[%v] Secret %v.%v - Certificates are more than %v days old or hostnames have changed (%v), renewing them with Let's Encrypt...", initiator, secret.Name, secret.Namespace, *daysBeforeRenewal, desiredState.Hostnames)
+		status = "skipped"
+		certificateSkippedTotals.With(prometheus.Labels{"initiator": getInitiatorType(initiator), "reason": "paused"}).Inc()
 
-		// 'lock' the secret for 15 minutes by storing the last attempt timestamp to prevent hitting the rate limit if the Let's Encrypt call fails and to prevent the watcher and the fallback polling to operate on the secret at the same time
-		currentState.LastAttempt = time.Now().Format(time.RFC3339)
+		return status, nil
+	}
 
-		// serialize state and store it in the annotation
-		letsEncryptCertificateStateByteArray, err := json.Marshal(currentState)
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
+	// stop renewing temporary certificates once they've expired, after clearing or revoking them once if requested
+	if desiredState.Enabled == "true" && isExpired(desiredState) {
+		if !currentState.Expired {
+			err = expireSecret(ctx, kubeClientset, secret, initiator, desiredState, currentState)
+			if err != nil {
+				log.Error().Err(err).Msgf("[%v] Secret %v.%v - Expiring secret has failed", initiator, secret.Name, secret.Namespace)
+				return status, err
+			}
 		}
-		secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
 
-		// update secret, with last attempt; this will fire an event for the watcher, but this shouldn't lead to any action because storing the last attempt locks the secret for 15 minutes
-		_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
-		if err != nil {
-			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Updating secret state has failed", initiator, secret.Name, secret.Namespace)
-			return status, err
+		status = "skipped"
+		certificateSkippedTotals.With(prometheus.Labels{"initiator": getInitiatorType(initiator), "reason": getSkipReason(desiredState, lastAttempt)}).Inc()
+
+		return status, nil
+	}
+
+	// swap the live certificate with the previous one when requested, for example when a renewed certificate breaks clients pinning its key
+	if value, ok := secret.Annotations[annotationLetsEncryptCertificateRollback]; ok && desiredState.Enabled == "true" {
+		pause, err := getRollbackPause(value)
+		if err == nil {
+			err = rollbackCertificate(ctx, kubeClientset, secret, initiator, desiredState, currentState, pause)
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Rolling back to the previous certificate has failed", initiator, secret.Name, secret.Namespace)
+			return status, err
+		}
+
+		return "succeeded", nil
+	}
+
+	// leave the rolled back certificate in place while the issue is investigated
+	if desiredState.Enabled == "true" && isRenewalPaused(secret, time.Now()) {
+		log.Debug().Msgf("[%v] Secret %v.%v - Skipping, renewals are paused after a rollback until %v", initiator, secret.Name, secret.Namespace, secret.Annotations[annotationLetsEncryptCertificateRenewalsPausedUntil])
+
+		status = "skipped"
+		certificateSkippedTotals.With(prometheus.Labels{"initiator": getInitiatorType(initiator), "reason": "rolled-back"}).Inc()
+
+		return status, nil
+	}
+
+	// don't place another order before the rate limit hit by the last one has passed, since retrying extends the penalty
+	if desiredState.Enabled == "true" && isRateLimited(currentState, time.Now()) {
+		status = "skipped"
+		certificateSkippedTotals.With(prometheus.Labels{"initiator": getInitiatorType(initiator), "reason": "rate-limited"}).Inc()
+
+		return status, nil
+	}
+
+	// get the secret holding the certificate data, which differs from the annotated secret if a target secret is configured
+	dataSecret, err := getCertificateDataSecret(ctx, kubeClientset, secret, desiredState)
+	if err != nil {
+		return status, err
+	}
+
+	// check whether the certificate data has been replaced out-of-band since it was last written by this controller
+	tampered, err := handleTamperedSecret(ctx, kubeClientset, secret, dataSecret, initiator, desiredState, &currentState)
+	if err != nil {
+		return status, err
+	}
+
+	// release a lock that no order of this process is behind, for example because the controller restarted mid-order, instead of stalling the secret until the retry delay has passed
+	lockKey := fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)
+	if desiredState.Enabled == "true" && currentState.LastAttempt != "" && time.Since(lastAttempt) <= getRetryAfter(desiredState) && secretLocks.IsStale(lockKey, currentState.LastAttempt, getStaleLockTimeout(desiredState), time.Now()) {
+		log.Warn().Msgf("[%v] Secret %v.%v - Lock from last attempt at %v hasn't been set by this controller process, releasing it...", initiator, secret.Name, secret.Namespace, currentState.LastAttempt)
+
+		err = postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "ReleaseLock", "StaleLock", fmt.Sprintf("Released stale lock from last attempt at %v for secret %v", currentState.LastAttempt, secret.Name), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+		if err != nil {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting stale lock event failed", initiator, secret.Name, secret.Namespace)
+		}
+
+		// take over the lock so it's only released once
+		secretLocks.Acquire(lockKey, currentState.LastAttempt)
+		lastAttempt = time.Time{}
+	}
+
+	if shouldRenew(dataSecret, initiator, desiredState, currentState, lastRenewed, lastAttempt, tampered) {
+
+		// don't order a certificate that can't be stored, since the order would count against the rate limits of the CA for nothing
+		if immutableSecret := getImmutableStoreTarget(ctx, kubeClientset, secret, dataSecret, desiredState); immutableSecret != nil && *immutableSecretPolicy != "recreate" {
+			log.Warn().Msgf("[%v] Secret %v.%v - Skipping renewal, secret %v.%v to store the certificates in is immutable", initiator, secret.Name, secret.Namespace, immutableSecret.Name, immutableSecret.Namespace)
+			postImmutableSecretEvent(ctx, kubeClientset, immutableSecret, initiator)
+
+			status = "skipped"
+			certificateSkippedTotals.With(prometheus.Labels{"initiator": getInitiatorType(initiator), "reason": "immutable"}).Inc()
+
+			return status, nil
+		}
+
+		log.Info().Msgf("[%v] Secret %v.%v - Certificates are due for renewal or hostnames have changed (%v), renewing them with Let's Encrypt...", initiator, secret.Name, secret.Namespace, desiredState.Hostnames)
+
+		// 'lock' the secret for the retry delay by storing the last attempt timestamp to prevent hitting the rate limit if the Let's Encrypt call fails and to prevent the watcher and the fallback polling to operate on the secret at the same time
+		currentState.LastAttempt = time.Now().Format(time.RFC3339)
+		currentState.IssueStatus = "pending"
+		currentState.IssueFailure = ""
+		secretLocks.Acquire(lockKey, currentState.LastAttempt)
+
+		// serialize state and store it in the annotation
+		letsEncryptCertificateStateByteArray, err := json.Marshal(currentState)
+		if err != nil {
+			log.Error().Err(err)
+			return status, err
+		}
+		secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+
+		// update secret, with last attempt; this will fire an event for the watcher, but this shouldn't lead to any action because storing the last attempt locks the secret for the retry delay
+		setLastChangedBy(secret, initiator)
+		_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Updating secret state has failed", initiator, secret.Name, secret.Namespace)
+			return status, err
 		}
 
 		// error if any of the host names is longer than 64 bytes
@@ -379,225 +1069,1365 @@ func makeSecretChanges(ctx context.Context, kubeClientset *kubernetes.Clientset,
 				log.Error().Err(err)
 				return status, err
 			}
+			if !isAllowedHostname(hostname) {
+				err = fmt.Errorf("Hostname %v is not within the allowed domains of the cluster configuration", hostname)
+				log.Error().Err(err)
+				notifyRenewal(ctx, secret, initiator, desiredState, "failed", err)
+				return status, err
+			}
+		}
+
+		certificates, renewedAt, restored, err := obtainOrRestoreCertificates(ctx, kubeClientset, secret, dataSecret, initiator, desiredState, hostnames)
+		var dualCertificates *certificate.Resource
+		var dualErr error
+		if err == nil && desiredState.DualKeyTypes {
+			dualCertificates, dualErr = obtainDualCertificates(ctx, kubeClientset, secret, dataSecret, initiator, desiredState, certificates, hostnames)
+		}
+		if err != nil {
+			stateErr := updateSecretState(ctx, kubeClientset, secret, getFailedIssueState(currentState, err))
+			if stateErr != nil {
+				log.Warn().Err(stateErr).Msgf("[%v] Secret %v.%v - Recording failed issue phase in state failed", initiator, secret.Name, secret.Namespace)
+			}
+			recordRateLimit(ctx, kubeClientset, secret, initiator, getFailedIssueState(currentState, err), err)
+			recordACMEDiagnostics(ctx, kubeClientset, secret, initiator, err)
+			notifyRenewal(ctx, secret, initiator, desiredState, "failed", err)
+			return status, err
+		}
+
+		// clean up acme challenge records afterwards
+		// for _, hostname := range hostnames {
+		// 	log.Info().Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v...", initiator, secret.Name, secret.Namespace, hostname)
+		// 	err = cloudflareProvider.CleanUp(hostname, "", "123d==")
+		// 	if err != nil {
+		// 		log.Info().Err(err).Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v failed", initiator, secret.Name, secret.Namespace, hostname)
+		// 	}
+		// }
+
+		lockedState := currentState
+		currentState = getRenewedState(desiredState, certificates, renewedAt)
+		// keep track of the cloudflare objects uploaded to for earlier certificates, they're still owned by this controller
+		currentState.UploadTargets = lockedState.UploadTargets
+		if dualErr != nil {
+			// store the certificate of the primary key type instead of discarding it; it's kept as pending renewal, so the next attempt only orders the missing key type
+			log.Warn().Err(dualErr).Msgf("[%v] Secret %v.%v - Obtaining the certificate of the dual key type failed, storing the one of the primary key type only...", initiator, secret.Name, secret.Namespace)
+			currentState = getMissingDualKeyTypeState(currentState, lockedState, dualErr)
+		}
+		storedSecret, storedDataSecret, err := storeCertificates(ctx, kubeClientset, secret, initiator, desiredState, currentState, certificates, dualCertificates)
+		if err != nil {
+			// keep the state from before the renewal, so the secret isn't taken for renewed, and pick up the pending certificates once the retry delay has passed
+			stateErr := updateSecretState(ctx, kubeClientset, secret, getFailedStoreState(lockedState, err))
+			if stateErr != nil {
+				log.Warn().Err(stateErr).Msgf("[%v] Secret %v.%v - Recording failed store phase in state failed", initiator, secret.Name, secret.Namespace)
+			}
+			notifyRenewal(ctx, secret, initiator, desiredState, "failed", err)
+			return status, err
+		}
+		secret, dataSecret = storedSecret, storedDataSecret
+
+		log.Info().Msgf("[%v] Secret %v.%v - Certificates have been stored in secret successfully...", initiator, secret.Name, secret.Namespace)
+
+		if dualErr != nil {
+			recordRateLimit(ctx, kubeClientset, secret, initiator, currentState, dualErr)
+			recordACMEDiagnostics(ctx, kubeClientset, secret, initiator, dualErr)
+			notifyRenewal(ctx, secret, initiator, desiredState, "failed", dualErr)
+		} else {
+			status = "succeeded"
+			notifyRenewal(ctx, secret, initiator, desiredState, status, nil)
+			removePendingRenewal(secret, initiator)
+		}
+
+		if !restored {
+			backupCertificates(secret, currentState, certificates, initiator)
+		}
+
+		// distribute the certificates in a fixed order: copy to other namespaces first, then upload to cloudflare
+		err = distributeCertificates(ctx, kubeClientset, secret, dataSecret, initiator, "renewal", desiredState, currentState, certificates.Certificate, certificates.PrivateKey)
+		if err != nil {
+			return status, err
+		}
+
+		return status, dualErr
+	}
+
+	// retry only the copy and upload phases that failed after the certificate was stored, instead of waiting for the next renewal
+	if desiredState.Enabled == "true" && hasFailedPhase(currentState) && initiator == "poller" {
+		err = retryFailedPhases(ctx, kubeClientset, secret, dataSecret, initiator, desiredState, currentState)
+		if err != nil {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Retrying failed phases failed", initiator, secret.Name, secret.Namespace)
+		}
+	}
+
+	// re-upload the stored certificate when cloudflare's copy is about to expire, even though the local certificate isn't due for renewal yet
+	if desiredState.Enabled == "true" && desiredState.UploadToCloudflare && currentState.UploadToCloudflare && initiator == "poller" {
+		err = refreshCloudflareUpload(ctx, kubeClientset, secret, dataSecret, desiredState, currentState, initiator)
+		if err != nil {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Refreshing cloudflare upload failed", initiator, secret.Name, secret.Namespace)
+		}
+	}
+
+	status = "skipped"
+	certificateSkippedTotals.With(prometheus.Labels{"initiator": getInitiatorType(initiator), "reason": getSkipReason(desiredState, lastAttempt)}).Inc()
+
+	return status, nil
+}
+
+// shouldRenew decides whether a certificate has to be obtained for a secret: letsencrypt has to be enabled with hostnames set, the last attempt has to be longer than the retry delay ago, and either the hostnames or environment have changed or the certificate is due for renewal, has been tampered with, its issuer has been retired or its key type is being replaced
+func shouldRenew(dataSecret *v1.Secret, initiator string, desiredState, currentState LetsEncryptCertificateState, lastRenewed, lastAttempt time.Time, tampered bool) bool {
+	if desiredState.Enabled != "true" || len(desiredState.Hostnames) == 0 || time.Since(lastAttempt) <= getRetryAfter(desiredState) {
+		return false
+	}
+
+	return desiredState.Hostnames != currentState.Hostnames || desiredState.Environment != currentState.Environment || desiredState.ACMEDirectoryURL != currentState.ACMEDirectoryURL || desiredState.KeyType != currentState.KeyType || desiredState.DualKeyTypes != currentState.DualKeyTypes || isSecretDueForRenewal(dataSecret, lastRenewed, desiredState) || tampered || hasRetiredIssuer(dataSecret, currentState, initiator) || hasOutdatedKeyType(dataSecret, desiredState, initiator)
+}
+
+// obtainOrRestoreCertificates picks up certificates obtained earlier that never made it into the secret, restores the certificates from backup if they're still valid (e.g. after a cluster rebuild), and otherwise obtains new ones, sharing the order with concurrent renewals of the same hostnames
+func obtainOrRestoreCertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, hostnames []string) (certificates *certificate.Resource, renewedAt time.Time, restored bool, err error) {
+	certificates, renewedAt, pending := loadPendingRenewal(secret, desiredState, initiator)
+	if pending {
+		return certificates, renewedAt, false, nil
+	}
+
+	certificates, renewedAt, restored = restoreCertificatesFromBackup(secret, dataSecret, desiredState, initiator)
+	if restored {
+		return certificates, renewedAt, restored, nil
+	}
+
+	renewedAt = time.Now()
+	certificateBytes, _ := getSecretCertificate(dataSecret)
+	certificates, err, shared := certificateFlights.Do(getCertificateFlightKey(secret.Namespace, desiredState, hostnames), func() (*certificate.Resource, error) {
+		return obtainCertificates(ctx, kubeClientset, secret, initiator, desiredState, hostnames, certificateBytes)
+	})
+	if err != nil {
+		return nil, renewedAt, false, err
+	}
+	if shared {
+		log.Info().Msgf("[%v] Secret %v.%v - Reusing certificates obtained concurrently for the same hostnames", initiator, secret.Name, secret.Namespace)
+	} else {
+		certificateIssuedTotals.With(prometheus.Labels{"environment": desiredState.Environment, "wildcard": getWildcardLabel(desiredState.Hostnames)}).Inc()
+	}
+	if certificate, err := parseCertificate(certificates.Certificate); err == nil {
+		issuers.Observe(desiredState.Environment, certificate.Issuer.CommonName)
+	}
+
+	storePendingRenewal(secret, desiredState, certificates, renewedAt, initiator)
+
+	return certificates, renewedAt, false, nil
+}
+
+// obtainDualCertificates obtains a certificate with the other key type than the one of the obtained certificates, for the dual-key-types annotation
+func obtainDualCertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, certificates *certificate.Resource, hostnames []string) (*certificate.Resource, error) {
+	dualState := desiredState
+	dualState.KeyType = getDualKeyType(getPrimaryKeyType(certificates, desiredState))
+
+	log.Info().Msgf("[%v] Secret %v.%v - Obtaining %v certificate as well for dual key types...", initiator, secret.Name, secret.Namespace, dualState.KeyType)
+
+	dualCertificates, err, shared := certificateFlights.Do(getCertificateFlightKey(secret.Namespace, dualState, hostnames), func() (*certificate.Resource, error) {
+		return obtainCertificates(ctx, kubeClientset, secret, initiator, dualState, hostnames, dataSecret.Data[getKeyTypeDataPrefix(dualState.KeyType)+".crt"])
+	})
+	if err == nil && !shared {
+		certificateIssuedTotals.With(prometheus.Labels{"environment": desiredState.Environment, "wildcard": getWildcardLabel(desiredState.Hostnames)}).Inc()
+	}
+
+	return dualCertificates, err
+}
+
+// getFailedIssueState records the failed issue phase and what kind of failure it was, keeping the last attempt so the secret stays locked for the retry delay and the CA's rate limits aren't hit by retrying right away
+func getFailedIssueState(currentState LetsEncryptCertificateState, issueErr error) LetsEncryptCertificateState {
+	currentState.IssueStatus = getPhaseStatus(issueErr)
+	currentState.IssueFailure = getIssueFailure(issueErr)
+
+	return currentState
+}
+
+// getMissingDualKeyTypeState records that only the certificate of the primary key type has been obtained, so the secret is renewed again to obtain the dual one, keeping the last attempt so that doesn't happen before the retry delay has passed
+func getMissingDualKeyTypeState(renewedState, lockedState LetsEncryptCertificateState, dualErr error) LetsEncryptCertificateState {
+	renewedState.DualKeyTypes = false
+	renewedState.LastAttempt = lockedState.LastAttempt
+	renewedState.IssueStatus = getPhaseStatus(dualErr)
+	renewedState.IssueFailure = getIssueFailure(dualErr)
+
+	return renewedState
+}
+
+// getFailedStoreState records that the certificates have been obtained but storing them failed, keeping the last attempt so the secret stays locked for the retry delay
+func getFailedStoreState(currentState LetsEncryptCertificateState, storeErr error) LetsEncryptCertificateState {
+	currentState.IssueStatus = getPhaseStatus(nil)
+	currentState.StoreStatus = getPhaseStatus(storeErr)
+
+	return currentState
+}
+
+// getRenewedState returns the state after storing newly obtained certificates; the copy and upload settings only get rolled forward in the state once those phases have succeeded, so the state never claims more than has been done
+func getRenewedState(desiredState LetsEncryptCertificateState, certificates *certificate.Resource, renewedAt time.Time) LetsEncryptCertificateState {
+	renewedState := desiredState
+	renewedState.CopyToAllNamespaces = false
+	renewedState.CopyToNamespaces = ""
+	renewedState.UploadToCloudflare = false
+	renewedState.LastRenewed = renewedAt.Format(time.RFC3339)
+	renewedState.RenewedBy = version
+	renewedState.NextRenewal = getRenewalTimeForCertificate(certificates.Certificate, renewedAt, desiredState.RenewDaysBefore).Format(time.RFC3339)
+	renewedState.IssueStatus = getPhaseStatus(nil)
+	if desiredState.SeparateKeySecret {
+		renewedState.DataHash = getCertificateDataHash(certificates.Certificate, nil)
+	} else {
+		renewedState.DataHash = getCertificateDataHash(certificates.Certificate, certificates.PrivateKey)
+	}
+
+	return renewedState
+}
+
+// storeCertificates writes the certificates and the renewed state to the annotated secret or its target secret, and to the additionally selected storage backends
+func storeCertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState, currentState LetsEncryptCertificateState, certificates, dualCertificates *certificate.Resource) (storedSecret, dataSecret *v1.Secret, err error) {
+
+	// reload secret to avoid object has been modified error
+	secret, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Error().Err(err)
+		return nil, nil, err
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Updating secret because new certificates have been obtained...", initiator, secret.Name, secret.Namespace)
+
+	// serialize state and store it in the annotation, keeping the challenge record values stored during the order
+	currentState.StoreStatus = getPhaseStatus(nil)
+	currentState.ChallengeValues = getCurrentSecretState(secret).ChallengeValues
+	letsEncryptCertificateStateByteArray, err := json.Marshal(currentState)
+	if err != nil {
+		log.Error().Err(err)
+		return nil, nil, err
+	}
+	secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+	secret.Annotations[annotationLetsEncryptCertificateNextRenewal] = currentState.NextRenewal
+	delete(secret.Annotations, annotationLetsEncryptCertificateDiagnostics)
+
+	// write the certificates to the target secret if configured, otherwise to the annotated secret itself
+	dataSecret = secret
+	if desiredState.TargetSecret != "" {
+		dataSecret, err = getTargetSecret(ctx, kubeClientset, secret, desiredState.TargetSecret)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Getting target secret %v has failed", initiator, secret.Name, secret.Namespace, desiredState.TargetSecret)
+			return nil, nil, err
+		}
+		dataSecret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Secret has %v data items before writing the certificates...", initiator, dataSecret.Name, dataSecret.Namespace, len(dataSecret.Data))
+
+	// keep the replaced certificates for a quick rollback; with a separate key secret the keys aren't at hand, so there's nothing to roll back to
+	if !desiredState.SeparateKeySecret {
+		rotatePreviousCertificates(dataSecret, getKeepPrevious(secret))
+	}
+
+	err = writeCertificatesToSecretData(dataSecret, certificates, desiredState.OutputFamilies, getBoolAnnotation(secret, annotationLetsEncryptCertificateWriteJSON, false))
+	if err != nil {
+		// the json items are only a convenience, so store the certificates without them
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Unable to marshal CertResource for domain %v, storing the certificates without json data items", initiator, secret.Name, secret.Namespace, certificates.Domain)
+	}
+	writeDualCertificatesToSecretData(dataSecret, certificates, dualCertificates, desiredState)
+
+	if *pruneOutputFamilies {
+		pruneStaleOutputFamilies(dataSecret, desiredState.OutputFamilies)
+	}
+
+	if desiredState.SeparateKeySecret {
+		// move the private key material into its own secret, so access to it can be restricted separately
+		err = moveKeysToSeparateSecret(ctx, kubeClientset, dataSecret, initiator)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Storing private key in separate secret has failed", initiator, secret.Name, secret.Namespace)
+			return nil, nil, err
+		}
+	}
+
+	// stay clear of the size limit of secrets, since the update fails once it's exceeded
+	err = enforceSecretSizeLimit(dataSecret, initiator)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] Secret %v.%v - Secret size check has failed", initiator, secret.Name, secret.Namespace)
+		return nil, nil, err
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Secret has %v data items after writing the certificates...", initiator, dataSecret.Name, dataSecret.Namespace, len(dataSecret.Data))
+
+	if dataSecret != secret {
+		// create or update the target secret holding the certificates
+		if dataSecret.ResourceVersion == "" {
+			setLastChangedBy(dataSecret, initiator)
+			dataSecret, err = kubeClientset.CoreV1().Secrets(dataSecret.Namespace).Create(ctx, dataSecret, metav1.CreateOptions{})
+		} else {
+			dataSecret, err = updateSecretData(ctx, kubeClientset, dataSecret, initiator)
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Storing certificates in target secret %v has failed", initiator, secret.Name, secret.Namespace, desiredState.TargetSecret)
+			return nil, nil, err
+		}
+	}
+
+	// store the certificates in the additionally selected backends
+	storages, err := getCertificateStorages(kubeClientset, secret.Annotations[annotationLetsEncryptCertificateStorage])
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] Secret %v.%v - Getting storage backends has failed", initiator, secret.Name, secret.Namespace)
+		return nil, nil, err
+	}
+	for _, storage := range storages {
+		err = storage.Store(ctx, dataSecret, certificates)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Storing certificates in %T has failed", initiator, secret.Name, secret.Namespace, storage)
+			return nil, nil, err
+		}
+	}
+
+	// update secret last, because the data and state annotation have changed; the state only records the store phase as succeeded once all other writes have succeeded as well
+	_, err = updateSecretData(ctx, kubeClientset, secret, initiator)
+	if err != nil {
+		log.Error().Err(err)
+		return nil, nil, err
+	}
+
+	return secret, dataSecret, nil
+}
+
+// isExpired returns true if the expires-after date of a temporary certificate has passed; invalid dates never expire
+func isExpired(state LetsEncryptCertificateState) bool {
+	if state.ExpiresAfter == "" {
+		return false
+	}
+
+	expiresAfter, err := time.Parse(time.RFC3339, state.ExpiresAfter)
+	if err != nil {
+		expiresAfter, err = time.Parse("2006-01-02", state.ExpiresAfter)
+		if err != nil {
+			return false
+		}
+	}
+
+	return time.Now().After(expiresAfter)
+}
+
+// expireSecret applies the expired-action of a temporary certificate: keep leaves the data in place, clear removes it and revoke revokes the certificate before removing it
+func expireSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState, currentState LetsEncryptCertificateState) error {
+
+	log.Info().Msgf("[%v] Secret %v.%v - Certificate has expired after %v, applying expired action %v...", initiator, secret.Name, secret.Namespace, desiredState.ExpiresAfter, desiredState.ExpiredAction)
+
+	dataSecret, err := getCertificateDataSecret(ctx, kubeClientset, secret, desiredState)
+	if err != nil {
+		return err
+	}
+
+	if desiredState.ExpiredAction == "revoke" {
+		certificateBytes, _ := getSecretCertificate(dataSecret)
+		if certificateBytes != nil {
+			legoClient, _, err := newLegoClient(ctx, kubeClientset, secret, initiator, currentState)
+			if err != nil {
+				return err
+			}
+			err = legoClient.Certificate.Revoke(certificateBytes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if desiredState.ExpiredAction == "clear" || desiredState.ExpiredAction == "revoke" {
+		clearCertificatesFromSecretData(dataSecret)
+
+		if dataSecret != secret && dataSecret.Name != "" {
+			_, err := updateSecretData(ctx, kubeClientset, dataSecret, initiator)
+			if err != nil {
+				return err
+			}
+		}
+
+		if currentState.SeparateKeySecret {
+			err := kubeClientset.CoreV1().Secrets(dataSecret.Namespace).Delete(ctx, fmt.Sprintf("%v-key", dataSecret.Name), metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	// mark the state as expired so the action is only applied once
+	currentState.Expired = true
+	letsEncryptCertificateStateByteArray, err := json.Marshal(currentState)
+	if err != nil {
+		return err
+	}
+	secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+
+	_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// clearCertificatesFromSecretData removes all data items written by writeCertificatesToSecretData and writeDualCertificatesToSecretData
+func clearCertificatesFromSecretData(secret *v1.Secret) {
+	for _, family := range getOutputFamilies("") {
+		for _, suffix := range []string{".crt", ".key", ".pem", ".issuer.crt", ".json"} {
+			delete(secret.Data, family+suffix)
+		}
+	}
+	writeDualCertificatesToSecretData(secret, nil, nil, LetsEncryptCertificateState{})
+}
+
+// pruneStaleOutputFamilies removes the data items of the output families that are no longer written, so outdated certificate copies don't linger in the secret
+func pruneStaleOutputFamilies(secret *v1.Secret, outputFamilies string) {
+	writtenFamilies := map[string]bool{}
+	for _, family := range getOutputFamilies(outputFamilies) {
+		writtenFamilies[family] = true
+	}
+
+	for _, family := range getOutputFamilies("") {
+		if writtenFamilies[family] {
+			continue
 		}
+		for _, suffix := range []string{".crt", ".key", ".pem", ".issuer.crt", ".json"} {
+			delete(secret.Data, family+suffix)
+		}
+	}
+}
+
+// getSkipReason explains why makeSecretChanges didn't take any action for a secret
+func getSkipReason(desiredState LetsEncryptCertificateState, lastAttempt time.Time) string {
+	switch {
+	case desiredState.Enabled != "true":
+		return "disabled"
+	case len(desiredState.Hostnames) == 0:
+		return "no-hostnames"
+	case isExpired(desiredState):
+		return "expired"
+	case time.Since(lastAttempt) <= getRetryAfter(desiredState):
+		return "locked"
+	}
+
+	return "not-due"
+}
+
+// getInitiatorType strips the event type from watcher initiators to keep metric label cardinality low
+func getInitiatorType(initiator string) string {
+	return strings.SplitN(initiator, ":", 2)[0]
+}
+
+// refreshCloudflareUpload uploads the certificate stored in the secret to cloudflare if the custom certificate in the cloudflare-zone zone, or otherwise any of the hostnames' zones, expires within the refresh window and isn't the stored certificate, and records the upload targets in the state
+func refreshCloudflareUpload(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, desiredState, currentState LetsEncryptCertificateState, initiator string) error {
+
+	// cloudflare renews certificate packs itself
+	if *cloudflareCertificateAPI == "certificate-packs" {
+		return nil
+	}
+
+	certificateBytes, privateKey := getSecretCertificate(dataSecret)
+	if certificateBytes == nil || privateKey == nil {
+		return nil
+	}
+
+	authentication, err := getCloudflareAuthenticationForSecret(ctx, kubeClientset, dataSecret.Namespace, desiredState)
+	if err != nil {
+		return err
+	}
+	cf := NewCloudflare(authentication)
+
+	// check the zone the certificate has been uploaded to, which is the one of the cloudflare-zone annotation if set
+	zoneNameOrID := desiredState.CloudflareZone
+	if zoneNameOrID == "" {
+		zoneNameOrID = currentState.CloudflareZone
+	}
+	zones, err := getCloudflareUploadZones(cf, strings.Split(desiredState.Hostnames, ","), zoneNameOrID)
+	if err != nil {
+		return err
+	}
+
+	for _, zone := range zones {
+		refresh, err := cf.SSLConfigurationNeedsRefreshByZone(zone, certificateBytes, *cloudflareRefreshWindow)
+		if err != nil {
+			return err
+		}
+		if refresh {
+			log.Info().Msgf("[%v] Secret %v.%v - Custom certificate at cloudflare in zone %v expires within %v and differs from the stored certificate, uploading stored certificate...", initiator, dataSecret.Name, dataSecret.Namespace, zone.Name, *cloudflareRefreshWindow)
+			targets, uploadErr := uploadToCloudflare(authentication, desiredState.Hostnames, zoneNameOrID, certificateBytes, privateKey)
+			if len(targets) > 0 {
+				currentState.UploadTargets = mergeCloudflareUploadTargets(currentState.UploadTargets, targets)
+				err = updateSecretState(ctx, kubeClientset, secret, currentState)
+				if err != nil {
+					log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Recording cloudflare upload targets in state failed", initiator, secret.Name, secret.Namespace)
+				}
+			}
+			return uploadErr
+		}
+	}
+
+	return nil
+}
+
+// newLegoClient creates a lego client for the account and the environment or ACME directory of the secret
+func newLegoClient(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState) (legoClient *lego.Client, letsEncryptUser *LetsEncryptUser, err error) {
+
+	// load account.json and account.key for the named account or the account matching the requested email address, or the default account
+	log.Info().Msgf("[%v] Secret %v.%v - Loading account...", initiator, secret.Name, secret.Namespace)
+	user, err := loadAccount(ctx, kubeClientset, desiredState.Account, desiredState.AccountEmail)
+	if err != nil {
+		log.Error().Err(err)
+		return nil, nil, err
+	}
+	letsEncryptUser = &user
+
+	log.Info().Msgf("[%v] Secret %v.%v - Creating lego config...", initiator, secret.Name, secret.Namespace)
+	config := lego.NewConfig(letsEncryptUser)
+	config.CADirURL = getStateACMEDirectoryURL(desiredState)
+	config.Certificate.KeyType = keyTypes[getStateKeyType(desiredState)]
+
+	// route the traffic to the CA through the proxy configured for the account or environment, if any
+	proxyURL, err := getACMEProxy(*acmeProxies, letsEncryptUser.Email, desiredState.Environment)
+	if err != nil {
+		log.Error().Err(err)
+		return nil, nil, err
+	}
+	if proxyURL != nil {
+		if transport, ok := config.HTTPClient.Transport.(*http.Transport); ok {
+			log.Info().Msgf("[%v] Secret %v.%v - Using proxy %v for the CA...", initiator, secret.Name, secret.Namespace, proxyURL.Redacted())
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	// create letsencrypt lego client
+	log.Info().Msgf("[%v] Secret %v.%v - Creating lego client...", initiator, secret.Name, secret.Namespace)
+	legoClient, err = lego.NewClient(config)
+	if err != nil {
+		log.Error().Err(err)
+		return nil, nil, err
+	}
+
+	if !isRegisteredWithDirectory(letsEncryptUser.Registration, config.CADirURL) {
+		// the account is registered with a single CA, so look it up or register it with the other directory as well, like staging or a CA requiring external account binding
+		log.Info().Msgf("[%v] Secret %v.%v - Resolving account with ACME directory %v...", initiator, secret.Name, secret.Namespace, config.CADirURL)
+		letsEncryptUser.Registration, err = legoClient.Registration.ResolveAccountByKey()
+		if err != nil {
+			eabCredentials, err := getACMEEABCredentialsForSecret(ctx, kubeClientset, secret.Namespace, desiredState)
+			if err != nil {
+				log.Error().Err(err).Msgf("[%v] Secret %v.%v - Getting external account binding credentials failed", initiator, secret.Name, secret.Namespace)
+				return nil, nil, err
+			}
+			letsEncryptUser.Registration, err = registerWithDirectory(legoClient, eabCredentials)
+			if err != nil {
+				log.Error().Err(err)
+				return nil, nil, err
+			}
+		}
+	}
+
+	return legoClient, letsEncryptUser, nil
+}
+
+// obtainCertificates orders certificates for the hostnames, marking the order as the replacement of replacedCertificate if it's set
+func obtainCertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, hostnames []string, replacedCertificate []byte) (certificates *certificate.Resource, err error) {
+
+	legoClient, letsEncryptUser, err := newLegoClient(ctx, kubeClientset, secret, initiator, desiredState)
+	if err != nil {
+		return nil, err
+	}
+
+	// set challenge provider, keeping track of the presented dns records for the diagnostics of a failed order
+	recordingProvider := &recordingDNSProvider{}
+	if getChallengeType(secret) == "http-01" {
+		err = setHTTP01Provider(legoClient, secret, initiator, hostnames)
+	} else {
+		recordingProvider, err = setDNS01Provider(ctx, kubeClientset, legoClient, secret, initiator, desiredState)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// get certificate
+	log.Info().Msgf("[%v] Secret %v.%v - Obtaining certificate...", initiator, secret.Name, secret.Namespace)
+	request := certificate.ObtainRequest{
+		Domains:        hostnames,
+		Bundle:         !desiredState.LeafOnly,
+		ReplacesCertID: getReplacedCertificateID(secret, desiredState, replacedCertificate, time.Now()),
+	}
+	certificates, err = legoClient.Certificate.Obtain(request)
+
+	// order without marking the replaced certificate if the CA refuses it, for example because it has been replaced by an order whose certificate never made it into the secret
+	if err != nil && request.ReplacesCertID != "" && isReplacementRejectedError(err) {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - CA rejected the order as replacement of certificate %v, ordering without it...", initiator, secret.Name, secret.Namespace, request.ReplacesCertID)
+		request.ReplacesCertID = ""
+		certificates, err = legoClient.Certificate.Obtain(request)
+	}
+
+	// agree to updated terms of service and retry once, if allowed
+	if err != nil && *acceptTermsOfServiceUpdates && isTermsOfServiceUpdateError(err) {
+		termsOfServiceURL := legoClient.GetToSURL()
+		log.Info().Msgf("[%v] Secret %v.%v - Agreeing to updated terms of service %v...", initiator, secret.Name, secret.Namespace, termsOfServiceURL)
+		letsEncryptUser.Registration, err = legoClient.Registration.UpdateRegistration(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Agreeing to updated terms of service failed", initiator, secret.Name, secret.Namespace)
+			return nil, err
+		}
+
+		err = postEventAboutStatus(ctx, kubeClientset, secret, "Normal", "AcceptTermsOfService", "TermsOfServiceAccepted", fmt.Sprintf("Agreed to updated terms of service %v for account %v", termsOfServiceURL, letsEncryptUser.Email), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+		if err != nil {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting terms of service event failed", initiator, secret.Name, secret.Namespace)
+		}
+
+		certificates, err = legoClient.Certificate.Obtain(request)
+	}
+
+	// if obtaining secret failed exit and retry once the retry delay has passed
+	if err != nil {
+		log.Error().Err(err).Msgf("Could not obtain certificates for domains %v due to error", hostnames)
+		return nil, &acmeOrderError{err: err, diagnostics: getACMEDiagnostics(err, desiredState, letsEncryptUser.Email, recordingProvider.Records())}
+	}
+	if certificates == nil {
+		err = fmt.Errorf("Certificates for domains %v are empty", hostnames)
+		log.Error().Msgf("Could not obtain certificates for domains %v, certificates are empty", hostnames)
+		return nil, err
+	}
+
+	return certificates, nil
+}
+
+// setDNS01Provider sets the provider to solve the DNS-01 challenges with, wrapped to keep to the api budget of the provider and to record the presented records
+func setDNS01Provider(ctx context.Context, kubeClientset *kubernetes.Clientset, legoClient *lego.Client, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState) (*recordingDNSProvider, error) {
+
+	// get dns challenge
+	log.Info().Msgf("[%v] Secret %v.%v - Creating %v provider...", initiator, secret.Name, secret.Namespace, getDNSProviderName(desiredState))
+	selectedDNSProvider, err := newDNSProvider(ctx, kubeClientset, secret, desiredState)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] Secret %v.%v - Creating %v provider failed", initiator, secret.Name, secret.Namespace, getDNSProviderName(desiredState))
+		return nil, err
+	}
+
+	// clean up acme challenge records in advance
+	// for _, hostname := range hostnames {
+	// 	log.Info().Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v...", initiator, secret.Name, secret.Namespace, hostname)
+	// 	err = cloudflareProvider.CleanUp(hostname, "", "123d==")
+	// 	if err != nil {
+	// 		log.Info().Err(err).Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v failed", initiator, secret.Name, secret.Namespace, hostname)
+	// 	}
+	// }
+
+	// set challenge provider, keeping track of the presented records for the diagnostics of a failed order and of the api calls for the budget of the provider
+	challengeProvider := challenge.Provider(&budgetedDNSProvider{Provider: selectedDNSProvider, name: getDNSProviderName(desiredState), budget: dnsBudgets})
+	if *dnsCleanupPolicy == "owned-only" {
+		challengeProvider = &ownedOnlyDNSProvider{Provider: challengeProvider, owned: getCurrentSecretState(secret).ChallengeValues, knownValues: getDiagnosticsChallengeValues(secret), lookupTXT: lookupChallengeTXT, persist: func(owned []string) error {
+			return updateChallengeValues(ctx, kubeClientset, secret, owned)
+		}}
+	}
+	recordingProvider := &recordingDNSProvider{Provider: challengeProvider}
+	challengeProvider = recordingProvider
+	if getBoolAnnotation(secret, annotationLetsEncryptCertificateDNSSequential, *dnsSequential) {
+		challengeProvider = &sequentialDNSProvider{Provider: challengeProvider, interval: *dnsSequentialInterval}
+	}
+	dnsChallengeOptions := []dns01.ChallengeOption{}
+	if getBoolAnnotation(secret, annotationLetsEncryptCertificateDNSDisablePropagationCheck, *dnsDisablePropagationCheck) {
+		dnsChallengeOptions = append(dnsChallengeOptions, dns01.WrapPreCheck(skipPreCheck))
+	}
+	err = legoClient.Challenge.SetDNS01Provider(challengeProvider, dnsChallengeOptions...)
+	if err != nil {
+		log.Error().Err(err)
+		return nil, err
+	}
+
+	return recordingProvider, nil
+}
+
+// parseCertificate returns the first certificate from PEM encoded data
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Decoding certificate failed")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// hasRetiredIssuer checks whether the certificate in the secret has been issued by an intermediate the CA no longer issues from; it only returns true if the issuer change policy is renew
+func hasRetiredIssuer(secret *v1.Secret, currentState LetsEncryptCertificateState, initiator string) bool {
+	if *issuerChangePolicy == "ignore" {
+		return false
+	}
+
+	certificateBytes, _ := getSecretCertificate(secret)
+	certificate, err := parseCertificate(certificateBytes)
+	if err != nil || !issuers.IsRetired(currentState.Environment, certificate.Issuer.CommonName) {
+		return false
+	}
+
+	log.Warn().Msgf("[%v] Secret %v.%v - Certificate has been issued by %v, which hasn't issued any new certificates within %v, applying issuer change policy %v...", initiator, secret.Name, secret.Namespace, certificate.Issuer.CommonName, *issuerChangeWindow, *issuerChangePolicy)
+
+	return *issuerChangePolicy == "renew"
+}
+
+// isDueForRenewal checks whether the certificate in the secret has reached its renewal time, going by its own validity period, or falls back to the age since the last renewal if the certificate can't be parsed
+func isDueForRenewal(secret *v1.Secret, lastRenewed time.Time, renewDaysBeforeExpiry int) bool {
+	return time.Now().After(getRenewalTime(secret, lastRenewed, renewDaysBeforeExpiry))
+}
+
+// getRenewalTime returns the time after which the certificate in the secret is due for renewal
+func getRenewalTime(secret *v1.Secret, lastRenewed time.Time, renewDaysBeforeExpiry int) time.Time {
+	certificateBytes, _ := getSecretCertificate(secret)
+
+	return getRenewalTimeForCertificate(certificateBytes, lastRenewed, renewDaysBeforeExpiry)
+}
+
+// getRenewalTimeForCertificate returns when the pem encoded certificate is due for renewal, based on its validity period; lastRenewed from the state is only used if the certificate can't be parsed, since it's off for certificates restored from backup or created by other tooling; without per secret margin --renew-before-days applies
+func getRenewalTimeForCertificate(certificateBytes []byte, lastRenewed time.Time, renewDaysBeforeExpiry int) time.Time {
+	certificate, err := parseCertificate(certificateBytes)
+	if err != nil {
+		return lastRenewed.Add(time.Duration(*daysBeforeRenewal) * 24 * time.Hour)
+	}
+
+	renewalTime := certificate.NotBefore.Add(time.Duration(*daysBeforeRenewal) * 24 * time.Hour)
+	if renewDaysBeforeExpiry <= 0 {
+		renewDaysBeforeExpiry = getDefaultRenewBeforeDays()
+	}
+
+	if *renewBeforePercent > 0 {
+		validity := certificate.NotAfter.Sub(certificate.NotBefore)
+		renewalTime = certificate.NotAfter.Add(-time.Duration(float64(validity) * float64(*renewBeforePercent) / 100))
+	}
+
+	// the per secret margin can only make the renewal happen earlier, for clients with skewed clocks
+	if renewDaysBeforeExpiry > 0 {
+		if earlierRenewalTime := certificate.NotAfter.Add(-time.Duration(renewDaysBeforeExpiry) * 24 * time.Hour); earlierRenewalTime.Before(renewalTime) {
+			renewalTime = earlierRenewalTime
+		}
+	}
+
+	return renewalTime
+}
+
+// getRetryAfter returns how long a secret stays locked after an attempt to obtain a certificate, from the annotation or else --retry-after-minutes
+func getRetryAfter(desiredState LetsEncryptCertificateState) time.Duration {
+	if desiredState.RetryAfterMinutes > 0 {
+		return time.Duration(desiredState.RetryAfterMinutes) * time.Minute
+	}
+
+	return time.Duration(*retryAfterMinutes) * time.Minute
+}
+
+// getStaleLockTimeout returns the age after which a lock not set by this process is released; it defaults to the retry delay, since an order of another process can take as long as that
+func getStaleLockTimeout(desiredState LetsEncryptCertificateState) time.Duration {
+	if *staleLockTimeout > 0 {
+		return *staleLockTimeout
+	}
+
+	return getRetryAfter(desiredState)
+}
+
+// getNextRenewal returns when the certificate managed through secret and stored in dataSecret is planned to be renewed with the current settings; ok is false if it hasn't been obtained yet
+func getNextRenewal(secret, dataSecret *v1.Secret) (nextRenewal time.Time, ok bool) {
+	currentState := getCurrentSecretState(secret)
+	lastRenewed, err := time.Parse(time.RFC3339, currentState.LastRenewed)
+	if err != nil {
+		return nextRenewal, false
+	}
+
+	return getSecretRenewalTime(dataSecret, lastRenewed, getDesiredSecretState(secret)), true
+}
+
+// getNextCheck returns until when a secret that has just been skipped can't need any action as long as it doesn't change, going by the certificate in dataSecret, the secret holding its certificate data; ok is false if it should be evaluated again on the next poll
+func getNextCheck(dataSecret *v1.Secret, desiredState, currentState LetsEncryptCertificateState) (nextCheck time.Time, ok bool) {
+
+	// secrets that aren't managed (anymore) only need evaluating once they change
+	if desiredState.Enabled != "true" || len(desiredState.Hostnames) == 0 || (isExpired(desiredState) && currentState.Expired) {
+		return time.Now().Add(100 * 365 * 24 * time.Hour), true
+	}
+
+	// pending changes held back by the lock on recent attempts need evaluating once the lock expires
+	if desiredState.Hostnames != currentState.Hostnames || desiredState.Environment != currentState.Environment || desiredState.ACMEDirectoryURL != currentState.ACMEDirectoryURL || desiredState.KeyType != currentState.KeyType || desiredState.DualKeyTypes != currentState.DualKeyTypes || desiredState.CopyToAllNamespaces != currentState.CopyToAllNamespaces || desiredState.CopyToNamespaces != currentState.CopyToNamespaces || desiredState.UploadToCloudflare != currentState.UploadToCloudflare {
+		return nextCheck, false
+	}
+
+	lastRenewed, err := time.Parse(time.RFC3339, currentState.LastRenewed)
+	if err != nil {
+		return nextCheck, false
+	}
+
+	// re-evaluate at least every ttl to pick up changes outside the secret itself, like a replaced target secret or an expiring certificate at cloudflare
+	nextCheck = time.Now().Add(*stateCacheTTL)
+	if renewalTime := getSecretRenewalTime(dataSecret, lastRenewed, desiredState); renewalTime.Before(nextCheck) {
+		nextCheck = renewalTime
+	}
+
+	return nextCheck, true
+}
+
+// getCertificateDataHash returns a hash over the certificate and private key, to detect changes made outside of the controller
+func getCertificateDataHash(certificate, privateKey []byte) string {
+	hash := sha256.Sum256(bytes.Join([][]byte{certificate, privateKey}, []byte{}))
+	return hex.EncodeToString(hash[:])
+}
+
+// handleTamperedSecret detects whether tls.crt or tls.key no longer match the data written by the controller and applies the tamper policy; it returns true if the certificate should be reissued
+func handleTamperedSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, currentState *LetsEncryptCertificateState) (tampered bool, err error) {
+
+	if desiredState.Enabled != "true" || currentState.DataHash == "" {
+		return false, nil
+	}
+
+	certificateBytes, privateKey := getSecretCertificate(dataSecret)
+	if currentState.SeparateKeySecret {
+		privateKey = nil
+	}
+	dataHash := getCertificateDataHash(certificateBytes, privateKey)
+	if dataHash == currentState.DataHash {
+		return false, nil
+	}
+
+	log.Warn().Msgf("[%v] Secret %v.%v - Certificate data has been changed outside of the controller, applying tamper policy %v...", initiator, secret.Name, secret.Namespace, *tamperPolicy)
+
+	err = postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "Tampered", "TamperedData", fmt.Sprintf("Certificate data in secret %v has been changed outside of the controller, applying tamper policy %v", secret.Name, *tamperPolicy), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting tamper event failed", initiator, secret.Name, secret.Namespace)
+	}
+
+	switch *tamperPolicy {
+	case "restore":
+		return true, nil
+
+	case "adopt":
+		currentState.DataHash = dataHash
+
+		letsEncryptCertificateStateByteArray, err := json.Marshal(currentState)
+		if err != nil {
+			return false, err
+		}
+		secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+
+		setLastChangedBy(secret, initiator)
+		updatedSecret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Adopting certificate data has failed", initiator, secret.Name, secret.Namespace)
+			return false, err
+		}
+		*secret = *updatedSecret
+	}
+
+	return false, nil
+}
+
+// privateKeyDataKeys are the secret data keys that contain the private key
+var privateKeyDataKeys = []string{"ssl.key", "ssl.pem", "ssl.json", "tls.key", "tls.pem", "tls.json", "tls-ecdsa.key", "tls-rsa.key"}
+
+// moveKeysToSeparateSecret moves all data items holding private key material from the secret to a secret named <name>-key in the same namespace
+func moveKeysToSeparateSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string) error {
+
+	keySecretName := fmt.Sprintf("%v-key", secret.Name)
+
+	keyData := map[string][]byte{}
+	for _, key := range privateKeyDataKeys {
+		if value, ok := secret.Data[key]; ok {
+			keyData[key] = value
+			delete(secret.Data, key)
+		}
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Storing private key in secret %v...", initiator, secret.Name, secret.Namespace, keySecretName)
+
+	keySecret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, keySecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		keySecret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      keySecretName,
+				Namespace: secret.Namespace,
+				Labels:    secret.Labels,
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateLinkedSecret: fmt.Sprintf("%v/%v", secret.Namespace, secret.Name),
+				},
+			},
+			Data: keyData,
+		}
+
+		setLastChangedBy(keySecret, initiator)
+		_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Create(ctx, keySecret, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	keySecret.Data = keyData
+	_, err = updateSecretData(ctx, kubeClientset, keySecret, initiator)
+	return err
+}
+
+// distributeCertificates copies the certificates to all namespaces and uploads them to cloudflare if desired, and afterwards rolls the state forward to reflect the phases that succeeded
+func distributeCertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, initiator, copyInitiator string, desiredState, currentState LetsEncryptCertificateState, certificate, privateKey []byte) (err error) {
+
+	if !desiredState.CopyToAllNamespaces && desiredState.CopyToNamespaces == "" && !desiredState.UploadToCloudflare {
+		return nil
+	}
+
+	// a failing phase doesn't prevent the other one from running, each outcome is recorded in the state separately
+	var copyErr, uploadErr error
+
+	if desiredState.CopyToAllNamespaces || desiredState.CopyToNamespaces != "" {
+		// copy to all other namespaces or the ones matching the regex, if either annotation is set
+		copyErr = copySecretToAllNamespaces(ctx, kubeClientset, secret, dataSecret, initiator, copyInitiator)
+		currentState.CopyStatus = getPhaseStatus(copyErr)
+		if copyErr != nil {
+			postPhaseFailedEvent(ctx, kubeClientset, secret, initiator, "copy", "FailedCopy", copyErr)
+		} else {
+			currentState.CopyToAllNamespaces = desiredState.CopyToAllNamespaces
+			currentState.CopyToNamespaces = desiredState.CopyToNamespaces
+		}
+	}
+
+	if desiredState.UploadToCloudflare {
+		// upload certificate to cloudflare for each hostname
+		var authentication APIAuthentication
+		authentication, uploadErr = getCloudflareAuthenticationForSecret(ctx, kubeClientset, secret.Namespace, desiredState)
+		if uploadErr == nil {
+			var targets []cloudflareUploadTarget
+			targets, uploadErr = uploadToCloudflare(authentication, desiredState.Hostnames, desiredState.CloudflareZone, certificate, privateKey)
+			currentState.UploadTargets = mergeCloudflareUploadTargets(currentState.UploadTargets, targets)
+		}
+		currentState.UploadStatus = getPhaseStatus(uploadErr)
+		if uploadErr != nil {
+			postPhaseFailedEvent(ctx, kubeClientset, secret, initiator, "upload", "FailedUpload", uploadErr)
+		} else {
+			currentState.UploadToCloudflare = true
+		}
+	}
+
+	err = updateSecretState(ctx, kubeClientset, secret, currentState)
+	if err != nil {
+		return err
+	}
+
+	if copyErr != nil {
+		return copyErr
+	}
+
+	return uploadErr
+}
+
+// hasFailedPhase returns true if copying or uploading the stored certificate failed
+func hasFailedPhase(state LetsEncryptCertificateState) bool {
+	return state.CopyStatus == "failed" || state.UploadStatus == "failed"
+}
+
+// retryFailedPhases runs the failed copy and upload phases again with the certificate stored in the secret
+func retryFailedPhases(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, initiator string, desiredState, currentState LetsEncryptCertificateState) error {
+
+	certificateBytes, privateKey := getSecretCertificate(dataSecret)
+	if certificateBytes == nil || privateKey == nil {
+		return nil
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Retrying failed phases (copy: %v, upload: %v) with the stored certificate...", initiator, secret.Name, secret.Namespace, currentState.CopyStatus, currentState.UploadStatus)
+
+	// leave out the phases that succeeded
+	retryState := desiredState
+	if currentState.CopyStatus != "failed" {
+		retryState.CopyToAllNamespaces = false
+		retryState.CopyToNamespaces = ""
+	}
+	if currentState.UploadStatus != "failed" {
+		retryState.UploadToCloudflare = false
+	}
+
+	return distributeCertificates(ctx, kubeClientset, secret, dataSecret, initiator, "drift-repair", retryState, currentState, certificateBytes, privateKey)
+}
+
+// getPhaseStatus returns the status to record in the state for a phase that returned err
+func getPhaseStatus(err error) string {
+	if err != nil {
+		return "failed"
+	}
+
+	return "succeeded"
+}
+
+// postPhaseFailedEvent logs the failure of a phase and emits a warning event about it, so partial failures after storing the certificate are visible
+func postPhaseFailedEvent(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator, phase, reason string, phaseErr error) {
+	log.Error().Err(phaseErr).Msgf("[%v] Secret %v.%v - Phase %v has failed", initiator, secret.Name, secret.Namespace, phase)
+
+	err := postEventAboutStatus(ctx, kubeClientset, secret, "Warning", strings.Title(phase), reason, fmt.Sprintf("Certificate for secret %v has been stored, but phase %v failed: %v", secret.Name, phase, phaseErr), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting %v phase event failed", initiator, secret.Name, secret.Namespace, phase)
+	}
+}
+
+// updateSecretState stores the state in the state annotation of the latest version of the secret
+func updateSecretState(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, state LetsEncryptCertificateState) error {
+
+	// reload secret to avoid object has been modified error
+	secret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	// the challenge record values are only maintained by the dns provider during an order, keep the stored ones
+	state.ChallengeValues = getCurrentSecretState(secret).ChallengeValues
+	letsEncryptCertificateStateByteArray, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+
+	_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// writeCertificatesToSecretData stores the certificate, private key and issuer certificate in the ssl.* and/or tls.* data items of the secret, depending on outputFamilies; the lego CertResource is only stored in the .json items if writeJSON is set, since it holds the private key and acme urls a second time, and stale .json items are removed otherwise
+func writeCertificatesToSecretData(secret *v1.Secret, certificates *certificate.Resource, outputFamilies string, writeJSON bool) error {
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	families := getOutputFamilies(outputFamilies)
+
+	// ssl keys for legacy usage and tls keys for ingress object
+	for _, family := range families {
+		secret.Data[family+".crt"] = certificates.Certificate
+		secret.Data[family+".key"] = certificates.PrivateKey
+		secret.Data[family+".pem"] = bytes.Join([][]byte{certificates.Certificate, certificates.PrivateKey}, []byte{})
+		if certificates.IssuerCertificate != nil {
+			secret.Data[family+".issuer.crt"] = certificates.IssuerCertificate
+		}
+		delete(secret.Data, family+".json")
+	}
+
+	if !writeJSON {
+		return nil
+	}
+
+	jsonBytes, err := json.MarshalIndent(certificates, "", "\t")
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		secret.Data[family+".json"] = jsonBytes
+	}
+
+	return nil
+}
+
+// maxSecretSize is the size limit kubernetes (etcd) enforces for secrets
+const maxSecretSize = 1024 * 1024
+
+// getSecretSize returns the approximate stored size of a secret, counting its data, labels and annotations
+func getSecretSize(secret *v1.Secret) (size int) {
+	for key, value := range secret.Data {
+		size += len(key) + len(value)
+	}
+	for key, value := range secret.StringData {
+		size += len(key) + len(value)
+	}
+	for key, value := range secret.Labels {
+		size += len(key) + len(value)
+	}
+	for key, value := range secret.Annotations {
+		size += len(key) + len(value)
+	}
+
+	return size
+}
+
+// enforceSecretSizeLimit applies --secret-size-policy when the secret gets within --secret-size-warn-percent of the size limit, and fails if it exceeds the limit
+func enforceSecretSizeLimit(secret *v1.Secret, initiator string) error {
+	size := getSecretSize(secret)
+	if size*100 < maxSecretSize*(*secretSizeWarnPercent) {
+		return nil
+	}
+
+	if *secretSizePolicy == "drop-json" {
+		// the json items hold the certificate and key a second time, so they're the first to go
+		for key := range secret.Data {
+			if strings.HasSuffix(key, ".json") {
+				delete(secret.Data, key)
+			}
+		}
+		droppedSize := getSecretSize(secret)
+		log.Warn().Msgf("[%v] Secret %v.%v - Secret size of %v bytes is close to the limit of %v bytes, dropped json data items to get to %v bytes", initiator, secret.Name, secret.Namespace, size, maxSecretSize, droppedSize)
+		size = droppedSize
+	}
+
+	if size > maxSecretSize {
+		return fmt.Errorf("Secret size of %v bytes exceeds the limit of %v bytes", size, maxSecretSize)
+	}
+	if *secretSizePolicy == "fail" {
+		return fmt.Errorf("Secret size of %v bytes is over %v%% of the limit of %v bytes", size, *secretSizeWarnPercent, maxSecretSize)
+	}
+	if *secretSizePolicy == "warn" {
+		log.Warn().Msgf("[%v] Secret %v.%v - Secret size of %v bytes is over %v%% of the limit of %v bytes", initiator, secret.Name, secret.Namespace, size, *secretSizeWarnPercent, maxSecretSize)
+	}
+
+	return nil
+}
+
+// getOutputFamilies returns the prefixes of the data items to write for the output-families annotation value tls, ssl or both
+func getOutputFamilies(outputFamilies string) []string {
+	switch outputFamilies {
+	case "tls":
+		return []string{"tls"}
+	case "ssl":
+		return []string{"ssl"}
+	}
+
+	return []string{"ssl", "tls"}
+}
+
+// getSecretCertificate returns the certificate and private key stored in the secret, from the tls.* items or otherwise the ssl.* items
+func getSecretCertificate(secret *v1.Secret) (certificate, privateKey []byte) {
+	if certificate, ok := secret.Data["tls.crt"]; ok {
+		return certificate, secret.Data["tls.key"]
+	}
+
+	return secret.Data["ssl.crt"], secret.Data["ssl.key"]
+}
+
+// getTargetSecret returns the secret with name targetSecretName in the namespace of the annotated secret, or a new unsaved secret if it doesn't exist yet
+func getTargetSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, targetSecretName string) (*v1.Secret, error) {
 
-		// load account.json
-		log.Info().Msgf("[%v] Secret %v.%v - Loading account.json...", initiator, secret.Name, secret.Namespace)
-		fileBytes, err := ioutil.ReadFile("/account/account.json")
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
+	targetSecret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, targetSecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      targetSecretName,
+				Namespace: secret.Namespace,
+				Labels:    secret.Labels,
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateLinkedSecret: fmt.Sprintf("%v/%v", secret.Namespace, secret.Name),
+				},
+			},
+			Type: v1.SecretTypeOpaque,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		var letsEncryptUser LetsEncryptUser
-		err = json.Unmarshal(fileBytes, &letsEncryptUser)
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
+	if targetSecret.Annotations == nil {
+		targetSecret.Annotations = map[string]string{}
+	}
 
-		// load private key
-		log.Info().Msgf("[%v] Secret %v.%v - Loading account.key...", initiator, secret.Name, secret.Namespace)
-		privateKey, err := loadPrivateKey("/account/account.key")
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
-		letsEncryptUser.key = privateKey
+	return targetSecret, nil
+}
 
-		log.Info().Msgf("[%v] Secret %v.%v - Creating lego config...", initiator, secret.Name, secret.Namespace)
-		config := lego.NewConfig(&letsEncryptUser)
+// getCertificateDataSecret returns the secret holding the certificate data of an annotated secret, which is the target secret if configured, otherwise the annotated secret itself; a target secret that doesn't exist yet is returned as an empty secret, any other error getting it is returned so the missing data isn't mistaken for tampering
+func getCertificateDataSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, desiredState LetsEncryptCertificateState) (*v1.Secret, error) {
 
-		// create letsencrypt lego client
-		log.Info().Msgf("[%v] Secret %v.%v - Creating lego client...", initiator, secret.Name, secret.Namespace)
-		legoClient, err := lego.NewClient(config)
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
+	if desiredState.TargetSecret == "" {
+		return secret, nil
+	}
 
-		// get dns challenge
-		log.Info().Msgf("[%v] Secret %v.%v - Creating cloudflare provider...", initiator, secret.Name, secret.Namespace)
-		cloudflareConfig := cloudflare.NewDefaultConfig()
-		cloudflareConfig.AuthEmail = *cfAPIEmail
-		cloudflareConfig.AuthKey = *cfAPIKey
-		cloudflareConfig.PropagationTimeout = 10 * time.Minute
+	targetSecret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, desiredState.TargetSecret, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &v1.Secret{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		cloudflareProvider, err := cloudflare.NewDNSProviderConfig(cloudflareConfig)
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
+	return targetSecret, nil
+}
 
-		// clean up acme challenge records in advance
-		// for _, hostname := range hostnames {
-		// 	log.Info().Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v...", initiator, secret.Name, secret.Namespace, hostname)
-		// 	err = cloudflareProvider.CleanUp(hostname, "", "123d==")
-		// 	if err != nil {
-		// 		log.Info().Err(err).Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v failed", initiator, secret.Name, secret.Namespace, hostname)
-		// 	}
-		// }
+// copyNameTemplateData is the data available in the copy name template annotation
+type copyNameTemplateData struct {
+	SourceName      string
+	SourceNamespace string
+	Namespace       string
+}
 
-		// set challenge provider
-		legoClient.Challenge.SetDNS01Provider(cloudflareProvider)
+// getCopyName returns the name for the copy of the secret in the namespace, based on the copy name mapping or template annotations of the annotated secret; it defaults to the name of the secret holding the certificates
+func getCopyName(secret, dataSecret *v1.Secret, namespace string) (string, error) {
 
-		// get certificate
-		log.Info().Msgf("[%v] Secret %v.%v - Obtaining certificate...", initiator, secret.Name, secret.Namespace)
-		request := certificate.ObtainRequest{
-			Domains: hostnames,
-			Bundle:  true,
+	// a fixed mapping for the namespace takes precedence over the template
+	if copyNameMapping, ok := secret.Annotations[annotationLetsEncryptCertificateCopyNameMapping]; ok {
+		for _, mapping := range strings.Split(copyNameMapping, ",") {
+			parts := strings.SplitN(strings.TrimSpace(mapping), "=", 2)
+			if len(parts) == 2 && parts[0] == namespace {
+				return parts[1], nil
+			}
 		}
-		certificates, err := legoClient.Certificate.Obtain(request)
+	}
 
-		// if obtaining secret failed exit and retry after more than 15 minutes
-		if err != nil {
-			log.Error().Err(err).Msgf("Could not obtain certificates for domains %v due to error", hostnames)
-			return status, err
-		}
-		if certificates == nil {
-			log.Error().Msgf("Could not obtain certificates for domains %v, certificates are empty", hostnames)
-			return status, err
-		}
+	copyNameTemplate, ok := secret.Annotations[annotationLetsEncryptCertificateCopyNameTemplate]
+	if !ok {
+		return dataSecret.Name, nil
+	}
 
-		// clean up acme challenge records afterwards
-		// for _, hostname := range hostnames {
-		// 	log.Info().Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v...", initiator, secret.Name, secret.Namespace, hostname)
-		// 	err = cloudflareProvider.CleanUp(hostname, "", "123d==")
-		// 	if err != nil {
-		// 		log.Info().Err(err).Msgf("[%v] Secret %v.%v - Cleaning up TXT record _acme-challenge.%v failed", initiator, secret.Name, secret.Namespace, hostname)
-		// 	}
-		// }
+	tmpl, err := template.New("copyName").Parse(copyNameTemplate)
+	if err != nil {
+		return "", err
+	}
 
-		// reload secret to avoid object has been modified error
-		secret, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
+	var copyName bytes.Buffer
+	err = tmpl.Execute(&copyName, copyNameTemplateData{
+		SourceName:      dataSecret.Name,
+		SourceNamespace: dataSecret.Namespace,
+		Namespace:       namespace,
+	})
+	if err != nil {
+		return "", err
+	}
 
-		// update the secret
-		currentState = desiredState
-		currentState.LastRenewed = time.Now().Format(time.RFC3339)
+	return copyName.String(), nil
+}
 
-		log.Info().Msgf("[%v] Secret %v.%v - Updating secret because new certificates have been obtained...", initiator, secret.Name, secret.Namespace)
+// copySkip records why copying a secret to a namespace has been skipped
+type copySkip struct {
+	Reason string `json:"reason"`
+	Time   string `json:"time"`
+}
 
-		// serialize state and store it in the annotation
-		letsEncryptCertificateStateByteArray, err = json.Marshal(currentState)
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
-		secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+func copySecretToAllNamespaces(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, initiator, copyInitiator string) (err error) {
 
-		// store the certificates
-		if secret.Data == nil {
-			secret.Data = make(map[string][]byte)
+	// get all namespaces
+	namespaces, err := kubeClientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	// loop namespaces, continuing with the next namespace on failure so a single broken namespace doesn't keep the others from getting updated certificates
+	desiredState := getDesiredSecretState(secret)
+	copySkips := map[string]copySkip{}
+	copyErrors := []error{}
+	for _, ns := range namespaces.Items {
+		if !shouldCopyToNamespace(desiredState, ns.Name) {
+			continue
 		}
+		err := copySecretToNamespace(ctx, kubeClientset, secret, dataSecret, &ns, initiator)
+		if errors.IsForbidden(err) || errors.IsInvalid(err) {
+			certificateCopyTotals.With(prometheus.Labels{"namespace": ns.Name, "status": "skipped", "initiator": copyInitiator}).Inc()
 
-		log.Info().Msgf("[%v] Secret %v.%v - Secret has %v data items before writing the certificates...", initiator, secret.Name, secret.Namespace, len(secret.Data))
+			// quota or admission policies in the namespace prevent the copy, record it and continue with the other namespaces
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Copying secret to namespace %v is not allowed, skipping it", initiator, secret.Name, secret.Namespace, ns.Name)
+			copySkips[ns.Name] = copySkip{Reason: err.Error(), Time: time.Now().Format(time.RFC3339)}
 
-		// ssl keys
-		secret.Data["ssl.crt"] = certificates.Certificate
-		secret.Data["ssl.key"] = certificates.PrivateKey
-		secret.Data["ssl.pem"] = bytes.Join([][]byte{certificates.Certificate, certificates.PrivateKey}, []byte{})
-		if certificates.IssuerCertificate != nil {
-			secret.Data["ssl.issuer.crt"] = certificates.IssuerCertificate
+			eventErr := postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "CopySkipped", "SkippedCopy", fmt.Sprintf("Copying secret %v to namespace %v is not allowed: %v", secret.Name, ns.Name, err), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+			if eventErr != nil {
+				log.Warn().Err(eventErr).Msgf("[%v] Secret %v.%v - Posting copy skipped event failed", initiator, secret.Name, secret.Namespace)
+			}
+			continue
 		}
-
-		jsonBytes, err := json.MarshalIndent(certificates, "", "\t")
 		if err != nil {
-			log.Error().Msgf("[%v] Secret %v.%v - Unable to marshal CertResource for domain %s\n\t%s", initiator, secret.Name, secret.Namespace, certificates.Domain, err.Error())
-			return status, err
+			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Copying secret to namespace %v failed", initiator, secret.Name, secret.Namespace, ns.Name)
+			certificateCopyTotals.With(prometheus.Labels{"namespace": ns.Name, "status": "failed", "initiator": copyInitiator}).Inc()
+			copyErrors = append(copyErrors, fmt.Errorf("namespace %v: %w", ns.Name, err))
+			continue
 		}
-		secret.Data["ssl.json"] = jsonBytes
+		certificateCopyTotals.With(prometheus.Labels{"namespace": ns.Name, "status": "succeeded", "initiator": copyInitiator}).Inc()
+	}
 
-		// tls keys for ingress object
-		secret.Data["tls.crt"] = certificates.Certificate
-		secret.Data["tls.key"] = certificates.PrivateKey
-		secret.Data["tls.pem"] = bytes.Join([][]byte{certificates.Certificate, certificates.PrivateKey}, []byte{})
-		if certificates.IssuerCertificate != nil {
-			secret.Data["tls.issuer.crt"] = certificates.IssuerCertificate
-		}
-		secret.Data["tls.json"] = jsonBytes
+	err = updateCopySkips(ctx, kubeClientset, secret, copySkips)
+	if err != nil {
+		copyErrors = append(copyErrors, err)
+	}
 
-		log.Info().Msgf("[%v] Secret %v.%v - Secret has %v data items after writing the certificates...", initiator, secret.Name, secret.Namespace, len(secret.Data))
+	if len(copyErrors) > 0 {
+		return fmt.Errorf("Copying secret %v.%v failed for %v out of %v namespaces: %w", secret.Name, secret.Namespace, len(copyErrors), len(namespaces.Items), utilerrors.NewAggregate(copyErrors))
+	}
 
-		// update secret, because the data and state annotation have changed
-		_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
-		if err != nil {
-			log.Error().Err(err)
-			return status, err
-		}
+	return nil
+}
 
-		status = "succeeded"
+// removeCopySkips removes the skips recorded for namespaces from a secret's copy-skips annotation, if any
+func removeCopySkips(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, namespaces []string) error {
 
-		log.Info().Msgf("[%v] Secret %v.%v - Certificates have been stored in secret successfully...", initiator, secret.Name, secret.Namespace)
+	copySkipsValue, ok := secret.Annotations[annotationLetsEncryptCertificateCopySkips]
+	if !ok {
+		return nil
+	}
 
-		if desiredState.CopyToAllNamespaces {
-			// copy to other namespaces if annotation is set to true
-			err = copySecretToAllNamespaces(ctx, kubeClientset, secret, initiator)
-			if err != nil {
-				return status, err
-			}
-		}
+	copySkips := map[string]copySkip{}
+	err := json.Unmarshal([]byte(copySkipsValue), &copySkips)
+	if err != nil {
+		return err
+	}
+	numberOfCopySkips := len(copySkips)
+	for _, namespace := range namespaces {
+		delete(copySkips, namespace)
+	}
+	if len(copySkips) == numberOfCopySkips {
+		return nil
+	}
 
-		if desiredState.UploadToCloudflare {
-			// upload certificate to cloudflare for each hostname
-			err = uploadToCloudflare(desiredState.Hostnames, certificates.Certificate, certificates.PrivateKey)
-			if err != nil {
-				return status, err
-			}
-		}
+	return updateCopySkips(ctx, kubeClientset, secret, copySkips)
+}
 
-		return status, nil
+// shouldCopyToNamespace returns true if the secret has to be copied to all namespaces or the namespace matches the copy-to-namespaces-matching regex, unless the cluster configuration excludes the namespace
+func shouldCopyToNamespace(desiredState LetsEncryptCertificateState, namespace string) bool {
+	if isExcludedFromCopy(namespace) {
+		return false
+	}
+	if desiredState.CopyToAllNamespaces {
+		return true
+	}
+	if desiredState.CopyToNamespaces == "" {
+		return false
 	}
 
-	status = "skipped"
+	matches, err := regexp.MatchString(desiredState.CopyToNamespaces, namespace)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Regex %v to match namespaces to copy to is invalid", desiredState.CopyToNamespaces)
+		return false
+	}
 
-	return status, nil
+	return matches
 }
 
-func copySecretToAllNamespaces(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string) (err error) {
+// updateCopySkips stores the namespaces the secret couldn't be copied to in an annotation on the secret, or removes the annotation if there are none
+func updateCopySkips(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, copySkips map[string]copySkip) error {
 
-	// get all namespaces
-	namespaces, err := kubeClientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	// reload secret to avoid object has been modified error
+	secret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
 
-	// loop namespaces
-	for _, ns := range namespaces.Items {
-		err := copySecretToNamespace(ctx, kubeClientset, secret, &ns, initiator)
+	_, hasCopySkips := secret.Annotations[annotationLetsEncryptCertificateCopySkips]
+	if len(copySkips) == 0 && !hasCopySkips {
+		return nil
+	}
+
+	if len(copySkips) == 0 {
+		delete(secret.Annotations, annotationLetsEncryptCertificateCopySkips)
+	} else {
+		copySkipsBytes, err := json.Marshal(copySkips)
 		if err != nil {
 			return err
 		}
+		secret.Annotations[annotationLetsEncryptCertificateCopySkips] = string(copySkipsBytes)
 	}
 
-	return nil
+	_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
 }
 
-func copySecretToNamespace(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, namespace *v1.Namespace, initiator string) error {
+// copySecretToNamespace copies the certificates in dataSecret to the namespace, using the copy settings of the annotated secret
+func copySecretToNamespace(ctx context.Context, kubeClientset *kubernetes.Clientset, secret, dataSecret *v1.Secret, namespace *v1.Namespace, initiator string) error {
 
-	if namespace.Name == secret.Namespace || namespace.Status.Phase != v1.NamespaceActive {
+	if namespace.Name == dataSecret.Namespace || namespace.Status.Phase != v1.NamespaceActive {
 		return nil
 	}
 
-	log.Info().Msgf("[%v] Secret %v.%v - Copying secret to namespace %v...", initiator, secret.Name, secret.Namespace, namespace.Name)
+	copyName, err := getCopyName(secret, dataSecret, namespace.Name)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("[%v] Secret %v.%v - Copying secret to namespace %v as %v...", initiator, dataSecret.Name, dataSecret.Namespace, namespace.Name, copyName)
+
+	copyData := getCopyData(dataSecret.Data, getBoolAnnotation(secret, annotationLetsEncryptCertificateCopyPrivateKey, true))
 
 	// check if secret with same name already exists
-	secretInNamespace, err := kubeClientset.CoreV1().Secrets(namespace.Name).Get(ctx, secret.Name, metav1.GetOptions{})
+	secretInNamespace, err := kubeClientset.CoreV1().Secrets(namespace.Name).Get(ctx, copyName, metav1.GetOptions{})
 	if errors.IsNotFound(err) {
 		// doesn't exist, create new secret
 		secretInNamespace = &v1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      secret.Name,
+				Name:      copyName,
 				Namespace: namespace.Name,
-				Labels:    secret.Labels,
+				Labels:    dataSecret.Labels,
 				Annotations: map[string]string{
-					annotationLetsEncryptCertificateLinkedSecret: fmt.Sprintf("%v/%v", secret.Namespace, secret.Name),
-					annotationLetsEncryptCertificateState:        secret.Annotations[annotationLetsEncryptCertificateState],
+					annotationLetsEncryptCertificateLinkedSecret: fmt.Sprintf("%v/%v", dataSecret.Namespace, dataSecret.Name),
+					annotationLetsEncryptCertificateState:        dataSecret.Annotations[annotationLetsEncryptCertificateState],
 				},
 			},
-			Data: secret.Data,
+			Data: copyData,
 		}
 
+		setLastChangedBy(secretInNamespace, initiator)
 		_, err = kubeClientset.CoreV1().Secrets(namespace.Name).Create(ctx, secretInNamespace, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -609,13 +2439,16 @@ func copySecretToNamespace(ctx context.Context, kubeClientset *kubernetes.Client
 	}
 
 	// already exists
-	log.Info().Msgf("[%v] Secret %v.%v - Already exists in namespace %v, updating data...", initiator, secret.Name, secret.Namespace, namespace.Name)
+	log.Info().Msgf("[%v] Secret %v.%v - Already exists in namespace %v as %v, updating data...", initiator, dataSecret.Name, dataSecret.Namespace, namespace.Name, copyName)
 
-	// update data in secret
-	secretInNamespace.Data = secret.Data
-	secretInNamespace.Annotations[annotationLetsEncryptCertificateState] = secret.Annotations[annotationLetsEncryptCertificateState]
+	// update data in secret, removing the private key from copies made before it was restricted
+	secretInNamespace.Data = copyData
+	if secretInNamespace.Annotations == nil {
+		secretInNamespace.Annotations = map[string]string{}
+	}
+	secretInNamespace.Annotations[annotationLetsEncryptCertificateState] = dataSecret.Annotations[annotationLetsEncryptCertificateState]
 
-	_, err = kubeClientset.CoreV1().Secrets(namespace.Name).Update(ctx, secretInNamespace, metav1.UpdateOptions{})
+	_, err = updateSecretData(ctx, kubeClientset, secretInNamespace, initiator)
 	if err != nil {
 		return err
 	}
@@ -623,6 +2456,26 @@ func copySecretToNamespace(ctx context.Context, kubeClientset *kubernetes.Client
 	return nil
 }
 
+// getCopyData returns the data items to copy to other namespaces; without copyPrivateKey the items holding the private key are left out, so consumers that only need to trust the certificate don't get the key
+func getCopyData(data map[string][]byte, copyPrivateKey bool) map[string][]byte {
+	if copyPrivateKey {
+		return data
+	}
+
+	copyData := map[string][]byte{}
+	for key, value := range data {
+		copyData[key] = value
+	}
+	for key := range copyData {
+		// the keys of the dual key types, sni and previous certificates
+		if containsString(privateKeyDataKeys, key) || (strings.HasPrefix(key, "tls-") && strings.HasSuffix(key, ".key")) {
+			delete(copyData, key)
+		}
+	}
+
+	return copyData
+}
+
 func isEventExist(ctx context.Context, kubeClientset *kubernetes.Clientset, namespace string, name string) (*v1.Event, string, error) {
 	event, err := kubeClientset.CoreV1().Events(namespace).Get(ctx, name, metav1.GetOptions{})
 	if errors.IsNotFound(err) {
@@ -637,6 +2490,17 @@ func isEventExist(ctx context.Context, kubeClientset *kubernetes.Clientset, name
 }
 
 func postEventAboutStatus(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, eventType string, action string, reason string, message string, kind string, reportingController string, reportingInstance string) (err error) {
+	eventType = getEventType(eventType, *successEventType, *failureEventType)
+	if eventType == "none" {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			eventFailureTotals.With(prometheus.Labels{"namespace": secret.Namespace, "reason": reason}).Inc()
+		}
+	}()
+
 	now := time.Now().UTC()
 	count := int32(1)
 	eventName := fmt.Sprintf("%v-%v", secret.Name, action)
@@ -655,6 +2519,12 @@ func postEventAboutStatus(ctx context.Context, kubeClientset *kubernetes.Clients
 		eventResp.Message = message
 		eventResp.Count = count
 		eventResp.LastTimestamp = metav1.NewTime(now)
+		if *eventSeries {
+			eventResp.Series = &v1.EventSeries{
+				Count:            count,
+				LastObservedTime: metav1.NewMicroTime(now),
+			}
+		}
 		_, err = kubeClientset.CoreV1().Events(secret.Namespace).Update(ctx, eventResp, metav1.UpdateOptions{})
 
 		if err != nil {
@@ -706,25 +2576,80 @@ func postEventAboutStatus(ctx context.Context, kubeClientset *kubernetes.Clients
 	return
 }
 
+// getEventType maps the Normal and Warning event types used for successful and failed actions to the configured types, which can be none to not emit the event
+func getEventType(eventType, successType, failureType string) string {
+	if eventType == "Warning" {
+		return failureType
+	}
+
+	return successType
+}
+
 func processSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string) (status string, err error) {
 	status = "failed"
 
 	if secret != nil {
 
+		// promote a staging certificate to production by dropping the staging annotation, which makes the environment differ from the state and reissues the certificate
+		if getBoolAnnotation(secret, annotationLetsEncryptCertificatePromote, false) {
+			promotedSecret, err := promoteSecret(ctx, kubeClientset, secret, initiator)
+			if err != nil {
+				log.Error().Err(err).Msgf("[%v] Secret %v.%v - Promoting to production failed", initiator, secret.Name, secret.Namespace)
+				return status, err
+			}
+			secret = promotedSecret
+		}
+
+		// skip unchanged secrets that didn't need any action when last evaluated, without re-reading them from the api
+		if initiator == "poller" && *stateCacheTTL > 0 && secretStates.CanSkip(secret) {
+			certificateSkippedTotals.With(prometheus.Labels{"initiator": initiator, "reason": "cached"}).Inc()
+			return "skipped", nil
+		}
+
 		desiredState := getDesiredSecretState(secret)
 		currentState := getCurrentSecretState(secret)
 		status, err = makeSecretChanges(ctx, kubeClientset, secret, initiator, desiredState, currentState)
 
+		// renew the certificates of an sni bundle independently of the main certificate, once it's in order; a removed bundle leaves its state behind until its certificates are cleaned up
+		hasSNICertificates := hasSNIBundle(secret)
+		if hasSNICertificates && err == nil {
+			sniStatus, sniErr := renewSNICertificates(ctx, kubeClientset, secret, initiator, desiredState)
+			if sniErr != nil || sniStatus == "succeeded" {
+				status, err = sniStatus, sniErr
+			}
+		}
+
+		// a secret skipped because issuance is paused might need action as soon as it's resumed; sni bundles aren't cached since their certificates have their own renewal times
+		if status == "skipped" && err == nil && *stateCacheTTL > 0 && !controllerPause.IsPaused() && !hasSNICertificates {
+			if dataSecret, dataErr := getCertificateDataSecret(ctx, kubeClientset, secret, desiredState); dataErr == nil {
+				if nextCheck, ok := getNextCheck(dataSecret, desiredState, currentState); ok {
+					secretStates.Set(secret, nextCheck)
+				}
+			}
+		}
+
+		secretKey := fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)
 		if err != nil {
-			log.Error().Err(err).Msgf("[%v] Secret %v.%v - Error occurred...", initiator, secret.Name, secret.Namespace)
+			if ok, suppressed := secretErrors.Sample(secretKey, err); ok {
+				log.Error().Err(err).Int("suppressed", suppressed).Msgf("[%v] Secret %v.%v - Error occurred...", initiator, secret.Name, secret.Namespace)
+			}
+		} else {
+			secretErrors.Clear(secretKey)
 		}
 
+		// failing to post the event, for example due to restrictive rbac in the namespace, doesn't change the outcome of processing the secret
 		if status == "failed" {
-			err = postEventAboutStatus(ctx, kubeClientset, secret, "Warning", strings.Title(status), "FailedObtain", fmt.Sprintf("Certificate for secret %v obtaining failed", secret.Name), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+			eventErr := postEventAboutStatus(ctx, kubeClientset, secret, "Warning", strings.Title(status), "FailedObtain", fmt.Sprintf("Certificate for secret %v obtaining failed", secret.Name), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+			if eventErr != nil {
+				log.Warn().Err(eventErr).Msgf("[%v] Secret %v.%v - Posting failed obtain event failed", initiator, secret.Name, secret.Namespace)
+			}
 			return
 		}
 		if status == "succeeded" {
-			err = postEventAboutStatus(ctx, kubeClientset, secret, "Normal", strings.Title(status), "SuccessfulObtain", fmt.Sprintf("Certificate for secret %v has been obtained succesfully", secret.Name), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+			eventErr := postEventAboutStatus(ctx, kubeClientset, secret, "Normal", strings.Title(status), "SuccessfulObtain", fmt.Sprintf("Certificate for secret %v has been obtained succesfully", secret.Name), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+			if eventErr != nil {
+				log.Warn().Err(eventErr).Msgf("[%v] Secret %v.%v - Posting successful obtain event failed", initiator, secret.Name, secret.Namespace)
+			}
 			return
 		}
 	}
@@ -733,6 +2658,18 @@ func processSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, sec
 	return status, nil
 }
 
+// promoteSecret removes the staging and promote annotations from the secret
+func promoteSecret(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string) (*v1.Secret, error) {
+
+	log.Info().Msgf("[%v] Secret %v.%v - Promoting certificate from staging to production...", initiator, secret.Name, secret.Namespace)
+
+	delete(secret.Annotations, annotationLetsEncryptCertificateStaging)
+	delete(secret.Annotations, annotationLetsEncryptCertificatePromote)
+	setLastChangedBy(secret, initiator)
+
+	return kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+}
+
 func validateHostname(hostname string) bool {
 	if len(hostname) > 253 {
 		return false
@@ -760,19 +2697,151 @@ func validateHostname(hostname string) bool {
 	return true
 }
 
-func uploadToCloudflare(hostnames string, certificate, privateKey []byte) (err error) {
+// addCompanionHostnames adds the www. counterpart for every apex hostname and the apex counterpart for every www. hostname, skipping wildcards and duplicates
+func addCompanionHostnames(hostnames string) string {
+	hostnameList := strings.Split(hostnames, ",")
+
+	seen := map[string]bool{}
+	for _, hostname := range hostnameList {
+		seen[hostname] = true
+	}
+
+	augmentedHostnameList := []string{}
+	for _, hostname := range hostnameList {
+		augmentedHostnameList = append(augmentedHostnameList, hostname)
+
+		if strings.HasPrefix(hostname, "*.") {
+			continue
+		}
+
+		companion := "www." + hostname
+		if strings.HasPrefix(hostname, "www.") {
+			companion = strings.TrimPrefix(hostname, "www.")
+		}
+
+		// the apex of www.<tld> isn't a valid hostname, so leave those alone
+		if len(strings.Split(companion, ".")) < 2 || seen[companion] {
+			continue
+		}
+
+		seen[companion] = true
+		augmentedHostnameList = append(augmentedHostnameList, companion)
+	}
+
+	return strings.Join(augmentedHostnameList, ",")
+}
+
+// uploadToCloudflare uploads the certificate to, or orders certificate packs for, the zone or the zone of each hostname; it returns the cloudflare objects that have been uploaded to, also if a later upload fails
+func uploadToCloudflare(authentication APIAuthentication, hostnames, zoneNameOrID string, certificate, privateKey []byte) (targets []cloudflareUploadTarget, err error) {
+	defer func() {
+		if err != nil {
+			cloudflareUploadTotals.With(prometheus.Labels{"status": "failed", "reason": getCloudflareErrorReason(err)}).Inc()
+			return
+		}
+		cloudflareUploadTotals.With(prometheus.Labels{"status": "succeeded", "reason": ""}).Inc()
+	}()
+
 	// init cf
-	authentication := APIAuthentication{Key: *cfAPIKey, Email: *cfAPIEmail}
 	cf := NewCloudflare(authentication)
 
-	// loop hostnames
 	hostnameList := strings.Split(hostnames, ",")
+
+	// let cloudflare issue and renew an advanced certificate pack for the hostnames instead of uploading the certificate
+	if *cloudflareCertificateAPI == "certificate-packs" {
+		return orderCloudflareCertificatePacks(cf, hostnameList, zoneNameOrID)
+	}
+
+	// verify the certificate before uploading, to get a clear error instead of cloudflare's opaque one
+	err = VerifyCertificateForUpload(certificate, hostnameList)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := getCloudflareUploadZones(cf, hostnameList, zoneNameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zone := range zones {
+		sslConfig, err := cf.UpsertSSLConfigurationByZone(zone, certificate, privateKey)
+		if err != nil {
+			return targets, err
+		}
+		targets = append(targets, getCloudflareUploadTarget(zone, "custom-certificate", sslConfig.ID, time.Now()))
+	}
+
+	return targets, nil
+}
+
+// orderCloudflareCertificatePacks makes sure the zone, or otherwise the zone of each hostname, has a certificate pack covering the hostnames
+func orderCloudflareCertificatePacks(cf *Cloudflare, hostnameList []string, zoneNameOrID string) (targets []cloudflareUploadTarget, err error) {
+	zones, err := getCloudflareUploadZones(cf, hostnameList, zoneNameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zone := range zones {
+		certificatePack, err := cf.UpsertCertificatePackByZone(zone, hostnameList)
+		if err != nil {
+			return targets, err
+		}
+		targets = append(targets, getCloudflareUploadTarget(zone, "certificate-pack", certificatePack.ID, time.Now()))
+	}
+
+	return targets, nil
+}
+
+// getCloudflareUploadZones returns the explicitly configured zone, or otherwise the distinct zones of the hostnames
+func getCloudflareUploadZones(cf *Cloudflare, hostnameList []string, zoneNameOrID string) (zones []Zone, err error) {
+	if zoneNameOrID != "" {
+		zone, err := cf.GetZoneByNameOrID(zoneNameOrID)
+		if err != nil {
+			return nil, err
+		}
+		return []Zone{zone}, nil
+	}
+
+	zoneIDs := map[string]bool{}
 	for _, hostname := range hostnameList {
-		_, err := cf.UpsertSSLConfigurationByDNSName(hostname, certificate, privateKey)
+		zone, err := cf.GetZoneByDNSName(hostname)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if !zoneIDs[zone.ID] {
+			zoneIDs[zone.ID] = true
+			zones = append(zones, zone)
 		}
 	}
 
-	return nil
+	return zones, nil
+}
+
+// getCloudflareUploadTarget returns the upload target to record in the state for a custom certificate or certificate pack in a zone
+func getCloudflareUploadTarget(zone Zone, targetType, id string, uploadedAt time.Time) cloudflareUploadTarget {
+	return cloudflareUploadTarget{
+		ZoneID:       zone.ID,
+		Zone:         zone.Name,
+		Type:         targetType,
+		ID:           id,
+		LastUploaded: uploadedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// mergeCloudflareUploadTargets adds the targets to the recorded ones, replacing a recorded target for the same object so its last upload time moves forward
+func mergeCloudflareUploadTargets(recorded, targets []cloudflareUploadTarget) []cloudflareUploadTarget {
+	merged := append([]cloudflareUploadTarget{}, recorded...)
+	for _, target := range targets {
+		found := false
+		for i := range merged {
+			if merged[i].ZoneID == target.ZoneID && merged[i].Type == target.Type && merged[i].ID == target.ID {
+				merged[i] = target
+				found = true
+			}
+		}
+		if !found {
+			merged = append(merged, target)
+		}
+	}
+
+	return merged
 }
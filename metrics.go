@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// daysUntilExpiryBuckets are the upper bounds in days of the histogram of remaining certificate validity
+var daysUntilExpiryBuckets = []float64{1, 3, 7, 14, 21, 30, 45, 60, 75, 90}
+
+// expiryCollector exposes the remaining validity of all managed certificates as seen by the last poll as a histogram, so it reflects the current fleet instead of accumulating observations forever
+type expiryCollector struct {
+	mutex           sync.RWMutex
+	daysUntilExpiry []float64
+	description     *prometheus.Desc
+}
+
+func newExpiryCollector() *expiryCollector {
+	return &expiryCollector{
+		description: prometheus.NewDesc(
+			"estafette_letsencrypt_certificate_days_until_expiry",
+			"Distribution of the number of days until managed certificates expire.",
+			nil,
+			nil,
+		),
+	}
+}
+
+// Update replaces the observed remaining validity with the certificates of the managed secrets in the list
+func (c *expiryCollector) Update(secrets []v1.Secret) {
+	daysUntilExpiry := []float64{}
+	for _, secret := range secrets {
+		if secret.Annotations[annotationLetsEncryptCertificate] != "true" {
+			continue
+		}
+		certificateBytes, _ := getSecretCertificate(&secret)
+		certificate, err := parseCertificate(certificateBytes)
+		if err != nil {
+			continue
+		}
+		daysUntilExpiry = append(daysUntilExpiry, time.Until(certificate.NotAfter).Hours()/24)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.daysUntilExpiry = daysUntilExpiry
+}
+
+// Describe implements prometheus.Collector
+func (c *expiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.description
+}
+
+// Collect implements prometheus.Collector
+func (c *expiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	sum := 0.0
+	buckets := map[float64]uint64{}
+	for _, days := range c.daysUntilExpiry {
+		sum += days
+		for _, upperBound := range daysUntilExpiryBuckets {
+			if days <= upperBound {
+				buckets[upperBound]++
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstHistogram(c.description, uint64(len(c.daysUntilExpiry)), sum, buckets)
+}
+
+// renewalCollector exposes when each managed certificate is planned to be renewed as seen by the last poll, so removed secrets disappear from the metric
+type renewalCollector struct {
+	mutex       sync.RWMutex
+	renewals    []plannedRenewal
+	description *prometheus.Desc
+}
+
+type plannedRenewal struct {
+	namespace string
+	secret    string
+	wildcard  string
+	time      time.Time
+}
+
+func newRenewalCollector() *renewalCollector {
+	return &renewalCollector{
+		description: prometheus.NewDesc(
+			"estafette_letsencrypt_certificate_next_renewal_timestamp_seconds",
+			"Unix time at which managed certificates are planned to be renewed.",
+			[]string{"namespace", "secret", "wildcard"},
+			nil,
+		),
+	}
+}
+
+// Update replaces the planned renewals with those of the managed secrets in the list that owns returns true for; the certificate is read from the target secret if one is configured, which can be in another shard than the annotated secret, so the list holds the secrets of all shards
+func (c *renewalCollector) Update(secrets []v1.Secret, owns func(key string) bool) {
+	secretsByKey := map[string]*v1.Secret{}
+	for i := range secrets {
+		secretsByKey[fmt.Sprintf("%v/%v", secrets[i].Namespace, secrets[i].Name)] = &secrets[i]
+	}
+
+	renewals := []plannedRenewal{}
+	for i := range secrets {
+		secret := &secrets[i]
+		if secret.Annotations[annotationLetsEncryptCertificate] != "true" || !owns(fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)) {
+			continue
+		}
+		dataSecret := secret
+		if targetSecret := secret.Annotations[annotationLetsEncryptCertificateTargetSecret]; targetSecret != "" {
+			dataSecret = secretsByKey[fmt.Sprintf("%v/%v", secret.Namespace, targetSecret)]
+			if dataSecret == nil {
+				continue
+			}
+		}
+		if renewalTime, ok := getNextRenewal(secret, dataSecret); ok {
+			renewals = append(renewals, plannedRenewal{namespace: secret.Namespace, secret: secret.Name, wildcard: getWildcardLabel(secret.Annotations[annotationLetsEncryptCertificateHostnames]), time: renewalTime})
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.renewals = renewals
+}
+
+// Describe implements prometheus.Collector
+func (c *renewalCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.description
+}
+
+// Collect implements prometheus.Collector
+func (c *renewalCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, renewal := range c.renewals {
+		ch <- prometheus.MustNewConstMetric(c.description, prometheus.GaugeValue, float64(renewal.time.Unix()), renewal.namespace, renewal.secret, renewal.wildcard)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenewalCollectorUpdate(t *testing.T) {
+	t.Run("ReadsTargetSecretOfAnotherShardButOnlyExposesOwnedSecrets", func(t *testing.T) {
+
+		state := `{"enabled":"true","hostnames":"estafette.io","lastRenewed":"2026-01-02T03:04:05Z"}`
+		secrets := []v1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", Annotations: map[string]string{
+					annotationLetsEncryptCertificate:             "true",
+					annotationLetsEncryptCertificateHostnames:    "estafette.io",
+					annotationLetsEncryptCertificateTargetSecret: "a-data",
+					annotationLetsEncryptCertificateState:        state,
+				}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-data", Namespace: "default"},
+				Data: map[string][]byte{
+					"tls.crt": generateTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(90*24*time.Hour)),
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default", Annotations: map[string]string{
+					annotationLetsEncryptCertificate:          "true",
+					annotationLetsEncryptCertificateHostnames: "estafette.io",
+					annotationLetsEncryptCertificateState:     state,
+				}},
+			},
+		}
+		collector := newRenewalCollector()
+
+		// act
+		collector.Update(secrets, func(key string) bool {
+			return key == "default/a"
+		})
+
+		if assert.Equal(t, 1, len(collector.renewals)) {
+			assert.Equal(t, "default", collector.renewals[0].namespace)
+			assert.Equal(t, "a", collector.renewals[0].secret)
+		}
+	})
+}
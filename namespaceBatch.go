@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// namespaceBatch collects namespace events until they're processed together, so a burst of created or deleted namespaces only needs a single listing of all secrets
+type namespaceBatch struct {
+	mutex   sync.Mutex
+	added   map[string]*v1.Namespace
+	deleted map[string]bool
+}
+
+func newNamespaceBatch() *namespaceBatch {
+	return &namespaceBatch{
+		added:   map[string]*v1.Namespace{},
+		deleted: map[string]bool{},
+	}
+}
+
+// Add records a created namespace, undoing an earlier deletion of a namespace with the same name in the batch
+func (b *namespaceBatch) Add(namespace *v1.Namespace) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.deleted, namespace.Name)
+	b.added[namespace.Name] = namespace
+}
+
+// Delete records a deleted namespace, undoing an earlier creation of the namespace in the batch
+func (b *namespaceBatch) Delete(name string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.added, name)
+	b.deleted[name] = true
+}
+
+// Take returns the recorded namespaces and starts a new batch
+func (b *namespaceBatch) Take() (added []*v1.Namespace, deleted []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, namespace := range b.added {
+		added = append(added, namespace)
+	}
+	for name := range b.deleted {
+		deleted = append(deleted, name)
+	}
+	b.added = map[string]*v1.Namespace{}
+	b.deleted = map[string]bool{}
+
+	return added, deleted
+}
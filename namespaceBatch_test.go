@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceBatch(t *testing.T) {
+	t.Run("ReturnsEachAddedNamespaceOnce", func(t *testing.T) {
+
+		b := newNamespaceBatch()
+		b.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}})
+		b.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-2"}})
+		b.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}})
+
+		// act
+		added, deleted := b.Take()
+
+		assert.Equal(t, 2, len(added))
+		assert.Equal(t, 0, len(deleted))
+	})
+
+	t.Run("ReturnsOnlyDeletionIfNamespaceIsAddedAndDeletedInSameBatch", func(t *testing.T) {
+
+		b := newNamespaceBatch()
+		b.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}})
+		b.Delete("preview-1")
+
+		// act
+		added, deleted := b.Take()
+
+		assert.Equal(t, 0, len(added))
+		assert.Equal(t, []string{"preview-1"}, deleted)
+	})
+
+	t.Run("StartsANewBatchAfterTake", func(t *testing.T) {
+
+		b := newNamespaceBatch()
+		b.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}})
+		b.Take()
+
+		// act
+		added, deleted := b.Take()
+
+		assert.Equal(t, 0, len(added))
+		assert.Equal(t, 0, len(deleted))
+	})
+}
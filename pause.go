@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pauseSwitch pauses issuance for the whole controller, either through the admin endpoints or the pause config map, for example during CA incidents or Cloudflare maintenance
+type pauseSwitch struct {
+	mutex     sync.Mutex
+	manual    bool
+	configMap bool
+}
+
+func newPauseSwitch() *pauseSwitch {
+	return &pauseSwitch{}
+}
+
+// SetManual pauses or resumes through the admin endpoints
+func (p *pauseSwitch) SetManual(paused bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.manual = paused
+	p.updateMetric()
+}
+
+// SetConfigMap pauses or resumes through the pause config map
+func (p *pauseSwitch) SetConfigMap(paused bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.configMap = paused
+	p.updateMetric()
+}
+
+// IsPaused returns true if either the admin endpoints or the config map paused issuance
+func (p *pauseSwitch) IsPaused() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.manual || p.configMap
+}
+
+func (p *pauseSwitch) updateMetric() {
+	if p.manual || p.configMap {
+		controllerPaused.Set(1)
+		return
+	}
+	controllerPaused.Set(0)
+}
+
+// ServeHTTP pauses on POST /pause, resumes on POST /resume and returns whether issuance is paused on GET
+func (p *pauseSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		paused := r.URL.Path == "/pause"
+		log.Info().Msgf("Setting paused to %v through admin endpoint %v", paused, r.URL.Path)
+		p.SetManual(paused)
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "Only GET and POST are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.mutex.Lock()
+	state := map[string]bool{
+		"paused":    p.manual || p.configMap,
+		"manual":    p.manual,
+		"configMap": p.configMap,
+	}
+	p.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// watchPauseConfigMap periodically reads the paused key of the pause config map in the controller's namespace
+func watchPauseConfigMap(ctx context.Context, kubeClientset *kubernetes.Clientset, name string) {
+	namespace := getCurrentNamespace()
+	lastPaused := false
+
+	for {
+		configMap, err := kubeClientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			lastPaused = false
+			controllerPause.SetConfigMap(false)
+		case err != nil:
+			// keep the last known value, to not resume in the middle of an incident because of an api hiccup
+			log.Warn().Err(err).Msgf("Reading pause config map %v.%v failed", name, namespace)
+		default:
+			paused, _ := strconv.ParseBool(configMap.Data["paused"])
+			if paused != lastPaused {
+				log.Info().Msgf("Pause config map %v.%v sets paused to %v", name, namespace, paused)
+			}
+			lastPaused = paused
+			controllerPause.SetConfigMap(paused)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseSwitch(t *testing.T) {
+
+	t.Run("ReturnsFalseByDefault", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+
+		// act
+		paused := pause.IsPaused()
+
+		assert.False(t, paused)
+	})
+
+	t.Run("ReturnsTrueIfPausedManually", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+		pause.SetManual(true)
+
+		// act
+		paused := pause.IsPaused()
+
+		assert.True(t, paused)
+	})
+
+	t.Run("ReturnsTrueIfPausedByConfigMapAfterManualResume", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+		pause.SetConfigMap(true)
+		pause.SetManual(false)
+
+		// act
+		paused := pause.IsPaused()
+
+		assert.True(t, paused)
+	})
+
+	t.Run("PausesOnPostToPause", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+		recorder := httptest.NewRecorder()
+
+		// act
+		pause.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/pause", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"paused":true,"manual":true,"configMap":false}`, recorder.Body.String())
+		assert.True(t, pause.IsPaused())
+	})
+
+	t.Run("ResumesOnPostToResume", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+		pause.SetManual(true)
+		recorder := httptest.NewRecorder()
+
+		// act
+		pause.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/resume", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.False(t, pause.IsPaused())
+	})
+
+	t.Run("DoesNotChangeStateOnGet", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+		recorder := httptest.NewRecorder()
+
+		// act
+		pause.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/pause", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.False(t, pause.IsPaused())
+	})
+
+	t.Run("ReturnsMethodNotAllowedForDelete", func(t *testing.T) {
+
+		pause := newPauseSwitch()
+		recorder := httptest.NewRecorder()
+
+		// act
+		pause.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/pause", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// pendingRenewal is the snapshot of certificates that have been obtained but not stored in their secret yet, kept on local disk so they survive a restart during an api server outage
+type pendingRenewal struct {
+	Hostnames         string `json:"hostnames"`
+	Environment       string `json:"environment"`
+	ACMEDirectoryURL  string `json:"acmeDirectoryURL,omitempty"`
+	KeyType           string `json:"keyType,omitempty"`
+	RenewedAt         string `json:"renewedAt"`
+	Domain            string `json:"domain"`
+	Certificate       []byte `json:"certificate"`
+	PrivateKey        []byte `json:"privateKey"`
+	IssuerCertificate []byte `json:"issuerCertificate,omitempty"`
+}
+
+// pendingRenewalsInMemory keeps the pending renewals of this process when they aren't persisted, so certificates that couldn't be stored yet aren't obtained again by the next attempt
+var pendingRenewalsInMemory = struct {
+	sync.Mutex
+	renewals map[string][]byte
+}{renewals: map[string][]byte{}}
+
+// getPendingRenewalPath returns the file holding the pending renewal of a secret, or an empty string if pending renewals aren't persisted
+func getPendingRenewalPath(secret *v1.Secret) string {
+	if *pendingRenewalsPath == "" {
+		return ""
+	}
+
+	return filepath.Join(*pendingRenewalsPath, getBackupKey(secret)+".json")
+}
+
+// storePendingRenewal persists newly obtained certificates until they've been stored in the secret, in memory if pending renewals aren't persisted; failures are logged but don't fail the renewal
+func storePendingRenewal(secret *v1.Secret, desiredState LetsEncryptCertificateState, certificates *certificate.Resource, renewedAt time.Time, initiator string) {
+	data, err := json.Marshal(pendingRenewal{
+		Hostnames:         desiredState.Hostnames,
+		Environment:       desiredState.Environment,
+		ACMEDirectoryURL:  desiredState.ACMEDirectoryURL,
+		KeyType:           desiredState.KeyType,
+		RenewedAt:         renewedAt.Format(time.RFC3339),
+		Domain:            certificates.Domain,
+		Certificate:       certificates.Certificate,
+		PrivateKey:        certificates.PrivateKey,
+		IssuerCertificate: certificates.IssuerCertificate,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Persisting pending renewal failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	path := getPendingRenewalPath(secret)
+	if path == "" {
+		pendingRenewalsInMemory.Lock()
+		pendingRenewalsInMemory.renewals[getBackupKey(secret)] = data
+		pendingRenewalsInMemory.Unlock()
+		return
+	}
+
+	err = os.MkdirAll(*pendingRenewalsPath, 0700)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Persisting pending renewal failed", initiator, secret.Name, secret.Namespace)
+		return
+	}
+
+	// write to a temporary file first so a restart halfway doesn't leave a truncated snapshot behind
+	err = ioutil.WriteFile(path+".tmp", data, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Persisting pending renewal failed", initiator, secret.Name, secret.Namespace)
+	}
+}
+
+// loadPendingRenewal returns the certificates obtained earlier but never stored in the secret, as long as they're for the same desired state and haven't expired; snapshots that no longer apply are removed
+func loadPendingRenewal(secret *v1.Secret, desiredState LetsEncryptCertificateState, initiator string) (certificates *certificate.Resource, renewedAt time.Time, ok bool) {
+	data, err := readPendingRenewal(secret)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Loading pending renewal failed", initiator, secret.Name, secret.Namespace)
+		}
+		return nil, renewedAt, false
+	}
+
+	var pending pendingRenewal
+	err = json.Unmarshal(data, &pending)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Unmarshalling pending renewal failed", initiator, secret.Name, secret.Namespace)
+		removePendingRenewal(secret, initiator)
+		return nil, renewedAt, false
+	}
+
+	if !isPendingRenewalUsable(pending, desiredState, time.Now()) {
+		log.Info().Msgf("[%v] Secret %v.%v - Discarding pending renewal for %v, it no longer applies", initiator, secret.Name, secret.Namespace, pending.Hostnames)
+		removePendingRenewal(secret, initiator)
+		return nil, renewedAt, false
+	}
+
+	renewedAt, _ = time.Parse(time.RFC3339, pending.RenewedAt)
+
+	log.Info().Msgf("[%v] Secret %v.%v - Storing certificates obtained at %v that haven't been stored in the secret yet, instead of obtaining new ones...", initiator, secret.Name, secret.Namespace, pending.RenewedAt)
+
+	return &certificate.Resource{
+		Domain:            pending.Domain,
+		Certificate:       pending.Certificate,
+		PrivateKey:        pending.PrivateKey,
+		IssuerCertificate: pending.IssuerCertificate,
+	}, renewedAt, true
+}
+
+// readPendingRenewal returns the persisted pending renewal of a secret, or the one kept in memory if pending renewals aren't persisted; it returns an os.IsNotExist error if there's none
+func readPendingRenewal(secret *v1.Secret) ([]byte, error) {
+	path := getPendingRenewalPath(secret)
+	if path != "" {
+		return ioutil.ReadFile(path)
+	}
+
+	pendingRenewalsInMemory.Lock()
+	defer pendingRenewalsInMemory.Unlock()
+
+	data, ok := pendingRenewalsInMemory.renewals[getBackupKey(secret)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return data, nil
+}
+
+// isPendingRenewalUsable checks whether a pending renewal was obtained for the same hostnames, environment, directory and key type as desired, and its certificate is still valid
+func isPendingRenewalUsable(pending pendingRenewal, desiredState LetsEncryptCertificateState, now time.Time) bool {
+	if pending.Hostnames != desiredState.Hostnames || pending.Environment != desiredState.Environment || pending.ACMEDirectoryURL != desiredState.ACMEDirectoryURL || pending.KeyType != desiredState.KeyType {
+		return false
+	}
+
+	if _, err := time.Parse(time.RFC3339, pending.RenewedAt); err != nil {
+		return false
+	}
+
+	cert, err := parseCertificate(pending.Certificate)
+	if err != nil {
+		return false
+	}
+
+	return now.Before(cert.NotAfter)
+}
+
+// removePendingRenewal removes the pending renewal of a secret once its certificates have been stored
+func removePendingRenewal(secret *v1.Secret, initiator string) {
+	path := getPendingRenewalPath(secret)
+	if path == "" {
+		pendingRenewalsInMemory.Lock()
+		delete(pendingRenewalsInMemory.renewals, getBackupKey(secret))
+		pendingRenewalsInMemory.Unlock()
+		return
+	}
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Removing pending renewal failed", initiator, secret.Name, secret.Namespace)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPendingRenewal(t *testing.T) {
+	t.Run("ReturnsStoredCertificatesForSameDesiredState", func(t *testing.T) {
+
+		directory, _ := ioutil.TempDir("", "pending")
+		defer os.RemoveAll(directory)
+		*pendingRenewalsPath = directory
+		defer func() { *pendingRenewalsPath = "" }()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		desiredState := LetsEncryptCertificateState{Hostnames: "estafette.io", Environment: "production"}
+		renewedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+		certificates := &certificate.Resource{Domain: "estafette.io", Certificate: generateTestCertificate(t, renewedAt, renewedAt.Add(90*24*time.Hour)), PrivateKey: []byte("key")}
+		storePendingRenewal(secret, desiredState, certificates, renewedAt, "test")
+
+		// act
+		pendingCertificates, pendingRenewedAt, ok := loadPendingRenewal(secret, desiredState, "test")
+
+		assert.True(t, ok)
+		assert.Equal(t, certificates, pendingCertificates)
+		assert.True(t, renewedAt.Equal(pendingRenewedAt))
+	})
+
+	t.Run("DiscardsSnapshotForOtherHostnames", func(t *testing.T) {
+
+		directory, _ := ioutil.TempDir("", "pending")
+		defer os.RemoveAll(directory)
+		*pendingRenewalsPath = directory
+		defer func() { *pendingRenewalsPath = "" }()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		renewedAt := time.Now().Add(-time.Hour)
+		certificates := &certificate.Resource{Domain: "estafette.io", Certificate: generateTestCertificate(t, renewedAt, renewedAt.Add(90*24*time.Hour)), PrivateKey: []byte("key")}
+		storePendingRenewal(secret, LetsEncryptCertificateState{Hostnames: "estafette.io"}, certificates, renewedAt, "test")
+
+		// act
+		_, _, ok := loadPendingRenewal(secret, LetsEncryptCertificateState{Hostnames: "estafette.io,www.estafette.io"}, "test")
+
+		assert.False(t, ok)
+		_, err := os.Stat(getPendingRenewalPath(secret))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("ReturnsNothingAfterRemoval", func(t *testing.T) {
+
+		directory, _ := ioutil.TempDir("", "pending")
+		defer os.RemoveAll(directory)
+		*pendingRenewalsPath = directory
+		defer func() { *pendingRenewalsPath = "" }()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		desiredState := LetsEncryptCertificateState{Hostnames: "estafette.io"}
+		renewedAt := time.Now().Add(-time.Hour)
+		certificates := &certificate.Resource{Domain: "estafette.io", Certificate: generateTestCertificate(t, renewedAt, renewedAt.Add(90*24*time.Hour)), PrivateKey: []byte("key")}
+		storePendingRenewal(secret, desiredState, certificates, renewedAt, "test")
+		removePendingRenewal(secret, "test")
+
+		// act
+		_, _, ok := loadPendingRenewal(secret, desiredState, "test")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("KeepsPendingRenewalInMemoryIfPathIsNotSet", func(t *testing.T) {
+
+		*pendingRenewalsPath = ""
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		desiredState := LetsEncryptCertificateState{Hostnames: "estafette.io"}
+		renewedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+		certificates := &certificate.Resource{Domain: "estafette.io", Certificate: generateTestCertificate(t, renewedAt, renewedAt.Add(90*24*time.Hour)), PrivateKey: []byte("key")}
+		storePendingRenewal(secret, desiredState, certificates, renewedAt, "test")
+		defer removePendingRenewal(secret, "test")
+
+		// act
+		pendingCertificates, _, ok := loadPendingRenewal(secret, desiredState, "test")
+
+		assert.True(t, ok)
+		assert.Equal(t, certificates, pendingCertificates)
+	})
+
+	t.Run("ReturnsNothingIfPathIsNotSetAfterRemoval", func(t *testing.T) {
+
+		*pendingRenewalsPath = ""
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "my-namespace"}}
+		desiredState := LetsEncryptCertificateState{Hostnames: "estafette.io"}
+		renewedAt := time.Now().Add(-time.Hour)
+		certificates := &certificate.Resource{Domain: "estafette.io", Certificate: generateTestCertificate(t, renewedAt, renewedAt.Add(90*24*time.Hour)), PrivateKey: []byte("key")}
+		storePendingRenewal(secret, desiredState, certificates, renewedAt, "test")
+		removePendingRenewal(secret, "test")
+
+		// act
+		_, _, ok := loadPendingRenewal(secret, desiredState, "test")
+
+		assert.False(t, ok)
+	})
+}
+
+func TestIsPendingRenewalUsable(t *testing.T) {
+	t.Run("ReturnsFalseIfCertificateHasExpired", func(t *testing.T) {
+
+		now := time.Now()
+		pending := pendingRenewal{Hostnames: "estafette.io", RenewedAt: now.Add(-100 * 24 * time.Hour).Format(time.RFC3339), Certificate: generateTestCertificate(t, now.Add(-100*24*time.Hour), now.Add(-10*24*time.Hour))}
+
+		// act
+		usable := isPendingRenewalUsable(pending, LetsEncryptCertificateState{Hostnames: "estafette.io"}, now)
+
+		assert.False(t, usable)
+	})
+
+	t.Run("ReturnsFalseIfKeyTypeDiffers", func(t *testing.T) {
+
+		now := time.Now()
+		pending := pendingRenewal{Hostnames: "estafette.io", KeyType: "rsa2048", RenewedAt: now.Format(time.RFC3339), Certificate: generateTestCertificate(t, now, now.Add(90*24*time.Hour))}
+
+		// act
+		usable := isPendingRenewalUsable(pending, LetsEncryptCertificateState{Hostnames: "estafette.io", KeyType: "ec256"}, now)
+
+		assert.False(t, usable)
+	})
+}
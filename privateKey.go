@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 structure of an ENCRYPTED PRIVATE KEY pem block
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// parsePrivateKey parses a pem encoded PKCS#1 rsa, SEC1 ec or PKCS#8 private key, decrypting it with passphrase if it's encrypted
+func parsePrivateKey(keyBytes, passphrase []byte) (crypto.PrivateKey, error) {
+	keyBlock, _ := pem.Decode(keyBytes)
+	if keyBlock == nil {
+		return nil, errors.New("Private key is not pem encoded")
+	}
+
+	keyDER := keyBlock.Bytes
+	keyType := keyBlock.Type
+	// besides pkcs#8 encryption, support the legacy encrypted pem blocks openssl writes for rsa and ec keys with -aes256
+	if keyType == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(keyBlock) {
+		if len(passphrase) == 0 {
+			return nil, errors.New("Private key is encrypted, but no passphrase is set")
+		}
+
+		var err error
+		if keyType == "ENCRYPTED PRIVATE KEY" {
+			keyDER, err = decryptPKCS8PrivateKey(keyBlock.Bytes, passphrase)
+			keyType = "PRIVATE KEY"
+		} else {
+			keyDER, err = x509.DecryptPEMBlock(keyBlock, passphrase)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Decrypting private key failed, the passphrase may be wrong: %w", err)
+		}
+	}
+
+	switch keyType {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(keyDER)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(keyDER)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(keyDER)
+		if err != nil {
+			return nil, err
+		}
+		// acme account keys can only be rsa or ecdsa
+		switch key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			return key, nil
+		}
+		return nil, fmt.Errorf("Private key of type %T is not supported, use an rsa or ecdsa key", key)
+	}
+
+	return nil, errors.New("Unknown private key type.")
+}
+
+// decryptPKCS8PrivateKey decrypts the PBES2 encrypted data of an ENCRYPTED PRIVATE KEY block, as written by openssl by default, and returns the unencrypted PKCS#8 data
+func decryptPKCS8PrivateKey(der, passphrase []byte) ([]byte, error) {
+	var keyInfo encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &keyInfo); err != nil {
+		return nil, err
+	}
+	if !keyInfo.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("Encryption algorithm %v is not supported, use PBES2", keyInfo.EncryptionAlgorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(keyInfo.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("Key derivation function %v is not supported, use PBKDF2", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0 || kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("Pseudorandom function %v is not supported, use hmacWithSHA1 or hmacWithSHA256", kdfParams.PRF.Algorithm)
+	}
+
+	var keyLength int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, fmt.Errorf("Encryption scheme %v is not supported, use aes-128-cbc, aes-192-cbc or aes-256-cbc", params.EncryptionScheme.Algorithm)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(keyInfo.EncryptedData) == 0 || len(keyInfo.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New("Encrypted private key data is malformed")
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLength, prf))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(keyInfo.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, keyInfo.EncryptedData)
+
+	// strip and check the pkcs#7 padding; it's invalid in most cases when the passphrase is wrong
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("Decrypted private key has invalid padding")
+	}
+
+	return data[:len(data)-padding], nil
+}
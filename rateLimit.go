@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// acmeRateLimitedError is the problem type returned by the CA when an order hits one of its rate limits
+const acmeRateLimitedError = "urn:ietf:params:acme:error:rateLimited"
+
+// rateLimitDefaultRetryAfter is how long to wait after a rate limit error without a retry-after hint; the limits are counted over hours, so retrying after the usual 15 minutes would only extend the penalty
+const rateLimitDefaultRetryAfter = time.Hour
+
+// rateLimitRetryAfterRegex matches the retry-after hint Let's Encrypt puts in the detail of its rate limit errors; lego doesn't pass on the Retry-After header of the response
+var rateLimitRetryAfterRegex = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) UTC`)
+
+// getRateLimitRetryAfter returns when to attempt an order again after a rate limit error of the CA, from the hint in the error or an hour from now; ok is false if the error isn't a rate limit error
+func getRateLimitRetryAfter(err error, now time.Time) (retryAfter time.Time, ok bool) {
+	if err == nil {
+		return retryAfter, false
+	}
+
+	// the problems of failed authorizations are only available as text, since lego joins them into a single error per order
+	var problem *acme.ProblemDetails
+	if !(errors.As(err, &problem) && problem.Type == acmeRateLimitedError) && !strings.Contains(err.Error(), acmeRateLimitedError) {
+		return retryAfter, false
+	}
+
+	if match := rateLimitRetryAfterRegex.FindStringSubmatch(err.Error()); match != nil {
+		if hint, parseErr := time.Parse("2006-01-02 15:04:05", match[1]); parseErr == nil && hint.After(now) {
+			return hint, true
+		}
+	}
+
+	return now.Add(rateLimitDefaultRetryAfter), true
+}
+
+// isRateLimited checks whether the last order of the secret hit a rate limit that still holds
+func isRateLimited(state LetsEncryptCertificateState, now time.Time) bool {
+	rateLimitedUntil, err := time.Parse(time.RFC3339, state.RateLimitedUntil)
+
+	return err == nil && now.Before(rateLimitedUntil)
+}
+
+// recordRateLimit stores until when the rate limit hit by the order holds in the state of the secret, so no order is placed before then
+func recordRateLimit(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, currentState LetsEncryptCertificateState, orderErr error) {
+	retryAfter, ok := getRateLimitRetryAfter(orderErr, time.Now())
+	if !ok {
+		return
+	}
+
+	log.Warn().Msgf("[%v] Secret %v.%v - Order hit a rate limit of the CA, retrying after %v", initiator, secret.Name, secret.Namespace, retryAfter.UTC().Format(time.RFC3339))
+
+	currentState.RateLimitedUntil = retryAfter.UTC().Format(time.RFC3339)
+	err := updateSecretState(ctx, kubeClientset, secret, currentState)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Recording rate limit in state failed", initiator, secret.Name, secret.Namespace)
+	}
+}
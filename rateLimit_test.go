@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRateLimitRetryAfter(t *testing.T) {
+
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("ReturnsRetryAfterHintOfRateLimitError", func(t *testing.T) {
+
+		err := fmt.Errorf("obtaining failed: %w", &acme.ProblemDetails{HTTPStatus: 429, Type: acmeRateLimitedError, Detail: "too many certificates (5) already issued for this exact set of domains in the last 168h0m0s, retry after 2026-03-02 08:30:00 UTC: see https://letsencrypt.org/docs/rate-limits/"})
+
+		// act
+		retryAfter, ok := getRateLimitRetryAfter(err, now)
+
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, 3, 2, 8, 30, 0, 0, time.UTC), retryAfter)
+	})
+
+	t.Run("ReturnsAnHourFromNowWithoutHint", func(t *testing.T) {
+
+		err := errors.New("error: one or more domains had a problem:\n[estafette.io] acme: error: 429 :: POST :: https://acme-v02.api.letsencrypt.org/acme/new-order :: urn:ietf:params:acme:error:rateLimited :: too many failed authorizations recently")
+
+		// act
+		retryAfter, ok := getRateLimitRetryAfter(err, now)
+
+		assert.True(t, ok)
+		assert.Equal(t, now.Add(time.Hour), retryAfter)
+	})
+
+	t.Run("ReturnsFalseForOtherErrors", func(t *testing.T) {
+
+		err := &acme.ProblemDetails{HTTPStatus: 403, Type: "urn:ietf:params:acme:error:unauthorized"}
+
+		// act
+		_, ok := getRateLimitRetryAfter(err, now)
+
+		assert.False(t, ok)
+	})
+}
+
+func TestIsRateLimited(t *testing.T) {
+
+	t.Run("ReturnsTrueBeforeRateLimitedUntil", func(t *testing.T) {
+
+		state := LetsEncryptCertificateState{RateLimitedUntil: "2026-03-02T08:30:00Z"}
+
+		// act
+		rateLimited := isRateLimited(state, time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC))
+
+		assert.True(t, rateLimited)
+	})
+
+	t.Run("ReturnsFalseAfterRateLimitedUntil", func(t *testing.T) {
+
+		state := LetsEncryptCertificateState{RateLimitedUntil: "2026-03-02T08:30:00Z"}
+
+		// act
+		rateLimited := isRateLimited(state, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+
+		assert.False(t, rateLimited)
+	})
+}
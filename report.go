@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// tenantReport summarizes the health of the managed certificates in a single namespace, for sharing with the team owning the namespace
+type tenantReport struct {
+	Namespace           string             `json:"namespace"`
+	GeneratedAt         string             `json:"generatedAt"`
+	Certificates        int                `json:"certificates"`
+	Renewals            int                `json:"renewals"`
+	Failures            int                `json:"failures"`
+	UpcomingExpirations []reportExpiration `json:"upcomingExpirations"`
+}
+
+// reportExpiration describes a certificate in a tenant report that expires within the report's expiry window
+type reportExpiration struct {
+	Secret    string `json:"secret"`
+	Hostnames string `json:"hostnames"`
+	NotAfter  string `json:"notAfter"`
+}
+
+// reportHTTPClient is used to push reports to the webhook; the timeout keeps a hanging webhook from stalling the next reports
+var reportHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// getTenantReports returns a report per namespace with managed certificates, ordered by namespace; renewals are counted within the period before now and expirations within the window after now
+func getTenantReports(secrets []v1.Secret, now time.Time, period, expiryWindow time.Duration) []tenantReport {
+	secretsByKey := map[string]*v1.Secret{}
+	for i := range secrets {
+		secretsByKey[fmt.Sprintf("%v/%v", secrets[i].Namespace, secrets[i].Name)] = &secrets[i]
+	}
+
+	reports := map[string]*tenantReport{}
+	for i := range secrets {
+		secret := &secrets[i]
+		desiredState := getDesiredSecretState(secret)
+		if desiredState.Enabled != "true" || desiredState.Hostnames == "" {
+			continue
+		}
+
+		report, ok := reports[secret.Namespace]
+		if !ok {
+			report = &tenantReport{
+				Namespace:           secret.Namespace,
+				GeneratedAt:         now.UTC().Format(time.RFC3339),
+				UpcomingExpirations: []reportExpiration{},
+			}
+			reports[secret.Namespace] = report
+		}
+		report.Certificates++
+
+		currentState := getCurrentSecretState(secret)
+		if lastRenewed, err := time.Parse(time.RFC3339, currentState.LastRenewed); err == nil && now.Sub(lastRenewed) <= period {
+			report.Renewals++
+		}
+		if currentState.IssueStatus == "failed" || currentState.StoreStatus == "failed" || currentState.CopyStatus == "failed" || currentState.UploadStatus == "failed" {
+			report.Failures++
+		}
+
+		dataSecret := secret
+		if desiredState.TargetSecret != "" {
+			dataSecret = secretsByKey[fmt.Sprintf("%v/%v", secret.Namespace, desiredState.TargetSecret)]
+			if dataSecret == nil {
+				continue
+			}
+		}
+		certificateBytes, _ := getSecretCertificate(dataSecret)
+		certificate, err := parseCertificate(certificateBytes)
+		if err == nil && certificate.NotAfter.Sub(now) <= expiryWindow {
+			report.UpcomingExpirations = append(report.UpcomingExpirations, reportExpiration{
+				Secret:    secret.Name,
+				Hostnames: desiredState.Hostnames,
+				NotAfter:  certificate.NotAfter.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+
+	namespaces := []string{}
+	for namespace := range reports {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	orderedReports := []tenantReport{}
+	for _, namespace := range namespaces {
+		report := reports[namespace]
+		sort.Slice(report.UpcomingExpirations, func(i, j int) bool {
+			return report.UpcomingExpirations[i].NotAfter < report.UpcomingExpirations[j].NotAfter
+		})
+		orderedReports = append(orderedReports, *report)
+	}
+
+	return orderedReports
+}
+
+// writeReportConfigMap stores the report as report.json in the config map with the given name in the namespace of the report, so the tenant can read it with access to its own namespace only; a config map with that name that hasn't been created for the report is left alone
+func writeReportConfigMap(ctx context.Context, kubeClientset *kubernetes.Clientset, name string, report tenantReport) error {
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data := map[string]string{"report.json": string(reportBytes)}
+
+	configMap, err := kubeClientset.CoreV1().ConfigMaps(report.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: report.Namespace,
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateManagedConfigMap: "report",
+				},
+			},
+			Data: data,
+		}
+		_, err = kubeClientset.CoreV1().ConfigMaps(report.Namespace).Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !isManagedConfigMap(configMap, "report") {
+		return fmt.Errorf("Config map %v.%v already exists and hasn't been created for the report, not overwriting it", name, report.Namespace)
+	}
+
+	configMap.Data = data
+	_, err = kubeClientset.CoreV1().ConfigMaps(report.Namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// isManagedConfigMap checks whether the config map has been created by the controller for purpose, using the managed-config-map annotation set on creation
+func isManagedConfigMap(configMap *v1.ConfigMap, purpose string) bool {
+	return configMap.Annotations[annotationLetsEncryptCertificateManagedConfigMap] == purpose
+}
+
+// postReportToWebhook posts the report as json to the webhook url, one request per namespace so the receiver can route it to the right tenant
+func postReportToWebhook(ctx context.Context, url string, report tenantReport) error {
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reportBytes))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := reportHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with status code %v for the report of namespace %v", response.StatusCode, report.Namespace)
+	}
+
+	return nil
+}
+
+// runReports periodically generates the tenant reports and writes them to the report config maps and/or the webhook
+func runReports(ctx context.Context, kubeClientset *kubernetes.Clientset) {
+	for {
+		secretList, err := kubeClientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			log.Error().Err(err).Msg("Listing secrets for tenant reports failed")
+		} else {
+			reports := getTenantReports(secretList.Items, time.Now(), *reportInterval, *reportExpiryWindow)
+			for _, report := range reports {
+				if *reportConfigMap != "" {
+					if err := writeReportConfigMap(ctx, kubeClientset, *reportConfigMap, report); err != nil {
+						log.Warn().Err(err).Msgf("Writing tenant report to config map %v.%v failed", *reportConfigMap, report.Namespace)
+					}
+				}
+				if *reportWebhookURL != "" {
+					if err := postReportToWebhook(ctx, *reportWebhookURL, report); err != nil {
+						log.Warn().Err(err).Msgf("Posting tenant report for namespace %v to webhook failed", report.Namespace)
+					}
+				}
+			}
+			log.Info().Msgf("Generated tenant reports for %v namespaces", len(reports))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*reportInterval):
+		}
+	}
+}
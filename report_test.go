@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetTenantReports(t *testing.T) {
+	t.Run("ReturnsNoReportsIfNoSecretsAreManaged", func(t *testing.T) {
+
+		secrets := []v1.Secret{{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}}
+
+		// act
+		reports := getTenantReports(secrets, time.Now(), 24*time.Hour, 720*time.Hour)
+
+		assert.Equal(t, 0, len(reports))
+	})
+
+	t.Run("ReturnsReportPerNamespaceOrderedByNamespace", func(t *testing.T) {
+
+		secrets := []v1.Secret{
+			getTestReportSecret("mysecret", "namespace-b", ""),
+			getTestReportSecret("mysecret", "namespace-a", ""),
+			getTestReportSecret("othersecret", "namespace-b", ""),
+		}
+
+		// act
+		reports := getTenantReports(secrets, time.Now(), 24*time.Hour, 720*time.Hour)
+
+		assert.Equal(t, 2, len(reports))
+		assert.Equal(t, "namespace-a", reports[0].Namespace)
+		assert.Equal(t, 1, reports[0].Certificates)
+		assert.Equal(t, "namespace-b", reports[1].Namespace)
+		assert.Equal(t, 2, reports[1].Certificates)
+	})
+
+	t.Run("CountsRenewalsWithinPeriodAndFailures", func(t *testing.T) {
+
+		now := time.Now()
+		secrets := []v1.Secret{
+			getTestReportSecret("recent", "mynamespace", `{"lastRenewed":"`+now.Add(-1*time.Hour).Format(time.RFC3339)+`"}`),
+			getTestReportSecret("old", "mynamespace", `{"lastRenewed":"`+now.Add(-48*time.Hour).Format(time.RFC3339)+`"}`),
+			getTestReportSecret("failed", "mynamespace", `{"issueStatus":"failed"}`),
+		}
+
+		// act
+		reports := getTenantReports(secrets, now, 24*time.Hour, 720*time.Hour)
+
+		assert.Equal(t, 1, len(reports))
+		assert.Equal(t, 3, reports[0].Certificates)
+		assert.Equal(t, 1, reports[0].Renewals)
+		assert.Equal(t, 1, reports[0].Failures)
+	})
+
+	t.Run("ListsCertificatesExpiringWithinWindow", func(t *testing.T) {
+
+		now := time.Now()
+		expiring := getTestReportSecret("expiring", "mynamespace", "")
+		expiring.Data = map[string][]byte{"tls.crt": generateTestCertificate(t, now.Add(-24*time.Hour), now.Add(10*24*time.Hour))}
+		valid := getTestReportSecret("valid", "mynamespace", "")
+		valid.Data = map[string][]byte{"tls.crt": generateTestCertificate(t, now.Add(-24*time.Hour), now.Add(60*24*time.Hour))}
+
+		// act
+		reports := getTenantReports([]v1.Secret{expiring, valid}, now, 24*time.Hour, 720*time.Hour)
+
+		assert.Equal(t, 1, len(reports))
+		assert.Equal(t, 1, len(reports[0].UpcomingExpirations))
+		assert.Equal(t, "expiring", reports[0].UpcomingExpirations[0].Secret)
+		assert.Equal(t, "estafette.io", reports[0].UpcomingExpirations[0].Hostnames)
+	})
+}
+
+func TestPostReportToWebhook(t *testing.T) {
+	t.Run("PostsReportAsJSON", func(t *testing.T) {
+
+		var postedReport tenantReport
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			json.NewDecoder(r.Body).Decode(&postedReport)
+		}))
+		defer server.Close()
+
+		// act
+		err := postReportToWebhook(context.Background(), server.URL, tenantReport{Namespace: "mynamespace", Certificates: 2})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "mynamespace", postedReport.Namespace)
+		assert.Equal(t, 2, postedReport.Certificates)
+	})
+
+	t.Run("ReturnsErrorIfWebhookRespondsWithErrorStatusCode", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		// act
+		err := postReportToWebhook(context.Background(), server.URL, tenantReport{Namespace: "mynamespace"})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func getTestReportSecret(name, namespace, state string) v1.Secret {
+	annotations := map[string]string{
+		annotationLetsEncryptCertificate:          "true",
+		annotationLetsEncryptCertificateHostnames: "estafette.io",
+	}
+	if state != "" {
+		annotations[annotationLetsEncryptCertificateState] = state
+	}
+
+	return v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations}}
+}
+
+func TestIsManagedConfigMap(t *testing.T) {
+	t.Run("ReturnsTrueIfConfigMapHasBeenCreatedForPurpose", func(t *testing.T) {
+
+		configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "certificate-report", Annotations: map[string]string{annotationLetsEncryptCertificateManagedConfigMap: "report"}}}
+
+		// act
+		managed := isManagedConfigMap(configMap, "report")
+
+		assert.True(t, managed)
+	})
+
+	t.Run("ReturnsFalseIfConfigMapHasNoManagedConfigMapAnnotation", func(t *testing.T) {
+
+		configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "certificate-report"}}
+
+		// act
+		managed := isManagedConfigMap(configMap, "report")
+
+		assert.False(t, managed)
+	})
+
+	t.Run("ReturnsFalseIfConfigMapHasBeenCreatedForOtherPurpose", func(t *testing.T) {
+
+		configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "certificate-report", Annotations: map[string]string{annotationLetsEncryptCertificateManagedConfigMap: "ca-bundle"}}}
+
+		// act
+		managed := isManagedConfigMap(configMap, "report")
+
+		assert.False(t, managed)
+	})
+}
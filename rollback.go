@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getRollbackPause returns how long to pause renewals after a rollback, from the value of the rollback annotation: true for --rollback-pause or a duration
+func getRollbackPause(value string) (time.Duration, error) {
+	if rollback, err := strconv.ParseBool(value); err == nil {
+		if !rollback {
+			return 0, fmt.Errorf("Rollback is false")
+		}
+		return *rollbackPause, nil
+	}
+
+	pause, err := time.ParseDuration(value)
+	if err != nil || pause <= 0 {
+		return 0, fmt.Errorf("Value %v is not true or a positive duration", value)
+	}
+
+	return pause, nil
+}
+
+// isRenewalPaused checks whether renewals of the secret are paused after a rollback
+func isRenewalPaused(secret *v1.Secret, now time.Time) bool {
+	pausedUntil, err := time.Parse(time.RFC3339, secret.Annotations[annotationLetsEncryptCertificateRenewalsPausedUntil])
+	if err != nil {
+		return false
+	}
+
+	return now.Before(pausedUntil)
+}
+
+// getRollbackPausedUntil returns until when to pause renewals after rolling back to the previous certificate, capped at the time its renew-before margin starts so the rolled back certificate gets renewed before it expires; a previous certificate that is expired or already within that margin is refused
+func getRollbackPausedUntil(previousCertificate []byte, renewDaysBeforeExpiry int, pause time.Duration, now time.Time) (time.Time, error) {
+	certificate, err := parseCertificate(previousCertificate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Parsing the previous certificate failed: %w", err)
+	}
+	if !now.Before(certificate.NotAfter) {
+		return time.Time{}, fmt.Errorf("Previous certificate expired at %v, not rolling back to it", certificate.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if renewDaysBeforeExpiry <= 0 {
+		renewDaysBeforeExpiry = getDefaultRenewBeforeDays()
+	}
+	renewFrom := certificate.NotAfter.Add(-time.Duration(renewDaysBeforeExpiry) * 24 * time.Hour)
+	if !now.Before(renewFrom) {
+		return time.Time{}, fmt.Errorf("Previous certificate expires at %v, within %v days, so it would be renewed right away; not rolling back to it", certificate.NotAfter.UTC().Format(time.RFC3339), renewDaysBeforeExpiry)
+	}
+
+	pausedUntil := now.Add(pause)
+	if renewFrom.Before(pausedUntil) {
+		pausedUntil = renewFrom
+	}
+
+	return pausedUntil, nil
+}
+
+// swapPreviousCertificate makes the tls-previous certificate and key the live ones in the data items of the output families, and the live ones the previous, so a second rollback undoes the first
+func swapPreviousCertificate(secret *v1.Secret, outputFamilies string) (certificate, privateKey []byte, err error) {
+	previousCertificate, previousPrivateKey := secret.Data[getPreviousCertificateDataPrefix(1)+".crt"], secret.Data[getPreviousCertificateDataPrefix(1)+".key"]
+	if len(previousCertificate) == 0 || len(previousPrivateKey) == 0 {
+		return nil, nil, fmt.Errorf("Secret %v has no previous certificate to roll back to, set the keep-previous annotation to keep them", secret.Name)
+	}
+
+	currentCertificate, currentPrivateKey := getSecretCertificate(secret)
+	if len(currentCertificate) == 0 || len(currentPrivateKey) == 0 {
+		return nil, nil, fmt.Errorf("Secret %v has no current certificate and key to roll back from", secret.Name)
+	}
+
+	for _, family := range getOutputFamilies(outputFamilies) {
+		secret.Data[family+".crt"] = previousCertificate
+		secret.Data[family+".key"] = previousPrivateKey
+		secret.Data[family+".pem"] = bytes.Join([][]byte{previousCertificate, previousPrivateKey}, []byte{})
+		// the json items hold the replaced certificate
+		delete(secret.Data, family+".json")
+	}
+	secret.Data[getPreviousCertificateDataPrefix(1)+".crt"] = currentCertificate
+	secret.Data[getPreviousCertificateDataPrefix(1)+".key"] = currentPrivateKey
+
+	return previousCertificate, previousPrivateKey, nil
+}
+
+// rollbackCertificate swaps the live certificate of the secret with the previous one and pauses its renewals for the pause period, at most until the previous certificate is due for renewal, for example when a renewed certificate breaks clients pinning its key
+func rollbackCertificate(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState, currentState LetsEncryptCertificateState, pause time.Duration) error {
+
+	if desiredState.SeparateKeySecret {
+		return fmt.Errorf("Secret %v stores its key in a separate secret, which doesn't keep previous certificates to roll back to", secret.Name)
+	}
+
+	dataSecret := secret
+	if desiredState.TargetSecret != "" {
+		var err error
+		dataSecret, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, desiredState.TargetSecret, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	if dataSecret.Data == nil {
+		dataSecret.Data = map[string][]byte{}
+	}
+
+	certificate, privateKey, err := swapPreviousCertificate(dataSecret, desiredState.OutputFamilies)
+	if err != nil {
+		return err
+	}
+	pausedUntil, err := getRollbackPausedUntil(certificate, desiredState.RenewDaysBefore, pause, time.Now())
+	if err != nil {
+		return err
+	}
+
+	log.Warn().Msgf("[%v] Secret %v.%v - Rolling back to the previous certificate and pausing renewals until %v...", initiator, secret.Name, secret.Namespace, pausedUntil.UTC().Format(time.RFC3339))
+
+	// record the rolled back data as written by the controller, so it isn't treated as tampered with
+	currentState.DataHash = getCertificateDataHash(certificate, privateKey)
+	letsEncryptCertificateStateByteArray, err := json.Marshal(currentState)
+	if err != nil {
+		return err
+	}
+	secret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+	secret.Annotations[annotationLetsEncryptCertificateRenewalsPausedUntil] = pausedUntil.UTC().Format(time.RFC3339)
+	delete(secret.Annotations, annotationLetsEncryptCertificateRollback)
+
+	if dataSecret != secret {
+		dataSecret.Annotations[annotationLetsEncryptCertificateState] = string(letsEncryptCertificateStateByteArray)
+		dataSecret, err = updateSecretData(ctx, kubeClientset, dataSecret, initiator)
+		if err != nil {
+			return err
+		}
+	}
+	secret, err = updateSecretData(ctx, kubeClientset, secret, initiator)
+	if err != nil {
+		return err
+	}
+	if desiredState.TargetSecret == "" {
+		dataSecret = secret
+	}
+
+	err = postEventAboutStatus(ctx, kubeClientset, secret, "Warning", "Rollback", "RolledBack", fmt.Sprintf("Certificate for secret %v has been rolled back to the previous one, renewals are paused until %v", secret.Name, secret.Annotations[annotationLetsEncryptCertificateRenewalsPausedUntil]), "Secret", "estafette.io/letsencrypt-certificate", os.Getenv("HOSTNAME"))
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%v] Secret %v.%v - Posting rollback event failed", initiator, secret.Name, secret.Namespace)
+	}
+	notifyRenewal(ctx, secret, initiator, desiredState, "rolled-back", nil)
+
+	// replace the certificate in the copies and cloudflare as well
+	return distributeCertificates(ctx, kubeClientset, secret, dataSecret, initiator, "rollback", desiredState, currentState, certificate, privateKey)
+}
+
+// newRollbackHandler sets the rollback annotation on the secret in the namespace and secret query parameters on POST /rollback, with the optional pause query parameter as its value; the replica owning the secret then performs the rollback
+func newRollbackHandler(ctx context.Context, kubeClientset *kubernetes.Clientset) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		namespace, name := r.URL.Query().Get("namespace"), r.URL.Query().Get("secret")
+		if namespace == "" || name == "" {
+			http.Error(w, "Query parameters namespace and secret are required", http.StatusBadRequest)
+			return
+		}
+
+		value := "true"
+		if pause := r.URL.Query().Get("pause"); pause != "" {
+			if _, err := getRollbackPause(pause); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			value = pause
+		}
+
+		secret, err := kubeClientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("Secret %v.%v doesn't exist", name, namespace), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Getting secret %v.%v for rollback failed", name, namespace)
+			http.Error(w, "Getting secret failed", http.StatusInternalServerError)
+			return
+		}
+		if secret.Annotations[annotationLetsEncryptCertificate] != "true" {
+			http.Error(w, fmt.Sprintf("Secret %v.%v isn't managed by the controller", name, namespace), http.StatusBadRequest)
+			return
+		}
+
+		log.Info().Msgf("Requesting rollback of secret %v.%v through admin endpoint", name, namespace)
+		secret.Annotations[annotationLetsEncryptCertificateRollback] = value
+		setLastChangedBy(secret, "admin")
+		_, err = kubeClientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			log.Error().Err(err).Msgf("Requesting rollback of secret %v.%v failed", name, namespace)
+			http.Error(w, "Updating secret failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetRollbackPause(t *testing.T) {
+	t.Run("ReturnsFlagValueForTrue", func(t *testing.T) {
+
+		*rollbackPause = 72 * time.Hour
+		defer func() { *rollbackPause = 0 }()
+
+		// act
+		pause, err := getRollbackPause("true")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 72*time.Hour, pause)
+	})
+
+	t.Run("ReturnsDuration", func(t *testing.T) {
+
+		// act
+		pause, err := getRollbackPause("48h")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 48*time.Hour, pause)
+	})
+
+	t.Run("ReturnsErrorForFalse", func(t *testing.T) {
+
+		// act
+		_, err := getRollbackPause("false")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForNegativeDuration", func(t *testing.T) {
+
+		// act
+		_, err := getRollbackPause("-1h")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestIsRenewalPaused(t *testing.T) {
+	t.Run("ReturnsTrueBeforePausedUntil", func(t *testing.T) {
+
+		now := time.Now()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"estafette.io/letsencrypt-certificate-renewals-paused-until": now.Add(time.Hour).Format(time.RFC3339)}}}
+
+		// act
+		paused := isRenewalPaused(secret, now)
+
+		assert.True(t, paused)
+	})
+
+	t.Run("ReturnsFalseAfterPausedUntil", func(t *testing.T) {
+
+		now := time.Now()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"estafette.io/letsencrypt-certificate-renewals-paused-until": now.Add(-time.Hour).Format(time.RFC3339)}}}
+
+		// act
+		paused := isRenewalPaused(secret, now)
+
+		assert.False(t, paused)
+	})
+
+	t.Run("ReturnsFalseWithoutAnnotation", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+		// act
+		paused := isRenewalPaused(secret, time.Now())
+
+		assert.False(t, paused)
+	})
+}
+
+func TestGetRollbackPausedUntil(t *testing.T) {
+	t.Run("ReturnsEndOfPauseIfPreviousCertificateIsValidLongEnough", func(t *testing.T) {
+
+		now := time.Now()
+		certificate := generateTestCertificate(t, now.Add(-30*24*time.Hour), now.Add(60*24*time.Hour))
+
+		// act
+		pausedUntil, err := getRollbackPausedUntil(certificate, 20, 24*time.Hour, now)
+
+		assert.Nil(t, err)
+		assert.Equal(t, now.Add(24*time.Hour), pausedUntil)
+	})
+
+	t.Run("CapsPauseAtStartOfRenewBeforeMargin", func(t *testing.T) {
+
+		now := time.Now().UTC().Truncate(time.Second)
+		notAfter := now.Add(25 * 24 * time.Hour)
+		certificate := generateTestCertificate(t, now.Add(-65*24*time.Hour), notAfter)
+
+		// act
+		pausedUntil, err := getRollbackPausedUntil(certificate, 20, 7*24*time.Hour, now)
+
+		assert.Nil(t, err)
+		assert.True(t, notAfter.Add(-20*24*time.Hour).Equal(pausedUntil))
+	})
+
+	t.Run("ReturnsErrorIfPreviousCertificateIsWithinRenewBeforeMargin", func(t *testing.T) {
+
+		now := time.Now()
+		certificate := generateTestCertificate(t, now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))
+
+		// act
+		_, err := getRollbackPausedUntil(certificate, 20, 24*time.Hour, now)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfPreviousCertificateIsExpired", func(t *testing.T) {
+
+		now := time.Now()
+		certificate := generateTestCertificate(t, now.Add(-90*24*time.Hour), now.Add(-time.Hour))
+
+		// act
+		_, err := getRollbackPausedUntil(certificate, 20, 24*time.Hour, now)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSwapPreviousCertificate(t *testing.T) {
+	t.Run("SwapsLiveAndPreviousCertificates", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt-2"), "tls.key": []byte("key-2"), "tls.pem": []byte("crt-2key-2"), "tls-previous.crt": []byte("crt-1"), "tls-previous.key": []byte("key-1")}}
+
+		// act
+		certificate, privateKey, err := swapPreviousCertificate(secret, "tls")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("crt-1"), certificate)
+		assert.Equal(t, []byte("key-1"), privateKey)
+		assert.Equal(t, []byte("crt-1"), secret.Data["tls.crt"])
+		assert.Equal(t, []byte("key-1"), secret.Data["tls.key"])
+		assert.Equal(t, []byte("crt-1key-1"), secret.Data["tls.pem"])
+		assert.Equal(t, []byte("crt-2"), secret.Data["tls-previous.crt"])
+		assert.Equal(t, []byte("key-2"), secret.Data["tls-previous.key"])
+	})
+
+	t.Run("ReturnsErrorWithoutPreviousCertificate", func(t *testing.T) {
+
+		secret := &v1.Secret{Data: map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key")}}
+
+		// act
+		_, _, err := swapPreviousCertificate(secret, "tls")
+
+		assert.NotNil(t, err)
+		assert.Equal(t, []byte("crt"), secret.Data["tls.crt"])
+	})
+}
+
+func TestRollbackHandler(t *testing.T) {
+	t.Run("RespondsMethodNotAllowedForGet", func(t *testing.T) {
+
+		request := httptest.NewRequest("GET", "/rollback?namespace=my-namespace&secret=my-secret", nil)
+		recorder := httptest.NewRecorder()
+
+		// act
+		newRollbackHandler(context.Background(), nil)(recorder, request)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+
+	t.Run("RespondsBadRequestWithoutSecret", func(t *testing.T) {
+
+		request := httptest.NewRequest("POST", "/rollback?namespace=my-namespace", nil)
+		recorder := httptest.NewRecorder()
+
+		// act
+		newRollbackHandler(context.Background(), nil)(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("RespondsBadRequestForInvalidPause", func(t *testing.T) {
+
+		request := httptest.NewRequest("POST", "/rollback?namespace=my-namespace&secret=my-secret&pause=soon", nil)
+		recorder := httptest.NewRecorder()
+
+		// act
+		newRollbackHandler(context.Background(), nil)(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
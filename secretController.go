@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// queuedSecret is what triggered the evaluation of a secret waiting in the queue and when it has been received; the queue itself only holds the namespace/name keys, so all triggers for the same secret are deduplicated into a single evaluation
+type queuedSecret struct {
+	Initiator string
+	At        time.Time
+}
+
+// secretController feeds the events of a secrets informer and the periodic polls into a rate-limited workqueue, which is processed by a fixed number of workers
+type secretController struct {
+	kubeClientset *kubernetes.Clientset
+	informer      cache.SharedIndexInformer
+	lister        corelisters.SecretLister
+	queue         workqueue.RateLimitingInterface
+	debouncer     *debouncer
+	maxRetries    int
+
+	// what triggered the items in the queue and when they have been received, to pass the initiator on and measure the latency until they're processed
+	queuedMutex sync.Mutex
+	queued      map[string]queuedSecret
+}
+
+func newSecretController(kubeClientset *kubernetes.Clientset, factory informers.SharedInformerFactory) *secretController {
+	c := &secretController{
+		kubeClientset: kubeClientset,
+		informer:      factory.Core().V1().Secrets().Informer(),
+		lister:        factory.Core().V1().Secrets().Lister(),
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(*secretRetryBaseDelay, *secretRetryMaxDelay), "secrets"),
+		maxRetries:    *secretMaxRetries,
+		queued:        map[string]queuedSecret{},
+	}
+	if *secretEventDebounce > 0 {
+		c.debouncer = newDebouncer(*secretEventDebounce, *secretEventMaxWait)
+	}
+
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueWatched(obj, "watcher:ADDED")
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, oldOk := oldObj.(*v1.Secret)
+			newSecret, newOk := newObj.(*v1.Secret)
+			if oldOk && newOk && oldSecret.ResourceVersion == newSecret.ResourceVersion {
+				return
+			}
+			c.enqueueWatched(newObj, "watcher:MODIFIED")
+		},
+	})
+
+	return c
+}
+
+// enqueueWatched adds a secret from an informer event to the queue once no more events for it came in for the debounce delay, so a burst of events for the same secret results in a single evaluation of its latest version
+func (c *secretController) enqueueWatched(obj interface{}, initiator string) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Warn().Err(err).Msg("Watcher for secrets returns event object of incorrect type")
+		return
+	}
+	if !replicaShard.Owns(key) {
+		return
+	}
+
+	c.markQueued(key, initiator, time.Now())
+	if c.debouncer == nil {
+		c.queue.Add(key)
+		return
+	}
+
+	c.debouncer.Debounce(key, func() {
+		c.queue.Add(key)
+	})
+}
+
+// Run starts the informer, the poller and the workers, and returns once the informer has synced
+func (c *secretController) Run(ctx context.Context, waitGroup *sync.WaitGroup, stopper chan struct{}, workers int) error {
+	log.Info().Msg("Watching secrets for all namespaces...")
+
+	go c.informer.Run(stopper)
+	if !cache.WaitForCacheSync(stopper, c.informer.HasSynced) {
+		return fmt.Errorf("Syncing the secrets informer failed")
+	}
+
+	go func() {
+		<-stopper
+		c.queue.ShutDown()
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c.processNextItem(ctx, waitGroup) {
+			}
+		}()
+	}
+
+	go c.poll(ctx)
+
+	return nil
+}
+
+// poll periodically queues all secrets from the informer's cache as a safety net for missed events and for the checks that only run for the poller, like refreshing cloudflare uploads
+func (c *secretController) poll(ctx context.Context) {
+	sleeper := newLoopSleeper(*loopSleepStrategy, 900*time.Second, *loopMaxSleep, *jitterDeviation)
+
+	// loop indefinitely
+	for {
+		log.Info().Msg("Listing secrets for all namespaces...")
+		listStartedAt := time.Now()
+		cachedSecrets, err := c.lister.List(labels.Everything())
+		if err != nil {
+			log.Error().Err(err).Msg("ListSecrets call failed")
+		}
+		log.Info().Msgf("Cluster has %v secrets", len(cachedSecrets))
+
+		// the secrets in the cache are shared with the informer, so only read them
+		secrets := make([]v1.Secret, 0, len(cachedSecrets))
+		ownedSecrets := make([]v1.Secret, 0, len(cachedSecrets))
+		for _, secret := range cachedSecrets {
+			secrets = append(secrets, *secret)
+			if replicaShard.Owns(fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)) {
+				ownedSecrets = append(ownedSecrets, *secret)
+			}
+		}
+
+		// each replica only exposes the metrics of its own shard, so they can be summed across replicas
+		certificateExpiry.Update(ownedSecrets)
+		certificateRenewals.Update(secrets, replicaShard.Owns)
+		secretStates.Prune(ownedSecrets)
+
+		// conflicts can be between secrets of different shards, and the ca bundle covers the secrets of all shards
+		if replicaShard.IsFirst() {
+			reportHostnameConflicts(ctx, c.kubeClientset, secrets)
+			if *caBundleConfigMap != "" && err == nil {
+				if bundleErr := writeCABundleConfigMaps(ctx, c.kubeClientset, *caBundleConfigMap, *caBundleNamespaces, secrets); bundleErr != nil {
+					log.Warn().Err(bundleErr).Msgf("Writing ca bundle config map %v failed", *caBundleConfigMap)
+				}
+			}
+		}
+
+		for _, secret := range ownedSecrets {
+			key := fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)
+			c.markQueued(key, "poller", listStartedAt)
+			c.queue.Add(key)
+		}
+
+		// sleep random time around 900 seconds, or longer when backing off from a failed listing
+		sleepTime := sleeper.Next(err != nil)
+		log.Info().Msgf("Sleeping for %v seconds...", int(sleepTime.Seconds()))
+		time.Sleep(sleepTime)
+	}
+}
+
+// processNextItem evaluates the next secret in the queue and retries it with backoff if it failed; it returns false once the queue is shut down
+func (c *secretController) processNextItem(ctx context.Context, waitGroup *sync.WaitGroup) bool {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.queue.Forget(obj)
+		return true
+	}
+	item, hasQueued := c.takeQueued(key)
+	if !hasQueued {
+		// queued again by an event that arrived while the secret was processed
+		item.Initiator = "watcher"
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.queue.Forget(obj)
+		return true
+	}
+
+	cachedSecret, err := c.lister.Secrets(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		// deleted since it was queued
+		c.queue.Forget(obj)
+		return true
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] Secret %v.%v - Getting secret from cache failed", item.Initiator, name, namespace)
+		c.queue.Forget(obj)
+		return true
+	}
+
+	waitGroup.Add(1)
+	// work on a copy, since processing the secret changes it and the cached secret is shared with the informer; errors are logged by processSecret, at most once per interval for identical errors
+	status, err := processSecret(ctx, c.kubeClientset, cachedSecret.DeepCopy(), item.Initiator)
+	certificateTotals.With(prometheus.Labels{"namespace": namespace, "status": status, "initiator": getInitiatorType(item.Initiator), "type": "secret", "wildcard": getWildcardLabel(cachedSecret.Annotations[annotationLetsEncryptCertificateHostnames])}).Inc()
+	waitGroup.Done()
+
+	if err != nil && c.queue.NumRequeues(obj) < c.maxRetries {
+		// measure the latency up to the last retry, from when the secret was first received
+		if hasQueued {
+			c.markQueued(key, item.Initiator, item.At)
+		}
+		c.queue.AddRateLimited(obj)
+		return true
+	}
+
+	if hasQueued {
+		secretProcessingLatency.With(prometheus.Labels{"initiator": getInitiatorType(item.Initiator)}).Observe(time.Since(item.At).Seconds())
+	}
+
+	c.queue.Forget(obj)
+	return true
+}
+
+// markQueued records what triggered the secret with the key and when it has been received, keeping the earliest receipt while it's waiting in the queue; a watcher initiator takes precedence over the poller, so a change isn't skipped as unchanged by the poller's state cache
+func (c *secretController) markQueued(key, initiator string, at time.Time) {
+	c.queuedMutex.Lock()
+	defer c.queuedMutex.Unlock()
+
+	queued, ok := c.queued[key]
+	if !ok {
+		c.queued[key] = queuedSecret{Initiator: initiator, At: at}
+		return
+	}
+
+	if at.Before(queued.At) {
+		queued.At = at
+	}
+	if initiator != "poller" || queued.Initiator == "" {
+		queued.Initiator = initiator
+	}
+	c.queued[key] = queued
+}
+
+// takeQueued returns and forgets what triggered the secret with the key and when, so events arriving while it's processed are measured from their own receipt
+func (c *secretController) takeQueued(key string) (queuedSecret, bool) {
+	c.queuedMutex.Lock()
+	defer c.queuedMutex.Unlock()
+
+	queued, ok := c.queued[key]
+	delete(c.queued, key)
+
+	return queued, ok
+}
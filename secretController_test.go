@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestEnqueueWatched(t *testing.T) {
+	t.Run("DeduplicatesEventsForTheSameSecret", func(t *testing.T) {
+
+		c := &secretController{queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), queued: map[string]queuedSecret{}}
+		defer c.queue.ShutDown()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+
+		assert.Equal(t, 1, c.queue.Len())
+		item, _ := c.queue.Get()
+		assert.Equal(t, "mynamespace/mysecret", item)
+		queued, ok := c.takeQueued("mynamespace/mysecret")
+		assert.True(t, ok)
+		assert.Equal(t, "watcher:MODIFIED", queued.Initiator)
+	})
+
+	t.Run("DeduplicatesEventsWithDifferentInitiatorsForTheSameSecret", func(t *testing.T) {
+
+		c := &secretController{queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), queued: map[string]queuedSecret{}}
+		defer c.queue.ShutDown()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		c.enqueueWatched(secret, "watcher:ADDED")
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+
+		assert.Equal(t, 1, c.queue.Len())
+		queued, _ := c.takeQueued("mynamespace/mysecret")
+		assert.Equal(t, "watcher:MODIFIED", queued.Initiator)
+	})
+
+	t.Run("QueuesSecretAfterDebounceDelay", func(t *testing.T) {
+
+		c := &secretController{queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), queued: map[string]queuedSecret{}, debouncer: newDebouncer(50*time.Millisecond, 0)}
+		defer c.queue.ShutDown()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+		queuedBeforeDelay := c.queue.Len()
+		time.Sleep(200 * time.Millisecond)
+
+		assert.Equal(t, 0, queuedBeforeDelay)
+		assert.Equal(t, 1, c.queue.Len())
+	})
+
+	t.Run("PostponesQueueingSecretWhileEventsKeepComingIn", func(t *testing.T) {
+
+		c := &secretController{queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), queued: map[string]queuedSecret{}, debouncer: newDebouncer(200*time.Millisecond, 0)}
+		defer c.queue.ShutDown()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+		time.Sleep(120 * time.Millisecond)
+		c.enqueueWatched(secret, "watcher:MODIFIED")
+		time.Sleep(120 * time.Millisecond)
+		queuedWhileEventsComeIn := c.queue.Len()
+		time.Sleep(400 * time.Millisecond)
+
+		assert.Equal(t, 0, queuedWhileEventsComeIn)
+		assert.Equal(t, 1, c.queue.Len())
+	})
+
+	t.Run("IgnoresObjectsWithoutMetadata", func(t *testing.T) {
+
+		c := &secretController{queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), queued: map[string]queuedSecret{}}
+		defer c.queue.ShutDown()
+
+		// act
+		c.enqueueWatched("mysecret", "watcher:ADDED")
+
+		assert.Equal(t, 0, c.queue.Len())
+	})
+}
+
+func TestMarkQueued(t *testing.T) {
+	t.Run("KeepsEarliestReceiptOfAWaitingItem", func(t *testing.T) {
+
+		c := &secretController{queued: map[string]queuedSecret{}}
+		firstEvent := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		// act
+		c.markQueued("mynamespace/mysecret", "watcher:MODIFIED", firstEvent)
+		c.markQueued("mynamespace/mysecret", "watcher:MODIFIED", firstEvent.Add(time.Second))
+
+		queued, ok := c.takeQueued("mynamespace/mysecret")
+		assert.True(t, ok)
+		assert.Equal(t, firstEvent, queued.At)
+	})
+
+	t.Run("KeepsWatcherInitiatorOverPoller", func(t *testing.T) {
+
+		c := &secretController{queued: map[string]queuedSecret{}}
+		now := time.Now()
+
+		// act
+		c.markQueued("mynamespace/mysecret", "watcher:MODIFIED", now)
+		c.markQueued("mynamespace/mysecret", "poller", now.Add(-time.Second))
+
+		queued, _ := c.takeQueued("mynamespace/mysecret")
+		assert.Equal(t, "watcher:MODIFIED", queued.Initiator)
+		assert.Equal(t, now.Add(-time.Second), queued.At)
+	})
+
+	t.Run("ReplacesPollerInitiatorWithWatcher", func(t *testing.T) {
+
+		c := &secretController{queued: map[string]queuedSecret{}}
+		now := time.Now()
+
+		// act
+		c.markQueued("mynamespace/mysecret", "poller", now)
+		c.markQueued("mynamespace/mysecret", "watcher:MODIFIED", now.Add(time.Second))
+
+		queued, _ := c.takeQueued("mynamespace/mysecret")
+		assert.Equal(t, "watcher:MODIFIED", queued.Initiator)
+	})
+
+	t.Run("ForgetsReceiptOnceTaken", func(t *testing.T) {
+
+		c := &secretController{queued: map[string]queuedSecret{}}
+		c.markQueued("mynamespace/mysecret", "poller", time.Now())
+		c.takeQueued("mynamespace/mysecret")
+
+		// act
+		_, ok := c.takeQueued("mynamespace/mysecret")
+
+		assert.False(t, ok)
+	})
+}
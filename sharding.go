@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// secretShard is the part of the secrets a replica owns when the secrets are sharded across replicas; each secret is owned by exactly one replica
+type secretShard struct {
+	index int
+	count int
+}
+
+// newSecretShard returns the shard of the replica with the index out of count replicas; an index of -1 is derived from the ordinal at the end of the hostname, as set for the pods of a stateful set
+func newSecretShard(index, count int, hostname string) (secretShard, error) {
+	if count < 1 {
+		return secretShard{}, fmt.Errorf("Shard count should be at least 1, but is %v", count)
+	}
+	if count == 1 {
+		return secretShard{index: 0, count: 1}, nil
+	}
+
+	if index == -1 {
+		ordinal, err := strconv.Atoi(hostname[strings.LastIndex(hostname, "-")+1:])
+		if err != nil {
+			return secretShard{}, fmt.Errorf("Hostname %v doesn't end with a replica ordinal to derive the shard index from", hostname)
+		}
+		index = ordinal
+	}
+	if index < 0 || index >= count {
+		return secretShard{}, fmt.Errorf("Shard index should be at least 0 and smaller than the shard count %v, but is %v", count, index)
+	}
+
+	return secretShard{index: index, count: count}, nil
+}
+
+// Owns returns true if the secret or custom resource with the namespace/name key is handled by this replica
+func (s secretShard) Owns(key string) bool {
+	if s.count <= 1 {
+		return true
+	}
+
+	return jumpConsistentHash(hashKey(key), s.count) == s.index
+}
+
+// hashKey returns the fnv-1a hash of the namespace/name key
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+
+	return hash.Sum64()
+}
+
+// IsFirst returns true for the replica that runs the cluster-wide tasks, like the canary, the tenant reports and the hostname conflict detection
+func (s secretShard) IsFirst() bool {
+	return s.index == 0
+}
+
+// jumpConsistentHash maps the key to one of the buckets, such that changing the number of buckets only moves the keys of the added or removed buckets; see https://arxiv.org/abs/1406.2294
+func jumpConsistentHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSecretShard(t *testing.T) {
+	t.Run("ReturnsSingleShardIfCountIsOne", func(t *testing.T) {
+
+		// act
+		shard, err := newSecretShard(-1, 1, "estafette-letsencrypt-certificate-5d9f7c8b6-x2x4z")
+
+		assert.Nil(t, err)
+		assert.Equal(t, secretShard{index: 0, count: 1}, shard)
+	})
+
+	t.Run("DerivesIndexFromStatefulSetOrdinal", func(t *testing.T) {
+
+		// act
+		shard, err := newSecretShard(-1, 3, "estafette-letsencrypt-certificate-2")
+
+		assert.Nil(t, err)
+		assert.Equal(t, secretShard{index: 2, count: 3}, shard)
+	})
+
+	t.Run("ReturnsErrorIfHostnameHasNoOrdinal", func(t *testing.T) {
+
+		// act
+		_, err := newSecretShard(-1, 3, "estafette-letsencrypt-certificate-5d9f7c8b6-x2x4z")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfIndexIsOutOfRange", func(t *testing.T) {
+
+		// act
+		_, err := newSecretShard(3, 3, "")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSecretShardOwns(t *testing.T) {
+	t.Run("AssignsEachSecretToExactlyOneShard", func(t *testing.T) {
+
+		shards := []secretShard{{index: 0, count: 3}, {index: 1, count: 3}, {index: 2, count: 3}}
+		secretsPerShard := make([]int, 3)
+
+		// act
+		for i := 0; i < 300; i++ {
+			owners := 0
+			for _, shard := range shards {
+				if shard.Owns(fmt.Sprintf("mynamespace/mysecret-%v", i)) {
+					owners++
+					secretsPerShard[shard.index]++
+				}
+			}
+			assert.Equal(t, 1, owners)
+		}
+
+		for _, count := range secretsPerShard {
+			assert.Greater(t, count, 50)
+		}
+	})
+
+	t.Run("OnlyMovesSecretsToAnAddedShard", func(t *testing.T) {
+
+		for i := 0; i < 300; i++ {
+			key := fmt.Sprintf("mynamespace/mysecret-%v", i)
+
+			// act
+			before := jumpConsistentHash(hashKey(key), 3)
+			after := jumpConsistentHash(hashKey(key), 4)
+
+			assert.True(t, after == before || after == 3)
+		}
+	})
+}
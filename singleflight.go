@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// certificateFlight is an in-progress or completed call to obtain certificates
+type certificateFlight struct {
+	done         chan struct{}
+	certificates *certificate.Resource
+	err          error
+}
+
+// certificateSingleFlight makes sure only one ACME order runs at a time for the same set of hostnames; concurrent callers for the same key wait for and share its result
+type certificateSingleFlight struct {
+	mutex   sync.Mutex
+	flights map[string]*certificateFlight
+}
+
+func newCertificateSingleFlight() *certificateSingleFlight {
+	return &certificateSingleFlight{
+		flights: map[string]*certificateFlight{},
+	}
+}
+
+// Do runs f unless a call for the same key is already in progress, in which case it waits for that call and returns its result with shared set to true
+func (s *certificateSingleFlight) Do(key string, f func() (*certificate.Resource, error)) (certificates *certificate.Resource, err error, shared bool) {
+	s.mutex.Lock()
+	if flight, ok := s.flights[key]; ok {
+		s.mutex.Unlock()
+		<-flight.done
+		return flight.certificates, flight.err, true
+	}
+
+	flight := &certificateFlight{done: make(chan struct{})}
+	s.flights[key] = flight
+	s.mutex.Unlock()
+
+	flight.certificates, flight.err = f()
+	close(flight.done)
+
+	s.mutex.Lock()
+	delete(s.flights, key)
+	s.mutex.Unlock()
+
+	return flight.certificates, flight.err, false
+}
+
+// getCertificateFlightKey returns the same key for identical hostname sets regardless of their order; the environment, ACME directory, key type, bundling, account and dns provider are included since those lead to different orders, and so is the credentials secret together with the namespace it's read from, so one tenant's order never runs with another tenant's credentials
+func getCertificateFlightKey(namespace string, state LetsEncryptCertificateState, hostnames []string) string {
+	sortedHostnames := append([]string{}, hostnames...)
+	sort.Strings(sortedHostnames)
+
+	credentials := ""
+	if state.CredentialsSecret != "" {
+		credentials = namespace + "/" + state.CredentialsSecret
+	}
+
+	return strings.Join([]string{state.Environment, state.ACMEDirectoryURL, state.KeyType, strconv.FormatBool(state.LeafOnly), state.Account, strings.ToLower(state.AccountEmail), state.DNSProvider, credentials, strings.Join(sortedHostnames, ",")}, "|")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateSingleFlight(t *testing.T) {
+	t.Run("RunsOnlyOneCallForConcurrentCallsWithTheSameKey", func(t *testing.T) {
+
+		s := newCertificateSingleFlight()
+		var calls int32
+		var wg sync.WaitGroup
+
+		// act
+		results := make([]*certificate.Resource, 3)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], _, _ = s.Do("estafette.io", func() (*certificate.Resource, error) {
+					atomic.AddInt32(&calls, 1)
+					time.Sleep(100 * time.Millisecond)
+					return &certificate.Resource{Domain: "estafette.io"}, nil
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		for _, result := range results {
+			assert.Equal(t, "estafette.io", result.Domain)
+		}
+	})
+
+	t.Run("RunsAgainOnceThePreviousCallHasCompleted", func(t *testing.T) {
+
+		s := newCertificateSingleFlight()
+		var calls int32
+		f := func() (*certificate.Resource, error) {
+			atomic.AddInt32(&calls, 1)
+			return &certificate.Resource{}, nil
+		}
+
+		// act
+		_, _, shared := s.Do("estafette.io", f)
+		_, _, sharedAgain := s.Do("estafette.io", f)
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+		assert.False(t, shared)
+		assert.False(t, sharedAgain)
+	})
+}
+
+func TestGetCertificateFlightKey(t *testing.T) {
+	t.Run("ReturnsSameKeyForHostnamesInDifferentOrder", func(t *testing.T) {
+
+		state := LetsEncryptCertificateState{Environment: environmentProduction}
+
+		// act
+		key := getCertificateFlightKey("default", state, []string{"www.estafette.io", "estafette.io"})
+		otherKey := getCertificateFlightKey("default", state, []string{"estafette.io", "www.estafette.io"})
+
+		assert.Equal(t, key, otherKey)
+	})
+
+	t.Run("ReturnsDifferentKeyForDifferentEnvironment", func(t *testing.T) {
+
+		// act
+		key := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction}, []string{"estafette.io"})
+		otherKey := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentStaging}, []string{"estafette.io"})
+
+		assert.NotEqual(t, key, otherKey)
+	})
+	t.Run("ReturnsDifferentKeyForDifferentDNSProvider", func(t *testing.T) {
+
+		// act
+		key := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction, DNSProvider: "cloudflare"}, []string{"estafette.io"})
+		otherKey := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction, DNSProvider: "route53"}, []string{"estafette.io"})
+
+		assert.NotEqual(t, key, otherKey)
+	})
+
+	t.Run("ReturnsDifferentKeyForSameCredentialsSecretInDifferentNamespace", func(t *testing.T) {
+
+		state := LetsEncryptCertificateState{Environment: environmentProduction, CredentialsSecret: "cloudflare-credentials"}
+
+		// act
+		key := getCertificateFlightKey("tenant-a", state, []string{"estafette.io"})
+		otherKey := getCertificateFlightKey("tenant-b", state, []string{"estafette.io"})
+
+		assert.NotEqual(t, key, otherKey)
+	})
+
+	t.Run("ReturnsDifferentKeyForDifferentCredentialsSecret", func(t *testing.T) {
+
+		// act
+		key := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction}, []string{"estafette.io"})
+		otherKey := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction, CredentialsSecret: "cloudflare-credentials"}, []string{"estafette.io"})
+
+		assert.NotEqual(t, key, otherKey)
+	})
+
+	t.Run("ReturnsSameKeyInDifferentNamespacesWithoutCredentialsSecret", func(t *testing.T) {
+
+		state := LetsEncryptCertificateState{Environment: environmentProduction}
+
+		// act
+		key := getCertificateFlightKey("tenant-a", state, []string{"estafette.io"})
+		otherKey := getCertificateFlightKey("tenant-b", state, []string{"estafette.io"})
+
+		assert.Equal(t, key, otherKey)
+	})
+	t.Run("ReturnsDifferentKeyForLeafOnlyCertificates", func(t *testing.T) {
+
+		// act
+		key := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction}, []string{"estafette.io"})
+		otherKey := getCertificateFlightKey("default", LetsEncryptCertificateState{Environment: environmentProduction, LeafOnly: true}, []string{"estafette.io"})
+
+		assert.NotEqual(t, key, otherKey)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"time"
+)
+
+// loopSleeper determines how long the main loops sleep between iterations, backing off after failed iterations depending on the strategy
+type loopSleeper struct {
+	strategy  string
+	base      time.Duration
+	max       time.Duration
+	deviation float64
+	previous  time.Duration
+	failures  int
+}
+
+func newLoopSleeper(strategy string, base, max time.Duration, deviation float64) *loopSleeper {
+	return &loopSleeper{
+		strategy:  strategy,
+		base:      base,
+		max:       max,
+		deviation: deviation,
+		previous:  base,
+	}
+}
+
+// Next returns the time to sleep after an iteration; after a successful iteration that's always the jittered base, after consecutive failures fixed keeps using it, exponential doubles it per failure and decorrelated picks a random time between the base and three times the previous sleep, both up to the max
+func (s *loopSleeper) Next(failed bool) time.Duration {
+	if !failed {
+		s.failures = 0
+		s.previous = s.base
+		return applyJitter(s.base, s.deviation)
+	}
+
+	s.failures++
+
+	switch s.strategy {
+	case "exponential":
+		sleepTime := s.base
+		for i := 0; i < s.failures && sleepTime < s.max; i++ {
+			sleepTime *= 2
+		}
+		if sleepTime > s.max {
+			sleepTime = s.max
+		}
+		return applyJitter(sleepTime, s.deviation)
+
+	case "decorrelated":
+		sleepTime := s.base
+		if upperBound := 3 * s.previous; upperBound > s.base {
+			sleepTime += time.Duration(r.Int63n(int64(upperBound - s.base)))
+		}
+		if sleepTime > s.max {
+			sleepTime = s.max
+		}
+		s.previous = sleepTime
+		return sleepTime
+	}
+
+	return applyJitter(s.base, s.deviation)
+}
+
+// applyJitter returns a random duration within the deviation as a fraction of the input around the input
+func applyJitter(input time.Duration, deviation float64) time.Duration {
+	maxDeviation := time.Duration(deviation * float64(input))
+	if maxDeviation <= 0 {
+		return input
+	}
+
+	return input - maxDeviation + time.Duration(r.Int63n(int64(2*maxDeviation)))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyJitter(t *testing.T) {
+
+	t.Run("ReturnsDurationWithinDeviation", func(t *testing.T) {
+
+		for i := 0; i < 100; i++ {
+			// act
+			sleepTime := applyJitter(900*time.Second, 0.25)
+
+			assert.GreaterOrEqual(t, sleepTime, 675*time.Second)
+			assert.Less(t, sleepTime, 1125*time.Second)
+		}
+	})
+
+	t.Run("ReturnsInputIfDeviationIsZero", func(t *testing.T) {
+
+		// act
+		sleepTime := applyJitter(900*time.Second, 0)
+
+		assert.Equal(t, 900*time.Second, sleepTime)
+	})
+}
+
+func TestLoopSleeper(t *testing.T) {
+
+	t.Run("ReturnsBaseAfterSuccess", func(t *testing.T) {
+
+		sleeper := newLoopSleeper("exponential", 30*time.Second, time.Hour, 0)
+		sleeper.Next(true)
+
+		// act
+		sleepTime := sleeper.Next(false)
+
+		assert.Equal(t, 30*time.Second, sleepTime)
+	})
+
+	t.Run("ReturnsBaseAfterFailureForFixedStrategy", func(t *testing.T) {
+
+		sleeper := newLoopSleeper("fixed", 30*time.Second, time.Hour, 0)
+		sleeper.Next(true)
+
+		// act
+		sleepTime := sleeper.Next(true)
+
+		assert.Equal(t, 30*time.Second, sleepTime)
+	})
+
+	t.Run("DoublesPerFailureForExponentialStrategy", func(t *testing.T) {
+
+		sleeper := newLoopSleeper("exponential", 30*time.Second, time.Hour, 0)
+		sleeper.Next(true)
+
+		// act
+		sleepTime := sleeper.Next(true)
+
+		assert.Equal(t, 120*time.Second, sleepTime)
+	})
+
+	t.Run("ReturnsMaxForExponentialStrategyAfterManyFailures", func(t *testing.T) {
+
+		sleeper := newLoopSleeper("exponential", 30*time.Second, time.Hour, 0)
+		for i := 0; i < 100; i++ {
+			sleeper.Next(true)
+		}
+
+		// act
+		sleepTime := sleeper.Next(true)
+
+		assert.Equal(t, time.Hour, sleepTime)
+	})
+
+	t.Run("ReturnsDurationBetweenBaseAndThreeTimesPreviousForDecorrelatedStrategy", func(t *testing.T) {
+
+		sleeper := newLoopSleeper("decorrelated", 30*time.Second, time.Hour, 0)
+
+		for i := 0; i < 100; i++ {
+			previous := sleeper.previous
+
+			// act
+			sleepTime := sleeper.Next(true)
+
+			assert.GreaterOrEqual(t, sleepTime, 30*time.Second)
+			assert.LessOrEqual(t, sleepTime, 3*previous)
+			assert.LessOrEqual(t, sleepTime, time.Hour)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sniCertificateNameRegex restricts the names of the certificates in an sni bundle to what's valid in a data item key; the names of the dual key types items are reserved
+var sniCertificateNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// sniCertificateState is the state of a single certificate of an sni bundle, renewed independently of the main certificate and the other certificates in the bundle
+type sniCertificateState struct {
+	Hostnames   string `json:"hostnames"`
+	LastRenewed string `json:"lastRenewed,omitempty"`
+	LastAttempt string `json:"lastAttempt,omitempty"`
+}
+
+// getSNICertificates returns the hostnames per name of the certificates of the sni bundle defined by the sni-certificates annotation
+func getSNICertificates(secret *v1.Secret) (map[string]string, error) {
+	return parseSNICertificates(secret.Annotations[annotationLetsEncryptCertificateSNICertificates])
+}
+
+// parseSNICertificates parses the json object of names to comma-separated hostnames of the sni-certificates annotation
+func parseSNICertificates(value string) (map[string]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var certificates map[string]string
+	err := json.Unmarshal([]byte(value), &certificates)
+	if err != nil {
+		return nil, fmt.Errorf("Value of %v is not a json object of names to hostnames: %w", annotationLetsEncryptCertificateSNICertificates, err)
+	}
+
+	for name, hostnames := range certificates {
+		if !sniCertificateNameRegex.MatchString(name) || containsString(dualCertificateDataPrefixes, getSNICertificateDataPrefix(name)) || isPreviousCertificateDataKey(getSNICertificateDataPrefix(name)+".crt") {
+			return nil, fmt.Errorf("Name %v of sni certificate is invalid, it should consist of lowercase letters, digits and dashes and not be ecdsa, rsa or start with previous", name)
+		}
+		certificates[name] = normalizeHostnames(hostnames)
+		if certificates[name] == "" {
+			return nil, fmt.Errorf("Sni certificate %v has no hostnames", name)
+		}
+	}
+
+	return certificates, nil
+}
+
+// hasSNIBundle checks whether the secret defines an sni bundle or still has the state of one that was removed, whose certificates have to be cleaned up
+func hasSNIBundle(secret *v1.Secret) bool {
+	_, hasSNICertificates := secret.Annotations[annotationLetsEncryptCertificateSNICertificates]
+	_, hasSNIState := secret.Annotations[annotationLetsEncryptCertificateSNIState]
+	return hasSNICertificates || hasSNIState
+}
+
+// getSNICertificateStates returns the state per name of the certificates of the sni bundle
+func getSNICertificateStates(secret *v1.Secret) map[string]sniCertificateState {
+	states := map[string]sniCertificateState{}
+	if value, ok := secret.Annotations[annotationLetsEncryptCertificateSNIState]; ok {
+		err := json.Unmarshal([]byte(value), &states)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Secret %v.%v - Unmarshalling sni certificates state failed, renewing all of them", secret.Name, secret.Namespace)
+			return map[string]sniCertificateState{}
+		}
+	}
+
+	return states
+}
+
+// getSNICertificateDataPrefix returns the prefix of the data items the certificate with the name is stored under
+func getSNICertificateDataPrefix(name string) string {
+	return "tls-" + name
+}
+
+// isSNICertificateDue checks whether a certificate of the sni bundle has to be obtained because it's new, its hostnames have changed or it's due for renewal
+func isSNICertificateDue(dataSecret *v1.Secret, name, hostnames string, state sniCertificateState, desiredState LetsEncryptCertificateState, now time.Time) bool {
+	certificateBytes, ok := dataSecret.Data[getSNICertificateDataPrefix(name)+".crt"]
+	if !ok || state.Hostnames != hostnames {
+		return true
+	}
+
+	lastRenewed, _ := time.Parse(time.RFC3339, state.LastRenewed)
+
+	return now.After(getRenewalTimeForCertificate(certificateBytes, lastRenewed, desiredState.RenewDaysBefore))
+}
+
+// renewSNICertificates obtains the certificates of the sni bundle that are due, each with its own lock for the retry delay, and removes the ones no longer in the annotation
+func renewSNICertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState) (status string, err error) {
+	status = "failed"
+
+	certificates, err := getSNICertificates(secret)
+	if err != nil {
+		return status, err
+	}
+	if desiredState.Enabled != "true" || controllerPause.IsPaused() {
+		return "skipped", nil
+	}
+
+	// reload secret to avoid object has been modified error
+	secret, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return status, err
+	}
+	dataSecret, err := getCertificateDataSecret(ctx, kubeClientset, secret, desiredState)
+	if err != nil {
+		return status, err
+	}
+	states := getSNICertificateStates(secret)
+
+	now := time.Now()
+	dueNames := []string{}
+	for name, hostnames := range certificates {
+		state := states[name]
+		lastAttempt, _ := time.Parse(time.RFC3339, state.LastAttempt)
+		if now.Sub(lastAttempt) > getRetryAfter(desiredState) && isSNICertificateDue(dataSecret, name, hostnames, state, desiredState, now) {
+			dueNames = append(dueNames, name)
+		}
+	}
+	sort.Strings(dueNames)
+
+	removedNames := []string{}
+	for name := range states {
+		if _, ok := certificates[name]; !ok {
+			removedNames = append(removedNames, name)
+		}
+	}
+
+	if len(dueNames) == 0 && len(removedNames) == 0 {
+		return "skipped", nil
+	}
+
+	// lock the due certificates for the retry delay before ordering them, like the main certificate
+	for _, name := range dueNames {
+		state := states[name]
+		state.LastAttempt = now.Format(time.RFC3339)
+		states[name] = state
+	}
+	err = updateSNICertificateStates(ctx, kubeClientset, secret, initiator, states)
+	if err != nil {
+		return status, err
+	}
+
+	obtained := map[string]*certificate.Resource{}
+	obtainErrors := []error{}
+	for _, name := range dueNames {
+		log.Info().Msgf("[%v] Secret %v.%v - Obtaining sni certificate %v for %v...", initiator, secret.Name, secret.Namespace, name, certificates[name])
+
+		resource, err := obtainSNICertificate(ctx, kubeClientset, secret, initiator, desiredState, certificates[name], dataSecret.Data[getSNICertificateDataPrefix(name)+".crt"])
+		if err != nil {
+			obtainErrors = append(obtainErrors, fmt.Errorf("sni certificate %v: %w", name, err))
+			continue
+		}
+		obtained[name] = resource
+	}
+
+	if len(obtained) > 0 || len(removedNames) > 0 {
+		err = storeSNICertificates(ctx, kubeClientset, secret, initiator, desiredState, certificates, obtained, removedNames, now)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	if len(obtainErrors) > 0 {
+		return status, fmt.Errorf("Obtaining %v out of %v sni certificates failed: %w", len(obtainErrors), len(dueNames), utilerrors.NewAggregate(obtainErrors))
+	}
+	if len(obtained) == 0 {
+		return "skipped", nil
+	}
+
+	return "succeeded", nil
+}
+
+// obtainSNICertificate obtains a certificate for the hostnames of a single certificate of the sni bundle, with the other settings of the secret
+func obtainSNICertificate(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, hostnames string, replacedCertificate []byte) (*certificate.Resource, error) {
+	sniState := desiredState
+	sniState.Hostnames = hostnames
+
+	hostnameList := strings.Split(hostnames, ",")
+	for _, hostname := range hostnameList {
+		if !validateHostname(hostname) {
+			return nil, fmt.Errorf("Hostname %v is invalid", hostname)
+		}
+		if !isAllowedHostname(hostname) {
+			return nil, fmt.Errorf("Hostname %v is not within the allowed domains of the cluster configuration", hostname)
+		}
+	}
+
+	certificates, err, shared := certificateFlights.Do(getCertificateFlightKey(secret.Namespace, sniState, hostnameList), func() (*certificate.Resource, error) {
+		return obtainCertificates(ctx, kubeClientset, secret, initiator, sniState, hostnameList, replacedCertificate)
+	})
+	if err == nil && !shared {
+		certificateIssuedTotals.With(prometheus.Labels{"environment": sniState.Environment, "wildcard": getWildcardLabel(hostnames)}).Inc()
+	}
+
+	return certificates, err
+}
+
+// storeSNICertificates writes the obtained certificates of the sni bundle to the secret holding the certificate data, removes the items of certificates no longer in the bundle, and records their state
+func storeSNICertificates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, desiredState LetsEncryptCertificateState, certificates map[string]string, obtained map[string]*certificate.Resource, removedNames []string, renewedAt time.Time) error {
+
+	// reload secret to avoid object has been modified error
+	secret, err := kubeClientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	dataSecret := secret
+	if desiredState.TargetSecret != "" {
+		dataSecret, err = getTargetSecret(ctx, kubeClientset, secret, desiredState.TargetSecret)
+		if err != nil {
+			return err
+		}
+	}
+	if dataSecret.Data == nil {
+		dataSecret.Data = map[string][]byte{}
+	}
+
+	states := getSNICertificateStates(secret)
+	for _, name := range removedNames {
+		log.Info().Msgf("[%v] Secret %v.%v - Removing sni certificate %v...", initiator, secret.Name, secret.Namespace, name)
+		for _, suffix := range []string{".crt", ".key", ".issuer.crt"} {
+			delete(dataSecret.Data, getSNICertificateDataPrefix(name)+suffix)
+		}
+		delete(states, name)
+	}
+	for name, resource := range obtained {
+		prefix := getSNICertificateDataPrefix(name)
+		dataSecret.Data[prefix+".crt"] = resource.Certificate
+		dataSecret.Data[prefix+".key"] = resource.PrivateKey
+		delete(dataSecret.Data, prefix+".issuer.crt")
+		if resource.IssuerCertificate != nil {
+			dataSecret.Data[prefix+".issuer.crt"] = resource.IssuerCertificate
+		}
+
+		state := states[name]
+		state.Hostnames = certificates[name]
+		state.LastRenewed = renewedAt.Format(time.RFC3339)
+		states[name] = state
+	}
+
+	err = setSNICertificateStates(secret, states)
+	if err != nil {
+		return err
+	}
+
+	if dataSecret != secret {
+		if dataSecret.ResourceVersion == "" {
+			setLastChangedBy(dataSecret, initiator)
+			_, err = kubeClientset.CoreV1().Secrets(dataSecret.Namespace).Create(ctx, dataSecret, metav1.CreateOptions{})
+		} else {
+			_, err = updateSecretData(ctx, kubeClientset, dataSecret, initiator)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = updateSecretData(ctx, kubeClientset, secret, initiator)
+	return err
+}
+
+// updateSNICertificateStates stores the states of the sni certificates in the latest version of the secret
+func updateSNICertificateStates(ctx context.Context, kubeClientset *kubernetes.Clientset, secret *v1.Secret, initiator string, states map[string]sniCertificateState) error {
+	err := setSNICertificateStates(secret, states)
+	if err != nil {
+		return err
+	}
+
+	setLastChangedBy(secret, initiator)
+	_, err = kubeClientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+func setSNICertificateStates(secret *v1.Secret, states map[string]sniCertificateState) error {
+	if len(states) == 0 {
+		delete(secret.Annotations, annotationLetsEncryptCertificateSNIState)
+		return nil
+	}
+
+	statesBytes, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	secret.Annotations[annotationLetsEncryptCertificateSNIState] = string(statesBytes)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSNICertificates(t *testing.T) {
+	t.Run("ReturnsNormalizedHostnamesPerName", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-certificates": `{"a":"A.estafette.io","b":" www.b.estafette.io , b.estafette.io"}`,
+		}}}
+
+		// act
+		certificates, err := getSNICertificates(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"a": normalizeHostnames("A.estafette.io"), "b": normalizeHostnames(" www.b.estafette.io , b.estafette.io")}, certificates)
+	})
+
+	t.Run("ReturnsNothingWithoutAnnotation", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+		// act
+		certificates, err := getSNICertificates(secret)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(certificates))
+	})
+
+	t.Run("ReturnsErrorForReservedName", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-certificates": `{"rsa":"estafette.io"}`,
+		}}}
+
+		// act
+		_, err := getSNICertificates(secret)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForNameThatIsNotValidInDataKey", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-certificates": `{"A_1":"estafette.io"}`,
+		}}}
+
+		// act
+		_, err := getSNICertificates(secret)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidJSON", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-certificates": "a=estafette.io",
+		}}}
+
+		// act
+		_, err := getSNICertificates(secret)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestHasSNIBundle(t *testing.T) {
+	t.Run("ReturnsTrueIfSNICertificatesAnnotationIsSet", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-certificates": `{"a":"a.estafette.io"}`,
+		}}}
+
+		// act
+		ok := hasSNIBundle(secret)
+
+		assert.True(t, ok)
+	})
+
+	t.Run("ReturnsTrueIfOnlySNIStateAnnotationRemains", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-state": `{"a":{"hostnames":"a.estafette.io"}}`,
+		}}}
+
+		// act
+		ok := hasSNIBundle(secret)
+
+		assert.True(t, ok)
+	})
+
+	t.Run("ReturnsFalseWithoutSNIAnnotations", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+		// act
+		ok := hasSNIBundle(secret)
+
+		assert.False(t, ok)
+	})
+}
+
+func TestIsSNICertificateDue(t *testing.T) {
+	t.Run("ReturnsTrueIfCertificateIsMissing", func(t *testing.T) {
+
+		dataSecret := &v1.Secret{Data: map[string][]byte{}}
+
+		// act
+		due := isSNICertificateDue(dataSecret, "a", "a.estafette.io", sniCertificateState{Hostnames: "a.estafette.io"}, LetsEncryptCertificateState{RenewDaysBefore: 30}, time.Now())
+
+		assert.True(t, due)
+	})
+
+	t.Run("ReturnsTrueIfHostnamesChanged", func(t *testing.T) {
+
+		now := time.Now()
+		dataSecret := &v1.Secret{Data: map[string][]byte{"tls-a.crt": generateTestCertificate(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))}}
+		state := sniCertificateState{Hostnames: "a.estafette.io", LastRenewed: now.Add(-time.Hour).Format(time.RFC3339)}
+
+		// act
+		due := isSNICertificateDue(dataSecret, "a", "a.estafette.io,www.a.estafette.io", state, LetsEncryptCertificateState{RenewDaysBefore: 30}, now)
+
+		assert.True(t, due)
+	})
+
+	t.Run("ReturnsFalseForRecentCertificateWithSameHostnames", func(t *testing.T) {
+
+		now := time.Now()
+		dataSecret := &v1.Secret{Data: map[string][]byte{"tls-a.crt": generateTestCertificate(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))}}
+		state := sniCertificateState{Hostnames: "a.estafette.io", LastRenewed: now.Add(-time.Hour).Format(time.RFC3339)}
+
+		// act
+		due := isSNICertificateDue(dataSecret, "a", "a.estafette.io", state, LetsEncryptCertificateState{RenewDaysBefore: 30}, now)
+
+		assert.False(t, due)
+	})
+
+	t.Run("ReturnsTrueForCertificateCloseToExpiry", func(t *testing.T) {
+
+		now := time.Now()
+		dataSecret := &v1.Secret{Data: map[string][]byte{"tls-a.crt": generateTestCertificate(t, now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))}}
+		state := sniCertificateState{Hostnames: "a.estafette.io", LastRenewed: now.Add(-80 * 24 * time.Hour).Format(time.RFC3339)}
+
+		// act
+		due := isSNICertificateDue(dataSecret, "a", "a.estafette.io", state, LetsEncryptCertificateState{RenewDaysBefore: 30}, now)
+
+		assert.True(t, due)
+	})
+}
+
+func TestSetSNICertificateStates(t *testing.T) {
+	t.Run("RemovesAnnotationWithoutStates", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"estafette.io/letsencrypt-certificate-sni-state": `{"a":{"hostnames":"a.estafette.io"}}`,
+		}}}
+
+		// act
+		err := setSNICertificateStates(secret, map[string]sniCertificateState{})
+
+		assert.Nil(t, err)
+		_, ok := secret.Annotations["estafette.io/letsencrypt-certificate-sni-state"]
+		assert.False(t, ok)
+	})
+
+	t.Run("RoundTripsStates", func(t *testing.T) {
+
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+		states := map[string]sniCertificateState{"a": {Hostnames: "a.estafette.io", LastRenewed: "2026-01-02T03:04:05Z"}}
+
+		// act
+		err := setSNICertificateStates(secret, states)
+
+		assert.Nil(t, err)
+		assert.Equal(t, states, getSNICertificateStates(secret))
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// secretStateCacheEntry holds the version of a secret that was evaluated without needing any action, and until when it can't need any
+type secretStateCacheEntry struct {
+	resourceVersion string
+	nextCheck       time.Time
+}
+
+// secretStateCache lets the poller skip secrets that haven't changed since they were last evaluated and can't need action yet, without parsing their annotations or reading their data secret from the api again
+type secretStateCache struct {
+	mutex   sync.Mutex
+	entries map[string]secretStateCacheEntry
+}
+
+func newSecretStateCache() *secretStateCache {
+	return &secretStateCache{
+		entries: map[string]secretStateCacheEntry{},
+	}
+}
+
+func getSecretStateCacheKey(secret *v1.Secret) string {
+	return fmt.Sprintf("%v/%v", secret.Namespace, secret.Name)
+}
+
+// Set records that this version of the secret doesn't need to be evaluated again before nextCheck
+func (c *secretStateCache) Set(secret *v1.Secret, nextCheck time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[getSecretStateCacheKey(secret)] = secretStateCacheEntry{resourceVersion: secret.ResourceVersion, nextCheck: nextCheck}
+}
+
+// CanSkip returns true if the same version of the secret has been evaluated before and can't need action yet
+func (c *secretStateCache) CanSkip(secret *v1.Secret) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[getSecretStateCacheKey(secret)]
+
+	return ok && entry.resourceVersion == secret.ResourceVersion && time.Now().Before(entry.nextCheck)
+}
+
+// Prune removes the entries for secrets that no longer exist
+func (c *secretStateCache) Prune(secrets []v1.Secret) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	existingKeys := map[string]bool{}
+	for i := range secrets {
+		existingKeys[getSecretStateCacheKey(&secrets[i])] = true
+	}
+
+	for key := range c.entries {
+		if !existingKeys[key] {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecretStateCache(t *testing.T) {
+	t.Run("ReturnsFalseIfSecretHasNotBeenEvaluated", func(t *testing.T) {
+
+		c := newSecretStateCache()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", ResourceVersion: "1"}}
+
+		// act
+		canSkip := c.CanSkip(secret)
+
+		assert.False(t, canSkip)
+	})
+
+	t.Run("ReturnsTrueIfSameVersionHasBeenEvaluatedAndNextCheckIsInTheFuture", func(t *testing.T) {
+
+		c := newSecretStateCache()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", ResourceVersion: "1"}}
+		c.Set(secret, time.Now().Add(time.Hour))
+
+		// act
+		canSkip := c.CanSkip(secret)
+
+		assert.True(t, canSkip)
+	})
+
+	t.Run("ReturnsFalseIfSecretHasChangedSinceEvaluation", func(t *testing.T) {
+
+		c := newSecretStateCache()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", ResourceVersion: "1"}}
+		c.Set(secret, time.Now().Add(time.Hour))
+		secret.ResourceVersion = "2"
+
+		// act
+		canSkip := c.CanSkip(secret)
+
+		assert.False(t, canSkip)
+	})
+
+	t.Run("ReturnsFalseIfNextCheckHasPassed", func(t *testing.T) {
+
+		c := newSecretStateCache()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", ResourceVersion: "1"}}
+		c.Set(secret, time.Now().Add(-time.Minute))
+
+		// act
+		canSkip := c.CanSkip(secret)
+
+		assert.False(t, canSkip)
+	})
+
+	t.Run("ForgetsSecretsThatNoLongerExistWhenPruned", func(t *testing.T) {
+
+		c := newSecretStateCache()
+		secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", ResourceVersion: "1"}}
+		c.Set(secret, time.Now().Add(time.Hour))
+
+		// act
+		c.Prune([]v1.Secret{})
+
+		assert.False(t, c.CanSkip(secret))
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certificate"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// certificateStorage is the interface for the backends certificates get stored in next to the kubernetes secret
+type certificateStorage interface {
+	Store(ctx context.Context, dataSecret *v1.Secret, certificates *certificate.Resource) error
+}
+
+// configMapStorage stores the public certificate chain, without private key, in a config map with the name of the secret, for consumers that may read config maps but not secrets
+type configMapStorage struct {
+	kubeClientset *kubernetes.Clientset
+}
+
+func (s *configMapStorage) Store(ctx context.Context, dataSecret *v1.Secret, certificates *certificate.Resource) error {
+	data := getPublicCertificateData(certificates)
+
+	configMap, err := s.kubeClientset.CoreV1().ConfigMaps(dataSecret.Namespace).Get(ctx, dataSecret.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      dataSecret.Name,
+				Namespace: dataSecret.Namespace,
+				Labels:    dataSecret.Labels,
+				Annotations: map[string]string{
+					annotationLetsEncryptCertificateLinkedSecret: fmt.Sprintf("%v/%v", dataSecret.Namespace, dataSecret.Name),
+				},
+			},
+			Data: data,
+		}
+		_, err = s.kubeClientset.CoreV1().ConfigMaps(dataSecret.Namespace).Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// never overwrite a config map with the same name that has been created by someone else
+	if !isLinkedConfigMap(configMap, dataSecret) {
+		return fmt.Errorf("Config map %v.%v already exists and isn't linked to secret %v, not overwriting it", configMap.Name, configMap.Namespace, dataSecret.Name)
+	}
+
+	configMap.Data = data
+	_, err = s.kubeClientset.CoreV1().ConfigMaps(dataSecret.Namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// isLinkedConfigMap checks whether the config map has been created by the config map storage for the secret, using the linked-secret annotation set on creation
+func isLinkedConfigMap(configMap *v1.ConfigMap, dataSecret *v1.Secret) bool {
+	return configMap.Annotations[annotationLetsEncryptCertificateLinkedSecret] == fmt.Sprintf("%v/%v", dataSecret.Namespace, dataSecret.Name)
+}
+
+// fileStorage stores the certificate, private key and issuer certificate as files in a <namespace>/<name> directory, for example on a volume shared with a web server outside of kubernetes
+type fileStorage struct {
+	directory string
+}
+
+func (s *fileStorage) Store(ctx context.Context, dataSecret *v1.Secret, certificates *certificate.Resource) error {
+	directory := filepath.Join(s.directory, dataSecret.Namespace, dataSecret.Name)
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return err
+	}
+
+	for name, data := range getPublicCertificateData(certificates) {
+		err = ioutil.WriteFile(filepath.Join(directory, name), []byte(data), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(filepath.Join(directory, "tls.key"), certificates.PrivateKey, 0600)
+}
+
+// getPublicCertificateData returns the certificate and issuer certificate, which can be shared without exposing the private key
+func getPublicCertificateData(certificates *certificate.Resource) map[string]string {
+	data := map[string]string{
+		"tls.crt": string(certificates.Certificate),
+	}
+	if certificates.IssuerCertificate != nil {
+		data["ca.crt"] = string(certificates.IssuerCertificate)
+	}
+
+	return data
+}
+
+// getCertificateStorages returns the backends selected with the comma-separated storage annotation value; the kubernetes secret is always written, since the state and renewals depend on it
+func getCertificateStorages(kubeClientset *kubernetes.Clientset, storage string) (storages []certificateStorage, err error) {
+	for _, name := range strings.Split(storage, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "secret":
+			continue
+		case "configmap":
+			storages = append(storages, &configMapStorage{kubeClientset: kubeClientset})
+		case "file":
+			if *storageFilePath == "" {
+				return nil, fmt.Errorf("Storage file requires --storage-file-path to be set")
+			}
+			storages = append(storages, &fileStorage{directory: *storageFilePath})
+		default:
+			return nil, fmt.Errorf("Storage %v is not supported, use secret, configmap or file", name)
+		}
+	}
+
+	return storages, nil
+}
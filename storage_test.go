@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCertificateStorages(t *testing.T) {
+	t.Run("ReturnsNoStoragesIfAnnotationIsEmpty", func(t *testing.T) {
+
+		// act
+		storages, err := getCertificateStorages(nil, "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(storages))
+	})
+
+	t.Run("ReturnsStoragePerBackendOtherThanSecret", func(t *testing.T) {
+
+		*storageFilePath = "/certificates"
+
+		// act
+		storages, err := getCertificateStorages(nil, "secret, configmap,file")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(storages))
+		assert.IsType(t, &configMapStorage{}, storages[0])
+		assert.IsType(t, &fileStorage{}, storages[1])
+	})
+
+	t.Run("ReturnsErrorIfFileStorageHasNoPath", func(t *testing.T) {
+
+		*storageFilePath = ""
+
+		// act
+		_, err := getCertificateStorages(nil, "file")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfBackendIsUnknown", func(t *testing.T) {
+
+		// act
+		_, err := getCertificateStorages(nil, "vault")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFileStorageStore(t *testing.T) {
+	t.Run("WritesCertificateFilesToNamespaceAndNameDirectory", func(t *testing.T) {
+
+		storage := &fileStorage{directory: t.TempDir()}
+		dataSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+		certificates := &certificate.Resource{Certificate: []byte("crt"), PrivateKey: []byte("key"), IssuerCertificate: []byte("issuer")}
+
+		// act
+		err := storage.Store(context.Background(), dataSecret, certificates)
+
+		assert.Nil(t, err)
+		for name, expected := range map[string]string{"tls.crt": "crt", "tls.key": "key", "ca.crt": "issuer"} {
+			data, err := ioutil.ReadFile(filepath.Join(storage.directory, "mynamespace", "mysecret", name))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, string(data))
+		}
+	})
+}
+
+func TestIsLinkedConfigMap(t *testing.T) {
+	t.Run("ReturnsTrueIfConfigMapIsLinkedToSecret", func(t *testing.T) {
+
+		configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", Annotations: map[string]string{annotationLetsEncryptCertificateLinkedSecret: "mynamespace/mysecret"}}}
+		dataSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		linked := isLinkedConfigMap(configMap, dataSecret)
+
+		assert.True(t, linked)
+	})
+
+	t.Run("ReturnsFalseIfConfigMapHasNoLinkedSecretAnnotation", func(t *testing.T) {
+
+		configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+		dataSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		linked := isLinkedConfigMap(configMap, dataSecret)
+
+		assert.False(t, linked)
+	})
+
+	t.Run("ReturnsFalseIfConfigMapIsLinkedToOtherSecret", func(t *testing.T) {
+
+		configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace", Annotations: map[string]string{annotationLetsEncryptCertificateLinkedSecret: "othernamespace/mysecret"}}}
+		dataSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "mynamespace"}}
+
+		// act
+		linked := isLinkedConfigMap(configMap, dataSecret)
+
+		assert.False(t, linked)
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// requiredPermission is a single resource/verb combination the controller needs to be allowed to perform cluster-wide
+type requiredPermission struct {
+	Resource string
+	Verb     string
+}
+
+// requiredPermissions mirrors the rules in the helm chart's clusterrole
+var requiredPermissions = []requiredPermission{
+	{Resource: "secrets", Verb: "create"},
+	{Resource: "secrets", Verb: "delete"},
+	{Resource: "secrets", Verb: "get"},
+	{Resource: "secrets", Verb: "list"},
+	{Resource: "secrets", Verb: "update"},
+	{Resource: "secrets", Verb: "watch"},
+	{Resource: "configmaps", Verb: "create"},
+	{Resource: "configmaps", Verb: "get"},
+	{Resource: "configmaps", Verb: "update"},
+	{Resource: "namespaces", Verb: "list"},
+	{Resource: "namespaces", Verb: "watch"},
+	{Resource: "events", Verb: "create"},
+	{Resource: "events", Verb: "get"},
+	{Resource: "events", Verb: "update"},
+}
+
+// validateConfig runs all configuration checks, logs a report line for each of them and returns false if any check failed
+func validateConfig(ctx context.Context) (valid bool) {
+
+	valid = true
+	report := func(check string, err error) {
+		if err != nil {
+			valid = false
+			log.Error().Err(err).Msgf("Check '%v' failed", check)
+			return
+		}
+		log.Info().Msgf("Check '%v' succeeded", check)
+	}
+
+	report("flags", validateFlags())
+
+	if *accountSecret != "" {
+		report("account secret", validateAccountSecret(ctx))
+	} else {
+		for _, accountPath := range strings.Split(*accountPaths, ",") {
+			_, err := loadLetsEncryptUser(accountPath)
+			report(fmt.Sprintf("account %v", accountPath), err)
+		}
+	}
+
+	accounts, _ := parseAccountSecrets(*accountSecrets)
+	accountNames := []string{}
+	for account := range accounts {
+		accountNames = append(accountNames, account)
+	}
+	sort.Strings(accountNames)
+	for _, account := range accountNames {
+		report(fmt.Sprintf("account %v", account), validateNamedAccountSecret(ctx, accounts[account]))
+	}
+
+	if validateCloudflareCredentials() == nil {
+		cf := NewCloudflare(getCloudflareAuthentication())
+		report("cloudflare credentials", cf.VerifyAuthentication())
+	}
+
+	report("rbac permissions", validatePermissions(ctx))
+
+	if valid {
+		log.Info().Msg("Configuration is valid")
+	} else {
+		log.Error().Msg("Configuration is invalid")
+	}
+
+	return
+}
+
+// validateAccountSecret checks the account in the account secret, or that an account can be registered for it on first run
+func validateAccountSecret(ctx context.Context) error {
+	kubeClientConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	_, err = loadLetsEncryptUserFromSecret(ctx, kubeClientset, getAccountSecretNamespace(), *accountSecret)
+	if errors.IsNotFound(err) && *accountEmail != "" {
+		return nil
+	}
+
+	return err
+}
+
+// validateNamedAccountSecret checks the account in the secret of a named account
+func validateNamedAccountSecret(ctx context.Context, secretName string) error {
+	kubeClientConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	_, err = loadLetsEncryptUserFromSecret(ctx, kubeClientset, getAccountSecretNamespace(), secretName)
+
+	return err
+}
+
+func validateFlags() error {
+	if err := validateCloudflareCredentials(); err != nil && *dnsProvider == "cloudflare" {
+		return err
+	}
+	if *dnsProvider == "azure" && *azureResourceGroup == "" {
+		return fmt.Errorf("Flag --azure-resource-group should be set when --dns-provider is azure")
+	}
+	if *dnsProvider == "rfc2136" && *rfc2136Nameserver == "" {
+		return fmt.Errorf("Flag --rfc2136-nameserver should be set when --dns-provider is rfc2136")
+	}
+	if *rfc2136TSIGKey != "" && *rfc2136TSIGSecret == "" {
+		return fmt.Errorf("Flag --rfc2136-tsig-secret should be set when --rfc2136-tsig-key is set")
+	}
+	if *daysBeforeRenewal <= 0 {
+		return fmt.Errorf("Flag --days-before-renewal should be larger than 0, but is %v", *daysBeforeRenewal)
+	}
+	if *renewBeforeDays < 0 {
+		return fmt.Errorf("Flag --renew-before-days should be at least 0, but is %v", *renewBeforeDays)
+	}
+	if *retryAfterMinutes <= 0 {
+		return fmt.Errorf("Flag --retry-after-minutes should be larger than 0, but is %v", *retryAfterMinutes)
+	}
+	if strings.TrimSpace(*accountPaths) == "" {
+		return fmt.Errorf("Flag --account-paths should have at least one path")
+	}
+	if _, err := parseAccountSecrets(*accountSecrets); err != nil {
+		return fmt.Errorf("Flag --account-secrets is invalid: %w", err)
+	}
+	if *jitterDeviation < 0 || *jitterDeviation >= 1 {
+		return fmt.Errorf("Flag --jitter-deviation should be at least 0 and smaller than 1, but is %v", *jitterDeviation)
+	}
+	if *rollbackPause <= 0 {
+		return fmt.Errorf("Flag --rollback-pause should be larger than 0, but is %v", *rollbackPause)
+	}
+	if *keepPreviousCertificates < 0 || *keepPreviousCertificates > maxPreviousCertificates {
+		return fmt.Errorf("Flag --keep-previous-certificates should be between 0 and %v, but is %v", maxPreviousCertificates, *keepPreviousCertificates)
+	}
+	if _, err := regexp.Compile(*caBundleNamespaces); err != nil {
+		return fmt.Errorf("Flag --ca-bundle-namespaces-matching is invalid: %w", err)
+	}
+	if *reportInterval <= 0 && (*reportConfigMap != "" || *reportWebhookURL != "") {
+		return fmt.Errorf("Flag --report-interval should be larger than 0, but is %v", *reportInterval)
+	}
+	if _, err := newSecretShard(*shardIndex, *shardCount, os.Getenv("HOSTNAME")); err != nil {
+		return fmt.Errorf("Flags --shard-index and --shard-count are invalid: %w", err)
+	}
+	if _, err := getACMEProxy(*acmeProxies, "", ""); err != nil {
+		return fmt.Errorf("Flag --acme-proxies is invalid: %w", err)
+	}
+	if (*acmeEABKeyID == "") != (*acmeEABHMACKey == "") {
+		return fmt.Errorf("Flags --acme-eab-key-id and --acme-eab-hmac-key should be set together")
+	}
+	if _, err := parseDNSBudgetLimits(*dnsAPIBudget); err != nil {
+		return fmt.Errorf("Flag --dns-api-budget is invalid: %w", err)
+	}
+	if *dnsAPIBudgetWindow <= 0 {
+		return fmt.Errorf("Flag --dns-api-budget-window should be larger than 0, but is %v", *dnsAPIBudgetWindow)
+	}
+	if *staleLockTimeout < 0 {
+		return fmt.Errorf("Flag --stale-lock-timeout should be at least 0, but is %v", *staleLockTimeout)
+	}
+	if err := validateBackupFlags(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateCloudflareCredentials checks that either a scoped api token or a global api key with its email address is set
+func validateCloudflareCredentials() error {
+	if *cfAPIToken == "" && (*cfAPIKey == "" || *cfAPIEmail == "") {
+		return fmt.Errorf("Either flag --cloudflare-api-token or flags --cloudflare-api-key and --cloudflare-api-email should be set")
+	}
+
+	return nil
+}
+
+func validatePermissions(ctx context.Context) error {
+	kubeClientConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	deniedPermissions := []string{}
+	for _, permission := range requiredPermissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Resource: permission.Resource,
+					Verb:     permission.Verb,
+				},
+			},
+		}
+
+		review, err = kubeClientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		if !review.Status.Allowed {
+			deniedPermissions = append(deniedPermissions, fmt.Sprintf("%v %v", permission.Verb, permission.Resource))
+		}
+	}
+
+	if len(deniedPermissions) > 0 {
+		return fmt.Errorf("Missing permissions: %v", strings.Join(deniedPermissions, ", "))
+	}
+
+	return nil
+}
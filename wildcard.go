@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// isWildcardHostname returns true for hostnames like *.estafette.io, which can only be validated with the DNS-01 challenge and count differently against rate limits
+func isWildcardHostname(hostname string) bool {
+	return strings.HasPrefix(strings.TrimSpace(hostname), "*.")
+}
+
+// hasWildcardHostname returns true if any of the comma-separated hostnames is a wildcard
+func hasWildcardHostname(hostnames string) bool {
+	for _, hostname := range strings.Split(hostnames, ",") {
+		if isWildcardHostname(hostname) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getWildcardLabel returns the value of the wildcard label of the metrics for a certificate with the comma-separated hostnames
+func getWildcardLabel(hostnames string) string {
+	return strconv.FormatBool(hasWildcardHostname(hostnames))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasWildcardHostname(t *testing.T) {
+	t.Run("ReturnsTrueIfAnyHostnameIsWildcard", func(t *testing.T) {
+
+		// act
+		wildcard := hasWildcardHostname("estafette.io,*.estafette.io")
+
+		assert.True(t, wildcard)
+	})
+
+	t.Run("ReturnsFalseForRegularHostnames", func(t *testing.T) {
+
+		// act
+		wildcard := hasWildcardHostname("estafette.io,www.estafette.io")
+
+		assert.False(t, wildcard)
+	})
+
+	t.Run("ReturnsFalseForAsteriskInsideHostname", func(t *testing.T) {
+
+		// act
+		wildcard := hasWildcardHostname("www*.estafette.io")
+
+		assert.False(t, wildcard)
+	})
+}